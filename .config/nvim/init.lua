@@ -41,30 +41,135 @@ require("lazy").setup({
 		},
 		{
 			"nvim-neorg/neorg",
+			-- A conversion may pin one of the Neorg versions installed in the image, whose checkout
+			-- then takes the place of the managed plugin on the runtimepath
+			dir = os.getenv("NEORG_DOCGEN_NEORG"),
 			lazy = false,
 			version = "*",
 			config = function()
-				require("neorg").setup {
-					load = {
-						["core.defaults"] = {},
-						["core.concealer"] = {},
-						["core.dirman"] = {
-							config = {
-								workspaces = {
-									notes = "~/notes",
-								},
-								default_workspace = "notes",
+				local load = {
+					["core.defaults"] = {},
+					["core.concealer"] = {},
+					["core.dirman"] = {
+						config = {
+							workspaces = {
+								notes = "~/notes",
 							},
+							default_workspace = "notes",
 						},
-						["core.export"] = {},
-						["core.export.markdown"] = {
-							config = {
-								extensions = "all",
-							},
+					},
+					["core.export"] = {},
+					["core.export.markdown"] = {
+						config = {
+							extensions = "all",
 						},
 					},
 				}
 
+				-- During conversion the service passes a module policy: only listed modules are
+				-- loaded and denied ones are also removed from the core.defaults bundle
+				local policy_path = os.getenv("NEORG_DOCGEN_MODULES")
+				if policy_path and vim.fn.filereadable(policy_path) == 1 then
+					local policy = vim.json.decode(table.concat(vim.fn.readfile(policy_path), "\n"))
+					local allowed = {}
+					for _, name in ipairs(policy.load or {}) do
+						allowed[name] = true
+					end
+					for name in pairs(load) do
+						if not allowed[name] then
+							load[name] = nil
+						end
+					end
+					if load["core.defaults"] and policy.disable and #policy.disable > 0 then
+						load["core.defaults"] = { config = { disable = policy.disable } }
+					end
+				end
+
+				-- A user neorg_config.lua may adjust workspaces and export settings. It runs with an
+				-- empty environment and an instruction budget, and must return a table of plain data.
+				local overlay_path = os.getenv("NEORG_DOCGEN_CONFIG")
+				if overlay_path and vim.fn.filereadable(overlay_path) == 1 then
+					local overlayable = { ["core.dirman"] = true, ["core.export"] = true, ["core.export.markdown"] = true }
+
+					local function check_data(value, where)
+						local kind = type(value)
+						if kind == "table" then
+							for k, v in pairs(value) do
+								check_data(k, where)
+								check_data(v, where .. "." .. tostring(k))
+							end
+						elseif kind ~= "string" and kind ~= "number" and kind ~= "boolean" then
+							error("neorg_config.lua: " .. where .. " must be plain data, got " .. kind)
+						end
+					end
+
+					local source = table.concat(vim.fn.readfile(overlay_path), "\n")
+					local chunk, err = loadstring(source, "=neorg_config.lua")
+					if not chunk then
+						error("neorg_config.lua: " .. err)
+					end
+					-- Instruction count hooks do not fire in JIT-compiled code
+					if jit then
+						jit.off(chunk, true)
+					end
+					setfenv(chunk, {})
+					-- String methods reach the string library through its metatable, so the chunk gets a
+					-- copy without dump and with rep limited
+					local overlay_string = {}
+					for name, fn in pairs(string) do
+						overlay_string[name] = fn
+					end
+					overlay_string.dump = nil
+					overlay_string.rep = function(s, n, sep)
+						if (#tostring(s) + #tostring(sep or "")) * (tonumber(n) or 0) > 1024 * 1024 then
+							error("string.rep result too large", 2)
+						end
+						return string.rep(s, n, sep)
+					end
+					local string_metatable = debug.getmetatable("")
+					local string_index = string_metatable.__index
+					string_metatable.__index = overlay_string
+					debug.sethook(function()
+						error("neorg_config.lua: instruction budget exceeded")
+					end, "", 1000000)
+					local ok, overlay = pcall(chunk)
+					debug.sethook()
+					string_metatable.__index = string_index
+					if not ok then
+						error(overlay)
+					end
+					if type(overlay) ~= "table" then
+						error("neorg_config.lua must return a table of module settings")
+					end
+					check_data(overlay, "config")
+
+					-- Workspaces must stay inside the converted project
+					local dirman = overlay["core.dirman"]
+					local workspaces = type(dirman) == "table" and type(dirman.config) == "table" and dirman.config.workspaces
+					if type(workspaces) == "table" then
+						for workspace, path in pairs(workspaces) do
+							if type(path) ~= "string" or path:match("^[/~]") or ("/" .. path .. "/"):find("/%.%./") then
+								error("neorg_config.lua: workspace " .. tostring(workspace) .. " must be a relative path inside the project")
+							end
+							workspaces[workspace] = vim.fn.getcwd() .. "/" .. path
+						end
+					end
+
+					for name, settings in pairs(overlay) do
+						if not overlayable[name] or type(settings) ~= "table" then
+							error("neorg_config.lua: module " .. tostring(name) .. " cannot be configured")
+						end
+						-- Modules removed by the module policy stay disabled
+						if load[name] then
+							load[name] = vim.tbl_deep_extend("force", load[name], settings)
+						end
+					end
+				end
+
+				require("neorg").setup {
+					load = load,
+				}
+
 				vim.wo.foldlevel = 99
 				vim.wo.conceallevel = 2
 			end,
@@ -13,7 +13,9 @@ io.write_to_wiki = function(filename, content)
     print("Wiki directory: " .. wiki_dir)
     print("Content lines: " .. #content)
     
-    vim.fn.writefile(content, wiki_dir .. "/" .. filename .. ".md")
+    local path = wiki_dir .. "/" .. filename .. ".md"
+    vim.fn.mkdir(vim.fn.fnamemodify(path, ":h"), "p")
+    vim.fn.writefile(content, path)
 end
 
 return io
@@ -0,0 +1,89 @@
+-- Pandoc custom reader for Neorg files, used by the pandoc converter backend
+-- when the Neovim pipeline is unavailable. It mirrors the line-based rules of
+-- simple_norg_converter.lua and hands the resulting Markdown to pandoc.
+--
+-- Usage: pandoc -f pandoc_norg_reader.lua -t gfm input.norg
+
+local function convert_inline(text)
+    -- Bold: {* text *} -> **text**
+    text = text:gsub("{%*%s*(.-)%s*%*}", "**%1**")
+    -- Italic: {/ text /} -> *text*
+    text = text:gsub("{/%s*(.-)%s*/}", "*%1*")
+    -- Code: {` text `} -> `text`
+    text = text:gsub("{`%s*(.-)%s*`}", "`%1`")
+    -- Strikethrough: {- text -} -> ~~text~~
+    text = text:gsub("{%-%s*(.-)%s*%-}", "~~%1~~")
+    -- Links: {url}[text] -> [text](url)
+    text = text:gsub("{([^}]+)}%[([^%]]+)%]", "[%2](%1)")
+    -- Anchors: [text]{url} -> [text](url)
+    text = text:gsub("%[([^%]]+)%]{([^}]+)}", "[%1](%2)")
+    -- Simple links: {url} -> [url](url)
+    text = text:gsub("{(https?://[^}]+)}", "[%1](%1)")
+    text = text:gsub("{(file://[^}]+)}", "[%1](%1)")
+    return text
+end
+
+local function norg_to_markdown(source)
+    local lines = {}
+    for line in (source .. "\n"):gmatch("(.-)\r?\n") do
+        table.insert(lines, line)
+    end
+
+    local has_top_level_header = false
+    for _, line in ipairs(lines) do
+        if line:match("^%*[^%*]") then
+            has_top_level_header = true
+            break
+        end
+    end
+
+    local markdown = {}
+    local in_code_block = false
+    local in_meta_block = false
+
+    for _, line in ipairs(lines) do
+        if line:match("^@document%.meta") then
+            in_meta_block = true
+        elseif in_meta_block then
+            if line:match("^@end") then
+                in_meta_block = false
+            else
+                local title = line:match("^title:%s*(.+)")
+                if title and not has_top_level_header then
+                    table.insert(markdown, "# " .. title)
+                    table.insert(markdown, "")
+                end
+            end
+        elseif line:match("^%s*@code") then
+            table.insert(markdown, "```" .. (line:match("@code%s*(%w*)") or ""))
+            in_code_block = true
+        elseif in_code_block then
+            if line:match("^%s*@end") then
+                table.insert(markdown, "```")
+                in_code_block = false
+            else
+                table.insert(markdown, line)
+            end
+        elseif line:match("^%*+%s") then
+            local level, text = line:match("^(%*+)%s*(.*)")
+            table.insert(markdown, string.rep("#", #level) .. " " .. convert_inline(text))
+            table.insert(markdown, "")
+        elseif line:match("^%s*[%-~%*]+%s*%(.-%)") then
+            local marker, status, text = line:match("^%s*([%-~%*]+)%s*%((.-)%)%s*(.*)")
+            local checkbox = status:match("^%s*$") and "[ ]" or "[x]"
+            table.insert(markdown, string.rep(" ", math.max(0, (#marker - 1) * 2)) .. "- " .. checkbox .. " " .. convert_inline(text))
+        elseif line:match("^%s*[%-~]+%s") then
+            local marker, text = line:match("^%s*([%-~]+)%s*(.*)")
+            local list_char = marker:match("^%-") and "-" or "1."
+            table.insert(markdown, string.rep(" ", math.max(0, (#marker - 1) * 2)) .. list_char .. " " .. convert_inline(text))
+        else
+            table.insert(markdown, convert_inline(line))
+        end
+    end
+
+    return table.concat(markdown, "\n")
+end
+
+function Reader(input, reader_options)
+    return pandoc.read(norg_to_markdown(tostring(input)), "gfm", reader_options)
+end
@@ -1,6 +1,29 @@
 -- Simple Neorg to Markdown converter without full Neorg setup
 local fileio = require("fileio")
 
+-- Localized strings for generated scaffolding, written next to this script by the service
+local strings = {
+    no_files_title = "No Neorg Files Found",
+    no_files_body = "This directory did not contain any `.norg` files to convert.",
+    no_files_hint = "To use this converter, include `.norg` files in your project archive.",
+}
+if vim.fn.filereadable("locale.json") == 1 then
+    local ok, decoded = pcall(vim.json.decode, table.concat(vim.fn.readfile("locale.json"), "\n"))
+    if ok and type(decoded) == "table" then
+        strings = vim.tbl_extend("force", strings, decoded)
+    else
+        print("WARNING: Could not parse locale.json, using default strings")
+    end
+end
+
+-- Markdown flavor requested by the service in NEORG_DOCGEN_FLAVOR: gfm, commonmark or pandoc
+local flavor = vim.env.NEORG_DOCGEN_FLAVOR
+if flavor ~= "commonmark" and flavor ~= "pandoc" then
+    flavor = "gfm"
+end
+-- CommonMark has no strikethrough syntax, so it falls back to HTML
+local strikethrough = flavor == "commonmark" and "<del>%1</del>" or "~~%1~~"
+
 print("=== SIMPLE NEORG TO MARKDOWN CONVERTER: Starting ===")
 
 print("DEBUG: Current working directory: " .. vim.fn.getcwd())
@@ -29,6 +52,220 @@ local function find_norg_files(dir)
     return files
 end
 
+-- Files that fail to convert are reported to the service in NEORG_DOCGEN_ERRORS, one JSON object
+-- per line, and the remaining files are still converted
+local errors_file = vim.env.NEORG_DOCGEN_ERRORS
+local converting_line = 0
+
+local function report_error(norg_file, line, message)
+    print("ERROR: " .. norg_file .. ":" .. line .. ": " .. message)
+    if not errors_file or errors_file == "" then
+        return
+    end
+    local handle = io.open(errors_file, "a")
+    if handle then
+        handle:write(vim.json.encode({ file = norg_file:gsub("^%.%./", ""), line = line, message = message }) .. "\n")
+        handle:close()
+    end
+end
+
+-- How long each file took is recorded in NEORG_DOCGEN_TIMINGS for the service's conversion report
+local timings_file = vim.env.NEORG_DOCGEN_TIMINGS
+
+local function record_timing(norg_file, started)
+    if not timings_file or timings_file == "" then
+        return
+    end
+    local handle = io.open(timings_file, "a")
+    if handle then
+        local duration_ms = math.floor((vim.loop.hrtime() - started) / 1e6)
+        handle:write(vim.json.encode({ file = norg_file:gsub("^%.%./", ""), converter = "nvim", duration_ms = duration_ms }) .. "\n")
+        handle:close()
+    end
+end
+
+-- Conversion hooks from the project's docgen_hooks.lua, staged next to this script by the service
+-- only when the deployment lets the client run them. The file returns a table of functions keyed
+-- by node type; each receives the node and its markdown and returns replacement markdown, or nil
+-- to keep it. Hooks only see the string, table and math libraries and run on an instruction budget.
+local hook_kinds = { heading = true, paragraph = true, list_item = true, task = true, code_block = true, document = true }
+local hook_budget = 1000000
+local max_hook_output = 1024 * 1024
+local hooks = {}
+
+local hook_string = {}
+for name, fn in pairs(string) do
+    hook_string[name] = fn
+end
+hook_string.dump = nil
+-- rep, gsub and format can build strings far larger than their arguments, so each is limited to
+-- max_hook_output before the result is allocated
+hook_string.rep = function(s, n, sep)
+    local count = tonumber(n) or 0
+    if (#tostring(s) + #tostring(sep or "")) * count > max_hook_output then
+        error("string.rep result too large", 2)
+    end
+    return string.rep(s, n, sep)
+end
+
+-- The replacement of one gsub match, as string.gsub would make it
+local function gsub_replacement(repl, whole, captures)
+    local kind = type(repl)
+    local value
+    if kind == "string" or kind == "number" then
+        return (string.gsub(tostring(repl), "%%(.)", function(c)
+            if c == "0" then
+                return whole
+            end
+            local index = tonumber(c)
+            if not index then
+                return c
+            end
+            if captures[index] == nil then
+                error("invalid capture index %" .. c .. " in replacement string", 0)
+            end
+            return tostring(captures[index])
+        end))
+    elseif kind == "table" then
+        value = repl[captures[1]]
+    elseif kind == "function" then
+        value = repl(unpack(captures))
+    else
+        error("bad argument #3 to 'gsub' (string/function/table expected)", 0)
+    end
+    if value == nil or value == false then
+        return whole
+    end
+    if type(value) ~= "string" and type(value) ~= "number" then
+        error("invalid replacement value (a " .. type(value) .. ")", 0)
+    end
+    return tostring(value)
+end
+
+-- string.gsub built match by match with string.find, failing once the result would exceed
+-- max_hook_output instead of after building it
+hook_string.gsub = function(s, pattern, repl, n)
+    s = tostring(s)
+    pattern = tostring(pattern)
+    local anchored = pattern:sub(1, 1) == "^"
+    local parts, size, count, position = {}, 0, 0, 1
+    local function append(part)
+        size = size + #part
+        if size > max_hook_output then
+            error("string.gsub result too large", 3)
+        end
+        table.insert(parts, part)
+    end
+    while n == nil or count < n do
+        local found = { string.find(s, pattern, position) }
+        local first, last = found[1], found[2]
+        if not first then
+            break
+        end
+        local whole = s:sub(first, last)
+        local captures = { unpack(found, 3, table.maxn(found)) }
+        if #captures == 0 then
+            captures[1] = whole
+        end
+        count = count + 1
+        append(s:sub(position, first - 1))
+        append(gsub_replacement(repl, whole, captures))
+        if last >= first then
+            position = last + 1
+        elseif first <= #s then
+            -- An empty match copies the next character and moves past it
+            append(s:sub(first, first))
+            position = first + 1
+        else
+            position = first
+            break
+        end
+        if anchored then
+            break
+        end
+    end
+    append(s:sub(position))
+    return table.concat(parts), count
+end
+
+hook_string.format = function(format, ...)
+    local result = string.format(format, ...)
+    if #result > max_hook_output then
+        error("string.format result too large", 2)
+    end
+    return result
+end
+local hook_environment = {
+    assert = assert, error = error, ipairs = ipairs, next = next, pairs = pairs, select = select,
+    tonumber = tonumber, tostring = tostring, type = type, unpack = unpack,
+    string = hook_string, table = vim.deepcopy(table), math = vim.deepcopy(math),
+}
+
+-- Runs fn from docgen_hooks.lua within the budget, with string methods limited to the hook library
+local function run_sandboxed(fn, ...)
+    local string_metatable = debug.getmetatable("")
+    local string_index = string_metatable.__index
+    string_metatable.__index = hook_string
+    debug.sethook(function()
+        error("instruction budget exceeded")
+    end, "", hook_budget)
+    local results = { pcall(fn, ...) }
+    debug.sethook()
+    string_metatable.__index = string_index
+    if not results[1] then
+        error("docgen_hooks.lua: " .. tostring(results[2]), 0)
+    end
+    return unpack(results, 2, table.maxn(results))
+end
+
+if vim.fn.filereadable("docgen_hooks.lua") == 1 then
+    local chunk, err = loadstring(table.concat(vim.fn.readfile("docgen_hooks.lua"), "\n"), "=docgen_hooks.lua")
+    if not chunk then
+        error("docgen_hooks.lua: " .. err)
+    end
+    -- Instruction count hooks do not fire in JIT-compiled code
+    if jit then
+        jit.off(chunk, true)
+    end
+    setfenv(chunk, hook_environment)
+    hooks = run_sandboxed(chunk)
+    if type(hooks) ~= "table" then
+        error("docgen_hooks.lua must return a table of hook functions")
+    end
+    for kind, hook in pairs(hooks) do
+        if not hook_kinds[kind] or type(hook) ~= "function" then
+            error("docgen_hooks.lua: " .. tostring(kind) .. " is not a hook for a node type")
+        end
+    end
+    print("DEBUG: Loaded docgen_hooks.lua")
+end
+
+-- Passes a node's markdown through its hook, if the project has one for the node type
+local function apply_hook(kind, node, markdown)
+    local hook = hooks[kind]
+    if not hook then
+        return markdown
+    end
+    local result = run_sandboxed(hook, node, markdown)
+    if result == nil then
+        return markdown
+    end
+    if type(result) ~= "string" then
+        error("docgen_hooks.lua: the " .. kind .. " hook must return a string or nil")
+    end
+    if #result > max_hook_output then
+        error("docgen_hooks.lua: the " .. kind .. " hook returned more than " .. max_hook_output .. " bytes")
+    end
+    return result
+end
+
+-- Appends markdown, which hooks may have made several lines long, to lines
+local function insert_markdown(lines, markdown)
+    for _, line in ipairs(vim.split(markdown, "\n", { plain = true })) do
+        table.insert(lines, line)
+    end
+end
+
 -- Function to convert a single .norg file to markdown
 local function convert_norg_to_markdown(norg_file)
     print("DEBUG: Converting " .. norg_file .. " to markdown")
@@ -54,8 +291,11 @@ local function convert_norg_to_markdown(norg_file)
     local in_code_block = false
     local in_meta_block = false
     local code_lang = ""
+    local code_start, code_lines = 0, {}
+    local file = (norg_file:gsub("^%.%./", ""))
     
-    for _, line in ipairs(content) do
+    for index, line in ipairs(content) do
+        converting_line = index
         -- Handle document.meta blocks
         if line:match("^@document%.meta") then
             in_meta_block = true
@@ -66,7 +306,13 @@ local function convert_norg_to_markdown(norg_file)
         elseif in_meta_block then
             -- Extract title from meta if available, but only if no top-level header exists
             local title = line:match("^title:%s*(.+)")
-            if title and not has_top_level_header then
+            if title and flavor == "pandoc" then
+                -- Pandoc's markdown reads the title from a YAML metadata block
+                table.insert(markdown_lines, 1, "---")
+                table.insert(markdown_lines, 2, "title: " .. vim.json.encode(title))
+                table.insert(markdown_lines, 3, "---")
+                table.insert(markdown_lines, 4, "")
+            elseif title and not has_top_level_header then
                 table.insert(markdown_lines, "# " .. title)
                 table.insert(markdown_lines, "")
             end
@@ -76,17 +322,26 @@ local function convert_norg_to_markdown(norg_file)
         -- Handle code blocks
         if line:match("^%s*@code") then
             code_lang = line:match("@code%s*(%w*)") or ""
+            code_start, code_lines = #markdown_lines + 1, {}
             table.insert(markdown_lines, "```" .. code_lang)
             in_code_block = true
             goto continue
         elseif line:match("^%s*@end") and in_code_block then
             table.insert(markdown_lines, "```")
             in_code_block = false
+            if hooks.code_block then
+                local block = table.concat(markdown_lines, "\n", code_start)
+                for i = #markdown_lines, code_start, -1 do
+                    markdown_lines[i] = nil
+                end
+                insert_markdown(markdown_lines, apply_hook("code_block", { file = file, language = code_lang, lines = code_lines }, block))
+            end
             goto continue
         end
         
         if in_code_block then
             table.insert(markdown_lines, line)
+            table.insert(code_lines, line)
             goto continue
         end
         
@@ -96,7 +351,7 @@ local function convert_norg_to_markdown(norg_file)
             local header_level, header_text = line:match("^(%*+)%s*(.*)")
             if header_level and header_text then
                 local md_header = string.rep("#", #header_level) .. " " .. header_text
-                table.insert(markdown_lines, md_header)
+                insert_markdown(markdown_lines, apply_hook("heading", { file = file, level = #header_level, text = header_text }, md_header))
                 table.insert(markdown_lines, "")
                 goto continue
             end
@@ -111,7 +366,7 @@ local function convert_norg_to_markdown(norg_file)
                 text = text:gsub("{%*%s*(.-)%s*%*}", "**%1**")
                 text = text:gsub("{/%s*(.-)%s*/}", "*%1*")
                 text = text:gsub("{`%s*(.-)%s*`}", "`%1`")
-                text = text:gsub("{%-%s*(.-)%s*%-}", "~~%1~~")
+                text = text:gsub("{%-%s*(.-)%s*%-}", strikethrough)
                 -- Links: {url}[text] -> [text](url)
                 text = text:gsub("{([^}]+)}%[([^%]]+)%]", "[%2](%1)")
                 -- Anchors: [text]{url} -> [text](url)
@@ -123,8 +378,13 @@ local function convert_norg_to_markdown(norg_file)
                 local indent_level = math.max(0, (#marker - 1) * 2)
                 -- Check if task is completed (x) or unchecked (empty or just spaces)
                 local checkbox = (status == "" or status:match("^%s*$")) and "[ ]" or "[x]"
+                -- CommonMark has no task lists; escaped, the checkbox stays literal text
+                if flavor == "commonmark" then
+                    checkbox = "\\" .. checkbox:sub(1, 2) .. "\\]"
+                end
                 local md_line = string.rep(" ", indent_level) .. "- " .. checkbox .. " " .. text
-                table.insert(markdown_lines, md_line)
+                local task = { file = file, level = #marker, status = status, done = checkbox:find("x", 1, true) ~= nil, text = text }
+                insert_markdown(markdown_lines, apply_hook("task", task, md_line))
                 goto continue
             end
         end
@@ -138,7 +398,7 @@ local function convert_norg_to_markdown(norg_file)
                 text = text:gsub("{%*%s*(.-)%s*%*}", "**%1**")
                 text = text:gsub("{/%s*(.-)%s*/}", "*%1*")
                 text = text:gsub("{`%s*(.-)%s*`}", "`%1`")
-                text = text:gsub("{%-%s*(.-)%s*%-}", "~~%1~~")
+                text = text:gsub("{%-%s*(.-)%s*%-}", strikethrough)
                 -- Links: {url}[text] -> [text](url)
                 text = text:gsub("{([^}]+)}%[([^%]]+)%]", "[%2](%1)")
                 -- Anchors: [text]{url} -> [text](url)
@@ -150,7 +410,8 @@ local function convert_norg_to_markdown(norg_file)
                 local indent_level = math.max(0, (#marker - 1) * 2)
                 local list_char = marker:match("^%-") and "-" or "1."
                 local md_line = string.rep(" ", indent_level) .. list_char .. " " .. text
-                table.insert(markdown_lines, md_line)
+                local item = { file = file, level = #marker, ordered = list_char ~= "-", text = text }
+                insert_markdown(markdown_lines, apply_hook("list_item", item, md_line))
                 goto continue
             end
         end
@@ -164,7 +425,7 @@ local function convert_norg_to_markdown(norg_file)
         -- Code: {` text `} -> `text`
         converted_line = converted_line:gsub("{`%s*(.-)%s*`}", "`%1`")
         -- Strikethrough: {- text -} -> ~~text~~
-        converted_line = converted_line:gsub("{%-%s*(.-)%s*%-}", "~~%1~~")
+        converted_line = converted_line:gsub("{%-%s*(.-)%s*%-}", strikethrough)
         -- Links: {url}[text] -> [text](url)
         converted_line = converted_line:gsub("{([^}]+)}%[([^%]]+)%]", "[%2](%1)")
         -- Anchors: [text]{url} -> [text](url)
@@ -173,10 +434,20 @@ local function convert_norg_to_markdown(norg_file)
         converted_line = converted_line:gsub("{(https?://[^}]+)}", "[%1](%1)")
         converted_line = converted_line:gsub("{(file://[^}]+)}", "[%1](%1)")
         
-        table.insert(markdown_lines, converted_line)
+        if converted_line:match("^%s*$") then
+            table.insert(markdown_lines, converted_line)
+        else
+            insert_markdown(markdown_lines, apply_hook("paragraph", { file = file, text = line }, converted_line))
+        end
         
         ::continue::
     end
+
+    if hooks.document then
+        local document = apply_hook("document", { file = file }, table.concat(markdown_lines, "\n"))
+        markdown_lines = {}
+        insert_markdown(markdown_lines, document)
+    end
     
     return markdown_lines
 end
@@ -192,23 +463,33 @@ end
 if #norg_files == 0 then
     print("No .norg files found - creating a sample markdown file")
     fileio.write_to_wiki("README", {
-        "# No Neorg Files Found",
+        "# " .. strings.no_files_title,
         "",
-        "This directory did not contain any `.norg` files to convert.",
+        strings.no_files_body,
         "",
-        "To use this converter, include `.norg` files in your project archive.",
+        strings.no_files_hint,
     })
 else
     for _, norg_file in ipairs(norg_files) do
-        local markdown_content = convert_norg_to_markdown(norg_file)
-        
-        if markdown_content then
-            -- Generate output filename
-            local base_name = norg_file:match("([^/]+)%.norg$") or "unknown"
-            
-            print("DEBUG: Writing markdown to " .. base_name .. ".md")
-            fileio.write_to_wiki(base_name, markdown_content)
+        converting_line = 0
+        local started = vim.loop.hrtime()
+        local ok, result = pcall(function()
+            local markdown_content = convert_norg_to_markdown(norg_file)
+
+            if markdown_content then
+                -- Mirror the source tree; the service applies the requested output layout afterwards
+                local relative_name = norg_file:gsub("^%.%./", ""):gsub("%.norg$", "")
+
+                print("DEBUG: Writing markdown to " .. relative_name .. ".md")
+                fileio.write_to_wiki(relative_name, markdown_content)
+            else
+                report_error(norg_file, 0, "could not read file")
+            end
+        end)
+        if not ok then
+            report_error(norg_file, converting_line, tostring(result))
         end
+        record_timing(norg_file, started)
     end
 end
 
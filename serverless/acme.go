@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMETLSConfig returns a TLS configuration obtaining and renewing certificates from Let's
+// Encrypt, or another ACME directory, for the hosts in ACME_DOMAINS, or nil when it is unset.
+// Challenges are answered over TLS-ALPN on the HTTPS port, and over HTTP on ACME_HTTP_ADDR when
+// set, which also redirects other requests to HTTPS.
+func newACMETLSConfig() *tls.Config {
+	var domains []string
+	for _, domain := range strings.Split(getEnv("ACME_DOMAINS", ""), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		return nil
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(getEnv("ACME_CACHE_DIR", "/app/data/acme")),
+		Email:      getEnv("ACME_EMAIL", ""),
+	}
+	if directory := getEnv("ACME_DIRECTORY_URL", ""); directory != "" {
+		manager.Client = &acme.Client{DirectoryURL: directory}
+	}
+
+	if addr := getEnv("ACME_HTTP_ADDR", ""); addr != "" {
+		go func() {
+			if err := http.ListenAndServe(addr, manager.HTTPHandler(nil)); err != nil {
+				logger.WithError(err).WithField("addr", addr).Error("ACME HTTP challenge server stopped")
+			}
+		}()
+	}
+	logger.WithField("domains", domains).Info("Obtaining TLS certificates with ACME")
+
+	config := manager.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return config
+}
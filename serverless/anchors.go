@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Heading anchor schemes selected with ?anchors
+const (
+	// anchorsGitHub lowercases, drops punctuation and turns every space into a hyphen
+	anchorsGitHub = "github"
+	// anchorsGitLab is GitHub's scheme with runs of hyphens collapsed into one
+	anchorsGitLab = "gitlab"
+	// anchorsCustom lowercases and joins the words with ?anchor_separator
+	anchorsCustom = "custom"
+)
+
+var anchorSchemes = map[string]bool{anchorsGitHub: true, anchorsGitLab: true, anchorsCustom: true}
+
+// anchorSeparators are the separators ?anchor_separator accepts for custom anchors
+var anchorSeparators = map[string]bool{"-": true, "_": true, ".": true}
+
+// validAnchors reports whether name is a supported ?anchors value
+func validAnchors(name string) bool {
+	return anchorSchemes[name]
+}
+
+// parseAnchorSeparator checks an ?anchor_separator value
+func parseAnchorSeparator(value string) (string, error) {
+	if !anchorSeparators[value] {
+		return "", fmt.Errorf("unknown anchor_separator %q (available: -, _, .)", value)
+	}
+	return value, nil
+}
+
+// anchorFunc returns the function turning a Norg link's heading part ("* Heading") into an
+// anchor under the requested scheme, GitHub's by default
+func anchorFunc(options ConversionOptions) func(heading string) string {
+	return func(heading string) string {
+		heading = strings.ToLower(strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(heading), "*#")))
+		if heading == "" {
+			return ""
+		}
+		switch options.Anchors {
+		case anchorsGitLab:
+			return gitLabAnchor(heading)
+		case anchorsCustom:
+			separator := options.AnchorSeparator
+			if separator == "" {
+				separator = "-"
+			}
+			return customAnchor(heading, separator)
+		default:
+			return gitHubAnchor(heading)
+		}
+	}
+}
+
+// gitHubAnchor builds the anchor GitHub gives a lowercased heading
+func gitHubAnchor(heading string) string {
+	var anchor strings.Builder
+	for _, r := range heading {
+		switch {
+		case r == ' ' || r == '-':
+			anchor.WriteRune('-')
+		case r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r > 127:
+			anchor.WriteRune(r)
+		}
+	}
+	return anchor.String()
+}
+
+// gitLabAnchor builds the anchor GitLab gives a lowercased heading
+func gitLabAnchor(heading string) string {
+	var anchor strings.Builder
+	for _, r := range heading {
+		switch {
+		case r == ' ' || r == '-':
+			if !strings.HasSuffix(anchor.String(), "-") {
+				anchor.WriteRune('-')
+			}
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			anchor.WriteRune(r)
+		}
+	}
+	return anchor.String()
+}
+
+// customAnchor joins the runs of letters and digits of a lowercased heading with separator
+func customAnchor(heading string, separator string) string {
+	words := strings.FieldsFunc(heading, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, separator)
+}
@@ -3,16 +3,26 @@ package main
 import (
 	"archive/tar"
 	"archive/zip"
+	"compress/flate"
 	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,6 +32,17 @@ import (
 
 var logger *logrus.Logger
 
+var (
+	// errNoDocumentation is returned when docgen ran but no workspace produced a wiki directory
+	errNoDocumentation = errors.New("no documentation was generated")
+	// errRootNotFound is returned when the requested root option does not exist in the archive
+	errRootNotFound = errors.New("root path not found in archive")
+	// errUnsafeLink is returned for archive links that point outside the archive
+	errUnsafeLink = errors.New("archive link points outside the archive")
+	// errInvalidArchive is returned when the upload cannot be read as a (compressed) tarball
+	errInvalidArchive = errors.New("invalid archive")
+)
+
 func init() {
 	logger = logrus.New()
 	
@@ -51,10 +72,16 @@ func init() {
 			TimestampFormat: time.RFC3339,
 		})
 	}
+
+	// Send logs to the configured sinks, keeping stderr when they cannot be set up
+	if err := configureLogOutput(); err != nil {
+		logger.SetOutput(os.Stderr)
+		logger.WithError(err).Error("Failed to configure log output, logging to stderr")
+	}
 	
 	logger.WithFields(logrus.Fields{
 		"service": "neorg-documentation-lambda",
-		"version": "1.0.0",
+		"version": buildInfo().Version,
 		"log_level": logLevel,
 	}).Info("Logger initialized")
 }
@@ -66,15 +93,45 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getEnvInt64 reads an integer environment variable, using fallback when unset or invalid
+func getEnvInt64(key string, fallback int64) int64 {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"key":      key,
+			"value":    value,
+			"fallback": fallback,
+		}).Warn("Invalid integer environment variable, using default")
+		return fallback
+	}
+	return parsed
+}
+
 type (
 	Response struct {
 		Error string `json:"error"`
-		Id    string `json:"id"`
+		// Code is the stable identifier of the failure, one of the ERR_ codes in errcodes.go
+		Code string `json:"code,omitempty"`
+		Id   string `json:"id"`
+		// Debug is the docgen output of a failed conversion, for requests with ?debug=1
+		Debug *DebugOutput `json:"debug,omitempty"`
+	}
+
+	// HealthStatus is the /health response for clients accepting JSON
+	HealthStatus struct {
+		Status string      `json:"status"`
+		Error  string      `json:"error,omitempty"`
+		Queue  QueueStatus `json:"queue"`
 	}
 
 	ConversionResult struct {
 		Files []string `json:"files"`
 		Error string  `json:"error,omitempty"`
+		Code  string  `json:"code,omitempty"`
 		Id    string  `json:"id"`
 	}
 )
@@ -83,6 +140,7 @@ func Unauthorized(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusUnauthorized)
 	unauthorized := map[string]any{
 		"error": "Unauthorized",
+		"code":  codeUnauthorized,
 	}
 	unauthorizedJson, err := json.Marshal(unauthorized)
 	if err != nil {
@@ -93,115 +151,640 @@ func Unauthorized(w http.ResponseWriter, r *http.Request) {
 	w.Write(unauthorizedJson)
 }
 
+// tokenPermissions are what a request may do beyond converting, decided by its auth token
+type tokenPermissions struct {
+	// LuaHooks lets the request's workspace run its docgen_hooks.lua
+	LuaHooks bool
+}
 
-// Extract tarball and generate documentation using make documentation
-func generateDocumentation(ctx context.Context, tarballData []byte, requestId string) (string, error) {
-	// Create temporary directory for extraction
-	tempDir := fmt.Sprintf("/tmp/neorg_%s", requestId)
+// authorize checks the x-auth-token header against the configured tokens and returns the
+// permissions it grants. Besides NEORG_DOCUMENTATION_AUTH_TOKEN, every token listed in
+// LUA_HOOKS_AUTH_TOKENS authenticates a request; when that list is set, only its tokens may run
+// Lua hooks.
+func authorize(r *http.Request) (tokenPermissions, bool) {
+	token := r.Header.Get("x-auth-token")
+	if token == "" {
+		return tokenPermissions{}, false
+	}
+	hooksEnabled := getEnv("ALLOW_LUA_HOOKS", "false") == "true"
+	hookTokens := getSecret("LUA_HOOKS_AUTH_TOKENS")
+	for _, hookToken := range strings.Split(hookTokens, ",") {
+		hookToken = strings.TrimSpace(hookToken)
+		if hookToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(hookToken)) == 1 {
+			return tokenPermissions{LuaHooks: hooksEnabled}, true
+		}
+	}
+	expectedToken := getSecret("NEORG_DOCUMENTATION_AUTH_TOKEN")
+	if expectedToken != "" && token == expectedToken {
+		return tokenPermissions{LuaHooks: hooksEnabled && strings.TrimSpace(hookTokens) == ""}, true
+	}
+	return tokenPermissions{}, false
+}
+
+// isAuthorized checks the x-auth-token header against the configured tokens
+func isAuthorized(r *http.Request) bool {
+	_, ok := authorize(r)
+	return ok
+}
+
+// getEnvFloat reads a floating point environment variable, using fallback when unset or invalid
+func getEnvFloat(key string, fallback float64) float64 {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"key":      key,
+			"value":    value,
+			"fallback": fallback,
+		}).Warn("Invalid number environment variable, using default")
+		return fallback
+	}
+	return parsed
+}
+
+// Extract tarball and generate documentation using make documentation.
+// Returns the temporary directory to clean up and the output directory holding the assembled documentation.
+func generateDocumentation(ctx context.Context, archive *spooledArchive, requestId string, options ConversionOptions, progress *Progress) (string, string, error) {
+	startedOn := time.Now()
+
+	// Create temporary directory for extraction in $TMPDIR, which the disk readiness check watches,
+	// with a unique suffix since request IDs may come from the caller and repeat
+	tempDir, err := os.MkdirTemp("", "neorg_"+requestId+"_")
+	if err != nil {
+		logger.WithError(err).Error("Failed to create temporary directory")
+		return "", "", fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	sourceDir := filepath.Join(tempDir, "source")
+	outputDir := filepath.Join(tempDir, "output")
 	logger.WithFields(logrus.Fields{
 		"temp_dir": tempDir,
 		"request_id": requestId,
 	}).Debug("Creating temporary directory for project extraction")
 
-	err := os.MkdirAll(tempDir, 0755)
+	err = os.MkdirAll(sourceDir, 0755)
 	if err != nil {
 		logger.WithError(err).Error("Failed to create temporary directory")
-		return "", fmt.Errorf("failed to create temp directory: %v", err)
+		return "", "", fmt.Errorf("failed to create temp directory: %v", err)
 	}
 
-	// Extract tarball to temporary directory
-	err = extractTarball(tarballData, tempDir)
+	// Extract tarball to temporary directory within the extraction budget
+	progress.SetStage(stageExtract)
+	extractCtx, cancelExtract := withStageTimeout(ctx, stageExtract)
+	renames, err := extractTarball(extractCtx, archive, sourceDir, options, progress)
+	err = stageError(ctx, extractCtx, stageExtract, err)
+	cancelExtract()
 	if err != nil {
 		logger.WithError(err).Error("Failed to extract tarball")
 		os.RemoveAll(tempDir)
-		return "", fmt.Errorf("failed to extract tarball: %v", err)
+		return "", "", fmt.Errorf("failed to extract tarball: %w", err)
 	}
 
-	// Copy docgen files to the project directory
-	err = copyDocgenFiles(tempDir)
+	// Restrict conversion to the requested sub-path of the archive
+	searchDir := filepath.Join(sourceDir, filepath.FromSlash(options.Root))
+	if info, err := os.Stat(searchDir); err != nil || !info.IsDir() {
+		os.RemoveAll(tempDir)
+		return "", "", fmt.Errorf("%w: %s", errRootNotFound, options.Root)
+	}
+
+	// Locate the Neorg workspaces inside the extracted tree
+	workspaces, err := findWorkspaces(searchDir)
 	if err != nil {
-		logger.WithError(err).Error("Failed to copy docgen files")
+		logger.WithError(err).Error("Failed to detect workspace root")
 		os.RemoveAll(tempDir)
-		return "", fmt.Errorf("failed to copy docgen files: %v", err)
+		return "", "", fmt.Errorf("failed to detect workspace root: %v", err)
 	}
 
-	// Run make documentation in the project directory
-	err = runMakeDocumentation(ctx, tempDir)
+	_, norgFiles := progress.Counts()
+	logger.WithFields(logrus.Fields{
+		"request_id": requestId,
+		"workspaces": len(workspaces),
+		"norg_files": norgFiles,
+	}).Info("Detected Neorg workspaces")
+
+	sources, err := sourceDigests(searchDir)
 	if err != nil {
-		logger.WithError(err).Error("Failed to run make documentation")
+		logger.WithError(err).Error("Failed to hash source files")
+		os.RemoveAll(tempDir)
+		return "", "", fmt.Errorf("failed to hash source files: %v", err)
+	}
+
+	// Every workspace is converted within the docgen budget
+	progress.SetStage(stageDocgen)
+	docgenCtx, cancelDocgen := withStageTimeout(ctx, stageDocgen)
+	defer cancelDocgen()
+	manifest := Manifest{Id: requestId, Renames: renames, Sources: sources}
+	reports := map[string]*SourceReport{}
+	if options.Reproducible {
+		// The request ID differs between runs, the input does not
+		manifest.Id = "sha256:" + archive.Digest()
+	}
+	if len(renames) > 0 {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"renamed":    len(renames),
+		}).Info("Renamed non-portable archive entries")
+	}
+	for _, workspace := range workspaces {
+		// A broken page template fails the request before anything is converted
+		pageTemplate, err := loadPageTemplate(workspace)
+		if err != nil {
+			os.RemoveAll(tempDir)
+			return "", "", err
+		}
+
+		err = convertWorkspace(docgenCtx, workspace, options, progress)
+		err = stageError(ctx, docgenCtx, stageDocgen, err)
+
+		// Files that failed on their own are listed and the rest delivered, unless strict
+		var fileErr *fileConversionError
+		if errors.As(err, &fileErr) {
+			failed := prefixFileErrors(fileErr.Files, workspace.Name)
+			manifest.FileErrors = append(manifest.FileErrors, failed...)
+			for _, failure := range failed {
+				progress.Warn("failed to convert " + failure.String())
+			}
+			logger.WithFields(logrus.Fields{
+				"request_id": requestId,
+				"workspace":  workspace.Name,
+				"failed":     len(failed),
+				"strict":     options.Strict,
+			}).Warn("Some files failed to convert")
+			err = nil
+			if options.Strict {
+				os.RemoveAll(tempDir)
+				return "", "", &fileConversionError{Files: manifest.FileErrors}
+			}
+		}
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"request_id": requestId,
+				"workspace":  workspace.Name,
+				"error":      err.Error(),
+			}).Error("Failed to convert workspace")
+			os.RemoveAll(tempDir)
+			return "", "", err
+		}
+
+		pages, err := applyOutputLayout(filepath.Join(workspace.Dir, "wiki"), options.Layout, anchorFunc(options))
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"request_id": requestId,
+				"workspace":  workspace.Name,
+				"layout":     options.Layout,
+				"error":      err.Error(),
+			}).Error("Failed to apply output layout")
+			os.RemoveAll(tempDir)
+			return "", "", fmt.Errorf("%w: workspace %q: %v", errLayoutFailed, workspace.Name, err)
+		}
+
+		// Images and attachments the pages link to are delivered with them
+		if pages != nil {
+			err = copyReferencedAssets(workspace, filepath.Join(workspace.Dir, "wiki"), pages)
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"request_id": requestId,
+					"workspace":  workspace.Name,
+					"error":      err.Error(),
+				}).Error("Failed to copy referenced assets")
+				os.RemoveAll(tempDir)
+				return "", "", fmt.Errorf("failed to copy referenced assets: %v", err)
+			}
+		}
+
+		// Pages are indexed for search before backlinks and the table of contents are added
+		var searchIndex []SearchDocument
+		if options.SearchIndex && pages != nil {
+			searchIndex, err = buildSearchIndex(filepath.Join(workspace.Dir, "wiki"))
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"request_id": requestId,
+					"workspace":  workspace.Name,
+					"error":      err.Error(),
+				}).Error("Failed to build search index")
+				os.RemoveAll(tempDir)
+				return "", "", fmt.Errorf("failed to build search index: %v", err)
+			}
+		}
+
+		if options.Frontmatter != "" && pages != nil {
+			err = writeFrontmatter(workspace, filepath.Join(workspace.Dir, "wiki"), pages, options.Frontmatter)
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"request_id": requestId,
+					"workspace":  workspace.Name,
+					"error":      err.Error(),
+				}).Error("Failed to write frontmatter")
+				os.RemoveAll(tempDir)
+				return "", "", fmt.Errorf("failed to write frontmatter: %v", err)
+			}
+		}
+
+		// The link graph is read before backlinks and the table of contents add links of their own
+		var links, backlinks map[string][]string
+		if (options.Backlinks != "" || options.LinkMap) && pages != nil {
+			links, err = pageLinks(filepath.Join(workspace.Dir, "wiki"), options.Layout)
+			if err == nil && options.Backlinks != "" {
+				backlinks = invertLinks(links)
+				if options.Backlinks != backlinksJSON {
+					err = appendBacklinkSections(filepath.Join(workspace.Dir, "wiki"), backlinks, options)
+				}
+			}
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"request_id": requestId,
+					"workspace":  workspace.Name,
+					"error":      err.Error(),
+				}).Warn("Failed to build link graph")
+				warning := fmt.Sprintf("workspace %q has no backlinks or link map: %v", workspace.Name, err)
+				manifest.Warnings = append(manifest.Warnings, warning)
+				progress.Warn(warning)
+				links, backlinks = nil, nil
+			}
+		}
+
+		if options.TOC != "" && pages != nil {
+			err = writeTableOfContents(workspace, filepath.Join(workspace.Dir, "wiki"), pages, options)
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"request_id": requestId,
+					"workspace":  workspace.Name,
+					"error":      err.Error(),
+				}).Warn("Failed to write table of contents")
+				warning := fmt.Sprintf("workspace %q has no table of contents: %v", workspace.Name, err)
+				manifest.Warnings = append(manifest.Warnings, warning)
+				progress.Warn(warning)
+			}
+		}
+
+		if pageTemplate != nil && pages != nil {
+			err = applyPageTemplate(docgenCtx, pageTemplate, workspace, filepath.Join(workspace.Dir, "wiki"), pages, options)
+			err = stageError(ctx, docgenCtx, stageDocgen, err)
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"request_id": requestId,
+					"workspace":  workspace.Name,
+					"error":      err.Error(),
+				}).Error("Failed to apply page template")
+				os.RemoveAll(tempDir)
+				return "", "", err
+			}
+		}
+
+		if transform := outputFormat(options.Output).Transform; transform != nil {
+			err = transform(filepath.Join(workspace.Dir, "wiki"))
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"request_id": requestId,
+					"workspace":  workspace.Name,
+					"output":     options.Output,
+					"error":      err.Error(),
+				}).Error("Failed to transform generated documentation for the output format")
+				os.RemoveAll(tempDir)
+				return "", "", err
+			}
+		}
+
+		if rewritesLinks(options) {
+			err = rewritePageLinks(filepath.Join(workspace.Dir, "wiki"), outputFormat(options.Output), options)
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"request_id": requestId,
+					"workspace":  workspace.Name,
+					"error":      err.Error(),
+				}).Error("Failed to rewrite links between pages")
+				os.RemoveAll(tempDir)
+				return "", "", fmt.Errorf("failed to rewrite links: %v", err)
+			}
+		}
+
+		// Link graphs are written once the output format has settled the page paths
+		graphs := map[string]map[string][]string{}
+		if backlinks != nil && options.Backlinks != backlinksSection {
+			graphs[backlinksFileName] = backlinks
+		}
+		if links != nil && options.LinkMap {
+			graphs[linkMapFileName] = links
+		}
+		for fileName, graph := range graphs {
+			err = writeLinksFile(filepath.Join(workspace.Dir, "wiki"), fileName, graph, outputFormat(options.Output))
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"request_id": requestId,
+					"workspace":  workspace.Name,
+					"file":       fileName,
+					"error":      err.Error(),
+				}).Error("Failed to write link graph")
+				os.RemoveAll(tempDir)
+				return "", "", fmt.Errorf("failed to write %s: %v", fileName, err)
+			}
+		}
+
+
+		if searchIndex != nil {
+			err = writeSearchIndex(filepath.Join(workspace.Dir, "wiki"), searchIndex, outputFormat(options.Output))
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"request_id": requestId,
+					"workspace":  workspace.Name,
+					"error":      err.Error(),
+				}).Error("Failed to write search index")
+				os.RemoveAll(tempDir)
+				return "", "", fmt.Errorf("failed to write search index: %v", err)
+			}
+		}
+
+		entry, err := collectWorkspaceOutput(workspace, outputDir, len(workspaces) > 1)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"request_id": requestId,
+				"workspace":  workspace.Name,
+				"error":      err.Error(),
+			}).Warn("Workspace produced no documentation")
+			warning := fmt.Sprintf("workspace %q produced no documentation", workspace.Name)
+			manifest.Warnings = append(manifest.Warnings, warning)
+			progress.Warn(warning)
+			continue
+		}
+		manifest.Workspaces = append(manifest.Workspaces, entry)
+		for source, report := range workspaceSourceReports(workspace, pages, entry, options) {
+			reports[source] = report
+		}
+	}
+
+	// A result whose every file failed to convert contains nothing worth delivering
+	generated := 0
+	for _, entry := range manifest.Workspaces {
+		generated += len(entry.Files)
+	}
+	if len(manifest.Workspaces) == 0 || (generated == 0 && len(manifest.FileErrors) > 0) {
 		os.RemoveAll(tempDir)
-		return "", fmt.Errorf("failed to generate documentation: %v", err)
+		if len(manifest.FileErrors) > 0 {
+			return "", "", fmt.Errorf("%w: %w", errNoDocumentation, &fileConversionError{Files: manifest.FileErrors})
+		}
+		return "", "", errNoDocumentation
 	}
 
-	return tempDir, nil
+	// Sites published at BaseURL get a sitemap of every delivered page
+	if options.BaseURL != "" {
+		err = writeSitemap(outputDir, options.BaseURL, manifest.Workspaces, outputFormat(options.Output))
+		if err != nil {
+			logger.WithError(err).Error("Failed to write sitemap")
+			os.RemoveAll(tempDir)
+			return "", "", fmt.Errorf("failed to write sitemap: %v", err)
+		}
+	}
+
+	manifest.Digests, err = outputDigests(outputDir)
+	if err != nil {
+		logger.WithError(err).Error("Failed to hash generated files")
+		os.RemoveAll(tempDir)
+		return "", "", fmt.Errorf("failed to hash generated files: %v", err)
+	}
+
+	// List what every source produced before the delta drops unchanged files
+	err = writeConversionReport(outputDir, buildConversionReport(manifest, reports))
+	if err != nil {
+		logger.WithError(err).Error("Failed to write conversion report")
+		os.RemoveAll(tempDir)
+		return "", "", fmt.Errorf("failed to write conversion report: %v", err)
+	}
+
+	// Return only what changed since the client's previous result
+	if options.Baseline != nil {
+		manifest.Delta, err = applyDelta(outputDir, manifest.Digests, options.Baseline)
+		if err != nil {
+			logger.WithError(err).Error("Failed to compute delta output")
+			os.RemoveAll(tempDir)
+			return "", "", fmt.Errorf("failed to compute delta output: %v", err)
+		}
+		if options.Baseline.Sources != nil {
+			manifest.Delta.Reconverted = changedSources(sources, options.Baseline.Sources)
+		}
+		if reason := pageReuseUnavailable(reports); reason != "" {
+			manifest.Delta.FullConversion = reason
+			manifest.Delta.Reconverted = changedSources(sources, nil)
+			warning := "every source was converted again: " + reason
+			manifest.Warnings = append(manifest.Warnings, warning)
+			progress.Warn(warning)
+		}
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"baseline":   options.Baseline.Id,
+			"changed":    len(manifest.Delta.Changed),
+			"deleted":    len(manifest.Delta.Deleted),
+			"unchanged":  manifest.Delta.Unchanged,
+		}).Info("Computed delta against baseline manifest")
+	}
+
+	err = writeManifest(outputDir, manifest)
+	if err != nil {
+		logger.WithError(err).Error("Failed to write manifest")
+		os.RemoveAll(tempDir)
+		return "", "", fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	// Record how the output was produced so published artifacts can be traced to their inputs
+	err = writeProvenance(outputDir, archive, requestId, options, startedOn)
+	if err != nil {
+		logger.WithError(err).Error("Failed to write provenance")
+		os.RemoveAll(tempDir)
+		return "", "", fmt.Errorf("failed to write provenance: %v", err)
+	}
+
+	return tempDir, outputDir, nil
 }
 
-// Extract tarball to specified directory (supports both .tar and .tar.gz)
-func extractTarball(tarballData []byte, destDir string) error {
-	var tarReader *tar.Reader
-	
-	// Check if the data is gzip-compressed by trying to create a gzip reader
-	dataReader := bytes.NewReader(tarballData)
-	gzipReader, err := gzip.NewReader(dataReader)
-	if err != nil {
-		// Not gzip-compressed, treat as plain tar
-		logger.Debug("Archive is not gzip-compressed, treating as plain tar")
-		dataReader.Seek(0, 0) // Reset reader position
-		tarReader = tar.NewReader(dataReader)
-	} else {
-		// Gzip-compressed, decompress first
-		logger.Debug("Archive is gzip-compressed, decompressing before tar extraction")
-		defer gzipReader.Close()
-		tarReader = tar.NewReader(gzipReader)
+// Extract tarball to specified directory (supports .tar, .tar.gz, .tar.zst and .tar.xz).
+// Entries outside the root option or matching an exclude pattern, and .norg files no include
+// pattern matches, are skipped; with neither, everything is extracted.
+// Every extracted .norg file is counted towards the progress estimate.
+// Entry names that are not valid UTF-8 or not portable are percent-encoded; the renames are returned.
+// Symbolic and hard links are recreated when they resolve inside the archive and refused otherwise.
+func extractTarball(ctx context.Context, archive *spooledArchive, destDir string, options ConversionOptions, progress *Progress) ([]FileRename, error) {
+	// Sniff the compression (gzip, zstd, xz or none) and decompress while reading the tar stream
+	decompressed, closeReader, err := decompressArchive(archive)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidArchive, err)
 	}
-	
+	defer closeReader()
+	tarReader := tar.NewReader(decompressed)
+	limits := newExtractionLimits(archive.Size())
+
+	names := newArchiveNames()
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("error reading tar: %v", err)
+			return nil, fmt.Errorf("%w: error reading tar: %v", errInvalidArchive, err)
+		}
+
+		// PAX global headers (as written by git archive) carry defaults rather than a file; PAX
+		// extended headers and GNU long names were already applied to header by the reader
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		// "./" entries, as written by tar -C dir ., stand for the destination itself
+		if path.Clean("/"+header.Name) == "/" {
+			continue
+		}
+		if !extractsEntry(header.Name, options) {
+			continue
 		}
 
 		targetPath := filepath.Join(destDir, header.Name)
 		
 		// Ensure the target path is within destDir (security check)
 		if !strings.HasPrefix(targetPath, filepath.Clean(destDir)+string(os.PathSeparator)) {
-			return fmt.Errorf("invalid file path: %s", header.Name)
+			return nil, fmt.Errorf("invalid file path: %q", header.Name)
 		}
+		targetPath = filepath.Join(destDir, filepath.FromSlash(names.Portable(header.Name)))
 
 		switch header.Typeflag {
 		case tar.TypeDir:
+			if err := limits.admit(header.Name, 0); err != nil {
+				return nil, err
+			}
 			err = os.MkdirAll(targetPath, os.FileMode(header.Mode))
 			if err != nil {
-				return fmt.Errorf("error creating directory %s: %v", targetPath, err)
+				return nil, fmt.Errorf("error creating directory %s: %v", targetPath, err)
+			}
+		case tar.TypeReg, tar.TypeGNUSparse:
+			// Refuse entries that would expand the archive beyond the extraction limits
+			if err := limits.admit(header.Name, header.Size); err != nil {
+				return nil, err
 			}
-		case tar.TypeReg:
+
 			// Ensure parent directory exists
-			err = os.MkdirAll(filepath.Dir(targetPath), 0755)
+			_, err = extractionParent(destDir, targetPath)
 			if err != nil {
-				return fmt.Errorf("error creating parent directory for %s: %v", targetPath, err)
+				return nil, err
 			}
 			
-			file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
+			// A later entry replaces an earlier one with the same name; removing it first keeps the
+			// write from going through a hard link into the file the link shares
+			if err := os.Remove(targetPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("error replacing file %s: %v", targetPath, err)
+			}
+			file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(header.Mode))
 			if err != nil {
-				return fmt.Errorf("error creating file %s: %v", targetPath, err)
+				return nil, fmt.Errorf("error creating file %s: %v", targetPath, err)
 			}
 			
 			_, err = io.Copy(file, tarReader)
 			file.Close()
 			if err != nil {
-				return fmt.Errorf("error writing file %s: %v", targetPath, err)
+				return nil, fmt.Errorf("error writing file %s: %v", targetPath, err)
+			}
+
+			if strings.HasSuffix(header.Name, ".norg") {
+				progress.AddTotal(1)
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			if err := limits.admit(header.Name, 0); err != nil {
+				return nil, err
+			}
+			err = extractLink(header, destDir, targetPath, names)
+			if err != nil {
+				return nil, err
+			}
+			if strings.HasSuffix(header.Name, ".norg") {
+				progress.AddTotal(1)
 			}
 		}
 	}
 	
+	return names.Renames, nil
+}
+
+// extractionParent creates the parent directory of targetPath and returns its real path, failing
+// when an earlier link made it resolve outside destDir
+func extractionParent(destDir string, targetPath string) (string, error) {
+	parent := filepath.Dir(targetPath)
+	err := os.MkdirAll(parent, 0755)
+	if err != nil {
+		return "", fmt.Errorf("error creating parent directory for %s: %v", targetPath, err)
+	}
+	realParent, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", fmt.Errorf("error resolving parent directory for %s: %v", targetPath, err)
+	}
+	realDest, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return "", err
+	}
+	if realParent != realDest && !strings.HasPrefix(realParent, realDest+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%w: %q", errUnsafeLink, targetPath)
+	}
+	return realParent, nil
+}
+
+// extractLink recreates a symbolic or hard link entry at targetPath. The link target is resolved
+// within the archive, so it must not be absolute or climb above the archive root, and is mapped to
+// its portable name. Symlinks are written relative to their real parent directory so they resolve
+// to the same entry however earlier links arranged the tree.
+func extractLink(header *tar.Header, destDir string, targetPath string, names *archiveNames) error {
+	linkName := filepath.ToSlash(header.Linkname)
+	if header.Typeflag == tar.TypeSymlink {
+		if path.IsAbs(linkName) {
+			return fmt.Errorf("%w: %q links to %q", errUnsafeLink, header.Name, header.Linkname)
+		}
+		linkName = path.Join(path.Dir(strings.TrimPrefix(path.Clean("/"+header.Name), "/")), linkName)
+	}
+	linkName = path.Clean(linkName)
+	if linkName == ".." || strings.HasPrefix(linkName, "../") || path.IsAbs(linkName) {
+		return fmt.Errorf("%w: %q links to %q", errUnsafeLink, header.Name, header.Linkname)
+	}
+	linkTarget := destDir
+	if linkName != "." {
+		linkTarget = filepath.Join(destDir, filepath.FromSlash(names.Portable(linkName)))
+	}
+
+	realParent, err := extractionParent(destDir, targetPath)
+	if err != nil {
+		return err
+	}
+	linkPath := filepath.Join(realParent, filepath.Base(targetPath))
+	os.Remove(linkPath)
+
+	if header.Typeflag == tar.TypeLink {
+		// Hard links may only share regular files extracted earlier
+		info, err := os.Lstat(linkTarget)
+		if err != nil || !info.Mode().IsRegular() {
+			return fmt.Errorf("invalid hard link %q to %q", header.Name, header.Linkname)
+		}
+		if err := os.Link(linkTarget, linkPath); err != nil {
+			return fmt.Errorf("error creating hard link %s: %v", targetPath, err)
+		}
+		return nil
+	}
+
+	realDest, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return err
+	}
+	relative, err := filepath.Rel(realParent, filepath.Join(realDest, strings.TrimPrefix(linkTarget, destDir)))
+	if err != nil {
+		return fmt.Errorf("%w: %q links to %q", errUnsafeLink, header.Name, header.Linkname)
+	}
+	if err := os.Symlink(relative, linkPath); err != nil {
+		return fmt.Errorf("error creating symlink %s: %v", targetPath, err)
+	}
 	return nil
 }
 
-// Copy docgen files to the project directory
-func copyDocgenFiles(projectDir string) error {
+// docgenFiles are the scripts copied from ./docgen into every workspace the Neovim converter runs in
+var docgenFiles = []string{"init.lua", "docgen.lua", "fileio.lua", "minimal_init.vim", "simple_norg_converter.lua"}
+
+// Copy docgen files to the project directory, along with the translations for the requested locale
+func copyDocgenFiles(projectDir string, options ConversionOptions) error {
 	docgenDir := filepath.Join(projectDir, "docgen")
 	err := os.MkdirAll(docgenDir, 0755)
 	if err != nil {
@@ -209,7 +792,6 @@ func copyDocgenFiles(projectDir string) error {
 	}
 
 	// Copy files from ./docgen to projectDir/docgen
-	docgenFiles := []string{"init.lua", "docgen.lua", "fileio.lua", "minimal_init.vim", "simple_norg_converter.lua"}
 	for _, file := range docgenFiles {
 		srcPath := filepath.Join("./docgen", file)
 		destPath := filepath.Join(docgenDir, file)
@@ -220,6 +802,40 @@ func copyDocgenFiles(projectDir string) error {
 		}
 	}
 
+	// Write the locale strings the converter uses for generated scaffolding
+	localeJson, err := json.Marshal(localeStrings(options.Locale))
+	if err != nil {
+		return fmt.Errorf("failed to encode locale strings: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(docgenDir, "locale.json"), localeJson, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write locale strings: %v", err)
+	}
+
+	// Write the Neorg modules docgen is allowed to load
+	err = writeNeorgModulePolicy(docgenDir, options)
+	if err != nil {
+		return fmt.Errorf("failed to write Neorg module policy: %v", err)
+	}
+
+	// Stage the user's Neorg configuration overlay, if the workspace has one
+	staged, err := stageUserConfig(projectDir, docgenDir)
+	if err != nil {
+		return err
+	}
+	if staged {
+		logger.WithField("project_dir", projectDir).Info("Applying user " + userConfigFileName + " overlay")
+	}
+
+	// Stage the user's conversion hooks, if the workspace has them and the client may run them
+	staged, err = stageLuaHooks(projectDir, docgenDir, options.LuaHooks)
+	if err != nil {
+		return err
+	}
+	if staged {
+		logger.WithField("project_dir", projectDir).Info("Running user " + luaHooksFileName + " hooks")
+	}
+
 	// Create Makefile in project directory
 	makefilePath := filepath.Join(projectDir, "Makefile")
 	makefileContent := `documentation:
@@ -251,8 +867,9 @@ func copyFile(src, dest string) error {
 	return err
 }
 
-// Run make documentation in the specified directory
-func runMakeDocumentation(ctx context.Context, projectDir string) error {
+// Run make documentation in the specified directory, writing markdown of the given flavor with
+// the Neorg checkout in neorgDir ("" for the one lazy.nvim manages)
+func runMakeDocumentation(ctx context.Context, projectDir string, flavor string, neorgDir string) error {
 	logger.WithFields(logrus.Fields{
 		"project_dir": projectDir,
 	}).Debug("Running make documentation")
@@ -265,14 +882,25 @@ func runMakeDocumentation(ctx context.Context, projectDir string) error {
 		"XDG_CONFIG_HOME=/app",
 		"XDG_DATA_HOME=/app/data",
 		"HOME=/app",
+		"NEORG_DOCGEN_MODULES="+filepath.Join(projectDir, "docgen", neorgModulesFileName),
+		"NEORG_DOCGEN_CONFIG="+filepath.Join(projectDir, "docgen", userConfigFileName),
+		"NEORG_DOCGEN_ERRORS="+filepath.Join(projectDir, "docgen", docgenErrorsFileName),
+		"NEORG_DOCGEN_TIMINGS="+timingsFile(projectDir),
+		"NEORG_DOCGEN_FLAVOR="+flavor,
 	)
+	if neorgDir != "" {
+		cmd.Env = append(cmd.Env, "NEORG_DOCGEN_NEORG="+neorgDir)
+	}
 	
 	// Capture command output for debugging
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	
-	err := cmd.Run()
+	// Run make in its own process group under the sandbox limits, killing the group on timeout
+	sandbox := sandboxConfigFromEnv()
+	sandbox.WritableDir = projectDir
+	err := runSandboxed(cmd, sandbox)
 	if err != nil {
 		logger.WithFields(logrus.Fields{
 			"project_dir": projectDir,
@@ -280,7 +908,7 @@ func runMakeDocumentation(ctx context.Context, projectDir string) error {
 			"stdout":      stdout.String(),
 			"stderr":      stderr.String(),
 		}).Error("Make documentation command failed")
-		return err
+		return &docgenOutputError{Err: err, Stdout: stdout.String(), Stderr: stderr.String()}
 	}
 	
 	logger.WithFields(logrus.Fields{
@@ -292,12 +920,12 @@ func runMakeDocumentation(ctx context.Context, projectDir string) error {
 	return nil
 }
 
-// Get the tarball of the neorg project from the request body
-func getTarballData(r *http.Request) ([]byte, error) {
+// Spool the tarball of the neorg project from the request body to disk
+func getTarballData(r *http.Request) (*spooledArchive, error) {
 	logger.Debug("Reading tarball from request body")
 	
-	// Read the tarball from the request body
-	body, err := io.ReadAll(r.Body)
+	// Stream the request body to a spool file rather than buffering it in memory
+	archive, err := spoolArchive(r.Body)
 	if err != nil {
 		logger.WithFields(logrus.Fields{
 			"error": err.Error(),
@@ -306,27 +934,26 @@ func getTarballData(r *http.Request) ([]byte, error) {
 	}
 
 	logger.WithFields(logrus.Fields{
-		"body_size": len(body),
+		"body_size": archive.Size(),
 	}).Debug("Request body read successfully")
 
-	// Basic validation - check if it looks like a tar or tar.gz file
-	if len(body) < 512 {
+	// Basic validation - check if it looks like a plain or compressed tar file
+	compression := archiveCompression(archive.head)
+	if compression == compressionNone && archive.Size() < 512 {
+		archive.Close()
 		return nil, fmt.Errorf("file too small to be a valid tarball")
 	}
 	
 	// Check for common archive signatures
 	isValidArchive := false
-	if len(body) >= 3 {
-		// Check for gzip magic number (1f 8b)
-		if body[0] == 0x1f && body[1] == 0x8b {
-			logger.Debug("Detected gzip-compressed archive")
-			isValidArchive = true
-		}
+	if compression != compressionNone {
+		logger.WithField("compression", compression).Debug("Detected compressed archive")
+		isValidArchive = true
 	}
-	if len(body) >= 512 {
+	if len(archive.head) >= 512 {
 		// Check for tar file (look for ustar magic in tar header)
 		// The ustar magic is at offset 257-261 in a tar header
-		if bytes.Contains(body[257:262], []byte("ustar")) {
+		if bytes.Contains(archive.head[257:262], []byte("ustar")) {
 			logger.Debug("Detected uncompressed tar archive")
 			isValidArchive = true
 		}
@@ -337,13 +964,19 @@ func getTarballData(r *http.Request) ([]byte, error) {
 		// Still allow the file through - the extraction function will handle format detection
 	}
 
-	return body, nil
+	return archive, nil
 }
 
 
 // createZipArchive creates a zip file containing all the generated wiki files
-func createZipArchive(wikiDir string, requestId string) (string, error) {
-	zipFileName := fmt.Sprintf("documentation_%s.zip", requestId)
+func createZipArchive(ctx context.Context, wikiDir string, requestId string, options ConversionOptions) (string, error) {
+	zipFileName := resultFileName(requestId, ".zip")
+
+	// Refuse to package output beyond the configured cap before writing anything
+	err := checkOutputSize(wikiDir, getEnvInt64("MAX_OUTPUT_BYTES", defaultMaxOutputBytes))
+	if err != nil {
+		return "", err
+	}
 	
 	logger.WithFields(logrus.Fields{
 		"request_id":     requestId,
@@ -362,8 +995,36 @@ func createZipArchive(wikiDir string, requestId string) (string, error) {
 	}
 	defer zipFile.Close()
 
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+	fileCount, totalBytesAdded, err := writeZipArchive(ctx, zipFile, wikiDir, requestId, options)
+	if err == nil {
+		err = zipFile.Close()
+	}
+	if err != nil {
+		os.Remove(zipFileName)
+		return "", err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"request_id":        requestId,
+		"zip_filename":      zipFileName,
+		"files_added":       fileCount,
+		"total_bytes_added": totalBytesAdded,
+	}).Info("ZIP archive creation completed successfully")
+
+	return zipFileName, nil
+}
+
+// writeZipArchive writes all files under wikiDir as a zip archive to out and reports how many
+// files and bytes were added
+func writeZipArchive(ctx context.Context, out io.Writer, wikiDir string, requestId string, options ConversionOptions) (int, int64, error) {
+	level, err := compressionLevel(options.Compression)
+	if err != nil {
+		return 0, 0, err
+	}
+	zipWriter := zip.NewWriter(out)
+	zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
 
 	// Walk through the wiki directory and add all files to the zip
 	var totalBytesAdded int64
@@ -373,6 +1034,9 @@ func createZipArchive(wikiDir string, requestId string) (string, error) {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		
 		// Skip directories
 		if info.IsDir() {
@@ -418,6 +1082,12 @@ func createZipArchive(wikiDir string, requestId string) (string, error) {
 		// Use the relative path as the name in the zip
 		header.Name = relPath
 		header.Method = zip.Deflate
+		if level == flate.NoCompression {
+			header.Method = zip.Store
+		}
+		if options.Reproducible {
+			normalizeZipHeader(header)
+		}
 
 		// Create the file in the zip
 		writer, err := zipWriter.CreateHeader(header)
@@ -456,30 +1126,23 @@ func createZipArchive(wikiDir string, requestId string) (string, error) {
 		return nil
 	})
 	
+	if err == nil {
+		err = zipWriter.Close()
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to walk wiki directory: %v", err)
+		return fileCount, totalBytesAdded, fmt.Errorf("failed to walk wiki directory: %v", err)
 	}
-
-	logger.WithFields(logrus.Fields{
-		"request_id":        requestId,
-		"zip_filename":      zipFileName,
-		"files_added":       fileCount,
-		"total_bytes_added": totalBytesAdded,
-	}).Info("ZIP archive creation completed successfully")
-
-	return zipFileName, nil
+	return fileCount, totalBytesAdded, nil
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
-	requestId := uuid.New().String()
+	requestId := requestID(r)
 	// Request logging is now handled by middleware, but we'll keep request ID for internal tracking
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("request-id", requestId)
 
 	// Check authentication
-	AuthTokenHeader := r.Header.Get("x-auth-token")
-	expectedToken := getEnv("NEORG_DOCUMENTATION_AUTH_TOKEN", "")
-	if expectedToken == "" || AuthTokenHeader != expectedToken {
+	if !isAuthorized(r) {
 		Unauthorized(w, r)
 		return
 	}
@@ -489,17 +1152,41 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		json.NewEncoder(w).Encode(Response{
 			Error: "Method not allowed",
+			Code:  codeMethodNotAllowed,
 			Id:    requestId,
 		})
 		return
 	}
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
+	// Besides raw archives, projects arrive as multipart/form-data from HTML forms or as JSON with
+	// inline files from clients that hold them in memory
+	archive, sourceURL, err := unpackUpload(r)
+	if rejectInvalidUpload(w, requestId, err) {
+		return
+	}
 
-	// Get the tarball data from the request body
-	tarballData, err := getTarballData(r)
+	request, ok := parseConversionRequest(w, r, requestId)
+	if !ok {
+		if archive != nil {
+			archive.Close()
+		}
+		return
+	}
+
+	// source_url archives are downloaded, and raw archive uploads spooled from the request body,
+	// once the request has been accepted
+	if sourceURL != "" {
+		archive, err = downloadSourceURL(r, sourceURL)
+		if rejectInvalidUpload(w, requestId, err) {
+			return
+		}
+	}
+	if archive == nil {
+		archive, err = getTarballData(r)
+	}
+	if rejectUploadTooLarge(w, requestId, err) {
+		return
+	}
 	if err != nil {
 		logger.WithFields(logrus.Fields{
 			"request_id": requestId,
@@ -508,67 +1195,410 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(Response{
 			Error: "Failed to process tarball",
+			Code:  codeInvalidArchive,
 			Id:    requestId,
 		})
 		return
 	}
 
-	logger.WithFields(logrus.Fields{
-		"request_id": requestId,
-		"tarball_size": len(tarballData),
-	}).Info("Starting documentation generation")
+	serveConversion(w, r, requestId, archive, request)
+}
 
-	// Generate documentation using the Neorg approach
-	projectDir, err := generateDocumentation(ctx, tarballData, requestId)
+// conversionRequest holds the settings of a conversion request that apply whatever the input source
+type conversionRequest struct {
+	Options  ConversionOptions
+	Priority int
+	// CallbackURL is the completion webhook; when set the conversion runs as a background job
+	CallbackURL string
+	// Async runs the conversion as a background job regardless of the request, e.g. for push webhooks
+	Async bool
+	// Timeout is the client's timeout for the conversion, zero for the server default
+	Timeout time.Duration
+}
+
+// parseConversionRequest reads the conversion options, priority and completion webhook of a request
+// and refuses it while the conversion queue is full. On failure the error response has already been
+// written and ok is false.
+func parseConversionRequest(w http.ResponseWriter, r *http.Request, requestId string) (conversionRequest, bool) {
+	// Parse conversion options from the query string
+	options, err := parseConversionOptions(r)
 	if err != nil {
 		logger.WithFields(logrus.Fields{
 			"request_id": requestId,
 			"error": err.Error(),
-		}).Error("Failed to generate documentation")
-		w.WriteHeader(http.StatusInternalServerError)
+		}).Warn("Invalid conversion options")
+		w.WriteHeader(optionsErrorStatus(err))
 		json.NewEncoder(w).Encode(Response{
-			Error: fmt.Sprintf("Documentation generation failed: %v", err),
+			Error: fmt.Sprintf("Invalid conversion options: %v", err),
+			Code:  optionsErrorCode(err),
 			Id:    requestId,
 		})
-		return
+		return conversionRequest{}, false
 	}
 
-	// Clean up project directory when done
-	defer os.RemoveAll(projectDir)
+	// Interactive requests can jump ahead of queued batch jobs
+	priority, err := requestPriority(r.Header.Get(priorityHeader), requestTenant(r.Header.Get(tenantHeader)))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: err.Error(),
+			Code:  codeInvalidRequest,
+			Id:    requestId,
+		})
+		return conversionRequest{}, false
+	}
 
-	// Check if wiki directory was created
-	wikiDir := filepath.Join(projectDir, "wiki")
-	if _, err := os.Stat(wikiDir); os.IsNotExist(err) {
-		logger.WithFields(logrus.Fields{
-			"request_id": requestId,
-			"wiki_dir": wikiDir,
-		}).Error("Wiki directory was not created - documentation generation may have failed")
-		w.WriteHeader(http.StatusInternalServerError)
+	// Small projects can fail fast and huge wikis be allowed more time, up to MAX_TIMEOUT
+	timeout, err := parseRequestTimeout(r.Header.Get(timeoutHeader))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(Response{
-			Error: "No documentation was generated",
+			Error: err.Error(),
+			Code:  codeInvalidRequest,
 			Id:    requestId,
 		})
+		return conversionRequest{}, false
+	}
+
+	// A completion webhook implies a background job
+	callbackURL, err := parseCallbackURL(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Invalid callback URL: %v", err),
+			Code:  codeInvalidRequest,
+			Id:    requestId,
+		})
+		return conversionRequest{}, false
+	}
+
+	// Refuse work up front while the queue is full rather than letting it time out
+	if conversionSlots.Full() {
+		rejectQueueFull(w, requestId)
+		return conversionRequest{}, false
+	}
+
+	return conversionRequest{Options: options, Priority: priority, CallbackURL: callbackURL, Timeout: timeout}, true
+}
+
+// serveConversion scans an archive, serves it from the result cache or converts it, either in the
+// background or while the client waits, and answers with the zip archive or an error response.
+// It takes over the archive, which is removed once the conversion no longer needs it.
+func serveConversion(w http.ResponseWriter, r *http.Request, requestId string, archive *spooledArchive, request conversionRequest) {
+	options, priority, callbackURL := request.Options, request.Priority, request.CallbackURL
+	// Create context with the client's timeout or the default
+	ctx, cancel := context.WithTimeout(context.Background(), conversionTimeout(request.Timeout, defaultConversionTimeout))
+	defer cancel()
+
+	// Report duration, sizes and outcome of the job once the response is done; background jobs
+	// report when they finish instead
+	job := newJobMetrics(requestId, int(archive.Size()))
+	detached := false
+	defer func() {
+		if !detached {
+			job.Emit()
+			archive.Close()
+		}
+	}()
+
+	// Clients polling for regenerated documentation need not download an unchanged result again;
+	// delta results depend on the baseline as well and get no ETag
+	etag := ""
+	if options.Baseline == nil {
+		etag = resultETag(archive, options)
+		if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+			w.Header().Del("Content-Type")
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	// Scan the archive for malware before anything is extracted
+	if rejectScanFailure(w, requestId, scanArchive(ctx, archive, requestId)) {
 		return
 	}
 
-	// Create zip archive of generated documentation
-	zipFileName, err := createZipArchive(wikiDir, requestId)
+	// Dry runs only extract the archive to report what would be converted
+	if options.DryRun {
+		serveDryRun(ctx, w, requestId, archive, options)
+		return
+	}
+
+	// Serve identical inputs from the result cache; delta requests depend on the baseline and are not cached
+	cacheKey, inputDigest := "", ""
+	if results != nil && options.Baseline != nil {
+		w.Header().Set(cacheStatusHeader, "BYPASS")
+	}
+	if results != nil && options.Baseline == nil {
+		cacheKey, inputDigest = resultCacheKey(archive, options)
+		w.Header().Set(cacheStatusHeader, "MISS")
+		if cached, entry, ok := results.Get(cacheKey); ok {
+			logger.WithFields(logrus.Fields{
+				"request_id": requestId,
+				"cache_key":  cacheKey,
+			}).Info("Serving documentation from result cache")
+			w.Header().Set(cacheStatusHeader, "HIT")
+			setETag(w, etag)
+			size, ok := sendArchiveFile(w, requestId, cached, outputFormat(options.Output), len(entry.Warnings))
+			cached.Close()
+			if ok {
+				job.Succeed(size, 0)
+			}
+			return
+		}
+	}
+
+	// Large conversions run in the background when asked to, so clients are not held past proxy timeouts
+	if callbackURL != "" || request.Async || wantsAsync(r, archive.Size()) {
+		if conversionSlots.Full() {
+			rejectQueueFull(w, requestId)
+			return
+		}
+		detached = true
+		// Jobs are looked up by ID, so one from the caller, which may be reused, gets its own
+		jobId := requestId
+		if requestIdSupplied(r) {
+			jobId = uuid.New().String()
+		}
+		submitJob(w, r, jobId, archive, options, priority, callbackURL, request.Timeout, cacheKey, inputDigest, job)
+		return
+	}
+
+	// Wait for a free conversion slot so bursts queue up instead of exhausting memory and CPU
+	release, err := acquireConversionSlot(ctx, priority, "")
+	if errors.Is(err, errQueueFull) {
+		rejectQueueFull(w, requestId)
+		return
+	}
 	if err != nil {
 		logger.WithFields(logrus.Fields{
 			"request_id": requestId,
-			"error": err.Error(),
-		}).Error("Failed to create output zip archive")
-		w.WriteHeader(http.StatusInternalServerError)
+		}).Warn("Timed out waiting for a conversion slot")
+		w.WriteHeader(http.StatusServiceUnavailable)
 		json.NewEncoder(w).Encode(Response{
-			Error: fmt.Sprintf("Failed to create zip archive: %v", err),
+			Error: "Timed out waiting for a free conversion slot",
+			Code:  codeUnavailable,
 			Id:    requestId,
 		})
 		return
 	}
 
+	progress := newProgress(requestId)
+
+	// Without a result cache to fill, formats that can stream are packaged straight into the
+	// response instead of through a temporary file
+	if format := outputFormat(options.Output); cacheKey == "" && format.Stream != nil {
+		conversionStart := time.Now()
+		projectDir, outputDir, err := generateOutput(ctx, archive, requestId, options, progress)
+		release()
+		setServerTiming(w, progress)
+		var failure *conversionFailure
+		if errors.As(err, &failure) {
+			w.WriteHeader(failure.Status)
+			json.NewEncoder(w).Encode(failure.Body)
+			return
+		}
+		defer os.RemoveAll(projectDir)
+
+		progress.SetStage(stageZip)
+		setETag(w, etag)
+		packageCtx, cancelPackage := withStageTimeout(ctx, stageZip)
+		defer cancelPackage()
+		size, ok := streamArchive(packageCtx, w, requestId, outputDir, format, options, len(progress.Warnings()))
+		// Packaging ends with the response, so its timing follows in a trailer
+		w.Header().Set(http.TrailerPrefix+"Server-Timing", serverTimingHeader(map[string]int64{stageZip: progress.Timings()[stageZip]}))
+		if !ok {
+			return
+		}
+		progress.Finish()
+		conversionDurations.Observe(time.Since(conversionStart))
+		convertedFiles, _ := progress.Counts()
+		job.Succeed(size, convertedFiles)
+		return
+	}
+
+	zipFileName, err := convertToArchive(ctx, archive, requestId, options, progress, requestTenant(r.Header.Get(tenantHeader)), cacheKey, inputDigest)
+	release()
+	setServerTiming(w, progress)
+	var failure *conversionFailure
+	if errors.As(err, &failure) {
+		w.WriteHeader(failure.Status)
+		json.NewEncoder(w).Encode(failure.Body)
+		return
+	}
+
 	// Clean up zip file after response
 	defer os.Remove(zipFileName)
 
+	setETag(w, etag)
+	size, ok := sendArchive(w, requestId, zipFileName, outputFormat(options.Output), len(progress.Warnings()))
+	if !ok {
+		return
+	}
+	convertedFiles, _ := progress.Counts()
+	job.Succeed(size, convertedFiles)
+}
+
+// conversionFailure is a failed conversion together with the response the client should receive
+type conversionFailure struct {
+	Status  int
+	Code    string
+	Message string
+	Body    interface{}
+}
+
+func (e *conversionFailure) Error() string {
+	return e.Message
+}
+
+// failConversion builds a conversionFailure answered with a plain error Response
+func failConversion(status int, code string, message string, requestId string) *conversionFailure {
+	return &conversionFailure{Status: status, Code: code, Message: message, Body: Response{Error: message, Code: code, Id: requestId}}
+}
+
+// generateOutput generates the documentation for an uploaded archive and returns the project
+// directory, which the caller removes, and the output directory within it to package. Failures are
+// returned as a *conversionFailure.
+func generateOutput(ctx context.Context, archive *spooledArchive, requestId string, options ConversionOptions, progress *Progress) (string, string, error) {
+	logger.WithFields(logrus.Fields{
+		"request_id": requestId,
+		"tarball_size": archive.Size(),
+	}).Info("Starting documentation generation")
+
+	// Generate documentation using the Neorg approach
+	projectDir, outputDir, err := generateDocumentation(ctx, archive, requestId, options, progress)
+	if errors.Is(err, errRootNotFound) || errors.Is(err, errInvalidUserConfig) || errors.Is(err, errInvalidTemplate) || errors.Is(err, errInvalidLuaHooks) || errors.Is(err, errUnsafeLink) || errors.Is(err, errInvalidArchive) {
+		return "", "", failConversion(http.StatusBadRequest, conversionErrorCode(err), err.Error(), requestId)
+	}
+	if errors.Is(err, errLuaHooksNotAllowed) {
+		return "", "", failConversion(http.StatusForbidden, codeHooksNotAllowed, err.Error(), requestId)
+	}
+	if errors.Is(err, errExtractionLimit) {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Warn("Archive exceeds extraction limits")
+		return "", "", failConversion(http.StatusUnprocessableEntity, codeExtractionLimit, err.Error(), requestId)
+	}
+	if errors.Is(err, errStageTimeout) {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Warn("Conversion stage timed out")
+		return "", "", attachDebugOutput(failConversion(http.StatusGatewayTimeout, codeTimeout, err.Error(), requestId), err, options)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Warn("Documentation generation timed out")
+		return "", "", attachDebugOutput(failConversion(http.StatusGatewayTimeout, codeTimeout, "Documentation generation timed out", requestId), err, options)
+	}
+	var fileErr *fileConversionError
+	if errors.As(err, &fileErr) && errors.Is(err, errNoDocumentation) {
+		return "", "", fileErrorsFailure(http.StatusUnprocessableEntity, codeNoOutput, "Every file failed to convert", fileErr.Files, requestId)
+	}
+	if errors.As(err, &fileErr) {
+		return "", "", fileErrorsFailure(http.StatusUnprocessableEntity, codeFilesFailed, fmt.Sprintf("%d file(s) failed to convert", len(fileErr.Files)), fileErr.Files, requestId)
+	}
+	if errors.Is(err, errLayoutFailed) {
+		return "", "", failConversion(http.StatusInternalServerError, codeLayoutFailed, err.Error(), requestId)
+	}
+	if errors.Is(err, errNoDocumentation) {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+		}).Error("Wiki directory was not created - documentation generation may have failed")
+		return "", "", failConversion(http.StatusInternalServerError, codeNoOutput, "No documentation was generated", requestId)
+	}
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error": err.Error(),
+		}).Error("Failed to generate documentation")
+		return "", "", attachDebugOutput(failConversion(http.StatusInternalServerError, codeDocgenFailed, fmt.Sprintf("Documentation generation failed: %v", err), requestId), err, options)
+	}
+
+	return projectDir, outputDir, nil
+}
+
+// outputTooLargeFailure answers generated output beyond MAX_OUTPUT_BYTES with the offending files
+func outputTooLargeFailure(tooLarge *outputTooLargeError, requestId string) *conversionFailure {
+	logger.WithFields(logrus.Fields{
+		"request_id":   requestId,
+		"output_bytes": tooLarge.Size,
+		"limit_bytes":  tooLarge.Limit,
+	}).Warn("Generated documentation exceeds the maximum output size")
+	return &conversionFailure{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    codeOutputTooLarge,
+		Message: "output_too_large",
+		Body: ConversionResult{
+			Error: "output_too_large",
+			Code:  codeOutputTooLarge,
+			Files: tooLarge.Files,
+			Id:    requestId,
+		},
+	}
+}
+
+// convertToArchive generates the documentation for an uploaded archive and packages it as a zip
+// file, which the caller removes once it has been delivered. The result is stored in the result
+// cache when cacheKey is set. Failures are returned as a *conversionFailure.
+func convertToArchive(ctx context.Context, archive *spooledArchive, requestId string, options ConversionOptions, progress *Progress, tenant string, cacheKey string, inputDigest string) (string, error) {
+	conversionStart := time.Now()
+	projectDir, outputDir, err := generateOutput(ctx, archive, requestId, options, progress)
+	if err != nil {
+		return "", err
+	}
+
+	// Clean up project directory when done
+	defer os.RemoveAll(projectDir)
+
+	// Package the generated documentation as a zip archive or in the requested output format
+	progress.SetStage(stageZip)
+	packageCtx, cancelPackage := withStageTimeout(ctx, stageZip)
+	zipFileName, err := packageOutput(packageCtx, outputDir, requestId, options)
+	err = stageError(ctx, packageCtx, stageZip, err)
+	cancelPackage()
+	var tooLarge *outputTooLargeError
+	if errors.As(err, &tooLarge) {
+		return "", outputTooLargeFailure(tooLarge, requestId)
+	}
+	if errors.Is(err, errStageTimeout) || (err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Warn("Packaging timed out")
+		return "", failConversion(http.StatusGatewayTimeout, codeTimeout, fmt.Sprintf("Packaging timed out: %v", err), requestId)
+	}
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error": err.Error(),
+		}).Error("Failed to create output archive")
+		return "", failConversion(http.StatusInternalServerError, codeInternal, fmt.Sprintf("Failed to create %s archive: %v", outputFormat(options.Output).Name, err), requestId)
+	}
+
+	progress.Finish()
+	conversionDurations.Observe(time.Since(conversionStart))
+
+	if cacheKey != "" {
+		_, err = results.Put(cacheKey, tenant, inputDigest, zipFileName, progress.Warnings())
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"request_id": requestId,
+				"error":      err.Error(),
+			}).Warn("Failed to cache generated documentation")
+		}
+	}
+	return zipFileName, nil
+}
+
+// sendArchive streams a result archive in the given output format to the client and reports its
+// size and whether it was sent completely. Error responses are written for failures before
+// streaming starts.
+func sendArchive(w http.ResponseWriter, requestId string, zipFileName string, format OutputFormat, warnings int) (int64, bool) {
 	// Open the zip file for reading
 	zipFile, err := os.Open(zipFileName)
 	if err != nil {
@@ -579,12 +1609,17 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(Response{
 			Error: "Failed to open zip file",
+			Code:  codeInternal,
 			Id:    requestId,
 		})
-		return
+		return 0, false
 	}
 	defer zipFile.Close()
+	return sendArchiveFile(w, requestId, zipFile, format, warnings)
+}
 
+// sendArchiveFile streams an open result archive like sendArchive, leaving it open
+func sendArchiveFile(w http.ResponseWriter, requestId string, zipFile *os.File, format OutputFormat, warnings int) (int64, bool) {
 	// Get file info for content length
 	zipInfo, err := zipFile.Stat()
 	if err != nil {
@@ -595,16 +1630,13 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(Response{
 			Error: "Failed to get zip file info",
+			Code:  codeInternal,
 			Id:    requestId,
 		})
-		return
+		return 0, false
 	}
 
-	// Set response headers for file download
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"neorg_documentation_%s.zip\"", requestId))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", zipInfo.Size()))
-	w.Header().Set("request-id", requestId)
+	setArchiveHeaders(w, requestId, format)
 
 	// Stream the zip file to the response
 	logger.WithFields(logrus.Fields{
@@ -612,24 +1644,122 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		"zip_size_bytes": zipInfo.Size(),
 	}).Info("Successfully generated documentation, sending response")
 	w.WriteHeader(http.StatusOK)
-	_, err = io.Copy(w, zipFile)
+	checksum := sha256.New()
+	written, err := io.Copy(io.MultiWriter(w, checksum), zipFile)
+	w.Header().Set("X-Warnings-Count", strconv.Itoa(warnings))
+	w.Header().Set("X-Content-SHA256", hex.EncodeToString(checksum.Sum(nil)))
+	if err == nil && written != zipInfo.Size() {
+		err = fmt.Errorf("streamed %d of %d bytes", written, zipInfo.Size())
+	}
 	if err != nil {
+		w.Header().Set("X-Conversion-Status", "failed")
 		logger.WithFields(logrus.Fields{
 			"request_id": requestId,
 			"error": err.Error(),
 		}).Error("Failed to stream zip file to client")
+		return written, false
+	}
+	w.Header().Set("X-Conversion-Status", "complete")
+	return written, true
+}
+
+// setServerTiming reports the time a conversion has spent in each stage in a Server-Timing header,
+// so clients can tell a slow project from a slow service
+func setServerTiming(w http.ResponseWriter, progress *Progress) {
+	if timings := progress.Timings(); len(timings) > 0 {
+		w.Header().Set("Server-Timing", serverTimingHeader(timings))
+	}
+}
+
+// setETag sets the ETag of a result about to be sent, if it has one
+func setETag(w http.ResponseWriter, etag string) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
 	}
 }
 
+// setArchiveHeaders sets the response headers for a result download. The archive is sent chunked,
+// without a Content-Length, so the trailers can report whether streaming completed.
+func setArchiveHeaders(w http.ResponseWriter, requestId string, format OutputFormat) {
+	w.Header().Set("Content-Type", format.ContentType)
+	if !format.Inline {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"neorg_documentation_%s%s\"", requestId, format.Extension))
+	}
+	w.Header().Set("Trailer", "X-Conversion-Status, X-Warnings-Count, X-Content-SHA256")
+	w.Header().Set("request-id", requestId)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// streamArchive packages the output directory straight into the response for formats that can be
+// streamed, without writing the archive to disk first, and reports its size and whether it was
+// sent completely. Output beyond MAX_OUTPUT_BYTES is refused before anything is sent; a failure
+// while streaming can only be reported through the X-Conversion-Status trailer.
+func streamArchive(ctx context.Context, w http.ResponseWriter, requestId string, outputDir string, format OutputFormat, options ConversionOptions, warnings int) (int64, bool) {
+	err := checkOutputSize(outputDir, getEnvInt64("MAX_OUTPUT_BYTES", defaultMaxOutputBytes))
+	var tooLarge *outputTooLargeError
+	if err != nil {
+		w.Header().Del("ETag")
+	}
+	if errors.As(err, &tooLarge) {
+		failure := outputTooLargeFailure(tooLarge, requestId)
+		w.WriteHeader(failure.Status)
+		json.NewEncoder(w).Encode(failure.Body)
+		return 0, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to create %s archive: %v", format.Name, err),
+			Code:  codeInternal,
+			Id:    requestId,
+		})
+		return 0, false
+	}
+
+	setArchiveHeaders(w, requestId, format)
+	logger.WithFields(logrus.Fields{
+		"request_id": requestId,
+		"output":     format.Name,
+	}).Info("Successfully generated documentation, streaming response")
+	w.WriteHeader(http.StatusOK)
+	checksum := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(w, checksum)}
+	err = format.Stream(ctx, counter, outputDir, requestId, options)
+	w.Header().Set("X-Warnings-Count", strconv.Itoa(warnings))
+	w.Header().Set("X-Content-SHA256", hex.EncodeToString(checksum.Sum(nil)))
+	if err != nil {
+		w.Header().Set("X-Conversion-Status", "failed")
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Error("Failed to stream archive to client")
+		return counter.n, false
+	}
+	w.Header().Set("X-Conversion-Status", "complete")
+	return counter.n, true
+}
+
 // LoggingMiddleware wraps HTTP handlers with comprehensive logging
 func LoggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		requestId := uuid.New().String()
+		r, requestId := withRequestId(r)
 		
 		// Create a response writer wrapper to capture status code
 		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 		w.Header().Set("request-id", requestId)
+		w.Header().Set(requestIdHeader, requestId)
 		
 		// Log request start
 		logger.WithFields(logrus.Fields{
@@ -684,6 +1814,11 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap lets http.ResponseController flush the underlying writer, as event streams need
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // checkNeorgHealth runs a simple test to verify Neovim and make are available
 func checkNeorgHealth() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -720,34 +1855,151 @@ func check_health(w http.ResponseWriter, r *http.Request) {
 		"method":   r.Method,
 	}).Debug("Health check requested")
 	
-	// Check Neorg health
-	if err := checkNeorgHealth(); err != nil {
+	// JSON clients also get the conversion queue's capacity and depth
+	wantsJson := strings.Contains(r.Header.Get("Accept"), "application/json")
+	if wantsJson {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	// Check Neorg health; ?deep=1 also converts a sample document with the real pipeline
+	err := checkNeorgHealth()
+	if deep := r.URL.Query().Get("deep"); err == nil && (deep == "1" || deep == "true") {
+		err = deepHealthCheck(r.Context())
+	}
+	if err != nil {
 		logger.WithError(err).Error("Neorg health check failed")
 		w.WriteHeader(http.StatusServiceUnavailable)
+		if wantsJson {
+			json.NewEncoder(w).Encode(HealthStatus{Status: "unavailable", Error: err.Error(), Queue: conversionSlots.Status()})
+			return
+		}
 		fmt.Fprintf(w, "Service unavailable: Neorg not ready - %v", err)
 		return
 	}
 	
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	if wantsJson {
+		json.NewEncoder(w).Encode(HealthStatus{Status: "ok", Queue: conversionSlots.Status()})
+	} else {
+		w.Write([]byte("OK"))
+	}
 	
 	logger.Debug("Health check completed successfully")
 }
 
 func main() {
+	flag.Parse()
+	if flag.Arg(0) == convertCommand {
+		if err := runConvertCommand(flag.Args()[1:]); err != nil {
+			if !errors.Is(err, flag.ErrHelp) {
+				logger.WithError(err).Error("Conversion failed")
+			}
+			os.Exit(1)
+		}
+		return
+	}
+	if *benchMode {
+		if err := runBenchmark(); err != nil {
+			logger.WithError(err).Fatal("Benchmark failed")
+		}
+		return
+	}
+
 	port := getEnv("PORT", "8080")
 	logger.WithFields(logrus.Fields{
 		"service": "neorg-documentation-lambda",
 		"port":    "8080",
 	}).Info("Starting Neorg Documentation Lambda server")
 	
+	// Load secrets from the configured backend before anything reads them
+	initSecrets()
+	results = newResultCacheFromEnv()
+	pageCache = newPageCacheFromEnv()
+	nvimPool = newNvimPoolFromEnv()
+	store, err := newJobStoreFromEnv()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to open job store")
+	}
+	jobStore = store
+	jobResults, err = newS3JobResultStoreFromEnv()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to connect background job results to S3")
+	}
+	if _, shared := store.(*redisJobStore); shared && jobResults == nil {
+		logger.WithField("dir", jobResultsDir()).Warn("JOB_RESULTS_S3_BUCKET is not set, so background job results are only served by the replica that ran the job unless JOB_RESULTS_DIR is shared storage")
+	}
+	maxConversions := getEnvInt64("MAX_CONCURRENT_CONVERSIONS", int64(runtime.NumCPU()))
+	conversionSlots = newConversionQueue(int(maxConversions), int(getEnvInt64("MAX_QUEUE_DEPTH", 4*maxConversions)))
+	if err := loadConversionProfiles(); err != nil {
+		logger.WithError(err).Fatal("Failed to load conversion profiles")
+	}
+
+	// Rate limit conversion endpoints per client IP before authentication runs
+	limiter := newIPRateLimiterFromEnv()
+	// Require signed, non-replayed requests when a signing secret is configured
+	verifier := newRequestVerifierFromEnv()
+	protect := func(next http.HandlerFunc) http.HandlerFunc {
+		return LoggingMiddleware(RateLimitMiddleware(limiter, UploadLimitMiddleware(SignatureMiddleware(verifier, next))))
+	}
+
+	// Routes live on their own mux: importing net/http/pprof registers its handlers on the
+	// default one, which must not be reachable on the public port
+	mux := http.NewServeMux()
+
 	// Wrap handlers with logging middleware
-	http.HandleFunc("/", LoggingMiddleware(handler))
-	http.HandleFunc("/health", LoggingMiddleware(check_health))
-	
-	logger.Info("Server routes registered, starting HTTP server on port " + port)
+	mux.HandleFunc("/", protect(handler))
+	mux.HandleFunc("/health", LoggingMiddleware(check_health))
+	// Probes are polled every few seconds, so they are not logged per request
+	mux.HandleFunc("/healthz", livenessHandler)
+	mux.HandleFunc("/readyz", readinessHandler)
+	mux.HandleFunc("/version", LoggingMiddleware(versionHandler))
+	mux.HandleFunc("/versions", LoggingMiddleware(versionsHandler))
+	mux.HandleFunc("/openapi.json", LoggingMiddleware(openAPIHandler))
+	mux.HandleFunc("/render", protect(renderHandler))
+	mux.HandleFunc("/preview", protect(previewHandler))
+	mux.HandleFunc("/ast", protect(astHandler))
+	mux.HandleFunc("/validate", protect(validateHandler))
+	mux.HandleFunc("/metadata", protect(metadataHandler))
+	mux.HandleFunc("/convert/git", protect(gitConvertHandler))
+	// Push webhooks authenticate with their own signatures instead of the auth token
+	mux.HandleFunc("/webhooks/github", LoggingMiddleware(RateLimitMiddleware(limiter, githubWebhookHandler)))
+	mux.HandleFunc("/webhooks/gitlab", LoggingMiddleware(RateLimitMiddleware(limiter, gitlabWebhookHandler)))
+	mux.HandleFunc("/cache", protect(cacheHandler))
+	mux.HandleFunc("/cache/", protect(cacheHandler))
+	mux.HandleFunc("/jobs/", protect(jobsHandler))
+	mux.Handle("/ui/", LoggingMiddleware(uiHandler().ServeHTTP))
+	mux.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+
+	startPprofServer()
+
+	// Serve HTTPS directly when a certificate is configured, for deployments without a proxy
+	tlsConfig, err := newTLSConfigFromEnv()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to configure TLS")
+	}
+	server := &http.Server{Addr: ":" + port, Handler: mux, TLSConfig: tlsConfig}
+
+	// Under systemd socket activation the listening socket is passed in and PORT is not used
+	listener, err := systemdListener()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to use systemd socket activation")
+	}
+	if listener != nil {
+		logger.WithField("addr", listener.Addr().String()).Info("Server routes registered, serving on the socket passed by systemd")
+	} else {
+		logger.Info("Server routes registered, starting HTTP server on port " + port)
+		listener, err = net.Listen("tcp", server.Addr)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to listen on port " + port)
+		}
+	}
 	
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	if tlsConfig != nil {
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil {
 		logger.WithFields(logrus.Fields{
 			"error": err.Error(),
 			"port":  "8080",
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is an entry written by buildTar; entries without a Typeflag are regular files
+type tarEntry struct {
+	header tar.Header
+	body   string
+}
+
+// buildTar writes entries into a tar stream, in the format of each header
+func buildTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	for _, entry := range entries {
+		header := entry.header
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(entry.body))
+		}
+		if header.Mode == 0 && header.Typeflag != tar.TypeXGlobalHeader {
+			header.Mode = 0644
+		}
+		if err := writer.WriteHeader(&header); err != nil {
+			t.Fatalf("writing header of %q: %v", header.Name, err)
+		}
+		if _, err := writer.Write([]byte(entry.body)); err != nil {
+			t.Fatalf("writing %q: %v", header.Name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// patchTarHeader changes the 512-byte tar header block with patch and updates its checksum, for
+// headers archive/tar will not write
+func patchTarHeader(header []byte, patch func(header []byte)) {
+	patch(header)
+	copy(header[148:156], "        ")
+	checksum := int64(0)
+	for _, b := range header {
+		checksum += int64(b)
+	}
+	copy(header[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+}
+
+// buildGNUSparseTar writes a tar stream holding a single old-style GNU sparse file named name,
+// with data stored at offset and a hole filling the rest of its size
+func buildGNUSparseTar(t *testing.T, name string, data string, offset int64, size int64) []byte {
+	t.Helper()
+	stream := buildTar(t, []tarEntry{{header: tar.Header{Name: name, Format: tar.FormatGNU}, body: data}})
+	octal := func(field []byte, value int64) {
+		copy(field, fmt.Sprintf("%0*o\x00", len(field)-1, value))
+	}
+	patchTarHeader(stream[:512], func(header []byte) {
+		header[156] = tar.TypeGNUSparse
+		// The first entry of the sparse map, then the real size of the file
+		octal(header[386:398], offset)
+		octal(header[398:410], int64(len(data)))
+		octal(header[483:495], size)
+	})
+	return stream
+}
+
+// buildZeroTarGz writes a gzipped tar stream holding a single file named name of size zero bytes,
+// which compresses to a tiny fraction of its size
+func buildZeroTarGz(t *testing.T, name string, size int64) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	compressor := gzip.NewWriter(&buffer)
+	writer := tar.NewWriter(compressor)
+	if err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.CopyN(writer, zeroReader{}, size); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// zeroReader reads endless zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// extractedFiles reads every regular file under dir by its slash-separated relative path
+func extractedFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relative, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(relative)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestExtractTarballFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+		// env configures the extraction limits
+		env  map[string]string
+		want map[string]string
+		// wantCode is the error code extraction must fail with, after extracting want
+		wantCode string
+	}{
+		{
+			name: "pax global header",
+			archive: func(t *testing.T) []byte {
+				// As written by git archive, ahead of every other entry and named like a file
+				stream := buildTar(t, []tarEntry{
+					{header: tar.Header{Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "0123456789abcdef"}}},
+					{header: tar.Header{Name: "project/index.norg"}, body: "* Index"},
+				})
+				patchTarHeader(stream[:512], func(header []byte) {
+					copy(header[:100], "pax_global_header")
+				})
+				return stream
+			},
+			want: map[string]string{"project/index.norg": "* Index"},
+		},
+		{
+			name: "dot entries",
+			archive: func(t *testing.T) []byte {
+				// As written by tar -C dir .
+				return buildTar(t, []tarEntry{
+					{header: tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755}},
+					{header: tar.Header{Typeflag: tar.TypeDir, Name: "./docs/", Mode: 0755}},
+					{header: tar.Header{Name: "./index.norg"}, body: "* Index"},
+					{header: tar.Header{Name: "./docs/guide.norg"}, body: "* Guide"},
+				})
+			},
+			want: map[string]string{"index.norg": "* Index", "docs/guide.norg": "* Guide"},
+		},
+		{
+			name: "gnu sparse file",
+			archive: func(t *testing.T) []byte {
+				return buildGNUSparseTar(t, "sparse.norg", "* Sparse", 4, 12)
+			},
+			want: map[string]string{"sparse.norg": "\x00\x00\x00\x00* Sparse"},
+		},
+		{
+			name: "file replacing a hard link",
+			archive: func(t *testing.T) []byte {
+				return buildTar(t, []tarEntry{
+					{header: tar.Header{Name: "index.norg"}, body: "* Index page"},
+					{header: tar.Header{Typeflag: tar.TypeLink, Name: "copy.norg", Linkname: "index.norg"}},
+					{header: tar.Header{Name: "copy.norg"}, body: "* Copy"},
+				})
+			},
+			want: map[string]string{"index.norg": "* Index page", "copy.norg": "* Copy"},
+		},
+		{
+			name: "files within the size limits",
+			archive: func(t *testing.T) []byte {
+				return buildTar(t, []tarEntry{
+					{header: tar.Header{Name: "index.norg"}, body: "* Index"},
+					{header: tar.Header{Name: "guide.norg"}, body: "* Guide"},
+				})
+			},
+			env:  map[string]string{"MAX_ARCHIVE_FILE_BYTES": "7", "MAX_EXTRACTED_BYTES": "14"},
+			want: map[string]string{"index.norg": "* Index", "guide.norg": "* Guide"},
+		},
+		{
+			name: "file over the per-file limit",
+			archive: func(t *testing.T) []byte {
+				return buildTar(t, []tarEntry{
+					{header: tar.Header{Name: "index.norg"}, body: "* Index"},
+					{header: tar.Header{Name: "large.norg"}, body: "* Large page"},
+				})
+			},
+			env:      map[string]string{"MAX_ARCHIVE_FILE_BYTES": "8"},
+			want:     map[string]string{"index.norg": "* Index"},
+			wantCode: codeExtractionLimit,
+		},
+		{
+			name: "archive over the total size limit",
+			archive: func(t *testing.T) []byte {
+				// Each file fits on its own, the second one is refused before it is written
+				return buildTar(t, []tarEntry{
+					{header: tar.Header{Name: "index.norg"}, body: "* Index"},
+					{header: tar.Header{Name: "guide.norg"}, body: "* Guide"},
+				})
+			},
+			env:      map[string]string{"MAX_ARCHIVE_FILE_BYTES": "7", "MAX_EXTRACTED_BYTES": "13"},
+			want:     map[string]string{"index.norg": "* Index"},
+			wantCode: codeExtractionLimit,
+		},
+		{
+			name: "compressible archive within the ratio floor",
+			archive: func(t *testing.T) []byte {
+				// Far more than MAX_EXPANSION_RATIO times its size, but small enough to be let through
+				return buildZeroTarGz(t, "zeros.norg", 1<<20)
+			},
+			want: map[string]string{"zeros.norg": strings.Repeat("\x00", 1<<20)},
+		},
+		{
+			name: "compressible archive past the ratio floor",
+			archive: func(t *testing.T) []byte {
+				return buildZeroTarGz(t, "zeros.norg", expansionRatioFloor+1)
+			},
+			wantCode: codeExtractionLimit,
+		},
+		{
+			name: "archive over the entry limit",
+			archive: func(t *testing.T) []byte {
+				return buildTar(t, []tarEntry{
+					{header: tar.Header{Typeflag: tar.TypeDir, Name: "docs/", Mode: 0755}},
+					{header: tar.Header{Name: "docs/index.norg"}, body: "* Index"},
+					{header: tar.Header{Name: "docs/guide.norg"}, body: "* Guide"},
+				})
+			},
+			env:      map[string]string{"MAX_ARCHIVE_ENTRIES": "2"},
+			want:     map[string]string{"docs/index.norg": "* Index"},
+			wantCode: codeExtractionLimit,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			archive, err := spoolBytes(test.archive(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer archive.Close()
+
+			dir := t.TempDir()
+			_, err = extractTarball(context.Background(), archive, dir, ConversionOptions{}, nil)
+			if test.wantCode != "" {
+				if code := conversionErrorCode(err); code != test.wantCode {
+					t.Fatalf("extractTarball = %v with code %s, want code %s", err, code, test.wantCode)
+				}
+			} else if err != nil {
+				t.Fatalf("extractTarball: %v", err)
+			}
+			if got := extractedFiles(t, dir); !maps.Equal(got, test.want) {
+				t.Errorf("extracted %q, want %q", got, test.want)
+			}
+		})
+	}
+}
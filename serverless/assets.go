@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// assetLinkPattern matches markdown link and image destinations, including the Norg file
+	// links ({/ path}) converters leave as "/ path"
+	assetLinkPattern = regexp.MustCompile(`\]\(<?(/ +)?([^)<>\s]+)>?((?:\s+"[^"]*")?)\)`)
+	// norgImagePattern matches the .image infirm tags converters leave untouched
+	norgImagePattern = regexp.MustCompile(`(?m)^\.image\s+(\S+)\s*$`)
+	// urlSchemePattern matches destinations with a URL scheme, like https: or mailto:
+	urlSchemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+)
+
+// copyReferencedAssets copies the images and attachments the converted pages of a workspace
+// link to into wikiDir, at their path in the workspace, and points the links at the copies.
+// pages maps each source page (workspace-relative, without .norg) to its page name after the
+// output layout; links resolve against the source's directory, or the workspace root for $/
+// paths as in Norg. Linked files outside the workspace, pages and missing files are left alone.
+func copyReferencedAssets(workspace Workspace, wikiDir string, pages map[string]string) error {
+	root, err := filepath.EvalSymlinks(workspace.Dir)
+	if err != nil {
+		return err
+	}
+
+	copied := map[string]bool{}
+	for source, page := range pages {
+		file := filepath.Join(wikiDir, filepath.FromSlash(page)+".md")
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		var copyErr error
+		resolve := func(target string) (string, bool) {
+			asset, ok := resolveAsset(root, path.Dir(source), target)
+			if !ok {
+				return "", false
+			}
+			if !copied[asset] {
+				dest := filepath.Join(wikiDir, filepath.FromSlash(asset))
+				if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+					copyErr = err
+					return "", false
+				}
+				if err := copyFile(filepath.Join(root, filepath.FromSlash(asset)), dest); err != nil {
+					copyErr = err
+					return "", false
+				}
+				copied[asset] = true
+			}
+			return relativeLink(path.Dir(page), asset), true
+		}
+
+		rewritten := assetLinkPattern.ReplaceAllFunc(content, func(match []byte) []byte {
+			groups := assetLinkPattern.FindSubmatch(match)
+			target, fragment := splitLinkFragment(string(groups[2]))
+			link, ok := resolve(target)
+			if !ok {
+				return match
+			}
+			return []byte("](" + markdownLinkTarget(link+fragment) + string(groups[3]) + ")")
+		})
+		rewritten = norgImagePattern.ReplaceAllFunc(rewritten, func(match []byte) []byte {
+			target := string(norgImagePattern.FindSubmatch(match)[1])
+			link, ok := resolve(target)
+			if !ok {
+				return match
+			}
+			return []byte("![](" + markdownLinkTarget(link) + ")")
+		})
+		if copyErr != nil {
+			return fmt.Errorf("failed to copy assets of %s: %v", source, copyErr)
+		}
+		if err := os.WriteFile(file, rewritten, 0644); err != nil {
+			return err
+		}
+	}
+
+	if len(copied) > 0 {
+		logger.WithFields(logrus.Fields{
+			"workspace": workspace.Name,
+			"assets":    len(copied),
+		}).Info("Copied referenced assets")
+	}
+	return nil
+}
+
+// resolveAsset returns the workspace-relative path of the file a link from a page in sourceDir
+// points to, when it is an asset inside the workspace root that was not converted itself
+func resolveAsset(root string, sourceDir string, target string) (string, bool) {
+	if target == "" || strings.HasPrefix(target, "#") || urlSchemePattern.MatchString(target) {
+		return "", false
+	}
+	if strings.HasSuffix(target, ".md") || strings.HasSuffix(target, ".norg") {
+		return "", false
+	}
+
+	var asset string
+	switch {
+	case strings.HasPrefix(target, "$/"):
+		asset = path.Clean(strings.TrimPrefix(target, "$/"))
+	case strings.HasPrefix(target, "/"), strings.HasPrefix(target, "~"):
+		return "", false
+	default:
+		asset = path.Join(sourceDir, target)
+	}
+	if asset == "." || asset == ".." || strings.HasPrefix(asset, "../") {
+		return "", false
+	}
+	// Generated directories are not part of the project
+	if top, _, _ := strings.Cut(asset, "/"); top == "wiki" || top == "docgen" {
+		return "", false
+	}
+
+	// Symbolic links may only lead to files inside the workspace
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(asset)))
+	if err != nil || !isWithinDir(resolved, root) {
+		return "", false
+	}
+	info, err := os.Stat(resolved)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return asset, true
+}
+
+// splitLinkFragment separates a link destination from its #fragment or ?query
+func splitLinkFragment(target string) (string, string) {
+	if i := strings.IndexAny(target, "#?"); i >= 0 {
+		return target[:i], target[i:]
+	}
+	return target, ""
+}
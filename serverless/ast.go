@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// astHandler parses a raw .norg document from the request body and returns its syntax tree as JSON,
+// using tree-sitter-norg when the binary was built with it
+func astHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	norgText, ok := readNorgRequest(w, r, requestId)
+	if !ok {
+		return
+	}
+
+	doc, _, parser := parseNorgSyntax(string(norgText))
+	w.Header().Set(norgParserHeader, parser)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(doc)
+}
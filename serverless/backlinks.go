@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Where ?backlinks puts the pages linking to each page
+const (
+	// backlinksSection appends a "Linked from" section to every linked page
+	backlinksSection = "section"
+	// backlinksJSON writes backlinks.json at the root of each workspace's output
+	backlinksJSON = "json"
+	// backlinksBoth does both
+	backlinksBoth = "both"
+)
+
+// backlinksFileName maps every delivered page to the pages linking to it
+const backlinksFileName = "backlinks.json"
+
+var backlinkModes = map[string]bool{backlinksSection: true, backlinksJSON: true, backlinksBoth: true}
+
+// wikiPageLinkPattern matches links between GitHub wiki pages, which have no .md extension
+var wikiPageLinkPattern = regexp.MustCompile(`\]\(<?([^)\s#?:<>/.]+)(?:#[^)\s>]*)?>?\)`)
+
+// validBacklinks reports whether mode is a supported ?backlinks value
+func validBacklinks(mode string) bool {
+	return backlinkModes[mode]
+}
+
+// pageLinks builds the link graph of the markdown pages under wikiDir, once the layout has
+// resolved the links between them, and returns for every page linking to others the pages it
+// links to. Keys and values are slash-separated page paths relative to wikiDir; self-links and
+// links to anything but a page are ignored.
+func pageLinks(wikiDir string, layout string) (map[string][]string, error) {
+	contents := map[string][]byte{}
+	err := filepath.Walk(wikiDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(file, ".md") {
+			return err
+		}
+		rel, err := filepath.Rel(wikiDir, file)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		contents[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pattern, extension := markdownLinkPattern, ""
+	if layout == layoutGitHubWiki {
+		pattern, extension = wikiPageLinkPattern, ".md"
+	}
+	links := map[string][]string{}
+	for page, content := range contents {
+		seen := map[string]bool{}
+		for _, match := range pattern.FindAllSubmatch(content, -1) {
+			target := path.Join(path.Dir(page), string(match[1])+extension)
+			if _, ok := contents[target]; !ok || target == page || seen[target] {
+				continue
+			}
+			seen[target] = true
+			links[page] = append(links[page], target)
+		}
+		sort.Strings(links[page])
+	}
+	return links, nil
+}
+
+// invertLinks turns a link graph into backlinks: for every linked page, the pages linking to it
+func invertLinks(links map[string][]string) map[string][]string {
+	backlinks := map[string][]string{}
+	for page, targets := range links {
+		for _, target := range targets {
+			backlinks[target] = append(backlinks[target], page)
+		}
+	}
+	for target := range backlinks {
+		sort.Strings(backlinks[target])
+	}
+	return backlinks
+}
+
+// appendBacklinkSections adds a section listing the pages that link to it to every linked page,
+// headed with the locale's "linked_from" string
+func appendBacklinkSections(wikiDir string, backlinks map[string][]string, options ConversionOptions) error {
+	heading := localeStrings(options.Locale).T("linked_from")
+	for target, sources := range backlinks {
+		file := filepath.Join(wikiDir, filepath.FromSlash(target))
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var section strings.Builder
+		fmt.Fprintf(&section, "\n\n## %s\n\n", heading)
+		for _, source := range sources {
+			linking, err := os.ReadFile(filepath.Join(wikiDir, filepath.FromSlash(source)))
+			if err != nil {
+				return err
+			}
+			title := markdownPageTitle(linking, strings.TrimSuffix(path.Base(source), ".md"))
+			link := relativeLink(path.Dir(target), source)
+			if options.Layout == layoutGitHubWiki {
+				link = strings.TrimSuffix(link, ".md")
+			}
+			fmt.Fprintf(&section, "- [%s](%s)\n", markdownLinkText(title), markdownLinkTarget(link))
+		}
+
+		content = append([]byte(strings.TrimRight(string(content), "\n")), section.String()...)
+		if err := os.WriteFile(file, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLinksFile stores a link graph, such as the backlinks, as JSON in a workspace's delivered
+// wiki, with page paths as the output format delivers them
+func writeLinksFile(wikiDir string, fileName string, links map[string][]string, format OutputFormat) error {
+	delivered := make(map[string][]string, len(links))
+	for page, targets := range links {
+		paths := make([]string, len(targets))
+		for i, target := range targets {
+			paths[i] = formatPagePath(format, target)
+		}
+		delivered[formatPagePath(format, page)] = paths
+	}
+	data, err := json.MarshalIndent(delivered, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(wikiDir, fileName), data, 0644)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Flags for the built-in benchmark mode, which drives synthetic workspaces through the full pipeline
+var (
+	benchMode        = flag.Bool("bench", false, "run the conversion benchmark instead of serving HTTP")
+	benchWorkspaces  = flag.Int("bench-workspaces", 20, "number of synthetic workspaces to convert")
+	benchFiles       = flag.Int("bench-files", 10, "number of .norg files per synthetic workspace")
+	benchSections    = flag.Int("bench-sections", 20, "number of sections per synthetic .norg file")
+	benchConcurrency = flag.Int("bench-concurrency", 1, "number of workspaces converted in parallel")
+	benchConverter   = flag.String("bench-converter", "", "conversion backend to benchmark (default: CONVERTER_BACKEND)")
+)
+
+type (
+	// BenchmarkReport summarizes a benchmark run
+	BenchmarkReport struct {
+		Workspaces          int     `json:"workspaces"`
+		FilesPerWorkspace   int     `json:"files_per_workspace"`
+		InputBytes          int     `json:"input_bytes_per_workspace"`
+		Concurrency         int     `json:"concurrency"`
+		Converter           string  `json:"converter"`
+		Failures            int     `json:"failures"`
+		TotalSeconds        float64 `json:"total_seconds"`
+		WorkspacesPerSecond float64 `json:"workspaces_per_second"`
+		FilesPerSecond      float64 `json:"files_per_second"`
+		LatencyMs           Latency `json:"latency_ms"`
+		PeakHeapBytes       uint64  `json:"peak_heap_bytes"`
+		PeakRSSBytes        int64   `json:"peak_rss_bytes"`
+		PeakChildRSSBytes   int64   `json:"peak_child_rss_bytes"`
+	}
+
+	// Latency holds per-workspace latency percentiles in milliseconds
+	Latency struct {
+		Min float64 `json:"min"`
+		P50 float64 `json:"p50"`
+		P90 float64 `json:"p90"`
+		P99 float64 `json:"p99"`
+		Max float64 `json:"max"`
+	}
+)
+
+// syntheticWorkspace builds an uncompressed tar archive holding one workspace of generated .norg files
+func syntheticWorkspace(files, sections int) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	write := func(name string, content []byte) error {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now()})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(content)
+		return err
+	}
+
+	if err := write("bench/.neorg", nil); err != nil {
+		return nil, err
+	}
+	for i := 0; i < files; i++ {
+		if err := write(fmt.Sprintf("bench/page_%d.norg", i), []byte(syntheticPage(i, sections))); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// syntheticPage generates the .norg source of one synthetic page with the given number of sections
+func syntheticPage(page, sections int) string {
+	var doc strings.Builder
+	fmt.Fprintf(&doc, "@document.meta\ntitle: Page %d\n@end\n\n* Page %d\n\n", page, page)
+	for s := 0; s < sections; s++ {
+		fmt.Fprintf(&doc, "** Section %d\n\nSome {* bold *} and {/ italic /} text with a {https://example.com}[link].\n\n", s)
+		doc.WriteString("- First item\n-- Nested item\n- ( ) Open task\n- (x) Done task\n\n")
+		fmt.Fprintf(&doc, "@code lua\nprint(\"section %d\")\n@end\n\n", s)
+	}
+	return doc.String()
+}
+
+// runBenchmark converts synthetic workspaces through extraction, conversion and packaging,
+// then prints a JSON report with throughput, latency percentiles and peak memory
+func runBenchmark() error {
+	workspaces, concurrency := max(*benchWorkspaces, 1), max(*benchConcurrency, 1)
+	data, err := syntheticWorkspace(*benchFiles, *benchSections)
+	if err != nil {
+		return fmt.Errorf("failed to build synthetic workspace: %v", err)
+	}
+	archive, err := spoolBytes(data)
+	if err != nil {
+		return fmt.Errorf("failed to spool synthetic workspace: %v", err)
+	}
+	defer archive.Close()
+
+	converter := strings.ToLower(*benchConverter)
+	if converter != "" && !validConverter(converter) {
+		return fmt.Errorf("unknown converter %q", converter)
+	}
+	options := ConversionOptions{Locale: defaultLocale, Converter: converter}
+	if converter == "" {
+		converter = strings.ToLower(getEnv("CONVERTER_BACKEND", converterAuto))
+	}
+
+	logger.WithFields(logrus.Fields{
+		"workspaces":  workspaces,
+		"files":       *benchFiles,
+		"input_bytes": len(data),
+		"concurrency": concurrency,
+		"converter":   converter,
+	}).Info("Starting benchmark")
+
+	// Sample the heap while the run is in progress to capture its peak
+	var peakHeap uint64
+	sampling := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		var stats runtime.MemStats
+		for {
+			runtime.ReadMemStats(&stats)
+			peakHeap = max(peakHeap, stats.HeapInuse)
+			select {
+			case <-sampling:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		failures  int
+		wg        sync.WaitGroup
+	)
+	jobs := make(chan int)
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				elapsed, err := benchmarkWorkspace(archive, options)
+				mu.Lock()
+				if err != nil {
+					failures++
+					logger.WithError(err).Warn("Benchmark conversion failed")
+				} else {
+					latencies = append(latencies, elapsed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < workspaces; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	total := time.Since(start)
+
+	close(sampling)
+	<-sampled
+
+	report := BenchmarkReport{
+		Workspaces:          workspaces,
+		FilesPerWorkspace:   *benchFiles,
+		InputBytes:          len(data),
+		Concurrency:         concurrency,
+		Converter:           converter,
+		Failures:            failures,
+		TotalSeconds:        total.Seconds(),
+		WorkspacesPerSecond: float64(len(latencies)) / total.Seconds(),
+		FilesPerSecond:      float64(len(latencies)*(*benchFiles)) / total.Seconds(),
+		LatencyMs:           latencyPercentiles(latencies),
+		PeakHeapBytes:       peakHeap,
+	}
+	report.PeakRSSBytes, report.PeakChildRSSBytes = peakRSSBytes()
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	if failures == workspaces {
+		return fmt.Errorf("all %d benchmark conversions failed", failures)
+	}
+	return nil
+}
+
+// benchmarkWorkspace runs one archive through the same steps as a conversion request
+func benchmarkWorkspace(archive *spooledArchive, options ConversionOptions) (time.Duration, error) {
+	requestId := uuid.New().String()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	tempDir, outputDir, err := generateDocumentation(ctx, archive, requestId, options, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	zipFileName, err := createZipArchive(ctx, outputDir, requestId, options)
+	if err != nil {
+		return 0, err
+	}
+	os.Remove(zipFileName)
+	return time.Since(start), nil
+}
+
+// latencyPercentiles computes nearest-rank percentiles in milliseconds
+func latencyPercentiles(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	at := func(p float64) float64 {
+		index := int(p*float64(len(sorted))+0.5) - 1
+		index = min(max(index, 0), len(sorted)-1)
+		return float64(sorted[index].Microseconds()) / 1000
+	}
+	return Latency{
+		Min: at(0),
+		P50: at(0.50),
+		P90: at(0.90),
+		P99: at(0.99),
+		Max: at(1),
+	}
+}
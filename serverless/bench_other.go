@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// peakRSSBytes reports no peak resident set size, which is only measured on Unix systems
+func peakRSSBytes() (int64, int64) {
+	return 0, 0
+}
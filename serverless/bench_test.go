@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// benchmarkArchive spools a synthetic workspace like the ones the -bench mode converts
+func benchmarkArchive(b *testing.B, files, sections int) *spooledArchive {
+	b.Helper()
+	data, err := syntheticWorkspace(files, sections)
+	if err != nil {
+		b.Fatal(err)
+	}
+	archive, err := spoolBytes(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { archive.Close() })
+	b.SetBytes(int64(len(data)))
+	return archive
+}
+
+func BenchmarkExtractTarball(b *testing.B) {
+	archive := benchmarkArchive(b, 10, 20)
+	options := ConversionOptions{Locale: defaultLocale}
+	for b.Loop() {
+		dir := b.TempDir()
+		if _, err := extractTarball(context.Background(), archive, dir, options, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseNorg(b *testing.B) {
+	source := syntheticPage(0, 50)
+	b.SetBytes(int64(len(source)))
+	for b.Loop() {
+		parseNorg(source)
+	}
+}
+
+func BenchmarkLintNorg(b *testing.B) {
+	source := syntheticPage(0, 50)
+	b.SetBytes(int64(len(source)))
+	for b.Loop() {
+		lintNorg(source)
+	}
+}
+
+// BenchmarkConvertNative drives a workspace through extraction, the native converter and
+// packaging, like a conversion request
+func BenchmarkConvertNative(b *testing.B) {
+	// The docgen scripts are looked up relative to the working directory, as in the image
+	b.Chdir("..")
+	if _, err := os.Stat("docgen"); err != nil {
+		b.Skip("docgen scripts not found:", err)
+	}
+	archive := benchmarkArchive(b, 10, 20)
+	options := ConversionOptions{Locale: defaultLocale, Converter: "native"}
+	for b.Loop() {
+		if _, err := benchmarkWorkspace(archive, options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
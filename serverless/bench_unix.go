@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// peakRSSBytes returns the peak resident set size of the process and of its waited-for children,
+// which covers Neovim and pandoc
+func peakRSSBytes() (int64, int64) {
+	// ru_maxrss is in kilobytes, except on Apple platforms where it is in bytes
+	unit := int64(1024)
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		unit = 1
+	}
+	var self, children int64
+	var usage syscall.Rusage
+	if syscall.Getrusage(syscall.RUSAGE_SELF, &usage) == nil {
+		self = int64(usage.Maxrss) * unit
+	}
+	if syscall.Getrusage(syscall.RUSAGE_CHILDREN, &usage) == nil {
+		children = int64(usage.Maxrss) * unit
+	}
+	return self, children
+}
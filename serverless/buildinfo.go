@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Build metadata set with -ldflags "-X main.buildVersion=... -X main.buildCommit=... -X main.buildDate=...",
+// as the Dockerfile does; unset values come from the build info the Go toolchain embeds
+var (
+	buildVersion string
+	buildCommit  string
+	buildDate    string
+)
+
+// BuildInfo is the response body of GET /version
+type BuildInfo struct {
+	// Version is the service's version, "(devel)" for untagged local builds
+	Version string `json:"version"`
+	// Commit is the git commit the binary was built from
+	Commit string `json:"commit,omitempty"`
+	// Modified reports a build from a working tree with uncommitted changes
+	Modified bool `json:"modified,omitempty"`
+	// BuildDate is when the binary was built, or the time of its commit when that is unknown (RFC 3339)
+	BuildDate string `json:"build_date,omitempty"`
+	// GoVersion is the Go toolchain the binary was built with
+	GoVersion string `json:"go_version"`
+}
+
+var (
+	buildInfoOnce sync.Once
+	serviceBuild  BuildInfo
+)
+
+// buildInfo reads the binary's build metadata once per process
+func buildInfo() BuildInfo {
+	buildInfoOnce.Do(func() {
+		serviceBuild = BuildInfo{Version: "unknown", GoVersion: runtime.Version()}
+		if info, ok := debug.ReadBuildInfo(); ok {
+			serviceBuild.Version = info.Main.Version
+			for _, setting := range info.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					serviceBuild.Commit = setting.Value
+				case "vcs.time":
+					serviceBuild.BuildDate = setting.Value
+				case "vcs.modified":
+					serviceBuild.Modified = setting.Value == "true"
+				}
+			}
+		}
+		if buildVersion != "" {
+			serviceBuild.Version = buildVersion
+		}
+		if buildCommit != "" {
+			serviceBuild.Commit = buildCommit
+		}
+		if buildDate != "" {
+			serviceBuild.BuildDate = buildDate
+		}
+	})
+	return serviceBuild
+}
+
+// versionHandler answers GET /version with the service's build metadata
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Method not allowed",
+			Code:  codeMethodNotAllowed,
+			Id:    requestId,
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(buildInfo())
+}
@@ -0,0 +1,700 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tenantHeader optionally tags cached results with the tenant that produced them
+const tenantHeader = "X-Tenant-ID"
+
+// cacheStatusHeader tells clients whether a conversion was served from the result cache: HIT, MISS
+// or BYPASS for delta requests, which are never cached
+const cacheStatusHeader = "X-Cache"
+
+// defaultTenant is used for results submitted without a tenant header
+const defaultTenant = "default"
+
+// adminTokenHeader carries CACHE_ADMIN_TOKEN to the cache administration endpoints
+const adminTokenHeader = "X-Admin-Token"
+
+type (
+	// CacheEntry describes one cached result archive
+	CacheEntry struct {
+		Key         string     `json:"key"`
+		Tenant      string     `json:"tenant"`
+		InputDigest string     `json:"input_digest"`
+		Size        int64      `json:"size_bytes"`
+		Created     time.Time  `json:"created"`
+		LastHit     *time.Time `json:"last_hit,omitempty"`
+		Hits        int64      `json:"hits"`
+		// Warnings are those of the conversion that produced the result, replayed on hits
+		Warnings []string `json:"warnings,omitempty"`
+	}
+
+	// CacheStats summarizes cache usage since the process started
+	CacheStats struct {
+		Entries   int     `json:"entries"`
+		Bytes     int64   `json:"bytes"`
+		Hits      int64   `json:"hits"`
+		Misses    int64   `json:"misses"`
+		HitRate   float64 `json:"hit_rate"`
+		Evictions int64   `json:"evictions"`
+	}
+)
+
+// ResultCache stores generated archives on disk keyed by the input content hash and conversion
+// options, optionally backed by an S3 bucket shared between instances. Entries expire after the
+// configured TTL, and the least recently used ones are evicted beyond the size and entry limits.
+// All methods are safe to call on a nil *ResultCache, which caches nothing.
+type ResultCache struct {
+	dir     string
+	mu      sync.Mutex
+	entries map[string]*CacheEntry
+	hits    int64
+	misses  int64
+	// evictions counts entries removed for expiry or the size and entry limits
+	evictions int64
+	// remote is the shared S3 store, nil when the cache is local only
+	remote *s3CacheStore
+	// maxBytes and maxEntries bound the local cache (0 for no limit)
+	maxBytes   int64
+	maxEntries int
+	// ttl is how long entries are served after being created (0 keeps them until evicted)
+	ttl time.Duration
+}
+
+// results is the process-wide result cache, nil when neither RESULT_CACHE_DIR nor
+// RESULT_CACHE_S3_BUCKET is set
+var results *ResultCache
+
+// newResultCacheFromEnv opens the cache in RESULT_CACHE_DIR, reloading entries left by a previous
+// run. With RESULT_CACHE_S3_BUCKET the directory holds local copies of the bucket's entries and
+// defaults to a temporary directory.
+func newResultCacheFromEnv() *ResultCache {
+	remote, err := newS3CacheStoreFromEnv()
+	if err != nil {
+		logger.WithError(err).Error("Failed to connect the result cache to S3, caching disabled")
+		return nil
+	}
+	defaultDir := ""
+	if remote != nil {
+		defaultDir = filepath.Join(os.TempDir(), "neorg_result_cache")
+	}
+	dir := getEnv("RESULT_CACHE_DIR", defaultDir)
+	if dir == "" {
+		return nil
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		logger.WithError(err).Error("Failed to create result cache directory, caching disabled")
+		return nil
+	}
+
+	cache := &ResultCache{
+		dir:        dir,
+		entries:    map[string]*CacheEntry{},
+		remote:     remote,
+		maxBytes:   getEnvInt64("RESULT_CACHE_MAX_BYTES", 0),
+		maxEntries: int(getEnvInt64("RESULT_CACHE_MAX_ENTRIES", 0)),
+		ttl:        time.Duration(getEnvInt64("RESULT_CACHE_TTL_SECONDS", 0)) * time.Second,
+	}
+	// Temporary files of writes cut short by a previous run's exit
+	stale, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	for _, path := range stale {
+		os.Remove(path)
+	}
+	metadata, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, path := range metadata {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry CacheEntry
+		if json.Unmarshal(data, &entry) != nil || entry.Key == "" {
+			continue
+		}
+		if _, err := os.Stat(cache.archivePath(entry.Key)); err != nil {
+			os.Remove(path)
+			continue
+		}
+		cache.entries[entry.Key] = &entry
+	}
+	cache.evictLocked()
+
+	logger.WithFields(logrus.Fields{
+		"entries": len(cache.entries),
+		"s3":      remote != nil,
+	}).Info("Result cache enabled at " + dir)
+	return cache
+}
+
+// resultCacheKey identifies a result by the input archive digest, every option that affects output
+// and the versions of the service, converter scripts and tools that produce it, so a deploy never
+// serves results of the previous release
+func resultCacheKey(input *spooledArchive, options ConversionOptions) (key string, inputDigest string) {
+	inputDigest = input.Digest()
+	// The profile name only labels where the options came from
+	options.Profile = ""
+	encoded, _ := json.Marshal(options)
+	versions, _ := json.Marshal(builderVersions())
+	sum := sha256.Sum256(append(append([]byte(inputDigest+"\n"), encoded...), versions...))
+	return hex.EncodeToString(sum[:]), inputDigest
+}
+
+// resultETag identifies the result of converting input with options using the current service and
+// converter versions. It is weak unless the result is reproducible, since archives otherwise differ
+// in timestamps and request IDs between runs.
+func resultETag(input *spooledArchive, options ConversionOptions) string {
+	key, _ := resultCacheKey(input, options)
+	tag := `"` + key[:32] + `"`
+	if !options.Reproducible {
+		tag = "W/" + tag
+	}
+	return tag
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, comparing weakly as RFC 9110
+// requires for If-None-Match
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// requestTenant returns the tenant a request belongs to
+func requestTenant(tenant string) string {
+	if tenant = strings.TrimSpace(tenant); tenant == "" {
+		return defaultTenant
+	}
+	return tenant
+}
+
+func (c *ResultCache) archivePath(key string) string {
+	return filepath.Join(c.dir, key+".zip")
+}
+
+func (c *ResultCache) metadataPath(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// expired reports whether an entry has outlived the cache's TTL
+func (c *ResultCache) expired(entry *CacheEntry) bool {
+	return c.ttl > 0 && time.Since(entry.Created) > c.ttl
+}
+
+// Get opens the cached archive for key and records a hit or miss. The archive is opened while the
+// cache is locked, so an eviction right after cannot remove it from under the caller, who must
+// close it. Entries missing locally are fetched from S3 when the cache is backed by a bucket.
+func (c *ResultCache) Get(key string) (*os.File, CacheEntry, bool) {
+	if c == nil {
+		return nil, CacheEntry{}, false
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && c.expired(entry) {
+		c.removeLocked(key)
+		c.evictions++
+		ok = false
+	}
+	if !ok && c.remote != nil {
+		c.mu.Unlock()
+		entry, ok = c.fetchRemote(key)
+		c.mu.Lock()
+	}
+	defer c.mu.Unlock()
+
+	if !ok {
+		c.misses++
+		return nil, CacheEntry{}, false
+	}
+	archive, err := os.Open(c.archivePath(key))
+	if err != nil {
+		c.removeLocked(key)
+		c.misses++
+		return nil, CacheEntry{}, false
+	}
+	c.hits++
+	entry.Hits++
+	now := time.Now()
+	entry.LastHit = &now
+	return archive, *entry, true
+}
+
+// fetchRemote downloads an entry from S3 into the local cache; expired entries are deleted
+func (c *ResultCache) fetchRemote(key string) (*CacheEntry, bool) {
+	entry, err := c.remote.Download(key, c.archivePath(key))
+	if err != nil {
+		return nil, false
+	}
+	if c.expired(&entry) {
+		os.Remove(c.archivePath(key))
+		go c.deleteRemote(key)
+		return nil, false
+	}
+	if data, err := json.Marshal(entry); err == nil {
+		writeFileAtomically(c.metadataPath(key), func(file *os.File) error {
+			_, err := file.Write(data)
+			return err
+		})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &entry
+	c.evictLocked()
+	if current, ok := c.entries[key]; ok {
+		return current, true
+	}
+	return nil, false
+}
+
+// deleteRemote removes an entry from S3, logging failures
+func (c *ResultCache) deleteRemote(key string) {
+	if err := c.remote.Delete(key); err != nil {
+		logger.WithFields(logrus.Fields{
+			"cache_key": key,
+			"error":     err.Error(),
+		}).Warn("Failed to delete cache entry from S3")
+	}
+}
+
+// evictLocked removes expired entries, then the least recently used ones until the cache fits
+// its size and entry limits. Evicted entries stay in S3, which a bucket lifecycle rule bounds.
+func (c *ResultCache) evictLocked() {
+	var total int64
+	var live []*CacheEntry
+	for key, entry := range c.entries {
+		if c.expired(entry) {
+			c.removeLocked(key)
+			c.evictions++
+			continue
+		}
+		total += entry.Size
+		live = append(live, entry)
+	}
+	if (c.maxBytes <= 0 || total <= c.maxBytes) && (c.maxEntries <= 0 || len(live) <= c.maxEntries) {
+		return
+	}
+
+	lastUsed := func(entry *CacheEntry) time.Time {
+		if entry.LastHit != nil {
+			return *entry.LastHit
+		}
+		return entry.Created
+	}
+	sort.Slice(live, func(i, j int) bool { return lastUsed(live[i]).Before(lastUsed(live[j])) })
+	for _, entry := range live {
+		if (c.maxBytes <= 0 || total <= c.maxBytes) && (c.maxEntries <= 0 || len(c.entries) <= c.maxEntries) {
+			break
+		}
+		total -= entry.Size
+		c.removeLocked(entry.Key)
+		c.evictions++
+	}
+}
+
+// Put copies a generated archive into the cache along with the warnings of its conversion
+func (c *ResultCache) Put(key, tenant, inputDigest, zipFileName string, warnings []string) (CacheEntry, error) {
+	if c == nil {
+		return CacheEntry{}, nil
+	}
+	var size int64
+	err := writeFileAtomically(c.archivePath(key), func(file *os.File) error {
+		source, err := os.Open(zipFileName)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		size, err = io.Copy(file, source)
+		return err
+	})
+	if err != nil {
+		return CacheEntry{}, fmt.Errorf("failed to store cached archive: %v", err)
+	}
+
+	entry := &CacheEntry{Key: key, Tenant: tenant, InputDigest: inputDigest, Size: size, Created: time.Now(), Warnings: warnings}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	err = writeFileAtomically(c.metadataPath(key), func(file *os.File) error {
+		_, err := file.Write(data)
+		return err
+	})
+	if err != nil {
+		return CacheEntry{}, fmt.Errorf("failed to store cache metadata: %v", err)
+	}
+
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.evictLocked()
+	c.mu.Unlock()
+
+	// Share the entry with other instances without holding up the response
+	if c.remote != nil {
+		archive, err := os.Open(zipFileName)
+		if err != nil {
+			return *entry, nil
+		}
+		go func(entry CacheEntry) {
+			defer archive.Close()
+			if err := c.remote.Upload(archive, entry); err != nil {
+				logger.WithFields(logrus.Fields{
+					"cache_key": entry.Key,
+					"error":     err.Error(),
+				}).Warn("Failed to store cache entry in S3")
+			}
+		}(*entry)
+	}
+	return *entry, nil
+}
+
+// Entries lists the cached results, optionally restricted to one tenant, newest first
+func (c *ResultCache) Entries(tenant string) []CacheEntry {
+	if c == nil {
+		return []CacheEntry{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := []CacheEntry{}
+	for _, entry := range c.entries {
+		if tenant == "" || entry.Tenant == tenant {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Created.After(entries[j].Created) })
+	return entries
+}
+
+// Stats reports the number and size of entries and the hit rate
+func (c *ResultCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{Entries: len(c.entries), Hits: c.hits, Misses: c.misses, Evictions: c.evictions}
+	for _, entry := range c.entries {
+		stats.Bytes += entry.Size
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		stats.HitRate = float64(c.hits) / float64(lookups)
+	}
+	return stats
+}
+
+// Invalidate removes a single entry, reporting whether it existed
+func (c *ResultCache) Invalidate(key string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok {
+		return false
+	}
+	c.removeLocked(key)
+	if c.remote != nil {
+		go c.deleteRemote(key)
+	}
+	return true
+}
+
+// InvalidateTenant removes every entry belonging to tenant and returns how many were removed
+func (c *ResultCache) InvalidateTenant(tenant string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, entry := range c.entries {
+		if entry.Tenant == tenant {
+			c.removeLocked(key)
+			if c.remote != nil {
+				go c.deleteRemote(key)
+			}
+			removed++
+		}
+	}
+	return removed
+}
+
+// writeFileAtomically writes path through a temporary file of its own in the same directory,
+// renamed into place once complete, so concurrent writers of the same path never share a
+// temporary file and readers never see a partial one
+func writeFileAtomically(path string, write func(file *os.File) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = file.Chmod(0644)
+	if err == nil {
+		err = write(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (c *ResultCache) removeLocked(key string) {
+	delete(c.entries, key)
+	os.Remove(c.archivePath(key))
+	os.Remove(c.metadataPath(key))
+}
+
+type (
+	// CacheListing is the response body of GET /cache
+	CacheListing struct {
+		Stats   CacheStats   `json:"stats"`
+		Entries []CacheEntry `json:"entries"`
+	}
+
+	// CacheInvalidation is the response body of DELETE /cache
+	CacheInvalidation struct {
+		Tenant  string `json:"tenant,omitempty"`
+		Removed int    `json:"removed"`
+	}
+)
+
+// cacheHandler exposes the result cache to operators:
+//
+//	GET    /cache[?tenant=]     statistics and entries
+//	DELETE /cache?tenant=<id>   invalidate a tenant's entries
+//	DELETE /cache/<key>         invalidate one entry
+//	POST   /cache/prewarm       convert the archive in the body and cache the result
+//
+// They see and change every tenant's entries, so they take CACHE_ADMIN_TOKEN in X-Admin-Token
+// rather than the client token, and answer 404 until it is configured.
+func cacheHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	adminToken := getSecret("CACHE_ADMIN_TOKEN")
+	if adminToken == "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Cache administration is not enabled",
+			Code:  codeNotFound,
+			Id:    requestId,
+		})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(adminTokenHeader)), []byte(adminToken)) != 1 {
+		Unauthorized(w, r)
+		return
+	}
+
+	if results == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Result cache is not enabled",
+			Code:  codeNotFound,
+			Id:    requestId,
+		})
+		return
+	}
+
+	key := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cache"), "/")
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		tenant := r.URL.Query().Get("tenant")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(CacheListing{Stats: results.Stats(), Entries: results.Entries(tenant)})
+
+	case key == "" && r.Method == http.MethodDelete:
+		tenant := r.URL.Query().Get("tenant")
+		if tenant == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: "The tenant query parameter is required",
+				Code:  codeInvalidRequest,
+				Id:    requestId,
+			})
+			return
+		}
+		removed := results.InvalidateTenant(tenant)
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"tenant":     tenant,
+			"removed":    removed,
+		}).Info("Invalidated tenant cache entries")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(CacheInvalidation{Tenant: tenant, Removed: removed})
+
+	case key == "prewarm" && r.Method == http.MethodPost:
+		prewarmCache(w, r, requestId)
+
+	case key != "" && r.Method == http.MethodDelete:
+		if !results.Invalidate(key) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Cache entry not found",
+				Code:  codeNotFound,
+				Id:    requestId,
+			})
+			return
+		}
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"cache_key":  key,
+		}).Info("Invalidated cache entry")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(CacheInvalidation{Removed: 1})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Method not allowed",
+			Code:  codeMethodNotAllowed,
+			Id:    requestId,
+		})
+	}
+}
+
+// prewarmCache converts the uploaded archive with the request's options and stores the result,
+// so the first real request for the same input is served from the cache
+func prewarmCache(w http.ResponseWriter, r *http.Request, requestId string) {
+	fail := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{
+			Error: message,
+			Code:  statusErrorCode(status),
+			Id:    requestId,
+		})
+	}
+
+	archive, sourceURL, err := unpackUpload(r)
+	if rejectInvalidUpload(w, requestId, err) {
+		return
+	}
+
+	// Like conversions, the request is validated before a source_url is downloaded or a raw
+	// archive spooled
+	reject := func(status int, message string) {
+		if archive != nil {
+			archive.Close()
+		}
+		fail(status, message)
+	}
+	options, err := parseConversionOptions(r)
+	if err != nil {
+		reject(optionsErrorStatus(err), fmt.Sprintf("Invalid conversion options: %v", err))
+		return
+	}
+	options.Baseline = nil
+
+	// Pre-warming converts like a synchronous request, bounded by the same timeout
+	timeout, err := parseRequestTimeout(r.Header.Get(timeoutHeader))
+	if err != nil {
+		reject(http.StatusBadRequest, err.Error())
+		return
+	}
+	if conversionSlots.Full() {
+		if archive != nil {
+			archive.Close()
+		}
+		rejectQueueFull(w, requestId)
+		return
+	}
+
+	if sourceURL != "" {
+		archive, err = downloadSourceURL(r, sourceURL)
+		if rejectInvalidUpload(w, requestId, err) {
+			return
+		}
+	}
+	if archive == nil {
+		archive, err = getTarballData(r)
+		if rejectUploadTooLarge(w, requestId, err) {
+			return
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, "Failed to process tarball")
+			return
+		}
+	}
+	defer archive.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), conversionTimeout(timeout, defaultConversionTimeout))
+	defer cancel()
+
+	if rejectScanFailure(w, requestId, scanArchive(ctx, archive, requestId)) {
+		return
+	}
+
+	// Pre-warming is bulk work and yields to interactive conversions
+	release, err := acquireConversionSlot(ctx, priorityLow, "")
+	if errors.Is(err, errQueueFull) {
+		rejectQueueFull(w, requestId)
+		return
+	}
+	if err != nil {
+		fail(http.StatusServiceUnavailable, "Timed out waiting for a free conversion slot")
+		return
+	}
+
+	// Tracked for its warnings, which are cached with the result
+	progress := newProgress(requestId)
+	zipFileName, err := convertToArchive(ctx, archive, requestId, options, progress, "", "", "")
+	release()
+	var failure *conversionFailure
+	if errors.As(err, &failure) {
+		w.WriteHeader(failure.Status)
+		json.NewEncoder(w).Encode(failure.Body)
+		return
+	}
+	if err != nil {
+		fail(http.StatusInternalServerError, fmt.Sprintf("Documentation generation failed: %v", err))
+		return
+	}
+	defer os.Remove(zipFileName)
+
+	key, inputDigest := resultCacheKey(archive, options)
+	entry, err := results.Put(key, requestTenant(r.Header.Get(tenantHeader)), inputDigest, zipFileName, progress.Warnings())
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Error("Failed to pre-warm result cache")
+		fail(http.StatusInternalServerError, fmt.Sprintf("Failed to cache result: %v", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
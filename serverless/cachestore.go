@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3CacheTimeout bounds each transfer between the result cache and S3
+const s3CacheTimeout = 2 * time.Minute
+
+// s3CacheStore keeps cached archives and their metadata in an S3 bucket, so every instance of the
+// service shares one result cache and entries survive instances being replaced. Objects are
+// stored as <prefix><key>.zip and <prefix><key>.json, using the default AWS credential chain.
+type s3CacheStore struct {
+	bucket string
+	prefix string
+	client *s3.Client
+}
+
+// newS3CacheStoreFromEnv connects to RESULT_CACHE_S3_BUCKET, or returns nil when it is unset
+func newS3CacheStoreFromEnv() (*s3CacheStore, error) {
+	bucket := getEnv("RESULT_CACHE_S3_BUCKET", "")
+	if bucket == "" {
+		return nil, nil
+	}
+	client, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+	return &s3CacheStore{
+		bucket: bucket,
+		prefix: getEnv("RESULT_CACHE_S3_PREFIX", "neorg-cache/"),
+		client: client,
+	}, nil
+}
+
+// newS3Client connects to S3 with the default AWS credential chain
+func newS3Client() (*s3.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3CacheTimeout)
+	defer cancel()
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+	return s3.NewFromConfig(cfg), nil
+}
+
+func (s *s3CacheStore) objectKey(key string, extension string) string {
+	return path.Join(s.prefix, key+extension)
+}
+
+// Upload stores an archive and its entry; the archive is read from an open file so the local copy
+// may be evicted meanwhile
+func (s *s3CacheStore) Upload(archive *os.File, entry CacheEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3CacheTimeout)
+	defer cancel()
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.objectKey(entry.Key, ".zip")),
+		Body:        archive,
+		ContentType: aws.String("application/octet-stream"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload cached archive: %v", err)
+	}
+
+	// The entry goes last so other instances never find metadata without its archive
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.objectKey(entry.Key, ".json")),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload cache metadata: %v", err)
+	}
+	return nil
+}
+
+// Download fetches the entry for key and writes its archive to archivePath
+func (s *s3CacheStore) Download(key string, archivePath string) (CacheEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3CacheTimeout)
+	defer cancel()
+
+	metadata, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key, ".json")),
+	})
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	var entry CacheEntry
+	err = json.NewDecoder(metadata.Body).Decode(&entry)
+	metadata.Body.Close()
+	if err != nil || entry.Key != key {
+		return CacheEntry{}, fmt.Errorf("invalid cache metadata for %s", key)
+	}
+
+	archive, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key, ".zip")),
+	})
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	defer archive.Body.Close()
+
+	var size int64
+	err = writeFileAtomically(archivePath, func(file *os.File) error {
+		size, err = io.Copy(file, archive.Body)
+		return err
+	})
+	if err != nil {
+		return CacheEntry{}, fmt.Errorf("failed to download cached archive: %v", err)
+	}
+	entry.Size = size
+	return entry, nil
+}
+
+// Delete removes the archive and entry for key
+func (s *s3CacheStore) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3CacheTimeout)
+	defer cancel()
+
+	for _, extension := range []string{".json", ".zip"} {
+		_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.objectKey(key, extension)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// convertCommand is the subcommand converting a local project without the HTTP server:
+//
+//	neorg-docs convert ./my-notes -o out.zip
+const convertCommand = "convert"
+
+// optionFlag is a conversion option given on the command line, collected into the query the
+// server would have received so the same parser validates it
+type optionFlag struct {
+	name    string
+	query   url.Values
+	boolean bool
+}
+
+func (f *optionFlag) String() string {
+	if f.query == nil {
+		return ""
+	}
+	return f.query.Get(f.name)
+}
+
+func (f *optionFlag) Set(value string) error {
+	f.query.Set(f.name, value)
+	return nil
+}
+
+// IsBoolFlag lets boolean options be given as -strict instead of -strict=true
+func (f *optionFlag) IsBoolFlag() bool {
+	return f.boolean
+}
+
+// runConvertCommand runs the extraction, docgen and packaging pipeline of a conversion request on
+// a project directory or archive and writes the result to a file. Conversion options are flags
+// named like the query parameters, e.g. -layout tree -output html+tar.gz.
+func runConvertCommand(args []string) error {
+	commands := flag.NewFlagSet(convertCommand, flag.ContinueOnError)
+	commands.Usage = func() {
+		fmt.Fprintf(commands.Output(), "Usage: %s convert <directory|archive|-> [-o <file|->] [options]\n\n", filepath.Base(os.Args[0]))
+		commands.PrintDefaults()
+	}
+	outputPath := commands.String("o", "", "result file, - for stdout (default: the project's name with the output's extension)")
+	timeout := commands.Duration("timeout", defaultConversionTimeout, "time the conversion may take")
+	neorgVersion := commands.String("neorg-version", "", "pinned Neorg version to convert with, as in "+neorgVersionHeader)
+	baselinePath := commands.String("baseline", "", "manifest.json of a previous result; only changed files are written")
+
+	query := url.Values{}
+	optionsType := reflect.TypeOf(ConversionOptions{})
+	for i := 0; i < optionsType.NumField(); i++ {
+		field := optionsType.Field(i)
+		name := field.Tag.Get("query")
+		// The local user sees docgen's output on failure anyway
+		if name == "" || name == "debug" {
+			continue
+		}
+		usage := conversionParameterDocs[name]
+		if values, ok := conversionParameterValues[name]; ok && len(values()) > 0 {
+			usage += " (" + strings.Join(values(), ", ") + ")"
+		}
+		commands.Var(&optionFlag{name: name, query: query, boolean: field.Type.Kind() == reflect.Bool}, name, usage)
+	}
+
+	// Flags may follow the project, as in convert ./my-notes -o out.zip
+	var positional []string
+	for {
+		if err := commands.Parse(args); err != nil {
+			return err
+		}
+		if commands.NArg() == 0 {
+			break
+		}
+		positional = append(positional, commands.Arg(0))
+		args = commands.Args()[1:]
+	}
+	if len(positional) != 1 {
+		commands.Usage()
+		return fmt.Errorf("expected one project directory or archive, got %d", len(positional))
+	}
+	input := positional[0]
+
+	if err := loadConversionProfiles(); err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, "/?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	// Without an explicit packaging the result file's extension picks one, like an Accept header
+	for _, packager := range formats.packagers {
+		if strings.HasSuffix(*outputPath, packager.Extension) {
+			request.Header.Set("Accept", packager.ContentType)
+		}
+	}
+	if strings.HasSuffix(*outputPath, ".tgz") {
+		request.Header.Set("Accept", "application/gzip")
+	}
+	if *neorgVersion != "" {
+		request.Header.Set(neorgVersionHeader, *neorgVersion)
+	}
+	if *baselinePath != "" {
+		manifest, err := os.ReadFile(*baselinePath)
+		if err != nil {
+			return fmt.Errorf("failed to read baseline manifest: %v", err)
+		}
+		setBaselineManifest(request, manifest)
+	}
+	options, err := parseConversionOptions(request)
+	if err != nil {
+		return err
+	}
+	options.Debug = true
+
+	archive, err := readProject(input)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	requestId := uuid.New().String()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if options.DryRun {
+		plan, err := planConversion(ctx, archive, requestId, options)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+
+	start := time.Now()
+	resultFile, err := convertToArchive(ctx, archive, requestId, options, nil, "", "", "")
+	var failure *conversionFailure
+	if errors.As(err, &failure) {
+		// The body holds what a client would get: the code, per-file errors and docgen's output
+		encoder := json.NewEncoder(os.Stderr)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(failure.Body)
+		return errors.New(failure.Message)
+	}
+	if err != nil {
+		return err
+	}
+	defer os.Remove(resultFile)
+
+	format := outputFormat(options.Output)
+	destination := *outputPath
+	if destination == "" {
+		destination = projectName(input) + format.Extension
+	}
+	size, err := writeResult(resultFile, destination)
+	if err != nil {
+		return err
+	}
+	logger.WithFields(logrus.Fields{
+		"request_id":  requestId,
+		"output":      format.Name,
+		"file":        destination,
+		"bytes":       size,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}).Info("Documentation written")
+	return nil
+}
+
+// readProject spools a project directory as a tarball, or an archive file (- for stdin) as is
+func readProject(input string) (*spooledArchive, error) {
+	if input == "-" {
+		return spoolArchive(os.Stdin)
+	}
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return tarDirectory(input)
+	}
+	file, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return spoolArchive(file)
+}
+
+// projectName names the default result file after the project directory or archive
+func projectName(input string) string {
+	if input == "-" {
+		return "documentation"
+	}
+	absolute, err := filepath.Abs(input)
+	if err != nil {
+		return "documentation"
+	}
+	name := filepath.Base(absolute)
+	for _, extension := range []string{".tar.gz", ".tgz", ".tar.zst", ".tar.xz", ".tar"} {
+		if trimmed, ok := strings.CutSuffix(name, extension); ok {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// writeResult copies a packaged result to its destination (- for stdout) and returns its size
+func writeResult(resultFile string, destination string) (int64, error) {
+	source, err := os.Open(resultFile)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+
+	if destination == "-" {
+		return io.Copy(os.Stdout, source)
+	}
+	out, err := os.Create(destination)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(out, source)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destination)
+		return 0, fmt.Errorf("failed to write %s: %v", destination, err)
+	}
+	return size, nil
+}
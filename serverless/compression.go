@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Archive compression formats recognized from their magic bytes
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+	compressionXz   = "xz"
+)
+
+var compressionMagic = []struct {
+	format string
+	magic  []byte
+}{
+	{compressionGzip, []byte{0x1f, 0x8b}},
+	{compressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{compressionXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// archiveCompression sniffs how an uploaded tarball is compressed; anything unrecognized is
+// treated as a plain tar
+func archiveCompression(data []byte) string {
+	for _, candidate := range compressionMagic {
+		if bytes.HasPrefix(data, candidate.magic) {
+			return candidate.format
+		}
+	}
+	return compressionNone
+}
+
+// decompressArchive returns a reader over the tar stream in archive, decompressing gzip, zstd or
+// xz as detected. The returned function releases the decompressor.
+func decompressArchive(archive *spooledArchive) (io.Reader, func(), error) {
+	format := archiveCompression(archive.head)
+	logger.WithField("compression", format).Debug("Detected archive compression")
+
+	switch format {
+	case compressionGzip:
+		reader, err := gzip.NewReader(archive.Reader())
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzip archive: %v", err)
+		}
+		return reader, func() { reader.Close() }, nil
+	case compressionZstd:
+		reader, err := zstd.NewReader(archive.Reader())
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid zstd archive: %v", err)
+		}
+		return reader, reader.Close, nil
+	case compressionXz:
+		reader, err := xz.NewReader(archive.Reader())
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid xz archive: %v", err)
+		}
+		return reader, func() {}, nil
+	default:
+		return archive.Reader(), func() {}, nil
+	}
+}
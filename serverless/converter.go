@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Converter turns the .norg files of a workspace into markdown under <workspace>/wiki
+type Converter interface {
+	Name() string
+	Convert(ctx context.Context, workspace Workspace, options ConversionOptions) error
+}
+
+// converters lists the available backends by name
+var converters = map[string]Converter{
+	"nvim":   nvimConverter{},
+	"pandoc": pandocConverter{},
+	"native": nativeConverter{},
+}
+
+// converterAuto tries the Neovim pipeline first and falls back to pandoc when it fails
+const converterAuto = "auto"
+
+// validConverter reports whether name is a backend or "auto"
+func validConverter(name string) bool {
+	_, ok := converters[name]
+	return ok || name == converterAuto
+}
+
+// Run docgen for a single workspace, leaving the generated wiki directory inside it.
+// The backend comes from the request options, then CONVERTER_BACKEND, defaulting to auto.
+func convertWorkspace(ctx context.Context, workspace Workspace, options ConversionOptions, progress *Progress) error {
+	backend := options.Converter
+	if backend == "" {
+		backend = strings.ToLower(getEnv("CONVERTER_BACKEND", converterAuto))
+	}
+
+	logger.WithFields(logrus.Fields{
+		"workspace":      workspace.Name,
+		"workspace_root": workspace.Dir,
+		"converter":      backend,
+	}).Info("Converting Neorg workspace")
+
+	if backend != converterAuto {
+		converter, ok := converters[backend]
+		if !ok {
+			return fmt.Errorf("unknown converter backend: %s", backend)
+		}
+		return runConverter(ctx, converter, workspace, options, progress)
+	}
+
+	// Files that failed on their own are reported rather than retried with another backend, and
+	// so are workspaces with Lua hooks, which pandoc cannot run
+	err := runConverter(ctx, converters["nvim"], workspace, options, progress)
+	var fileErr *fileConversionError
+	if err == nil || ctx.Err() != nil || errors.Is(err, errInvalidUserConfig) || errors.Is(err, errInvalidLuaHooks) || errors.Is(err, errLuaHooksNotAllowed) || errors.As(err, &fileErr) || hasLuaHooks(workspace.Dir) {
+		return err
+	}
+	if _, lookErr := exec.LookPath("pandoc"); lookErr != nil {
+		return err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"workspace": workspace.Name,
+		"error":     err.Error(),
+	}).Warn("Neovim conversion failed, falling back to pandoc")
+
+	// Discard partial output from the failed backend before retrying
+	os.RemoveAll(filepath.Join(workspace.Dir, "wiki"))
+	return runConverter(ctx, converters["pandoc"], workspace, options, progress)
+}
+
+// runConverter runs one backend while tracking converted files as they appear. Timings left by
+// a backend that failed before it are discarded.
+func runConverter(ctx context.Context, converter Converter, workspace Workspace, options ConversionOptions, progress *Progress) error {
+	os.Remove(timingsFile(workspace.Dir))
+	stopWatching := progress.watchOutput(filepath.Join(workspace.Dir, "wiki"))
+	defer stopWatching()
+	defer pageCache.Evict()
+	return converter.Convert(ctx, workspace, options)
+}
+
+// nvimConverter runs the Lua docgen scripts in headless Neovim via make documentation, or on
+// the Neovim pool when one is configured
+type nvimConverter struct{}
+
+func (nvimConverter) Name() string { return "nvim" }
+
+func (nvimConverter) Convert(ctx context.Context, workspace Workspace, options ConversionOptions) error {
+	// Copy docgen files to the workspace directory
+	err := copyDocgenFiles(workspace.Dir, options)
+	if err != nil {
+		logger.WithError(err).Error("Failed to copy docgen files")
+		return fmt.Errorf("failed to copy docgen files: %w", err)
+	}
+
+	// Dispatch to a warm pooled instance when NVIM_POOL_SIZE is set, otherwise run make
+	// documentation in the workspace directory. Pooled instances have the default Neorg loaded
+	// with the deployment's module policy, so a pinned version, a narrower module policy or a
+	// config overlay always gets a fresh Neovim.
+	errorsFile := filepath.Join(workspace.Dir, "docgen", docgenErrorsFileName)
+	os.Remove(errorsFile)
+	if nvimPool != nil && options.NeorgVersion == "" && nvimPool.Serves(workspace.Dir, options) {
+		err = nvimPool.Run(ctx, workspace.Dir, markdownFlavor(options))
+	} else {
+		err = runMakeDocumentation(ctx, workspace.Dir, markdownFlavor(options), neorgVersionPath(options.NeorgVersion))
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to run make documentation")
+		return fmt.Errorf("failed to generate documentation: %w", err)
+	}
+
+	// The Lua converter skips files it fails on and reports them
+	failed, err := readDocgenErrors(errorsFile)
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &fileConversionError{Files: failed}
+	}
+	return nil
+}
+
+// pandocConverter converts each .norg file with pandoc and the bundled custom Norg reader,
+// without needing Neovim
+type pandocConverter struct{}
+
+func (pandocConverter) Name() string { return "pandoc" }
+
+func (pandocConverter) Convert(ctx context.Context, workspace Workspace, options ConversionOptions) error {
+	reader, err := filepath.Abs(filepath.Join("./docgen", "pandoc_norg_reader.lua"))
+	if err != nil {
+		return fmt.Errorf("failed to locate pandoc reader: %v", err)
+	}
+
+	wikiDir := filepath.Join(workspace.Dir, "wiki")
+	err = os.MkdirAll(wikiDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create wiki directory: %v", err)
+	}
+
+	norgFiles, err := workspaceNorgFiles(workspace.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list .norg files: %v", err)
+	}
+
+	reused := 0
+	var failed []FileError
+	durations := map[string]time.Duration{}
+	for _, norgFile := range norgFiles {
+		started := time.Now()
+		outputFile, err := wikiOutputPath(workspace.Dir, norgFile)
+		if err != nil {
+			return err
+		}
+
+		// Incremental requests reuse the pages of sources converted before
+		source, err := os.ReadFile(norgFile)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", filepath.Base(norgFile), err)
+		}
+		rel, _ := filepath.Rel(workspace.Dir, norgFile)
+		cacheKey := pageCache.Key("pandoc", options, source)
+		if pageCache.Load(cacheKey, outputFile, options) {
+			durations[filepath.ToSlash(rel)] = time.Since(started)
+			reused++
+			continue
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "pandoc", "--from", reader, "--to", pandocWriter(markdownFlavor(options)), "--output", outputFile, norgFile)
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"file":   norgFile,
+				"error":  err.Error(),
+				"stderr": stderr.String(),
+			}).Error("Pandoc conversion failed")
+			if ctx.Err() != nil {
+				return fmt.Errorf("pandoc failed on %s: %v", filepath.Base(norgFile), err)
+			}
+			// Skip the file and keep converting the others
+			os.Remove(outputFile)
+			message := strings.TrimSpace(stderr.String())
+			if message == "" {
+				message = err.Error()
+			}
+			failed = append(failed, FileError{File: filepath.ToSlash(rel), Message: message})
+			continue
+		}
+		pageCache.Store(cacheKey, outputFile)
+		durations[filepath.ToSlash(rel)] = time.Since(started)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"workspace": workspace.Name,
+		"files":     len(norgFiles),
+		"reused":    reused,
+		"failed":    len(failed),
+	}).Info("Pandoc conversion completed")
+	if err := writeFileTimings(workspace.Dir, "pandoc", durations); err != nil {
+		return fmt.Errorf("failed to record conversion timings: %v", err)
+	}
+	if len(failed) > 0 {
+		return &fileConversionError{Files: failed}
+	}
+	return nil
+}
+
+// nativeConverter parses and renders .norg files in-process with the Go Norg parser.
+// It needs neither Neovim nor pandoc and converts simple documents in milliseconds.
+type nativeConverter struct{}
+
+func (nativeConverter) Name() string { return "native" }
+
+func (nativeConverter) Convert(ctx context.Context, workspace Workspace, options ConversionOptions) error {
+	wikiDir := filepath.Join(workspace.Dir, "wiki")
+	err := os.MkdirAll(wikiDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create wiki directory: %v", err)
+	}
+
+	norgFiles, err := workspaceNorgFiles(workspace.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list .norg files: %v", err)
+	}
+
+	// Mirror the placeholder page the Lua converter writes for archives without .norg files
+	if len(norgFiles) == 0 {
+		values := localeStrings(options.Locale)
+		placeholder := "# " + values.T("no_files_title") + "\n\n" + values.T("no_files_body") + "\n\n" + values.T("no_files_hint") + "\n"
+		return os.WriteFile(filepath.Join(wikiDir, "README.md"), []byte(placeholder), 0644)
+	}
+
+	reused := 0
+	durations := map[string]time.Duration{}
+	for _, norgFile := range norgFiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		started := time.Now()
+		source, err := os.ReadFile(norgFile)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", filepath.Base(norgFile), err)
+		}
+		outputFile, err := wikiOutputPath(workspace.Dir, norgFile)
+		if err != nil {
+			return err
+		}
+
+		// Incremental requests reuse the pages of sources converted before
+		rel, _ := filepath.Rel(workspace.Dir, norgFile)
+		cacheKey := pageCache.Key("native", options, source)
+		if pageCache.Load(cacheKey, outputFile, options) {
+			durations[filepath.ToSlash(rel)] = time.Since(started)
+			reused++
+			continue
+		}
+		err = os.WriteFile(outputFile, []byte(norgToMarkdown(string(source), markdownFlavor(options))), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", filepath.Base(outputFile), err)
+		}
+		pageCache.Store(cacheKey, outputFile)
+		durations[filepath.ToSlash(rel)] = time.Since(started)
+	}
+	if err := writeFileTimings(workspace.Dir, "native", durations); err != nil {
+		return fmt.Errorf("failed to record conversion timings: %v", err)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"workspace": workspace.Name,
+		"files":     len(norgFiles),
+		"reused":    reused,
+	}).Info("Native conversion completed successfully")
+	return nil
+}
+
+// wikiOutputPath returns where a converted file goes: like the Lua converter, the wiki directory
+// mirrors the source tree, and the requested output layout is applied afterwards
+func wikiOutputPath(workspaceDir, norgFile string) (string, error) {
+	rel, err := filepath.Rel(workspaceDir, norgFile)
+	if err != nil {
+		return "", err
+	}
+	outputFile := filepath.Join(workspaceDir, "wiki", strings.TrimSuffix(rel, ".norg")+".md")
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create wiki directory: %v", err)
+	}
+	return outputFile, nil
+}
+
+// workspaceNorgFiles lists the .norg files in a workspace, skipping the generated docgen and wiki directories
+func workspaceNorgFiles(workspaceDir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(workspaceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != workspaceDir && filepath.Dir(path) == workspaceDir && (info.Name() == "docgen" || info.Name() == "wiki") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".norg") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
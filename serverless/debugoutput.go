@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// defaultDebugOutputMaxBytes caps each stream attached to error responses unless
+// DEBUG_OUTPUT_MAX_BYTES overrides it
+const defaultDebugOutputMaxBytes = 16 << 10
+
+// docgenOutputError is a failed docgen run together with what it printed, so the Lua stack trace
+// can be returned to clients that ask for it instead of only landing in the server logs
+type docgenOutputError struct {
+	Err    error
+	Stdout string
+	Stderr string
+}
+
+func (e *docgenOutputError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *docgenOutputError) Unwrap() error {
+	return e.Err
+}
+
+// DebugOutput is the docgen output attached to error responses of ?debug=1 requests. Each stream
+// keeps its last DEBUG_OUTPUT_MAX_BYTES, where stack traces end up.
+type DebugOutput struct {
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// debugOutputAllowed reports whether clients may ask for docgen output with ?debug=1
+func debugOutputAllowed() bool {
+	return getEnv("ALLOW_DEBUG_OUTPUT", "false") == "true"
+}
+
+// debugOutput returns the docgen output carried by err, or nil when it carries none
+func debugOutput(err error) *DebugOutput {
+	var outputErr *docgenOutputError
+	if !errors.As(err, &outputErr) {
+		return nil
+	}
+	limit := int(getEnvInt64("DEBUG_OUTPUT_MAX_BYTES", defaultDebugOutputMaxBytes))
+	stdout, stdoutTruncated := truncateOutput(outputErr.Stdout, limit)
+	stderr, stderrTruncated := truncateOutput(outputErr.Stderr, limit)
+	return &DebugOutput{Stdout: stdout, Stderr: stderr, Truncated: stdoutTruncated || stderrTruncated}
+}
+
+// attachDebugOutput adds the docgen output behind err to a failure answered with a plain
+// Response, when the request asked for it
+func attachDebugOutput(failure *conversionFailure, err error, options ConversionOptions) *conversionFailure {
+	body, ok := failure.Body.(Response)
+	if !options.Debug || !ok {
+		return failure
+	}
+	body.Debug = debugOutput(err)
+	failure.Body = body
+	return failure
+}
+
+// truncateOutput keeps the last limit bytes of output, starting at a character boundary
+func truncateOutput(output string, limit int) (string, bool) {
+	if limit <= 0 || len(output) <= limit {
+		return output, false
+	}
+	output = output[len(output)-limit:]
+	for len(output) > 0 && !utf8.RuneStart(output[0]) {
+		output = output[1:]
+	}
+	return output, true
+}
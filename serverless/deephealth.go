@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthCheckDocument is the bundled document ?deep=1 health checks convert
+//
+//go:embed healthcheck.norg
+var healthCheckDocument []byte
+
+// deepHealthInterval is how long a deep check's result is reused, so polling /health?deep=1
+// does not keep a converter busy
+const deepHealthInterval = 30 * time.Second
+
+var deepHealth struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// deepHealthCheck converts the bundled document with the Neovim converter, which fails when the
+// Neorg plugin or its tree-sitter parser is broken even though nvim itself starts. Results are
+// reused for deepHealthInterval and concurrent checks wait for the one running.
+func deepHealthCheck(ctx context.Context) error {
+	deepHealth.mu.Lock()
+	defer deepHealth.mu.Unlock()
+	if !deepHealth.checked.IsZero() && time.Since(deepHealth.checked) < deepHealthInterval {
+		return deepHealth.err
+	}
+
+	timeout := time.Duration(getEnvInt64("DEEP_HEALTH_TIMEOUT", 20)) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	deepHealth.err = convertHealthCheckDocument(ctx)
+	deepHealth.checked = time.Now()
+	return deepHealth.err
+}
+
+// convertHealthCheckDocument runs the bundled document through the Neovim pipeline in a scratch
+// workspace and checks the page it produced
+func convertHealthCheckDocument(ctx context.Context) error {
+	dir, err := os.MkdirTemp("", "neorg_health_*")
+	if err != nil {
+		return fmt.Errorf("failed to create health check workspace: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "healthcheck.norg"), healthCheckDocument, 0644); err != nil {
+		return fmt.Errorf("failed to write health check document: %v", err)
+	}
+	workspace := Workspace{Name: ".", Dir: dir}
+	if err := (nvimConverter{}).Convert(ctx, workspace, ConversionOptions{Locale: defaultLocale}); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("sample conversion timed out: %v", err)
+		}
+		return fmt.Errorf("sample conversion failed: %v", err)
+	}
+
+	page, err := os.ReadFile(filepath.Join(dir, "wiki", "healthcheck.md"))
+	if err != nil {
+		return fmt.Errorf("sample conversion produced no page: %v", err)
+	}
+	if !strings.Contains(string(page), "Health Check") {
+		return fmt.Errorf("sample conversion produced an unexpected page")
+	}
+	return nil
+}
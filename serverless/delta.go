@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// baselineManifestHeader carries a previous result's manifest.json, base64 encoded, to request a delta
+const baselineManifestHeader = "X-Baseline-Manifest"
+
+// Delta summarizes how a result differs from the baseline manifest the client submitted
+type Delta struct {
+	Baseline  string   `json:"baseline"`
+	Changed   []string `json:"changed"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+	// Reconverted lists the .norg sources that were converted again: those that are new or changed
+	// since the baseline, or all of them with FullConversion
+	Reconverted []string `json:"reconverted,omitempty"`
+	// FullConversion explains why the pages of unchanged sources could not be reused
+	FullConversion string `json:"full_conversion,omitempty"`
+}
+
+// parseBaselineManifest decodes the baseline manifest header value
+func parseBaselineManifest(value string) (*Manifest, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("baseline manifest is not valid base64: %v", err)
+	}
+	var baseline Manifest
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("baseline manifest is not valid JSON: %v", err)
+	}
+	if baseline.Digests == nil {
+		return nil, fmt.Errorf("baseline manifest has no file digests")
+	}
+	return &baseline, nil
+}
+
+// outputDigests hashes every generated file in the output directory, keyed by slash-separated path
+func outputDigests(outputDir string) (map[string]string, error) {
+	digests := map[string]string{}
+	err := filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(outputDir, path)
+		if err != nil {
+			return err
+		}
+		digests[filepath.ToSlash(rel)] = sha256Digest(data)["sha256"]
+		return nil
+	})
+	return digests, err
+}
+
+// applyDelta removes output files whose digest matches the baseline and lists the baseline files
+// that no longer exist, leaving only changed and added files to be packaged
+func applyDelta(outputDir string, digests map[string]string, baseline *Manifest) (*Delta, error) {
+	delta := &Delta{Baseline: baseline.Id, Changed: []string{}, Deleted: []string{}}
+
+	for name, digest := range digests {
+		if baseline.Digests[name] != digest {
+			delta.Changed = append(delta.Changed, name)
+			continue
+		}
+		err := os.Remove(filepath.Join(outputDir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to drop unchanged file %s: %v", name, err)
+		}
+		delta.Unchanged++
+	}
+
+	for name := range baseline.Digests {
+		if _, ok := digests[name]; !ok {
+			delta.Deleted = append(delta.Deleted, name)
+		}
+	}
+
+	sort.Strings(delta.Changed)
+	sort.Strings(delta.Deleted)
+	return delta, nil
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// freeDiskBytes returns the bytes available to unprivileged users on the filesystem holding dir
+func freeDiskBytes(dir string) (uint64, bool, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, true, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), true, nil
+}
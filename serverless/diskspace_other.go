@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// freeDiskBytes reports that free disk space cannot be measured, which is only supported on Linux
+func freeDiskBytes(dir string) (uint64, bool, error) {
+	return 0, false, nil
+}
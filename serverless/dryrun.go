@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// ConversionPlan is the response body of ?dry_run=1 requests: what a conversion of the archive
+	// would convert and the pages it would produce, found without running a converter
+	ConversionPlan struct {
+		Id         string          `json:"id"`
+		DryRun     bool            `json:"dry_run"`
+		Root       string          `json:"root,omitempty"`
+		Include    []string        `json:"include,omitempty"`
+		Exclude    []string        `json:"exclude,omitempty"`
+		Converter  string          `json:"converter"`
+		Layout     string          `json:"layout"`
+		Output     string          `json:"output"`
+		Workspaces []WorkspacePlan `json:"workspaces"`
+		Renames    []FileRename    `json:"renames,omitempty"`
+		Warnings   []string        `json:"warnings,omitempty"`
+	}
+
+	// WorkspacePlan is a detected workspace, where its output would land and the files in it
+	WorkspacePlan struct {
+		Name   string        `json:"name"`
+		Output string        `json:"output"`
+		Files  []PlannedFile `json:"files"`
+	}
+
+	// PlannedFile is a .norg source and the estimated path of its page in the result
+	PlannedFile struct {
+		Source string `json:"source"`
+		Output string `json:"output"`
+	}
+)
+
+// planConversion extracts an archive and lists the workspaces and .norg files a conversion with
+// the given options would convert, with the page names the layout and output format would give
+// them. Converters may still skip files that fail, and the pages they generate besides (such as
+// the placeholder of workspaces without .norg files) are not listed.
+func planConversion(ctx context.Context, archive *spooledArchive, requestId string, options ConversionOptions) (ConversionPlan, error) {
+	tempDir, err := os.MkdirTemp("", "neorg_plan_*")
+	if err != nil {
+		return ConversionPlan{}, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	extractCtx, cancelExtract := withStageTimeout(ctx, stageExtract)
+	renames, err := extractTarball(extractCtx, archive, tempDir, options, nil)
+	err = stageError(ctx, extractCtx, stageExtract, err)
+	cancelExtract()
+	if err != nil {
+		return ConversionPlan{}, fmt.Errorf("failed to extract tarball: %w", err)
+	}
+
+	searchDir := filepath.Join(tempDir, filepath.FromSlash(options.Root))
+	if info, err := os.Stat(searchDir); err != nil || !info.IsDir() {
+		return ConversionPlan{}, fmt.Errorf("%w: %s", errRootNotFound, options.Root)
+	}
+	workspaces, err := findWorkspaces(searchDir)
+	if err != nil {
+		return ConversionPlan{}, fmt.Errorf("failed to detect workspace root: %v", err)
+	}
+
+	converter := options.Converter
+	if converter == "" {
+		converter = strings.ToLower(getEnv("CONVERTER_BACKEND", converterAuto))
+	}
+	layout := options.Layout
+	if layout == "" {
+		layout = layoutFlat
+	}
+	format := outputFormat(options.Output)
+	plan := ConversionPlan{
+		Id:         requestId,
+		DryRun:     true,
+		Root:       options.Root,
+		Include:    options.Include,
+		Exclude:    options.Exclude,
+		Converter:  converter,
+		Layout:     layout,
+		Output:     format.Name,
+		Workspaces: []WorkspacePlan{},
+		Renames:    renames,
+	}
+
+	for _, workspace := range workspaces {
+		norgFiles, err := workspaceNorgFiles(workspace.Dir)
+		if err != nil {
+			return ConversionPlan{}, fmt.Errorf("failed to list .norg files: %v", err)
+		}
+		entry := WorkspacePlan{Name: workspace.Name, Files: []PlannedFile{}}
+		if len(workspaces) > 1 {
+			entry.Output = workspace.Name
+		}
+
+		// Pages are named after their sources the way the converters and the layout name them
+		pages := make([]string, 0, len(norgFiles))
+		for _, norgFile := range norgFiles {
+			rel, err := filepath.Rel(workspace.Dir, norgFile)
+			if err != nil {
+				return ConversionPlan{}, err
+			}
+			pages = append(pages, strings.TrimSuffix(filepath.ToSlash(rel), ".norg"))
+		}
+		targets := layoutTargets(pages, layout)
+		for _, page := range pages {
+			entry.Files = append(entry.Files, PlannedFile{
+				Source: path.Join(workspace.Name, page+".norg"),
+				Output: path.Join(entry.Output, formatPagePath(format, targets[page]+".md")),
+			})
+		}
+		sort.Slice(entry.Files, func(i, j int) bool { return entry.Files[i].Source < entry.Files[j].Source })
+
+		if len(entry.Files) == 0 {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("workspace %q contains no .norg files", workspace.Name))
+		}
+		plan.Workspaces = append(plan.Workspaces, entry)
+	}
+	return plan, nil
+}
+
+// serveDryRun answers a ?dry_run=1 request with the conversion plan of its archive
+func serveDryRun(ctx context.Context, w http.ResponseWriter, requestId string, archive *spooledArchive, options ConversionOptions) {
+	plan, err := planConversion(ctx, archive, requestId, options)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Warn("Failed to plan conversion")
+		// Failures map to the statuses a conversion of the archive would get
+		status, code := http.StatusInternalServerError, codeInternal
+		switch {
+		case errors.Is(err, errRootNotFound), errors.Is(err, errUnsafeLink), errors.Is(err, errInvalidArchive):
+			status, code = http.StatusBadRequest, conversionErrorCode(err)
+		case errors.Is(err, errExtractionLimit):
+			status, code = http.StatusUnprocessableEntity, codeExtractionLimit
+		case errors.Is(err, errStageTimeout), errors.Is(ctx.Err(), context.DeadlineExceeded):
+			status, code = http.StatusGatewayTimeout, codeTimeout
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to plan conversion: %v", err),
+			Code:  code,
+			Id:    requestId,
+		})
+		return
+	}
+
+	files := 0
+	for _, workspace := range plan.Workspaces {
+		files += len(workspace.Files)
+	}
+	logger.WithFields(logrus.Fields{
+		"request_id": requestId,
+		"workspaces": len(plan.Workspaces),
+		"norg_files": files,
+	}).Info("Planned conversion")
+	json.NewEncoder(w).Encode(plan)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error codes are the stable identifiers sent in the code field of error responses, so API
+// clients can branch on failures without parsing the English messages, which may change. New
+// failures get a new code rather than changing the meaning of an existing one.
+const (
+	// codeInvalidRequest is a request that is malformed apart from its archive and options
+	codeInvalidRequest = "ERR_INVALID_REQUEST"
+	// codeMethodNotAllowed is a request using an HTTP method the endpoint does not serve
+	codeMethodNotAllowed = "ERR_METHOD_NOT_ALLOWED"
+	// codeUnauthorized is a missing or wrong auth token or request signature
+	codeUnauthorized = "ERR_UNAUTHORIZED"
+	// codeInvalidArchive is an upload that is not a readable (compressed) tarball
+	codeInvalidArchive = "ERR_INVALID_ARCHIVE"
+	// codeInvalidOptions is a conversion option or profile that is unknown or out of range
+	codeInvalidOptions = "ERR_INVALID_OPTIONS"
+	// codeInvalidConfig is a workspace whose Neorg configuration overlay is invalid
+	codeInvalidConfig = "ERR_INVALID_CONFIG"
+	// codeInvalidTemplate is a workspace whose page template cannot be parsed or rendered
+	codeInvalidTemplate = "ERR_INVALID_TEMPLATE"
+	// codeInvalidHooks is a workspace whose Lua conversion hooks are invalid
+	codeInvalidHooks = "ERR_INVALID_HOOKS"
+	// codeHooksNotAllowed is a workspace with Lua conversion hooks the client may not run
+	codeHooksNotAllowed = "ERR_HOOKS_NOT_ALLOWED"
+	// codeRootNotFound is a root option naming a path that is not in the archive
+	codeRootNotFound = "ERR_ROOT_NOT_FOUND"
+	// codeUnsafeArchive is an archive whose links point outside the archive
+	codeUnsafeArchive = "ERR_UNSAFE_ARCHIVE"
+	// codeExtractionLimit is an archive that would extract beyond the configured limits
+	codeExtractionLimit = "ERR_EXTRACTION_LIMIT"
+	// codeUploadTooLarge is a request body beyond MAX_UPLOAD_BYTES
+	codeUploadTooLarge = "ERR_UPLOAD_TOO_LARGE"
+	// codeOutputTooLarge is generated documentation beyond MAX_OUTPUT_BYTES
+	codeOutputTooLarge = "ERR_OUTPUT_TOO_LARGE"
+	// codeMalwareDetected is an archive the malware scanner flagged
+	codeMalwareDetected = "ERR_MALWARE_DETECTED"
+	// codeNotAcceptable is an output format the service cannot produce for the request
+	codeNotAcceptable = "ERR_NOT_ACCEPTABLE"
+	// codeDocgenFailed is a conversion that failed while docgen or a converter ran
+	codeDocgenFailed = "ERR_DOCGEN_FAILED"
+	// codeFilesFailed is a strict conversion in which some .norg files failed to convert
+	codeFilesFailed = "ERR_FILES_FAILED"
+	// codeNoOutput is a conversion in which no workspace produced documentation
+	codeNoOutput = "ERR_NO_OUTPUT"
+	// codeLayoutFailed is a conversion whose pages could not be arranged in the requested layout
+	codeLayoutFailed = "ERR_LAYOUT_FAILED"
+	// codeTimeout is a conversion, or one of its stages, that ran out of time
+	codeTimeout = "ERR_TIMEOUT"
+	// codeQueueFull is a conversion refused because the conversion queue is full
+	codeQueueFull = "ERR_QUEUE_FULL"
+	// codeRateLimited is a client or tenant that exceeded its request rate
+	codeRateLimited = "ERR_RATE_LIMITED"
+	// codeNotFound is a job, cache entry or other resource that does not exist
+	codeNotFound = "ERR_NOT_FOUND"
+	// codeUpstreamFailed is a repository or archive that could not be downloaded from its source
+	codeUpstreamFailed = "ERR_UPSTREAM_FAILED"
+	// codeUnavailable is a dependency, such as the malware scanner or the job store, or a
+	// conversion slot that was not available in time
+	codeUnavailable = "ERR_UNAVAILABLE"
+	// codeInternal is any other failure of the service itself
+	codeInternal = "ERR_INTERNAL"
+)
+
+// errorCodes lists every code, for the error schema of the OpenAPI document
+var errorCodes = []string{
+	codeInvalidRequest, codeMethodNotAllowed, codeUnauthorized, codeInvalidArchive, codeInvalidOptions,
+	codeInvalidConfig, codeInvalidTemplate, codeInvalidHooks, codeHooksNotAllowed, codeRootNotFound,
+	codeUnsafeArchive, codeExtractionLimit, codeUploadTooLarge, codeOutputTooLarge, codeMalwareDetected,
+	codeNotAcceptable, codeDocgenFailed, codeFilesFailed, codeNoOutput, codeLayoutFailed, codeTimeout,
+	codeQueueFull, codeRateLimited, codeNotFound, codeUpstreamFailed, codeUnavailable, codeInternal,
+}
+
+// conversionErrorCode is the code for an error returned by generateDocumentation
+func conversionErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errRootNotFound):
+		return codeRootNotFound
+	case errors.Is(err, errInvalidUserConfig):
+		return codeInvalidConfig
+	case errors.Is(err, errInvalidTemplate):
+		return codeInvalidTemplate
+	case errors.Is(err, errInvalidLuaHooks):
+		return codeInvalidHooks
+	case errors.Is(err, errLuaHooksNotAllowed):
+		return codeHooksNotAllowed
+	case errors.Is(err, errUnsafeLink):
+		return codeUnsafeArchive
+	case errors.Is(err, errInvalidArchive):
+		return codeInvalidArchive
+	case errors.Is(err, errExtractionLimit):
+		return codeExtractionLimit
+	case errors.Is(err, errStageTimeout):
+		return codeTimeout
+	case errors.Is(err, errNoDocumentation):
+		return codeNoOutput
+	case errors.Is(err, errLayoutFailed):
+		return codeLayoutFailed
+	}
+	return codeDocgenFailed
+}
+
+// statusErrorCode is the code for handlers that only distinguish failures by HTTP status
+func statusErrorCode(status int) string {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return codeUnauthorized
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusNotAcceptable:
+		return codeNotAcceptable
+	case http.StatusRequestEntityTooLarge:
+		return codeUploadTooLarge
+	case http.StatusTooManyRequests:
+		return codeRateLimited
+	case http.StatusBadGateway:
+		return codeUpstreamFailed
+	case http.StatusServiceUnavailable:
+		return codeUnavailable
+	case http.StatusGatewayTimeout:
+		return codeTimeout
+	}
+	if status >= 500 {
+		return codeInternal
+	}
+	return codeInvalidRequest
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// durationSmoothing is the weight given to the newest observation in the moving average
+const durationSmoothing = 0.2
+
+// defaultConversionEstimate is assumed before any conversion has completed
+const defaultConversionEstimate = 30 * time.Second
+
+// durationEstimator keeps an exponentially weighted moving average of conversion durations,
+// used to turn a queue position into an expected wait time
+type durationEstimator struct {
+	mu      sync.Mutex
+	average time.Duration
+	samples int
+}
+
+// conversionDurations tracks how long recent end-to-end conversions took
+var conversionDurations = &durationEstimator{}
+
+// Observe records the duration of a completed conversion
+func (e *durationEstimator) Observe(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.samples == 0 {
+		e.average = d
+	} else {
+		e.average = time.Duration(durationSmoothing*float64(d) + (1-durationSmoothing)*float64(e.average))
+	}
+	e.samples++
+}
+
+// Average returns the current average conversion duration
+func (e *durationEstimator) Average() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.samples == 0 {
+		return defaultConversionEstimate
+	}
+	return e.average
+}
+
+// EstimateWait returns how long a job at the given zero-based queue position can expect to wait
+// before it starts, when conversions are spread over the given number of workers
+func (e *durationEstimator) EstimateWait(position int, workers int) time.Duration {
+	if position <= 0 {
+		return 0
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	rounds := (position + workers - 1) / workers
+	return time.Duration(rounds) * e.Average()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxFetchedArchiveBytes bounds archives the service downloads itself
+	maxFetchedArchiveBytes = 1 << 30
+	// defaultFetchTimeoutSeconds bounds downloading an archive
+	defaultFetchTimeoutSeconds = 120
+)
+
+// fetchClient downloads archives from the forges push webhooks are configured for; the
+// per-request context bounds it
+var fetchClient = &http.Client{}
+
+// sourceFetchClient downloads the archives of source_url uploads. Every redirect is checked like
+// the source_url itself, and unless SOURCE_URL_ALLOWED_HOSTS limits the hosts, connections to
+// loopback, private and link-local addresses are refused.
+var sourceFetchClient = &http.Client{
+	Transport: guardedTransport(func() bool {
+		return getEnv("SOURCE_URL_ALLOWED_HOSTS", "") == ""
+	}),
+	CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after %d redirects", len(via))
+		}
+		if _, err := checkSourceURL(request.URL.String()); err != nil {
+			return fmt.Errorf("redirect refused: %v", err)
+		}
+		return nil
+	},
+}
+
+// fetchArchive downloads an archive with client to a spool file with a GET request carrying headers,
+// failing on non-2xx responses and on archives larger than maxFetchedArchiveBytes
+func fetchArchive(ctx context.Context, client *http.Client, archiveURL string, headers map[string]string) (*spooledArchive, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(getEnvInt64("FETCH_TIMEOUT_SECONDS", defaultFetchTimeoutSeconds))*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, archiveURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("download returned %s", response.Status)
+	}
+
+	archive, err := spoolArchive(io.LimitReader(response.Body, maxFetchedArchiveBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading download: %v", err)
+	}
+	if archive.Size() > maxFetchedArchiveBytes {
+		archive.Close()
+		return nil, fmt.Errorf("archive exceeds %d bytes", maxFetchedArchiveBytes)
+	}
+	return archive, nil
+}
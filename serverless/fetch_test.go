@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestCheckSourceURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		url     string
+		wantErr bool
+	}{
+		{name: "https without allowlist", url: "https://example.com/archive.tar.gz"},
+		{name: "http", url: "http://example.com/archive.tar.gz", wantErr: true},
+		{name: "relative", url: "/archive.tar.gz", wantErr: true},
+		{name: "allowed host", allowed: "example.com", url: "https://EXAMPLE.com/archive.tar.gz"},
+		{name: "allowed subdomain", allowed: "other.org, .amazonaws.com", url: "https://bucket.s3.amazonaws.com/archive.tar.gz"},
+		{name: "host not allowed", allowed: ".amazonaws.com", url: "https://example.com/archive.tar.gz", wantErr: true},
+		{name: "suffix without dot", allowed: ".amazonaws.com", url: "https://evilamazonaws.com/archive.tar.gz", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SOURCE_URL_ALLOWED_HOSTS", test.allowed)
+			_, err := checkSourceURL(test.url)
+			if (err != nil) != test.wantErr {
+				t.Errorf("checkSourceURL(%q) = %v, want error %v", test.url, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestInternalAddress(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"::1":              true,
+		"fd00::1":          true,
+		"fe80::1":          true,
+		"::ffff:127.0.0.1": true,
+		"93.184.216.34":    false,
+		"2606:4700::1111":  false,
+	}
+	for address, want := range tests {
+		if got := internalAddress(netip.MustParseAddr(address)); got != want {
+			t.Errorf("internalAddress(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestDownloadSourceURL(t *testing.T) {
+	archive := "archive contents"
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/archive.tar.gz":
+			w.Write([]byte(archive))
+		case "/redirect":
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// Trust the test server's certificate for the length of the test, under every host name; it is
+	// issued for example.com
+	transport := sourceFetchClient.Transport.(*http.Transport)
+	tlsConfig := transport.TLSClientConfig
+	transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	transport.TLSClientConfig.ServerName = "example.com"
+	defer func() { transport.TLSClientConfig = tlsConfig }()
+
+	// server.URL is https://127.0.0.1:port; the same server is reachable as localhost
+	localhostURL := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	tests := []struct {
+		name         string
+		allowed      string
+		url          string
+		wantErr      bool
+		wantInternal bool
+	}{
+		{name: "allowed host", allowed: "127.0.0.1", url: server.URL + "/archive.tar.gz"},
+		{name: "host not allowed", allowed: "localhost", url: server.URL + "/archive.tar.gz", wantErr: true},
+		{name: "internal address without allowlist", url: server.URL + "/archive.tar.gz", wantErr: true, wantInternal: true},
+		{
+			name:    "redirect to allowed host",
+			allowed: "127.0.0.1,localhost",
+			url:     server.URL + "/redirect?to=" + localhostURL + "/archive.tar.gz",
+		},
+		{
+			name:    "redirect to host not allowed",
+			allowed: "127.0.0.1",
+			url:     server.URL + "/redirect?to=" + localhostURL + "/archive.tar.gz",
+			wantErr: true,
+		},
+		{
+			name:    "redirect to http",
+			allowed: "127.0.0.1",
+			url:     server.URL + "/redirect?to=" + strings.Replace(server.URL, "https:", "http:", 1) + "/archive.tar.gz",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SOURCE_URL_ALLOWED_HOSTS", test.allowed)
+			// Dial every test afresh, so the address check is not skipped by a kept-alive connection
+			transport.CloseIdleConnections()
+			spooled, err := downloadSourceURL(httptest.NewRequest(http.MethodPost, "/convert", nil), test.url)
+			if test.wantErr {
+				if err == nil {
+					spooled.Close()
+					t.Fatalf("downloadSourceURL(%q) succeeded, want error", test.url)
+				}
+				if test.wantInternal && !errors.Is(err, errInternalAddress) {
+					t.Errorf("downloadSourceURL(%q) = %v, want %v", test.url, err, errInternalAddress)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("downloadSourceURL(%q): %v", test.url, err)
+			}
+			defer spooled.Close()
+			if spooled.Size() != int64(len(archive)) {
+				t.Errorf("downloaded %d bytes, want %d", spooled.Size(), len(archive))
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// docgenErrorsFileName is where the Lua converter reports the files it failed to convert, inside
+// the workspace's docgen directory
+const docgenErrorsFileName = "conversion_errors.jsonl"
+
+// FileError is a .norg file that failed to convert, with the line docgen was converting when it
+// failed (0 when unknown)
+type FileError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// String formats the error as file:line: message
+func (e FileError) String() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	}
+	return e.File + ": " + e.Message
+}
+
+// fileConversionError is returned by converters when some files failed to convert; the output of
+// the other files is left in place. File paths are relative to the workspace until
+// generateDocumentation makes them relative to the conversion root.
+type fileConversionError struct {
+	Files []FileError
+}
+
+func (e *fileConversionError) Error() string {
+	return fmt.Sprintf("%d file(s) failed to convert, first %s", len(e.Files), e.Files[0])
+}
+
+// FileErrorsResponse is the error body of strict conversions in which files failed to convert,
+// and of conversions that produced nothing because every file failed
+type FileErrorsResponse struct {
+	Error      string      `json:"error"`
+	Code       string      `json:"code"`
+	Id         string      `json:"id"`
+	FileErrors []FileError `json:"file_errors,omitempty"`
+}
+
+// fileErrorsFailure answers a conversion failed by individual files with the per-file errors
+func fileErrorsFailure(status int, code string, message string, files []FileError, requestId string) *conversionFailure {
+	return &conversionFailure{
+		Status:  status,
+		Code:    code,
+		Message: message,
+		Body:    FileErrorsResponse{Error: message, Code: code, Id: requestId, FileErrors: files},
+	}
+}
+
+// readDocgenErrors reads the per-file errors the Lua converter reported, returning nil when no
+// file failed
+func readDocgenErrors(errorsFile string) ([]FileError, error) {
+	file, err := os.Open(errorsFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var files []FileError
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry FileError
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("invalid docgen error report: %v", err)
+		}
+		files = append(files, entry)
+	}
+	return files, scanner.Err()
+}
+
+// prefixFileErrors makes workspace-relative file paths relative to the conversion root
+func prefixFileErrors(files []FileError, workspacePath string) []FileError {
+	prefixed := make([]FileError, len(files))
+	for i, file := range files {
+		prefixed[i] = file
+		prefixed[i].File = path.Join(workspacePath, file.File)
+	}
+	return prefixed
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FileRename records an archive entry whose name was changed to a portable one during extraction
+type FileRename struct {
+	// Original is the entry name as it appeared in the archive, Go-quoted so invalid bytes survive JSON
+	Original string `json:"original"`
+	Renamed  string `json:"renamed"`
+}
+
+// windowsReservedNames cannot be used as a file name, with or without extension, on Windows
+var windowsReservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// archiveNames maps archive entry names to portable names. Every directory prefix is mapped once,
+// so all entries below a renamed directory land in the same place, and names that would collide on a
+// case-insensitive file system get a numeric suffix.
+type archiveNames struct {
+	mapped  map[string]string
+	used    map[string]bool
+	Renames []FileRename
+}
+
+func newArchiveNames() *archiveNames {
+	return &archiveNames{mapped: map[string]string{}, used: map[string]bool{}}
+}
+
+// Portable returns the portable, slash-separated name for an archive entry
+func (n *archiveNames) Portable(name string) string {
+	cleaned := strings.TrimPrefix(path.Clean("/"+name), "/")
+	var original, portable string
+	for _, segment := range strings.Split(cleaned, "/") {
+		if segment == "" {
+			continue
+		}
+		original = path.Join(original, segment)
+		if mapped, ok := n.mapped[original]; ok {
+			portable = mapped
+			continue
+		}
+
+		candidate := path.Join(portable, portableSegment(segment))
+		for i := 2; n.used[strings.ToLower(candidate)]; i++ {
+			ext := path.Ext(segment)
+			candidate = path.Join(portable, fmt.Sprintf("%s~%d%s", portableSegment(strings.TrimSuffix(segment, ext)), i, portableSegment(ext)))
+		}
+		n.used[strings.ToLower(candidate)] = true
+		n.mapped[original] = candidate
+		portable = candidate
+	}
+
+	if portable != cleaned {
+		n.Renames = append(n.Renames, FileRename{Original: strconv.Quote(name), Renamed: portable})
+	}
+	return portable
+}
+
+// portableSegment percent-encodes the bytes of a path segment that are not valid UTF-8 or that
+// Windows, macOS or common unzip tools cannot represent, leaving everything else untouched
+func portableSegment(segment string) string {
+	var out strings.Builder
+	for i := 0; i < len(segment); {
+		r, size := utf8.DecodeRuneInString(segment[i:])
+		if (r == utf8.RuneError && size == 1) || unsafeFileRune(r) {
+			for _, b := range []byte(segment[i : i+size]) {
+				fmt.Fprintf(&out, "%%%02X", b)
+			}
+		} else {
+			out.WriteString(segment[i : i+size])
+		}
+		i += size
+	}
+	encoded := out.String()
+
+	// Windows drops trailing dots and spaces and refuses device names such as CON or LPT1
+	if trimmed := strings.TrimRight(encoded, ". "); trimmed != encoded {
+		for _, b := range []byte(encoded[len(trimmed):]) {
+			trimmed += fmt.Sprintf("%%%02X", b)
+		}
+		encoded = trimmed
+	}
+	base := strings.ToLower(strings.SplitN(encoded, ".", 2)[0])
+	if windowsReservedNames[base] {
+		encoded = "_" + encoded
+	}
+	return encoded
+}
+
+// unsafeFileRune reports whether r is reserved or invisible in file names on common platforms
+func unsafeFileRune(r rune) bool {
+	switch r {
+	case '<', '>', ':', '"', '\\', '|', '?', '*':
+		return true
+	}
+	return unicode.IsControl(r) || r == utf8.RuneError
+}
@@ -0,0 +1,47 @@
+package main
+
+import "encoding/json"
+
+// Markdown flavors selected with ?flavor
+const (
+	// flavorGFM is GitHub Flavored Markdown, with task lists and ~~strikethrough~~
+	flavorGFM = "gfm"
+	// flavorCommonMark sticks to CommonMark: tasks keep their checkbox as literal text and
+	// strikethrough becomes <del>
+	flavorCommonMark = "commonmark"
+	// flavorPandoc is Pandoc's markdown: like GFM, with the document title in a YAML metadata
+	// block instead of a heading
+	flavorPandoc = "pandoc"
+)
+
+// flavorPandocWriters maps every flavor to the pandoc writer producing it
+var flavorPandocWriters = map[string]string{flavorGFM: "gfm", flavorCommonMark: "commonmark", flavorPandoc: "markdown"}
+
+// validFlavor reports whether name is a supported ?flavor value
+func validFlavor(name string) bool {
+	_, ok := flavorPandocWriters[name]
+	return ok
+}
+
+// markdownFlavor returns the requested flavor, GFM when none was
+func markdownFlavor(options ConversionOptions) string {
+	if options.Flavor == "" {
+		return flavorGFM
+	}
+	return options.Flavor
+}
+
+// pandocWriter returns the pandoc --to value for a flavor
+func pandocWriter(flavor string) string {
+	if writer, ok := flavorPandocWriters[flavor]; ok {
+		return writer
+	}
+	return flavorPandocWriters[flavorGFM]
+}
+
+// yamlTitleBlock is the metadata block Pandoc's markdown reads a document title from. JSON
+// strings are valid YAML, which keeps colons and quotes in titles intact.
+func yamlTitleBlock(title string) []string {
+	quoted, _ := json.Marshal(title)
+	return []string{"---", "title: " + string(quoted), "---", ""}
+}
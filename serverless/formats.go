@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errNotAcceptable is returned when no output the client accepts can be produced, answered with 406
+var errNotAcceptable = errors.New("unsupported output")
+
+// Packager bundles the collected output directory into a single result file
+type Packager struct {
+	Name        string
+	Extension   string
+	ContentType string
+	// MediaTypes are the Accept header values selecting this packager
+	MediaTypes []string
+	// Inline results are meant to be consumed directly rather than saved as a download
+	Inline bool
+	// Converters limits the page converters whose output this packager can take (empty allows all)
+	Converters []string
+	Package    func(ctx context.Context, wikiDir string, requestId string, options ConversionOptions) (string, error)
+	// Stream writes the result straight to a response instead; nil when it needs a file
+	Stream func(ctx context.Context, w io.Writer, wikiDir string, requestId string, options ConversionOptions) error
+}
+
+// PageConverter turns each workspace's generated markdown pages into the delivered format
+type PageConverter struct {
+	Name string
+	// Transform rewrites the generated wiki in place; nil keeps the markdown as is
+	Transform func(wikiDir string) error
+	// PagePath is where Transform puts a markdown page, e.g. dir/page.md; nil keeps it in place
+	PagePath func(page string) string
+}
+
+// FormatRegistry maps ?output values and Accept headers to a page converter and a packager.
+// An output names a packager (markdown pages in that packaging), a converter (its pages in the
+// packaging the Accept header asks for, zip by default) or both as converter+packager.
+type FormatRegistry struct {
+	packagers       map[string]Packager
+	converters      map[string]PageConverter
+	defaultPackager string
+}
+
+// defaultConverter delivers the generated markdown unchanged
+const defaultConverter = "markdown"
+
+// defaultOutput packages results as zip archives, as the service always has
+const defaultOutput = "zip"
+
+// formats is the registry of every output the service can produce
+var formats = &FormatRegistry{
+	packagers: map[string]Packager{
+		"zip":    {Name: "zip", Extension: ".zip", ContentType: "application/zip", MediaTypes: []string{"application/zip", "application/x-zip-compressed"}, Package: createZipArchive, Stream: streamZipArchive},
+		"tar.gz": {Name: "tar.gz", Extension: ".tar.gz", ContentType: "application/gzip", MediaTypes: []string{"application/gzip", "application/x-gzip", "application/x-tar+gzip"}, Package: createTarGzArchive},
+		"json":   {Name: "json", Extension: ".json", ContentType: "application/json", MediaTypes: []string{"application/json"}, Inline: true, Package: createJSONOutput},
+		"pdf":    {Name: "pdf", Extension: ".pdf", ContentType: "application/pdf", MediaTypes: []string{"application/pdf"}, Converters: []string{defaultConverter}, Package: createPDFOutput},
+	},
+	converters: map[string]PageConverter{
+		defaultConverter: {Name: defaultConverter},
+		"html":           {Name: "html", Transform: renderHTMLPages, PagePath: htmlPagePath},
+		"vimdoc":         {Name: "vimdoc", Transform: renderVimdocPages, PagePath: vimdocFileName},
+		"mdbook":         {Name: "mdbook", Transform: arrangeMdBook, PagePath: sitePagePath("src")},
+		"mkdocs":         {Name: "mkdocs", Transform: arrangeMkDocs, PagePath: sitePagePath("docs")},
+		"docusaurus":     {Name: "docusaurus", Transform: arrangeDocusaurus, PagePath: sitePagePath("docs")},
+	},
+	defaultPackager: defaultOutput,
+}
+
+// Lookup resolves an ?output value without consulting an Accept header
+func (f *FormatRegistry) Lookup(output string) (OutputFormat, error) {
+	return f.Negotiate(output, "")
+}
+
+// Outputs lists every ?output value the registry resolves, sorted
+func (f *FormatRegistry) Outputs() []string {
+	var outputs []string
+	for name := range f.packagers {
+		outputs = append(outputs, name)
+	}
+	for converterName := range f.converters {
+		outputs = append(outputs, converterName)
+		for packagerName, packager := range f.packagers {
+			if packager.accepts(converterName) {
+				outputs = append(outputs, converterName+"+"+packagerName)
+			}
+		}
+	}
+	sort.Strings(outputs)
+	return outputs
+}
+
+// ContentTypes lists the content types of every packager's results, sorted
+func (f *FormatRegistry) ContentTypes() []string {
+	var types []string
+	for _, packager := range f.packagers {
+		types = append(types, packager.ContentType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Negotiate resolves an ?output value, letting the Accept header pick the packager when the
+// output does not name one. A packager given explicitly wins over the Accept header. Unknown
+// outputs are plain errors; errNotAcceptable wraps combinations that cannot be produced.
+func (f *FormatRegistry) Negotiate(output string, accept string) (OutputFormat, error) {
+	// An unescaped + in a query string arrives as a space
+	output = strings.ReplaceAll(output, " ", "+")
+	converterName, packagerName := defaultConverter, ""
+	if output != "" {
+		if before, after, found := strings.Cut(output, "+"); found {
+			converterName, packagerName = before, after
+			if _, ok := f.converters[converterName]; !ok {
+				return OutputFormat{}, fmt.Errorf("unknown output format %q", output)
+			}
+			if _, ok := f.packagers[packagerName]; !ok {
+				return OutputFormat{}, fmt.Errorf("unknown output format %q", output)
+			}
+		} else if _, ok := f.packagers[output]; ok {
+			packagerName = output
+		} else if _, ok := f.converters[output]; ok {
+			converterName = output
+		} else {
+			return OutputFormat{}, fmt.Errorf("unknown output format %q", output)
+		}
+	}
+
+	if packagerName == "" {
+		negotiated, err := f.negotiatePackager(converterName, accept)
+		if err != nil {
+			return OutputFormat{}, err
+		}
+		packagerName = negotiated
+	}
+	packager := f.packagers[packagerName]
+	if !packager.accepts(converterName) {
+		return OutputFormat{}, fmt.Errorf("%w: %s pages cannot be packaged as %s", errNotAcceptable, converterName, packagerName)
+	}
+
+	converter := f.converters[converterName]
+	return OutputFormat{
+		Name:        outputName(converterName, packagerName, f.defaultPackager),
+		Extension:   packager.Extension,
+		ContentType: packager.ContentType,
+		Inline:      packager.Inline,
+		Transform:   converter.Transform,
+		PagePath:    converter.PagePath,
+		Package:     packager.Package,
+		Stream:      packager.Stream,
+	}, nil
+}
+
+// negotiatePackager picks the packager for a converter's output from an Accept header, trying
+// media ranges in order of preference; no header, or a wildcard, selects the default packager
+func (f *FormatRegistry) negotiatePackager(converterName string, accept string) (string, error) {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return f.defaultPackager, nil
+	}
+
+	names := make([]string, 0, len(f.packagers))
+	for name := range f.packagers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, mediaRange := range ranges {
+		if mediaRange == "*/*" || mediaRange == "application/*" {
+			if f.packagers[f.defaultPackager].accepts(converterName) {
+				return f.defaultPackager, nil
+			}
+		}
+		for _, name := range names {
+			packager := f.packagers[name]
+			for _, mediaType := range packager.MediaTypes {
+				if mediaType == mediaRange && packager.accepts(converterName) {
+					return name, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s pages cannot be delivered as %s", errNotAcceptable, converterName, accept)
+}
+
+// accepts reports whether the packager can take the output of the named converter
+func (p Packager) accepts(converterName string) bool {
+	if len(p.Converters) == 0 {
+		return true
+	}
+	for _, name := range p.Converters {
+		if name == converterName {
+			return true
+		}
+	}
+	return false
+}
+
+// outputName is the canonical ?output value for a combination: the packager alone for markdown,
+// the converter alone with the default packager and converter+packager otherwise
+func outputName(converterName string, packagerName string, defaultPackager string) string {
+	switch {
+	case converterName == defaultConverter:
+		return packagerName
+	case packagerName == defaultPackager:
+		return converterName
+	}
+	return converterName + "+" + packagerName
+}
+
+// parseAccept returns the media ranges of an Accept header, most preferred first; ranges with
+// q=0 are dropped
+func parseAccept(accept string) []string {
+	type weighted struct {
+		mediaRange string
+		quality    float64
+	}
+	var ranges []weighted
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, weighted{mediaRange, quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	result := make([]string, len(ranges))
+	for i, r := range ranges {
+		result[i] = r.mediaRange
+	}
+	return result
+}
+
+// validOutput reports whether name is a known output format that can be produced
+func validOutput(name string) bool {
+	_, err := formats.Lookup(name)
+	return err == nil
+}
+
+// outputFormat returns the format for a normalized output option ("" selects the default zip)
+func outputFormat(name string) OutputFormat {
+	if format, err := formats.Lookup(name); err == nil {
+		return format
+	}
+	format, _ := formats.Lookup(defaultOutput)
+	return format
+}
+
+// formatPagePath is where a format delivers a markdown page of the generated wiki
+func formatPagePath(format OutputFormat, page string) string {
+	if format.PagePath == nil {
+		return page
+	}
+	return format.PagePath(page)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Frontmatter formats selected with ?frontmatter
+const (
+	// frontmatterYAML writes a block fenced by --- lines, read by Jekyll, Hugo, MkDocs, Docusaurus
+	// and most other static site generators
+	frontmatterYAML = "yaml"
+	// frontmatterTOML writes a block fenced by +++ lines, Hugo and Zola's native format
+	frontmatterTOML = "toml"
+)
+
+var frontmatterFormats = map[string]bool{frontmatterYAML: true, frontmatterTOML: true}
+
+// leadingFrontmatterPattern matches a metadata block opening a page, such as the title block of
+// the pandoc flavor, which injected frontmatter replaces
+var leadingFrontmatterPattern = regexp.MustCompile(`\A(?:---|\+\+\+)\n(?:.*\n)*?(?:---|\+\+\+)\n\n?`)
+
+// validFrontmatter reports whether name is a supported ?frontmatter value
+func validFrontmatter(name string) bool {
+	return frontmatterFormats[name]
+}
+
+// frontmatterField is one key of a page's frontmatter, a string or a list of strings
+type frontmatterField struct {
+	Key   string
+	Value interface{}
+}
+
+// writeFrontmatter prepends frontmatter built from the @document.meta block of its source to every
+// converted page in wikiDir. pages maps each source page (workspace-relative, without .norg) to
+// its page name after the output layout. The title falls back to the document's first heading,
+// then the page name; created becomes date, updated lastmod and categories tags.
+func writeFrontmatter(workspace Workspace, wikiDir string, pages map[string]string, format string) error {
+	for source, page := range pages {
+		file := filepath.Join(wikiDir, filepath.FromSlash(page)+".md")
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		metadata := DocumentMetadata{}
+		title := path.Base(page)
+		if norg, err := os.ReadFile(filepath.Join(workspace.Dir, filepath.FromSlash(source)+".norg")); err == nil {
+			metadata = documentMetadata(source, parseDocumentMeta(string(norg)))
+			if documentTitle := parseNorg(string(norg)).Title(); documentTitle != "" {
+				title = documentTitle
+			}
+		}
+
+		fields := []frontmatterField{{"title", title}}
+		for _, field := range []frontmatterField{
+			{"description", metadata.Description},
+			{"date", metadata.Created},
+			{"lastmod", metadata.Updated},
+			{"authors", metadata.Authors},
+			{"tags", metadata.Categories},
+		} {
+			if value, ok := field.Value.(string); ok && value == "" {
+				continue
+			}
+			if values, ok := field.Value.([]string); ok && len(values) == 0 {
+				continue
+			}
+			fields = append(fields, field)
+		}
+
+		content = leadingFrontmatterPattern.ReplaceAll(content, nil)
+		content = append(renderFrontmatter(fields, format), content...)
+		if err := os.WriteFile(file, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderFrontmatter writes fields as a YAML or TOML block followed by a blank line. Values are
+// written as JSON strings and arrays, which both formats read as is.
+func renderFrontmatter(fields []frontmatterField, format string) []byte {
+	fence, separator := "---", ": "
+	if format == frontmatterTOML {
+		fence, separator = "+++", " = "
+	}
+
+	var block strings.Builder
+	block.WriteString(fence + "\n")
+	for _, field := range fields {
+		block.WriteString(field.Key + separator + frontmatterValue(field.Value) + "\n")
+	}
+	block.WriteString(fence + "\n\n")
+	return []byte(block.String())
+}
+
+// frontmatterValue encodes a value as JSON without escaping HTML characters
+func frontmatterValue(value interface{}) string {
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.ReplaceAll(strings.TrimSpace(encoded.String()), `","`, `", "`)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultGitCloneTimeoutSeconds bounds fetching a repository for /convert/git
+	defaultGitCloneTimeoutSeconds = 120
+	// maxGitRequestBytes bounds the JSON body of /convert/git
+	maxGitRequestBytes = 64 << 10
+)
+
+// GitSource is the body of POST /convert/git
+type GitSource struct {
+	URL string `json:"url"`
+	// Ref is a branch, tag or commit; the remote's default branch is used when empty
+	Ref string `json:"ref,omitempty"`
+}
+
+// validateGitSource checks that a repository URL is an https URL, on a host listed in
+// GIT_ALLOWED_HOSTS when that is set, and that the ref cannot be mistaken for a git option
+func validateGitSource(source GitSource) (*url.URL, error) {
+	repo, err := url.Parse(source.URL)
+	if err != nil || repo.Scheme != "https" || repo.Host == "" {
+		return nil, fmt.Errorf("url must be an absolute https URL")
+	}
+	if allowed := getEnv("GIT_ALLOWED_HOSTS", ""); allowed != "" {
+		permitted := false
+		for _, host := range strings.Split(allowed, ",") {
+			if strings.EqualFold(strings.TrimSpace(host), repo.Hostname()) {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return nil, fmt.Errorf("repository host %q is not allowed", repo.Hostname())
+		}
+	}
+	if strings.HasPrefix(source.Ref, "-") || strings.ContainsAny(source.Ref, " \t\n\\:~^?*[") || strings.Contains(source.Ref, "..") {
+		return nil, fmt.Errorf("invalid ref %q", source.Ref)
+	}
+	return repo, nil
+}
+
+// pinGitHost resolves the repository's host when GIT_ALLOWED_HOSTS is unset, refusing internal
+// addresses like source_url downloads do, and returns the git options making git connect to the
+// address that was checked, so a DNS answer changing before the fetch cannot lead it into the
+// network. It returns no options when GIT_ALLOWED_HOSTS limits the hosts.
+func pinGitHost(ctx context.Context, repo *url.URL) ([]string, error) {
+	if getEnv("GIT_ALLOWED_HOSTS", "") != "" {
+		return nil, nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", repo.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return nil, fmt.Errorf("failed to resolve %s: %v", repo.Hostname(), err)
+	}
+	for _, addr := range addrs {
+		if internalAddress(addr) {
+			return nil, fmt.Errorf("%w %s", errInternalAddress, addr.Unmap())
+		}
+	}
+	port := repo.Port()
+	if port == "" {
+		port = "443"
+	}
+	address := addrs[0].Unmap().String()
+	if addrs[0].Unmap().Is6() {
+		address = "[" + address + "]"
+	}
+	return []string{"-c", "http.curloptResolve=" + repo.Hostname() + ":" + port + ":" + address}, nil
+}
+
+// fetchGitRepository makes a shallow checkout of ref (HEAD when empty) from repo into dir. Fetching
+// the single ref by name works for branches, tags and, on servers that allow it, commit hashes.
+func fetchGitRepository(ctx context.Context, repo *url.URL, ref string, dir string) error {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	pinned, err := pinGitHost(ctx, repo)
+	if err != nil {
+		return err
+	}
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_CONFIG_NOSYSTEM=1")
+	if pinned != nil {
+		// Through a proxy git would not connect to the pinned address
+		env = slices.DeleteFunc(env, func(variable string) bool {
+			name, _, _ := strings.Cut(strings.ToLower(variable), "=")
+			return name == "http_proxy" || name == "https_proxy" || name == "all_proxy"
+		})
+	}
+	steps := []struct {
+		name string
+		args []string
+	}{
+		{"init", []string{"init", "--quiet", dir}},
+		{"fetch", []string{"-C", dir, "fetch", "--quiet", "--depth", "1", "--no-tags", "--", repo.String(), ref}},
+		{"checkout", []string{"-C", dir, "-c", "advice.detachedHead=false", "checkout", "--quiet", "FETCH_HEAD"}},
+	}
+	for _, step := range steps {
+		// Only https is permitted, so the request cannot reach local repositories or helper
+		// transports, and redirects are not followed, so it cannot be sent to another host
+		args := []string{"-c", "http.followRedirects=false", "-c", "protocol.allow=never", "-c", "protocol.https.allow=always"}
+		args = append(append(args, pinned...), step.args...)
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Env = env
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("timed out fetching repository")
+			}
+			lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+			return fmt.Errorf("git %s failed: %s", step.name, lines[len(lines)-1])
+		}
+	}
+	return nil
+}
+
+// tarDirectory packs the regular files and directories under dir into an uncompressed tarball,
+// leaving out the .git directory, and spools it
+func tarDirectory(dir string) (*spooledArchive, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeDirectoryTar(writer, dir))
+	}()
+	archive, err := spoolArchive(reader)
+	reader.Close()
+	return archive, err
+}
+
+// writeDirectoryTar writes the tarball tarDirectory spools to out
+func writeDirectoryTar(out io.Writer, dir string) error {
+	tw := tar.NewWriter(out)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// gitConvertHandler serves POST /convert/git: it shallow-clones {"url", "ref"} and converts the
+// checkout like an uploaded archive, taking conversion options from the query string
+func gitConvertHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	if !isAuthorized(r) {
+		Unauthorized(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Method not allowed",
+			Code:  codeMethodNotAllowed,
+			Id:    requestId,
+		})
+		return
+	}
+
+	fail := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{
+			Error: message,
+			Code:  statusErrorCode(status),
+			Id:    requestId,
+		})
+	}
+
+	var source GitSource
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGitRequestBytes)).Decode(&source); err != nil {
+		fail(http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	repo, err := validateGitSource(source)
+	if err != nil {
+		fail(http.StatusBadRequest, fmt.Sprintf("Invalid repository: %v", err))
+		return
+	}
+
+	request, ok := parseConversionRequest(w, r, requestId)
+	if !ok {
+		return
+	}
+
+	checkoutDir, err := os.MkdirTemp("", "neorg_git_"+requestId)
+	if err != nil {
+		fail(http.StatusInternalServerError, "Failed to create checkout directory")
+		return
+	}
+	defer os.RemoveAll(checkoutDir)
+
+	logger.WithFields(logrus.Fields{
+		"request_id": requestId,
+		"repository": repo.Redacted(),
+		"ref":        source.Ref,
+	}).Info("Fetching git repository")
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(getEnvInt64("GIT_CLONE_TIMEOUT_SECONDS", defaultGitCloneTimeoutSeconds))*time.Second)
+	err = fetchGitRepository(ctx, repo, source.Ref, checkoutDir)
+	cancel()
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"repository": repo.Redacted(),
+			"error":      err.Error(),
+		}).Warn("Failed to fetch git repository")
+		if errors.Is(err, errInternalAddress) {
+			fail(http.StatusBadRequest, fmt.Sprintf("Invalid repository: %v", err))
+			return
+		}
+		fail(http.StatusBadGateway, fmt.Sprintf("Failed to fetch repository: %v", err))
+		return
+	}
+
+	archive, err := tarDirectory(checkoutDir)
+	if err != nil {
+		fail(http.StatusInternalServerError, "Failed to package repository")
+		return
+	}
+
+	serveConversion(w, r, requestId, archive, request)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// luaHooksFileName is the optional Lua file read from the workspace root whose functions
+	// customize how the Neovim converter renders each kind of node
+	luaHooksFileName = "docgen_hooks.lua"
+	// maxLuaHooksBytes caps the size of the hooks file
+	maxLuaHooksBytes = 64 << 10
+)
+
+var (
+	// errInvalidLuaHooks marks a docgen_hooks.lua rejected before conversion
+	errInvalidLuaHooks = errors.New("invalid " + luaHooksFileName)
+	// errLuaHooksNotAllowed marks a docgen_hooks.lua sent by a client the deployment does not run hooks for
+	errLuaHooksNotAllowed = errors.New(luaHooksFileName + " is not allowed")
+)
+
+// luaHooksAllowed reports whether the deployment runs Lua hooks for a request. ALLOW_LUA_HOOKS
+// turns them on, and the request's auth token must grant them; see authorize.
+func luaHooksAllowed(r *http.Request) bool {
+	permissions, ok := authorize(r)
+	return ok && permissions.LuaHooks
+}
+
+// stageLuaHooks validates a workspace's docgen_hooks.lua and copies it into the docgen directory,
+// where the converter runs it in a restricted environment. It reports whether hooks were staged.
+func stageLuaHooks(projectDir, docgenDir string, allowed bool) (bool, error) {
+	source, err := os.ReadFile(filepath.Join(projectDir, luaHooksFileName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", errInvalidLuaHooks, err)
+	}
+	if !allowed {
+		return false, fmt.Errorf("%w: Lua hooks are not enabled for this client", errLuaHooksNotAllowed)
+	}
+
+	if err := validateLuaSource(source, maxLuaHooksBytes); err != nil {
+		return false, fmt.Errorf("%w: %v", errInvalidLuaHooks, err)
+	}
+
+	err = os.WriteFile(filepath.Join(docgenDir, luaHooksFileName), source, 0644)
+	if err != nil {
+		return false, fmt.Errorf("failed to stage %s: %v", luaHooksFileName, err)
+	}
+	return true, nil
+}
+
+// hasLuaHooks reports whether hooks were staged for a workspace's conversion
+func hasLuaHooks(projectDir string) bool {
+	_, err := os.Stat(filepath.Join(projectDir, "docgen", luaHooksFileName))
+	return err == nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// markdownHrefPattern matches relative links to generated .md pages in rendered HTML
+var markdownHrefPattern = regexp.MustCompile(`href="([^":#?]+)\.md(#[^"]*)?"`)
+
+// markdownTitlePattern finds a page's first top-level heading
+var markdownTitlePattern = regexp.MustCompile(`(?m)^#\s+(.+?)\s*#*\s*$`)
+
+// frontmatterTitlePattern finds the title in a YAML metadata block opening a page
+var frontmatterTitlePattern = regexp.MustCompile(`\A---\n(?:.*\n)*?title:[ \t]*(.+?)[ \t]*\n(?:.*\n)*?---\n`)
+
+// markdownPageTitle returns a page's first top-level heading, or the title of its YAML metadata
+// block, or fallback when it has neither
+func markdownPageTitle(markdown []byte, fallback string) string {
+	if match := markdownTitlePattern.FindSubmatch(markdown); match != nil {
+		return string(match[1])
+	}
+	if match := frontmatterTitlePattern.FindSubmatch(markdown); match != nil {
+		var title string
+		if json.Unmarshal(match[1], &title) == nil {
+			return title
+		}
+		return string(match[1])
+	}
+	return fallback
+}
+
+// renderHTMLPages replaces every generated .md page under wikiDir with a standalone HTML page
+// styled like /preview, so the result can be served by a static web host as is. Links between
+// pages are pointed at the .html files.
+func renderHTMLPages(wikiDir string) error {
+	var pages []string
+	err := filepath.Walk(wikiDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, ".md") {
+			pages = append(pages, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, page := range pages {
+		markdown, err := os.ReadFile(page)
+		if err != nil {
+			return err
+		}
+
+		var body bytes.Buffer
+		if err := previewRenderer.Convert(markdown, &body); err != nil {
+			return fmt.Errorf("failed to render %s: %v", filepath.Base(page), err)
+		}
+		rendered := markdownHrefPattern.ReplaceAllString(body.String(), `href="$1.html$2"`)
+
+		title := markdownPageTitle(markdown, strings.TrimSuffix(filepath.Base(page), ".md"))
+
+		var html bytes.Buffer
+		err = previewTemplate.Execute(&html, struct {
+			Title string
+			Body  template.HTML
+		}{
+			Title: title,
+			Body:  template.HTML(rendered),
+		})
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(htmlPagePath(page), html.Bytes(), 0644); err != nil {
+			return err
+		}
+		if err := os.Remove(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// htmlPagePath is the file a markdown page is rendered to
+func htmlPagePath(page string) string {
+	return strings.TrimSuffix(page, ".md") + ".html"
+}
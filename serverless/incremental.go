@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultPageCacheMaxBytes bounds the page cache unless PAGE_CACHE_MAX_BYTES is set
+const defaultPageCacheMaxBytes = 512 << 20
+
+// PageCache keeps the pages converted by the per-file backends (native and pandoc) keyed by their
+// source content, converter and the versions of the tools involved, so incremental requests only
+// re-convert the .norg files that changed since the baseline. All methods are safe to call on a
+// nil *PageCache, which caches nothing.
+type PageCache struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+}
+
+// pageCache is the process-wide page cache, nil when PAGE_CACHE_DIR is unset
+var pageCache *PageCache
+
+// pageCacheConverters are the backends that convert file by file through the page cache
+var pageCacheConverters = map[string]bool{"native": true, "pandoc": true}
+
+// newPageCacheFromEnv opens the page cache in PAGE_CACHE_DIR
+func newPageCacheFromEnv() *PageCache {
+	dir := getEnv("PAGE_CACHE_DIR", "")
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.WithError(err).Error("Failed to create page cache directory, incremental conversion disabled")
+		return nil
+	}
+	logger.Info("Page cache enabled at " + dir)
+	return &PageCache{dir: dir, maxBytes: getEnvInt64("PAGE_CACHE_MAX_BYTES", defaultPageCacheMaxBytes)}
+}
+
+// Key identifies the page a converter produces from source with the given options
+func (c *PageCache) Key(converter string, options ConversionOptions, source []byte) string {
+	versions, _ := json.Marshal(builderVersions())
+	sum := sha256.New()
+	sum.Write([]byte(converter + "\n" + options.Locale + "\n" + markdownFlavor(options) + "\n"))
+	sum.Write(versions)
+	sum.Write([]byte("\n"))
+	sum.Write(source)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func (c *PageCache) path(key string) string {
+	return filepath.Join(c.dir, key+".md")
+}
+
+// Load copies the cached page for key to outputFile, reporting whether there was one. Pages are
+// only reused for incremental requests, which carry a baseline manifest.
+func (c *PageCache) Load(key string, outputFile string, options ConversionOptions) bool {
+	if c == nil || options.Baseline == nil {
+		return false
+	}
+	if err := copyFile(c.path(key), outputFile); err != nil {
+		return false
+	}
+	// Modification times order eviction, so reused pages stay longest
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	return true
+}
+
+// Store keeps a converted page for later incremental requests
+func (c *PageCache) Store(key string, outputFile string) {
+	if c == nil {
+		return
+	}
+	err := writeFileAtomically(c.path(key), func(file *os.File) error {
+		page, err := os.Open(outputFile)
+		if err != nil {
+			return err
+		}
+		defer page.Close()
+		_, err = io.Copy(file, page)
+		return err
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to store converted page in page cache")
+	}
+}
+
+// pageReuseUnavailable explains why the pages of unchanged sources could not be reused for a delta
+// request, given the reports of its sources, or returns "" when they could. The delta itself is
+// computed from the output either way.
+func pageReuseUnavailable(reports map[string]*SourceReport) string {
+	if pageCache == nil {
+		return "PAGE_CACHE_DIR is not set on this server"
+	}
+	for _, report := range reports {
+		if report.Converter != "" && !pageCacheConverters[report.Converter] {
+			return fmt.Sprintf("the %s converter converts whole workspaces and does not reuse pages", report.Converter)
+		}
+	}
+	return ""
+}
+
+// Evict removes the least recently used pages until the cache fits PAGE_CACHE_MAX_BYTES
+func (c *PageCache) Evict() {
+	if c == nil || c.maxBytes <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	var pages []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !strings.HasSuffix(entry.Name(), ".md") {
+			continue
+		}
+		pages = append(pages, info)
+		total += info.Size()
+	}
+	if total <= c.maxBytes {
+		return
+	}
+
+	sort.Slice(pages, func(i, j int) bool { return pages[i].ModTime().Before(pages[j].ModTime()) })
+	evicted := 0
+	for _, page := range pages {
+		if total <= c.maxBytes {
+			break
+		}
+		if os.Remove(filepath.Join(c.dir, page.Name())) == nil {
+			total -= page.Size()
+			evicted++
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		"evicted":   evicted,
+		"remaining": total,
+	}).Debug("Evicted pages from page cache")
+}
+
+// sourceDigests hashes every .norg file under dir, keyed by slash-separated path, so the manifest
+// records which sources the result was built from
+func sourceDigests(dir string) (map[string]string, error) {
+	digests := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(info.Name(), ".norg") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		digests[filepath.ToSlash(rel)] = sha256Digest(data)["sha256"]
+		return nil
+	})
+	return digests, err
+}
+
+// changedSources lists the sources that are new or differ from the baseline's, sorted
+func changedSources(sources map[string]string, baseline map[string]string) []string {
+	changed := []string{}
+	for name, digest := range sources {
+		if baseline[name] != digest {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
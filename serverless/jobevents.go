@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// jobEventProgress carries the job whenever its status, stage, progress or queue position changes
+	jobEventProgress = "progress"
+	// jobEventDone carries the finished job and ends the stream
+	jobEventDone = "done"
+	// jobEventsHeartbeat is how long the stream may stay silent before a comment line keeps idle
+	// proxies from closing it
+	jobEventsHeartbeat = 15 * time.Second
+)
+
+// jobEventKey is the part of a job whose changes are pushed; stage timings tick with every sync
+// and would send an event each time
+type jobEventKey struct {
+	status        string
+	stage         string
+	percent       int
+	queuePosition int
+	estimatedWait int
+}
+
+// streamJobEvents serves GET /jobs/<id>/events as a text/event-stream. It reads the job store at
+// the interval jobs publish their progress, so it follows jobs running on any instance, and sends
+// a progress event with the job on every change and a done event once it has finished.
+func streamJobEvents(w http.ResponseWriter, r *http.Request, record JobRecord) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	controller := http.NewResponseController(w)
+	send := func(event string, job Job) error {
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		return controller.Flush()
+	}
+
+	ticker := time.NewTicker(jobSyncInterval)
+	defer ticker.Stop()
+	var last jobEventKey
+	lastWrite := time.Time{}
+	for {
+		if record.Status == jobSucceeded || record.Status == jobFailed {
+			send(jobEventDone, record.Job)
+			return
+		}
+
+		key := jobEventKey{record.Status, record.Stage, record.Percent, record.QueuePosition, record.EstimatedWait}
+		if key != last || lastWrite.IsZero() {
+			if err := send(jobEventProgress, record.Job); err != nil {
+				return
+			}
+			last, lastWrite = key, time.Now()
+		} else if time.Since(lastWrite) >= jobEventsHeartbeat {
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || controller.Flush() != nil {
+				return
+			}
+			lastWrite = time.Now()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		next, found, err := jobStore.Get(record.Id)
+		if err != nil || !found {
+			logger.WithFields(logrus.Fields{
+				"request_id": record.Id,
+				"found":      found,
+				"error":      err,
+			}).Warn("Lost background job while streaming its events")
+			return
+		}
+		record = next
+	}
+}
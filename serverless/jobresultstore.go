@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3JobResultStore keeps the archives of finished background jobs in an S3 bucket, so with a
+// shared job store any replica can serve a result, not only the one that ran the job. Objects are
+// stored as <prefix><job id><extension>.
+type s3JobResultStore struct {
+	bucket string
+	prefix string
+	client *s3.Client
+}
+
+// jobResults holds job archives in S3 when JOB_RESULTS_S3_BUCKET is set, and is nil otherwise,
+// leaving them in JOB_RESULTS_DIR
+var jobResults *s3JobResultStore
+
+// newS3JobResultStoreFromEnv connects to JOB_RESULTS_S3_BUCKET, or returns nil when it is unset
+func newS3JobResultStoreFromEnv() (*s3JobResultStore, error) {
+	bucket := getEnv("JOB_RESULTS_S3_BUCKET", "")
+	if bucket == "" {
+		return nil, nil
+	}
+	client, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+	return &s3JobResultStore{
+		bucket: bucket,
+		prefix: getEnv("JOB_RESULTS_S3_PREFIX", "neorg-jobs/"),
+		client: client,
+	}, nil
+}
+
+// Upload stores the archive at archivePath for a job and returns its object key
+func (s *s3JobResultStore) Upload(id string, archivePath string, extension string) (string, error) {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3CacheTimeout)
+	defer cancel()
+	key := path.Join(s.prefix, id+extension)
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        archive,
+		ContentType: aws.String("application/octet-stream"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload job result: %v", err)
+	}
+	return key, nil
+}
+
+// Download copies the archive stored under key into file
+func (s *s3JobResultStore) Download(ctx context.Context, key string, file *os.File) error {
+	ctx, cancel := context.WithTimeout(ctx, s3CacheTimeout)
+	defer cancel()
+	archive, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer archive.Body.Close()
+	if _, err := io.Copy(file, archive.Body); err != nil {
+		return fmt.Errorf("failed to download job result: %v", err)
+	}
+	return nil
+}
+
+// Delete removes the archive stored under key
+func (s *s3JobResultStore) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3CacheTimeout)
+	defer cancel()
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Job statuses
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+const (
+	// defaultJobTimeoutSeconds bounds a background conversion, which may run far longer than a request
+	defaultJobTimeoutSeconds = 1800
+	// defaultJobResultTTLSeconds is how long finished jobs and their archives are kept
+	defaultJobResultTTLSeconds = 3600
+	// jobSyncInterval is how often a running job publishes its progress to the job store
+	jobSyncInterval = time.Second
+)
+
+// Job is a conversion running in the background after POST / answered 202 Accepted
+type Job struct {
+	Id       string `json:"id"`
+	Status   string `json:"status"`
+	Priority string `json:"priority"`
+	// Stage is the pipeline stage (extract, docgen, zip) the job is in or failed in
+	Stage string `json:"stage,omitempty"`
+	// StageTimings are the milliseconds spent in each stage so far
+	StageTimings map[string]int64 `json:"stage_timings_ms,omitempty"`
+	Percent      int              `json:"percent"`
+	CreatedAt    time.Time        `json:"created_at"`
+	StartedAt    *time.Time       `json:"started_at,omitempty"`
+	FinishedAt   *time.Time       `json:"finished_at,omitempty"`
+	Error        string           `json:"error,omitempty"`
+	// ErrorCode is the stable code of the failure, as in error responses
+	ErrorCode string `json:"error_code,omitempty"`
+	// ErrorDetails is the error response a synchronous request would have received
+	ErrorDetails interface{} `json:"error_details,omitempty"`
+	Warnings     []string    `json:"warnings,omitempty"`
+	ResultURL    string      `json:"result_url"`
+	StatusURL    string      `json:"status_url"`
+	// QueuePosition is the job's place in the conversion queue while it is queued, 1 being the
+	// next to start, and EstimatedWait the seconds it can expect to wait before it starts
+	QueuePosition int `json:"queue_position,omitempty"`
+	EstimatedWait int `json:"estimated_wait_seconds,omitempty"`
+}
+
+// JobRecord is a job as persisted in the job store, including the state clients do not see
+type JobRecord struct {
+	Job
+	ResultFile    string `json:"result_file,omitempty"`
+	FailureStatus int    `json:"failure_status,omitempty"`
+	CallbackURL   string `json:"callback_url,omitempty"`
+	// Output is the output format the result archive is packaged in
+	Output  string `json:"output,omitempty"`
+	BaseURL string `json:"base_url,omitempty"`
+	// ResultObject is the S3 key of the archive when job results are kept in JOB_RESULTS_S3_BUCKET
+	ResultObject string `json:"result_object,omitempty"`
+	// ResultFiles lists the archive's files for the callback, read before it may be uploaded
+	ResultFiles []string `json:"result_files,omitempty"`
+}
+
+// priorityLevel returns the job's scheduling priority
+func (record JobRecord) priorityLevel() int {
+	priority, _ := parsePriority(record.Priority)
+	return priority
+}
+
+// jobRun is the in-process owner of a running job. Only the replica running a job writes its
+// record, so the local copy is authoritative and every change is written through to the store.
+type jobRun struct {
+	mu       sync.Mutex
+	record   JobRecord
+	progress *Progress
+}
+
+// update applies change to the job, folds in the current progress and saves it to the job store
+func (run *jobRun) update(change func(record *JobRecord)) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if change != nil {
+		change(&run.record)
+	}
+	run.record.Stage = run.progress.Stage()
+	run.record.StageTimings = run.progress.Timings()
+	run.record.Percent = run.progress.Percent()
+	run.record.Warnings = run.progress.Warnings()
+	if run.record.Status != jobQueued {
+		run.record.QueuePosition, run.record.EstimatedWait = 0, 0
+	} else if position := conversionSlots.Position(run.record.Id); position > 0 {
+		run.record.QueuePosition = position
+		run.record.EstimatedWait = conversionSlots.EstimatedWaitSeconds(position)
+	}
+
+	if err := jobStore.Put(run.record); err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": run.record.Id,
+			"error":      err.Error(),
+		}).Error("Failed to save background job")
+	}
+}
+
+// fail marks the job as failed with the response a synchronous request would have received
+func (run *jobRun) fail(failure *conversionFailure) {
+	run.update(func(record *JobRecord) {
+		finished := time.Now().UTC()
+		record.Status = jobFailed
+		record.FinishedAt = &finished
+		record.Error = failure.Message
+		record.ErrorCode = failure.Code
+		record.ErrorDetails = failure.Body
+		record.FailureStatus = failure.Status
+	})
+}
+
+// snapshot returns a copy of the job's current record
+func (run *jobRun) snapshot() JobRecord {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.record
+}
+
+// syncProgress publishes the job's progress to the store until done is closed
+func (run *jobRun) syncProgress(done <-chan struct{}) {
+	ticker := time.NewTicker(jobSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			run.update(nil)
+		}
+	}
+}
+
+// wantsAsync reports whether a conversion should run in the background: when the client asks for it
+// with ?async=true or "Prefer: respond-async", or when the upload exceeds ASYNC_THRESHOLD_BYTES
+func wantsAsync(r *http.Request, tarballSize int64) bool {
+	if async, err := strconv.ParseBool(r.URL.Query().Get("async")); err == nil {
+		return async
+	}
+	for _, preference := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+			return true
+		}
+	}
+	threshold := getEnvInt64("ASYNC_THRESHOLD_BYTES", 0)
+	return threshold > 0 && tarballSize >= threshold
+}
+
+// jobResultsDir is where finished background jobs keep their archives until they expire
+func jobResultsDir() string {
+	return getEnv("JOB_RESULTS_DIR", filepath.Join(os.TempDir(), "neorg_jobs"))
+}
+
+// submitJob starts a background conversion and answers 202 Accepted with the job's status URL.
+// When callbackURL is set the finished job is also POSTed there. The job takes over the archive.
+func submitJob(w http.ResponseWriter, r *http.Request, requestId string, archive *spooledArchive, options ConversionOptions, priority int, callbackURL string, timeout time.Duration, cacheKey string, inputDigest string, metrics *jobMetrics) {
+	run := &jobRun{
+		record: JobRecord{
+			Job: Job{
+				Id:        requestId,
+				Status:    jobQueued,
+				Priority:  priorityName(priority),
+				CreatedAt: time.Now().UTC(),
+				ResultURL: "/jobs/" + requestId + "/result",
+				StatusURL: "/jobs/" + requestId,
+			},
+			CallbackURL: callbackURL,
+			Output:      options.Output,
+			BaseURL:     publicBaseURL(),
+		},
+		progress: newProgress(requestId),
+	}
+	// Until the job joins the queue it reports the place it is about to take
+	if position := conversionSlots.ProjectedPosition(priority); position > 0 {
+		run.record.QueuePosition = position
+		run.record.EstimatedWait = conversionSlots.EstimatedWaitSeconds(position)
+	}
+	run.update(nil)
+
+	tenant := requestTenant(r.Header.Get(tenantHeader))
+	go runJob(run, archive, options, tenant, timeout, cacheKey, inputDigest, metrics)
+
+	logger.WithFields(logrus.Fields{
+		"request_id":   requestId,
+		"tarball_size": archive.Size(),
+		"priority":     priorityName(priority),
+	}).Info("Accepted documentation generation as a background job")
+
+	w.Header().Set("Location", run.record.StatusURL)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run.snapshot().Job)
+}
+
+// runJob converts an archive in the background and records the outcome in the job store, removing
+// the archive when done
+func runJob(run *jobRun, archive *spooledArchive, options ConversionOptions, tenant string, timeout time.Duration, cacheKey string, inputDigest string, metrics *jobMetrics) {
+	id := run.record.Id
+	defer archive.Close()
+	defer metrics.Emit()
+	defer func() {
+		ttl := time.Duration(getEnvInt64("JOB_RESULT_TTL_SECONDS", defaultJobResultTTLSeconds)) * time.Second
+		time.AfterFunc(ttl, func() { expireJob(jobStore, id) })
+	}()
+	defer func() {
+		if record := run.snapshot(); record.CallbackURL != "" {
+			notifyCallback(record)
+		}
+	}()
+	// Unlike handlers, a panicking goroutine is not recovered by net/http and would stop the server
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger.WithFields(logrus.Fields{
+				"request_id": id,
+				"panic":      fmt.Sprint(recovered),
+			}).Error("Background job panicked")
+			run.fail(failConversion(http.StatusInternalServerError, codeInternal, "Documentation generation failed", id))
+		}
+	}()
+
+	jobTimeout := time.Duration(getEnvInt64("JOB_TIMEOUT_SECONDS", defaultJobTimeoutSeconds)) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), conversionTimeout(timeout, jobTimeout))
+	defer cancel()
+
+	// Publish the job's place in the queue while it waits, then its progress once it runs
+	done := make(chan struct{})
+	defer close(done)
+	go run.syncProgress(done)
+
+	// Wait for a conversion slot; higher priority jobs are started first
+	release, err := acquireConversionSlot(ctx, run.record.priorityLevel(), id)
+	if errors.Is(err, errQueueFull) {
+		run.fail(failConversion(http.StatusTooManyRequests, codeQueueFull, "Conversion queue is full", id))
+		return
+	}
+	if err != nil {
+		run.fail(failConversion(http.StatusServiceUnavailable, codeUnavailable, "Job timed out waiting for a free conversion slot", id))
+		return
+	}
+	defer release()
+
+	run.update(func(record *JobRecord) {
+		started := time.Now().UTC()
+		record.Status = jobRunning
+		record.StartedAt = &started
+	})
+
+	zipFileName, err := convertToArchive(ctx, archive, id, options, run.progress, tenant, cacheKey, inputDigest)
+	var size int64
+	var resultObject string
+	var resultFiles []string
+	if err == nil {
+		if info, statErr := os.Stat(zipFileName); statErr == nil {
+			size = info.Size()
+		}
+		if run.record.CallbackURL != "" {
+			resultFiles = archiveFileList(zipFileName)
+		}
+		zipFileName, resultObject, err = storeJobResult(id, zipFileName, outputFormat(options.Output).Extension)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"request_id": id,
+				"error":      err.Error(),
+			}).Error("Failed to store background job result")
+			err = failConversion(http.StatusInternalServerError, codeInternal, fmt.Sprintf("Failed to store job result: %v", err), id)
+		}
+	}
+
+	var failure *conversionFailure
+	if errors.As(err, &failure) {
+		run.fail(failure)
+		return
+	}
+
+	run.update(func(record *JobRecord) {
+		finished := time.Now().UTC()
+		record.Status = jobSucceeded
+		record.FinishedAt = &finished
+		record.ResultFile = zipFileName
+		record.ResultObject = resultObject
+		record.ResultFiles = resultFiles
+	})
+	if size > 0 {
+		convertedFiles, _ := run.progress.Counts()
+		metrics.Succeed(size, convertedFiles)
+	}
+	logger.WithFields(logrus.Fields{
+		"request_id": id,
+	}).Info("Background job finished")
+}
+
+// expireJob forgets a finished job and removes its result archive
+func expireJob(store JobStore, id string) {
+	record, ok, err := store.Get(id)
+	if err == nil && ok && record.ResultFile != "" {
+		os.Remove(record.ResultFile)
+	}
+	if err == nil && ok && record.ResultObject != "" && jobResults != nil {
+		if err := jobResults.Delete(record.ResultObject); err != nil {
+			logger.WithFields(logrus.Fields{
+				"request_id": id,
+				"error":      err.Error(),
+			}).Warn("Failed to remove expired background job result from S3")
+		}
+	}
+	if err := store.Delete(id); err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": id,
+			"error":      err.Error(),
+		}).Warn("Failed to remove expired background job")
+	}
+}
+
+// storeJobResult moves a finished archive into the job results directory, or uploads it to
+// JOB_RESULTS_S3_BUCKET and returns its object key instead
+func storeJobResult(id string, zipFileName string, extension string) (string, string, error) {
+	if jobResults != nil {
+		defer os.Remove(zipFileName)
+		key, err := jobResults.Upload(id, zipFileName, extension)
+		if err != nil {
+			return "", "", err
+		}
+		return "", key, nil
+	}
+
+	dir := jobResultsDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	dest := filepath.Join(dir, id+extension)
+	if err := os.Rename(zipFileName, dest); err == nil {
+		return dest, "", nil
+	}
+	// The working directory may be on a different file system than the results directory
+	defer os.Remove(zipFileName)
+	if err := copyFile(zipFileName, dest); err != nil {
+		return "", "", err
+	}
+	return dest, "", nil
+}
+
+// sendJobResult sends the archive of a succeeded job, fetching it from S3 when it is kept there.
+// Without shared storage only the replica that ran the job holds the archive; the others answer
+// 404 rather than failing to open it.
+func sendJobResult(w http.ResponseWriter, r *http.Request, record JobRecord) {
+	format := outputFormat(record.Output)
+	if record.ResultObject == "" {
+		if _, err := os.Stat(record.ResultFile); errors.Is(err, fs.ErrNotExist) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(Response{Error: "Job result is not stored on this instance", Code: codeNotFound, Id: record.Id})
+			return
+		}
+		sendArchive(w, record.Id, record.ResultFile, format, len(record.Warnings))
+		return
+	}
+
+	if jobResults == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Response{Error: "Job results are kept in S3, but JOB_RESULTS_S3_BUCKET is not set", Code: codeUnavailable, Id: record.Id})
+		return
+	}
+	file, err := os.CreateTemp("", "neorg_job_result_*"+format.Extension)
+	if err == nil {
+		defer os.Remove(file.Name())
+		defer file.Close()
+		err = jobResults.Download(r.Context(), record.ResultObject, file)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": record.Id,
+			"error":      err.Error(),
+		}).Error("Failed to fetch background job result from S3")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Response{Error: "Job result storage unavailable", Code: codeUnavailable, Id: record.Id})
+		return
+	}
+	sendArchiveFile(w, record.Id, file, format, len(record.Warnings))
+}
+
+// jobsHandler serves GET /jobs/<id> with the job's status, stage, timestamps and errors,
+// GET /jobs/<id>/result with the archive once the job succeeded, the original error response if
+// it failed, and 202 with the job while it is still running, and GET /jobs/<id>/events with a
+// stream of the job's progress
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isAuthorized(r) {
+		Unauthorized(w, r)
+		return
+	}
+
+	id, result := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/result")
+	id, events := strings.CutSuffix(id, "/events")
+	w.Header().Set("request-id", id)
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Error: "Method not allowed", Code: codeMethodNotAllowed, Id: id})
+		return
+	}
+
+	record, found, err := jobStore.Get(id)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": id,
+			"error":      err.Error(),
+		}).Error("Failed to read background job")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Response{Error: "Job store unavailable", Code: codeUnavailable, Id: id})
+		return
+	}
+	if !found || strings.Contains(id, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{Error: "Job not found", Code: codeNotFound, Id: id})
+		return
+	}
+
+	if events {
+		streamJobEvents(w, r, record)
+		return
+	}
+	if !result {
+		json.NewEncoder(w).Encode(record.Job)
+		return
+	}
+
+	if len(record.StageTimings) > 0 {
+		w.Header().Set("Server-Timing", serverTimingHeader(record.StageTimings))
+	}
+	switch record.Status {
+	case jobSucceeded:
+		sendJobResult(w, r, record)
+	case jobFailed:
+		w.WriteHeader(record.FailureStatus)
+		json.NewEncoder(w).Encode(record.ErrorDetails)
+	default:
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(record.Job)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+// JobStore persists background jobs. Only the replica running a job writes it, but any replica
+// may read it, so a shared store lets status requests land on any instance behind a load balancer.
+type JobStore interface {
+	// Put creates or replaces a job record
+	Put(record JobRecord) error
+	// Get returns the job with the given id and whether it exists
+	Get(id string) (JobRecord, bool, error)
+	// Delete removes a job record
+	Delete(id string) error
+}
+
+// jobStore holds the background jobs, selected by JOB_STORE in main
+var jobStore JobStore = newMemoryJobStore()
+
+// newJobStoreFromEnv selects the job store from JOB_STORE: memory (default), redis or sqlite
+func newJobStoreFromEnv() (JobStore, error) {
+	switch strings.ToLower(getEnv("JOB_STORE", "memory")) {
+	case "", "memory":
+		return newMemoryJobStore(), nil
+	case "redis":
+		return newRedisJobStore(getSecret("REDIS_URL"), getEnv("REDIS_KEY_PREFIX", "neorg:job:"))
+	case "sqlite":
+		return newSQLiteJobStore(getEnv("JOB_STORE_PATH", filepath.Join(jobResultsDir(), "jobs.db")))
+	default:
+		return nil, fmt.Errorf("unknown JOB_STORE %q", getEnv("JOB_STORE", ""))
+	}
+}
+
+// memoryJobStore keeps jobs in process memory; they are lost on restart and invisible to other replicas
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]JobRecord
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{jobs: map[string]JobRecord{}}
+}
+
+func (s *memoryJobStore) Put(record JobRecord) error {
+	s.mu.Lock()
+	s.jobs[record.Id] = record
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryJobStore) Get(id string) (JobRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.jobs[id]
+	return record, ok, nil
+}
+
+func (s *memoryJobStore) Delete(id string) error {
+	s.mu.Lock()
+	delete(s.jobs, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// redisJobStore keeps jobs as JSON strings in Redis. Keys expire after the job timeout plus the
+// result TTL, so records of jobs on a replica that died are eventually cleaned up too.
+type redisJobStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// newRedisJobStore connects to the Redis server at redisURL (redis://[:password@]host:port/db)
+func newRedisJobStore(redisURL, prefix string) (*redisJobStore, error) {
+	if redisURL == "" {
+		return nil, fmt.Errorf("REDIS_URL is required for the redis job store")
+	}
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+
+	ttl := time.Duration(getEnvInt64("JOB_TIMEOUT_SECONDS", defaultJobTimeoutSeconds)+getEnvInt64("JOB_RESULT_TTL_SECONDS", defaultJobResultTTLSeconds)) * time.Second
+	logger.WithFields(logrus.Fields{
+		"redis_addr": options.Addr,
+		"key_prefix": prefix,
+	}).Info("Using Redis job store")
+	return &redisJobStore{client: client, prefix: prefix, ttl: ttl}, nil
+}
+
+func (s *redisJobStore) Put(record JobRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.client.Set(ctx, s.prefix+record.Id, data, s.ttl).Err()
+}
+
+func (s *redisJobStore) Get(id string) (JobRecord, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	data, err := s.client.Get(ctx, s.prefix+id).Bytes()
+	if err == redis.Nil {
+		return JobRecord{}, false, nil
+	}
+	if err != nil {
+		return JobRecord{}, false, err
+	}
+	var record JobRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return JobRecord{}, false, fmt.Errorf("corrupt job record %s: %v", id, err)
+	}
+	return record, true, nil
+}
+
+func (s *redisJobStore) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.client.Del(ctx, s.prefix+id).Err()
+}
+
+// sqliteJobStore keeps jobs in an embedded SQLite database so they survive restarts of a single node
+type sqliteJobStore struct {
+	db *sql.DB
+}
+
+// newSQLiteJobStore opens (or creates) the job database at path. Jobs that were queued or running
+// when the previous process stopped are marked failed, and finished jobs are expired on schedule.
+func newSQLiteJobStore(path string) (*sqliteJobStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create job database directory: %v", err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job database: %v", err)
+	}
+	// A single connection serializes writers instead of failing them with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`PRAGMA journal_mode = WAL;
+		CREATE TABLE IF NOT EXISTS jobs (
+			id         TEXT PRIMARY KEY,
+			status     TEXT NOT NULL,
+			record     TEXT NOT NULL,
+			updated_at INTEGER NOT NULL
+		)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize job database: %v", err)
+	}
+
+	store := &sqliteJobStore{db: db}
+	if err := store.recover(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	logger.WithField("path", path).Info("Using SQLite job store")
+	return store, nil
+}
+
+// recover fails the jobs interrupted by the last shutdown or crash and reschedules the expiry
+// of finished jobs, whose timers did not survive the restart
+func (s *sqliteJobStore) recover() error {
+	rows, err := s.db.Query(`SELECT record FROM jobs`)
+	if err != nil {
+		return fmt.Errorf("failed to read job database: %v", err)
+	}
+	var records []JobRecord
+	for rows.Next() {
+		var data string
+		var record JobRecord
+		if rows.Scan(&data) == nil && json.Unmarshal([]byte(data), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	rows.Close()
+
+	ttl := time.Duration(getEnvInt64("JOB_RESULT_TTL_SECONDS", defaultJobResultTTLSeconds)) * time.Second
+	interrupted := 0
+	for _, record := range records {
+		if record.Status == jobQueued || record.Status == jobRunning {
+			finished := time.Now().UTC()
+			record.Status = jobFailed
+			record.FinishedAt = &finished
+			record.Error = "Job was interrupted by a service restart"
+			record.FailureStatus = http.StatusInternalServerError
+			record.ErrorCode = codeInternal
+			record.ErrorDetails = Response{Error: record.Error, Code: record.ErrorCode, Id: record.Id}
+			if err := s.Put(record); err != nil {
+				return fmt.Errorf("failed to mark interrupted job %s as failed: %v", record.Id, err)
+			}
+			interrupted++
+			if record.CallbackURL != "" {
+				go notifyCallback(record)
+			}
+		}
+
+		id := record.Id
+		time.AfterFunc(time.Until(record.FinishedAt.Add(ttl)), func() { expireJob(s, id) })
+	}
+
+	if interrupted > 0 {
+		logger.WithField("jobs", interrupted).Warn("Marked jobs interrupted by a restart as failed")
+	}
+	return nil
+}
+
+func (s *sqliteJobStore) Put(record JobRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO jobs (id, status, record, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET status = excluded.status, record = excluded.record, updated_at = excluded.updated_at`,
+		record.Id, record.Status, string(data), time.Now().Unix())
+	return err
+}
+
+func (s *sqliteJobStore) Get(id string) (JobRecord, bool, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT record FROM jobs WHERE id = ?`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return JobRecord{}, false, nil
+	}
+	if err != nil {
+		return JobRecord{}, false, err
+	}
+	var record JobRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return JobRecord{}, false, fmt.Errorf("corrupt job record %s: %v", id, err)
+	}
+	return record, true, nil
+}
+
+func (s *sqliteJobStore) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM jobs WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Output layouts for the generated wiki
+const (
+	// layoutFlat names every page after its source file, as the converter always has
+	layoutFlat = "flat"
+	// layoutTree mirrors the source directory structure
+	layoutTree = "tree"
+	// layoutSlug flattens the tree into unique lowercase slugs built from the full path
+	layoutSlug = "slug"
+	// layoutGitHubWiki uses GitHub wiki page names: flat, hyphenated, Home.md as the landing
+	// page, and links without the .md extension
+	layoutGitHubWiki = "github-wiki"
+)
+
+var outputLayouts = map[string]bool{layoutFlat: true, layoutTree: true, layoutSlug: true, layoutGitHubWiki: true}
+
+// errLayoutFailed is returned when the converted pages could not be moved into the requested
+// layout; the pages are half moved by then, so the conversion fails rather than deliver them
+var errLayoutFailed = errors.New("failed to apply output layout")
+
+var (
+	// norgFileLinkPattern matches markdown links whose target is still a Norg file link, e.g.
+	// [text](:notes/todo:) or [text](:$/index:* Heading)
+	norgFileLinkPattern = regexp.MustCompile(`\]\(:([^:)]+):([^)]*)\)`)
+	// norgHeadingLinkPattern matches markdown links whose target is still a Norg link to a heading
+	// of the same document, e.g. [text](* Heading) or [text](# Heading)
+	norgHeadingLinkPattern = regexp.MustCompile(`\]\(((?:\*+|#)\s+[^)]+)\)`)
+	slugSeparators         = regexp.MustCompile(`[^a-z0-9]+`)
+	wikiNameSeparators     = regexp.MustCompile(`[\s_]+`)
+)
+
+// validLayout reports whether name is a supported output layout
+func validLayout(name string) bool {
+	return outputLayouts[name]
+}
+
+// applyOutputLayout moves the pages the converter wrote into wikiDir (mirroring the source tree)
+// into the requested layout and rewrites links between them to match, turning headings into
+// anchors with anchor. It returns the new name of every source page, both slash-separated and
+// without extension.
+func applyOutputLayout(wikiDir, layout string, anchor func(heading string) string) (map[string]string, error) {
+	if layout == "" {
+		layout = layoutFlat
+	}
+
+	var pages []string
+	err := filepath.Walk(wikiDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(info.Name(), ".md") {
+			return err
+		}
+		rel, err := filepath.Rel(wikiDir, p)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, strings.TrimSuffix(filepath.ToSlash(rel), ".md"))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list converted pages: %v", err)
+	}
+	targets := layoutTargets(pages, layout)
+
+	// Read everything before moving anything, since pages may be renamed onto each other's paths
+	contents := make(map[string][]byte, len(pages))
+	for _, page := range pages {
+		data, err := os.ReadFile(filepath.Join(wikiDir, filepath.FromSlash(page)+".md"))
+		if err != nil {
+			return nil, err
+		}
+		contents[page] = data
+	}
+
+	for _, page := range pages {
+		if err := os.Remove(filepath.Join(wikiDir, filepath.FromSlash(page)+".md")); err != nil {
+			return nil, err
+		}
+	}
+	removeEmptyDirs(wikiDir)
+
+	for _, page := range pages {
+		dest := filepath.Join(wikiDir, filepath.FromSlash(targets[page])+".md")
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return nil, err
+		}
+		rewritten := rewriteNorgLinks(contents[page], page, targets, layout, anchor)
+		if err := os.WriteFile(dest, rewritten, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+// layoutTargets maps each source page (slash-separated, without extension) to its page name in
+// the layout, numbering the names that would collide regardless of case
+func layoutTargets(pages []string, layout string) map[string]string {
+	if layout == "" {
+		layout = layoutFlat
+	}
+	sorted := append([]string(nil), pages...)
+	sort.Strings(sorted)
+
+	targets := make(map[string]string, len(sorted))
+	used := map[string]bool{}
+	for _, page := range sorted {
+		name := layoutPageName(page, layout)
+		unique := name
+		for i := 2; used[strings.ToLower(unique)]; i++ {
+			unique = fmt.Sprintf("%s-%d", name, i)
+		}
+		used[strings.ToLower(unique)] = true
+		targets[page] = unique
+	}
+	return targets
+}
+
+// removeEmptyDirs deletes the empty directories below root, deepest first
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && p != root {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
+
+// layoutPageName returns the output page name, without extension, for a source page
+func layoutPageName(page, layout string) string {
+	switch layout {
+	case layoutTree:
+		return page
+	case layoutSlug:
+		slug := strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(page), "-"), "-")
+		if slug == "" {
+			slug = "page"
+		}
+		return slug
+	case layoutGitHubWiki:
+		base := path.Base(page)
+		if page == base && (strings.EqualFold(base, "index") || strings.EqualFold(base, "readme")) {
+			return "Home"
+		}
+		return wikiNameSeparators.ReplaceAllString(base, "-")
+	default:
+		return path.Base(page)
+	}
+}
+
+// rewriteNorgLinks points Norg file links at the pages' new locations and links to headings at
+// their anchors. Links that do not resolve to a converted page are left untouched.
+func rewriteNorgLinks(content []byte, page string, targets map[string]string, layout string, anchor func(heading string) string) []byte {
+	content = norgHeadingLinkPattern.ReplaceAllFunc(content, func(match []byte) []byte {
+		fragment := anchor(string(norgHeadingLinkPattern.FindSubmatch(match)[1]))
+		if fragment == "" {
+			return match
+		}
+		return []byte("](#" + fragment + ")")
+	})
+
+	from := path.Dir(targets[page])
+	return norgFileLinkPattern.ReplaceAllFunc(content, func(match []byte) []byte {
+		groups := norgFileLinkPattern.FindSubmatch(match)
+		target := strings.TrimSuffix(strings.TrimSpace(string(groups[1])), ".norg")
+		if strings.HasPrefix(target, "$/") {
+			target = strings.TrimPrefix(target, "$/")
+		} else {
+			target = path.Join(path.Dir(page), target)
+		}
+
+		newTarget, ok := targets[path.Clean(target)]
+		if !ok {
+			return match
+		}
+
+		var link string
+		if layout == layoutGitHubWiki {
+			link = newTarget
+		} else {
+			link = relativeLink(from, newTarget) + ".md"
+		}
+		if fragment := anchor(string(groups[2])); fragment != "" {
+			link += "#" + fragment
+		}
+		return []byte("](" + link + ")")
+	})
+}
+
+// relativeLink returns the slash-separated path to target relative to the directory fromDir
+func relativeLink(fromDir, target string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(fromDir), filepath.FromSlash(target))
+	if err != nil {
+		return target
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultMaxOutputBytes caps the generated output at 1 GiB unless MAX_OUTPUT_BYTES overrides it
+	defaultMaxOutputBytes = 1 << 30
+	// defaultMaxUploadBytes caps request bodies at 1 GiB unless MAX_UPLOAD_BYTES overrides it
+	defaultMaxUploadBytes = 1 << 30
+	// defaultMaxExtractedBytes caps what an archive may extract to at 2 GiB unless
+	// MAX_EXTRACTED_BYTES overrides it
+	defaultMaxExtractedBytes = 2 << 30
+	// defaultMaxExpansionRatio is how many times its own size an archive may extract to unless
+	// MAX_EXPANSION_RATIO overrides it
+	defaultMaxExpansionRatio = 100
+	// expansionRatioFloor is how much any archive may extract to regardless of the ratio, so small
+	// archives of highly compressible text are not refused
+	expansionRatioFloor = 64 << 20
+	// defaultMaxArchiveEntries caps the files and directories an archive may create unless
+	// MAX_ARCHIVE_ENTRIES overrides it
+	defaultMaxArchiveEntries = 50000
+	// defaultMaxArchiveFileBytes caps each extracted file at 100 MiB unless MAX_ARCHIVE_FILE_BYTES
+	// overrides it
+	defaultMaxArchiveFileBytes = 100 << 20
+)
+
+// errExtractionLimit is returned when an archive would extract beyond the configured limits
+var errExtractionLimit = errors.New("archive exceeds extraction limits")
+
+// extractionLimits bounds what extractTarball writes, so decompression bombs and pathological
+// archives are refused before they fill the disk or exhaust inodes. Entries are admitted by their
+// declared size before any of their bytes are written.
+type extractionLimits struct {
+	maxBytes     int64
+	maxRatio     int64
+	maxEntries   int64
+	maxFileBytes int64
+	archiveSize  int64
+	extracted    int64
+	entries      int64
+}
+
+// newExtractionLimits reads MAX_EXTRACTED_BYTES, MAX_EXPANSION_RATIO, MAX_ARCHIVE_ENTRIES and
+// MAX_ARCHIVE_FILE_BYTES for an archive of archiveSize bytes; zero or less disables a limit
+func newExtractionLimits(archiveSize int64) *extractionLimits {
+	return &extractionLimits{
+		maxBytes:     getEnvInt64("MAX_EXTRACTED_BYTES", defaultMaxExtractedBytes),
+		maxRatio:     getEnvInt64("MAX_EXPANSION_RATIO", defaultMaxExpansionRatio),
+		maxEntries:   getEnvInt64("MAX_ARCHIVE_ENTRIES", defaultMaxArchiveEntries),
+		maxFileBytes: getEnvInt64("MAX_ARCHIVE_FILE_BYTES", defaultMaxArchiveFileBytes),
+		archiveSize:  archiveSize,
+	}
+}
+
+// admit accounts for a file or directory of size bytes about to be extracted, failing when it
+// would take the archive past any limit
+func (l *extractionLimits) admit(name string, size int64) error {
+	l.entries++
+	if l.maxEntries > 0 && l.entries > l.maxEntries {
+		return fmt.Errorf("%w: archive has more than %d files and directories", errExtractionLimit, l.maxEntries)
+	}
+	if l.maxFileBytes > 0 && size > l.maxFileBytes {
+		return fmt.Errorf("%w: %q is %d bytes, larger than %d bytes", errExtractionLimit, name, size, l.maxFileBytes)
+	}
+	l.extracted += size
+	if l.maxBytes > 0 && l.extracted > l.maxBytes {
+		return fmt.Errorf("%w: extracting %q takes the archive past %d bytes", errExtractionLimit, name, l.maxBytes)
+	}
+	if l.maxRatio > 0 && l.extracted > expansionRatioFloor && l.extracted > l.maxRatio*l.archiveSize {
+		return fmt.Errorf("%w: extracting %q expands the archive more than %d times its size", errExtractionLimit, name, l.maxRatio)
+	}
+	return nil
+}
+
+// UploadTooLargeResponse is the 413 body returned for request bodies beyond MAX_UPLOAD_BYTES
+type UploadTooLargeResponse struct {
+	Error    string `json:"error"`
+	Code     string `json:"code"`
+	Id       string `json:"id"`
+	MaxBytes int64  `json:"max_bytes"`
+}
+
+// UploadLimitMiddleware caps request bodies at MAX_UPLOAD_BYTES (zero or less disables the cap).
+// Requests declaring a larger Content-Length are refused before anything is read; bodies that
+// turn out larger fail their read with an *http.MaxBytesError, see rejectUploadTooLarge.
+func UploadLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := getEnvInt64("MAX_UPLOAD_BYTES", defaultMaxUploadBytes)
+		if limit <= 0 {
+			next(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			rejectUploadTooLarge(w, w.Header().Get("request-id"), &http.MaxBytesError{Limit: limit})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next(w, r)
+	}
+}
+
+// rejectUploadTooLarge answers 413 Content Too Large when err stems from a request body beyond
+// MAX_UPLOAD_BYTES, reporting whether it did
+func rejectUploadTooLarge(w http.ResponseWriter, requestId string, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	logger.WithFields(logrus.Fields{
+		"request_id":  requestId,
+		"limit_bytes": tooLarge.Limit,
+	}).Warn("Request body exceeds the maximum upload size")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(UploadTooLargeResponse{
+		Error:    "upload_too_large",
+		Code:     codeUploadTooLarge,
+		Id:       requestId,
+		MaxBytes: tooLarge.Limit,
+	})
+	return true
+}
+
+// outputTooLargeError reports generated output exceeding the configured size cap
+type outputTooLargeError struct {
+	Size  int64
+	Limit int64
+	// Files lists the largest output files, which together account for the excess
+	Files []string
+}
+
+func (e *outputTooLargeError) Error() string {
+	return fmt.Sprintf("generated output is %d bytes, exceeding the limit of %d bytes", e.Size, e.Limit)
+}
+
+// checkOutputSize walks the output directory and fails when its total size exceeds limit.
+// A limit of zero or less disables the check.
+func checkOutputSize(outputDir string, limit int64) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	type outputFile struct {
+		path string
+		size int64
+	}
+
+	var files []outputFile
+	var total int64
+	err := filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(outputDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, outputFile{path: filepath.ToSlash(rel), size: info.Size()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to measure output size: %v", err)
+	}
+
+	if total <= limit {
+		return nil
+	}
+
+	// List the largest files until the remainder would fit under the limit
+	sort.Slice(files, func(i, j int) bool { return files[i].size > files[j].size })
+	tooLarge := &outputTooLargeError{Size: total, Limit: limit}
+	remaining := total
+	for _, file := range files {
+		if remaining <= limit {
+			break
+		}
+		tooLarge.Files = append(tooLarge.Files, file.path)
+		remaining -= file.size
+	}
+	return tooLarge
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Extensions ?link_extension gives links between pages
+const (
+	linkExtensionMarkdown = "md"
+	linkExtensionHTML     = "html"
+	// linkExtensionNone drops the extension, as GitHub and GitLab wikis and most static site
+	// generators expect
+	linkExtensionNone = "none"
+)
+
+// Cases ?link_case gives links between pages
+const (
+	linkCasePreserve = "preserve"
+	// linkCaseLower lowercases link paths, for static site generators that lowercase page URLs
+	linkCaseLower = "lower"
+)
+
+var (
+	linkExtensions = map[string]bool{linkExtensionMarkdown: true, linkExtensionHTML: true, linkExtensionNone: true}
+	linkCases      = map[string]bool{linkCasePreserve: true, linkCaseLower: true}
+)
+
+var (
+	// markdownDestinationPattern matches markdown link destinations that may point at a page
+	markdownDestinationPattern = regexp.MustCompile(`\]\(<?([^)\s:<>]+)>?\)`)
+	// htmlHrefPattern matches relative href attributes in rendered HTML pages
+	htmlHrefPattern = regexp.MustCompile(`href="([^":]+)"`)
+)
+
+// validLinkExtension reports whether name is a supported ?link_extension value
+func validLinkExtension(name string) bool {
+	return linkExtensions[name]
+}
+
+// validLinkCase reports whether name is a supported ?link_case value
+func validLinkCase(name string) bool {
+	return linkCases[name]
+}
+
+// parseLinkPrefix checks a ?link_prefix value, which is put verbatim in front of every link
+func parseLinkPrefix(value string) (string, error) {
+	if strings.ContainsAny(value, " \t\r\n\"'<>()") {
+		return "", fmt.Errorf("link_prefix %q must not contain whitespace, quotes, angle brackets or parentheses", value)
+	}
+	return value, nil
+}
+
+// rewritesLinks reports whether the options ask for links between pages to be rewritten
+func rewritesLinks(options ConversionOptions) bool {
+	return options.LinkExtension != "" || options.LinkCase == linkCaseLower || options.LinkPrefix != ""
+}
+
+// rewritePageLinks applies ?link_extension, ?link_case and ?link_prefix to the links between the
+// pages of a workspace's wiki once the output format has delivered them. Only links resolving to
+// a delivered page are touched; with a prefix they become the prefix followed by the page's path
+// relative to wikiDir. Formats that deliver neither markdown nor HTML pages are left alone.
+func rewritePageLinks(wikiDir string, format OutputFormat, options ConversionOptions) error {
+	extension := path.Ext(formatPagePath(format, "page.md"))
+	var pattern *regexp.Regexp
+	switch extension {
+	case ".md":
+		pattern = markdownDestinationPattern
+	case ".html":
+		pattern = htmlHrefPattern
+	default:
+		return nil
+	}
+
+	pages := map[string]bool{}
+	err := filepath.Walk(wikiDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(file) != extension {
+			return err
+		}
+		rel, err := filepath.Rel(wikiDir, file)
+		if err != nil {
+			return err
+		}
+		pages[filepath.ToSlash(rel)] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for page := range pages {
+		file := filepath.Join(wikiDir, filepath.FromSlash(page))
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rewritten := pattern.ReplaceAllFunc(content, func(match []byte) []byte {
+			destination := string(pattern.FindSubmatch(match)[1])
+			target, fragment := splitLinkFragment(destination)
+
+			// GitHub wiki pages link without an extension
+			resolved := path.Join(path.Dir(page), target)
+			if path.Ext(target) != extension {
+				resolved += extension
+			}
+			if target == "" || strings.HasPrefix(target, "/") || !pages[resolved] {
+				return match
+			}
+
+			link := strings.TrimSuffix(target, path.Ext(target))
+			if path.Ext(target) != extension {
+				link = target
+			}
+			if options.LinkPrefix != "" {
+				link = strings.TrimSuffix(resolved, extension)
+			}
+			if options.LinkCase == linkCaseLower {
+				link = strings.ToLower(link)
+			}
+			if options.LinkPrefix != "" {
+				link = strings.TrimSuffix(options.LinkPrefix, "/") + "/" + link
+			}
+			switch options.LinkExtension {
+			case "":
+				if path.Ext(target) == extension {
+					link += extension
+				}
+			case linkExtensionNone:
+			default:
+				link += "." + options.LinkExtension
+			}
+			link += fragment
+
+			if pattern == htmlHrefPattern {
+				return []byte(`href="` + link + `"`)
+			}
+			return []byte("](" + markdownLinkTarget(link) + ")")
+		})
+		if err := os.WriteFile(file, rewritten, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
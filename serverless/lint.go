@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Diagnostic severities reported by lintNorg
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// norgTaskStatuses lists the TODO status characters defined by the Norg specification
+var norgTaskStatuses = map[string]bool{
+	"":  true, // undone
+	"x": true, // done
+	"-": true, // pending
+	"=": true, // on hold
+	"_": true, // cancelled
+	"!": true, // urgent
+	"+": true, // recurring
+	"?": true, // needs input
+}
+
+type (
+	// Diagnostic is a single problem found in a .norg document
+	Diagnostic struct {
+		Line     int    `json:"line"`
+		Severity string `json:"severity"`
+		Message  string `json:"message"`
+	}
+
+	// ValidationResult is the response body of /validate
+	ValidationResult struct {
+		Id          string       `json:"id"`
+		Valid       bool         `json:"valid"`
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}
+)
+
+// lintNorg checks Norg source for structural errors and common mistakes. With tree-sitter-norg
+// the grammar's syntax errors are reported as well; it returns the name of the parser used.
+func lintNorg(source string) ([]Diagnostic, string) {
+	diagnostics := []Diagnostic{}
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+
+	// Ranged tags swallow the rest of the file when their @end is missing
+	openTag, openLine := "", 0
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+		switch {
+		case openTag != "":
+			if line == "@end" {
+				openTag = ""
+			}
+		case line == "@document.meta" || strings.HasPrefix(line, "@code"):
+			openTag, openLine = strings.Fields(line)[0], i+1
+		case line == "@end":
+			diagnostics = append(diagnostics, Diagnostic{Line: i + 1, Severity: severityError, Message: "@end without a matching ranged tag"})
+		}
+	}
+	if openTag != "" {
+		diagnostics = append(diagnostics, Diagnostic{Line: openLine, Severity: severityError, Message: fmt.Sprintf("%s is never closed with @end", openTag)})
+	}
+
+	doc, syntaxErrors, parser := parseNorgSyntax(source)
+	// An unclosed ranged tag is already reported on its line; tree-sitter flags it there too
+	reported := map[int]bool{}
+	for _, diagnostic := range diagnostics {
+		reported[diagnostic.Line] = true
+	}
+	for _, diagnostic := range syntaxErrors {
+		if !reported[diagnostic.Line] {
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+
+	previousLevel := 0
+	for _, block := range doc.Blocks {
+		switch block.Type {
+		case NorgHeading:
+			if block.Level > previousLevel+1 {
+				diagnostics = append(diagnostics, Diagnostic{
+					Line:     block.Line,
+					Severity: severityWarning,
+					Message:  fmt.Sprintf("heading level %d follows level %d", block.Level, previousLevel),
+				})
+			}
+			previousLevel = block.Level
+		case NorgTask:
+			if !norgTaskStatuses[block.Status] {
+				diagnostics = append(diagnostics, Diagnostic{
+					Line:     block.Line,
+					Severity: severityWarning,
+					Message:  fmt.Sprintf("unknown task status %q", block.Status),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool { return diagnostics[i].Line < diagnostics[j].Line })
+	return diagnostics, parser
+}
+
+// validateHandler lints a raw .norg document from the request body and reports its diagnostics.
+// The document is valid when no error-level diagnostics were found.
+func validateHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	norgText, ok := readNorgRequest(w, r, requestId)
+	if !ok {
+		return
+	}
+
+	diagnostics, parser := lintNorg(string(norgText))
+	result := ValidationResult{Id: requestId, Valid: true, Diagnostics: diagnostics}
+	for _, diagnostic := range result.Diagnostics {
+		if diagnostic.Severity == severityError {
+			result.Valid = false
+			break
+		}
+	}
+
+	w.Header().Set(norgParserHeader, parser)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
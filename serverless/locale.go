@@ -0,0 +1,96 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultLocale is used when the request does not ask for a locale
+const defaultLocale = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Translations holds the localized strings used for scaffolding generated around converted documents
+type Translations map[string]string
+
+// translations maps each embedded locale name (e.g. "de") to its strings, loaded at startup
+var translations = loadTranslations()
+
+func loadTranslations() map[string]Translations {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("failed to read embedded locales: %v", err))
+	}
+
+	loaded := make(map[string]Translations, len(entries))
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("failed to read locale %s: %v", entry.Name(), err))
+		}
+		var values Translations
+		if err := json.Unmarshal(data, &values); err != nil {
+			panic(fmt.Sprintf("invalid locale file %s: %v", entry.Name(), err))
+		}
+		loaded[strings.TrimSuffix(entry.Name(), ".json")] = values
+	}
+	return loaded
+}
+
+// supportedLocales lists the embedded locale names in sorted order
+func supportedLocales() []string {
+	names := make([]string, 0, len(translations))
+	for name := range translations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveLocale maps a requested locale such as "de-AT" or "pt_BR" to an embedded one,
+// trying the full tag before the bare language
+func resolveLocale(requested string) (string, error) {
+	tag := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(requested), "_", "-"))
+	if tag == "" {
+		return defaultLocale, nil
+	}
+	if _, ok := translations[tag]; ok {
+		return tag, nil
+	}
+	language, _, _ := strings.Cut(tag, "-")
+	if _, ok := translations[language]; ok {
+		return language, nil
+	}
+	return "", fmt.Errorf("unsupported locale %q (supported: %s)", requested, strings.Join(supportedLocales(), ", "))
+}
+
+// localeStrings returns the strings for a locale, filling gaps from the default locale
+func localeStrings(locale string) Translations {
+	merged := make(Translations, len(translations[defaultLocale]))
+	for key, value := range translations[defaultLocale] {
+		merged[key] = value
+	}
+	for key, value := range translations[locale] {
+		merged[key] = value
+	}
+	return merged
+}
+
+// T returns the localized string for key, or the key itself when it is not translated
+func (t Translations) T(key string) string {
+	if value, ok := t[key]; ok {
+		return value
+	}
+	return key
+}
+
+// FormatDate formats a date using the locale's date_format layout
+func (t Translations) FormatDate(date time.Time) string {
+	return date.Format(t.T("date_format"))
+}
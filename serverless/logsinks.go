@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configureLogOutput sends the logger's output to the sinks listed in LOG_OUTPUT: stderr (the
+// default), stdout, file (LOG_FILE, rotated by size and age) and syslog. Several sinks may be
+// combined, e.g. "stderr,file".
+func configureLogOutput() error {
+	var writers []io.Writer
+	for _, sink := range strings.Split(getEnv("LOG_OUTPUT", "stderr"), ",") {
+		switch strings.ToLower(strings.TrimSpace(sink)) {
+		case "":
+		case "stderr":
+			writers = append(writers, os.Stderr)
+		case "stdout":
+			writers = append(writers, os.Stdout)
+		case "file":
+			file, err := newRotatingFile(
+				getEnv("LOG_FILE", "neorg-lambda.log"),
+				getEnvInt64("LOG_FILE_MAX_BYTES", 100<<20),
+				time.Duration(getEnvInt64("LOG_FILE_MAX_AGE_HOURS", 0))*time.Hour,
+				int(getEnvInt64("LOG_FILE_MAX_BACKUPS", 5)),
+			)
+			if err != nil {
+				return err
+			}
+			writers = append(writers, file)
+		case "syslog":
+			// Entries reach syslog through a hook, at the priority matching their level
+			if err := addSyslogHook(getEnv("SYSLOG_NETWORK", ""), getEnv("SYSLOG_ADDR", ""), getEnv("SYSLOG_TAG", "neorg-lambda")); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown LOG_OUTPUT sink %q", sink)
+		}
+	}
+
+	switch len(writers) {
+	case 0:
+		logger.SetOutput(io.Discard)
+	case 1:
+		logger.SetOutput(writers[0])
+	default:
+		logger.SetOutput(io.MultiWriter(writers...))
+	}
+	return nil
+}
+
+// rotatingFile is a log file that is renamed with a timestamp suffix and replaced by a new one
+// once it grows past maxBytes or is older than maxAge, keeping the newest maxBackups old files
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	opened     time.Time
+}
+
+func newRotatingFile(path string, maxBytes int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, maxAge: maxAge, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open appends to the log file, creating it and its directory when missing
+func (f *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %v", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	full := f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes
+	old := f.maxAge > 0 && time.Since(f.opened) >= f.maxAge
+	if full || old {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside, opens a new one and removes backups beyond maxBackups
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	backup := f.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(f.path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %v", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if f.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil || len(backups) <= f.maxBackups {
+		return nil
+	}
+	// The timestamp suffixes sort oldest first
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-f.maxBackups] {
+		os.Remove(name)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package main
+
+import "fmt"
+
+// addSyslogHook fails: syslog is not available on this platform
+func addSyslogHook(network, addr, tag string) error {
+	return fmt.Errorf("syslog is not supported on this platform")
+}
@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+
+	logrussyslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+// addSyslogHook sends every log entry to syslog, the local daemon when addr is empty, at the
+// priority matching its level
+func addSyslogHook(network, addr, tag string) error {
+	hook, err := logrussyslog.NewSyslogHook(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog: %v", err)
+	}
+	logger.AddHook(hook)
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// manifestFileName is the name of the manifest written at the root of every result archive
+const manifestFileName = "manifest.json"
+
+type (
+	// Manifest describes the contents of a result archive
+	Manifest struct {
+		Id         string              `json:"id"`
+		Workspaces []WorkspaceManifest `json:"workspaces"`
+		Warnings   []string            `json:"warnings,omitempty"`
+		// FileErrors lists the .norg files that failed to convert and have no page in the result
+		FileErrors []FileError `json:"file_errors,omitempty"`
+		// Renames lists archive entries stored under a portable name instead of their original one
+		Renames []FileRename `json:"renames,omitempty"`
+		// Digests maps every generated file to its hex SHA-256, so the manifest can serve as a delta baseline
+		Digests map[string]string `json:"digests"`
+		// Sources maps every .norg source to its hex SHA-256, so incremental requests can tell which changed
+		Sources map[string]string `json:"sources,omitempty"`
+		Delta   *Delta            `json:"delta,omitempty"`
+	}
+
+	// WorkspaceManifest lists the output produced for a single Neorg workspace
+	WorkspaceManifest struct {
+		Name   string   `json:"name"`
+		Source string   `json:"source"`
+		Output string   `json:"output"`
+		Files  []string `json:"files"`
+	}
+)
+
+// writeManifest stores the manifest as manifest.json in the output directory
+func writeManifest(outputDir string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputDir, manifestFileName), data, 0644)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// DocumentMetadata is the @document.meta block of one .norg file. Fields the Norg
+	// specification does not define are kept as written under fields.
+	DocumentMetadata struct {
+		File        string                 `json:"file"`
+		Title       string                 `json:"title,omitempty"`
+		Description string                 `json:"description,omitempty"`
+		Authors     []string               `json:"authors,omitempty"`
+		Categories  []string               `json:"categories,omitempty"`
+		Created     string                 `json:"created,omitempty"`
+		Updated     string                 `json:"updated,omitempty"`
+		Fields      map[string]interface{} `json:"fields,omitempty"`
+	}
+
+	// MetadataResult is the response body of /metadata
+	MetadataResult struct {
+		Id    string             `json:"id"`
+		Files []DocumentMetadata `json:"files"`
+	}
+)
+
+// parseDocumentMeta reads the key/value pairs of the first @document.meta block. Values that are
+// arrays, written as [a, b] or [a b] on one line or with one item per line up to the closing
+// bracket, become string slices.
+func parseDocumentMeta(source string) map[string]interface{} {
+	meta := map[string]interface{}{}
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "@document.meta" {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return meta
+	}
+
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "@end" {
+			break
+		}
+		match := norgMetaPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		key, value := match[1], strings.TrimSpace(match[2])
+		if _, seen := meta[key]; seen {
+			continue
+		}
+		switch {
+		case value == "[":
+			items := []string{}
+			for i++; i < len(lines); i++ {
+				item := strings.TrimSpace(lines[i])
+				if item == "]" || item == "@end" {
+					break
+				}
+				if item != "" {
+					items = append(items, item)
+				}
+			}
+			meta[key] = items
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			meta[key] = splitMetaArray(strings.TrimSpace(value[1 : len(value)-1]))
+		default:
+			meta[key] = value
+		}
+	}
+	return meta
+}
+
+// splitMetaArray splits the items of a one-line array on commas, or on spaces when it has none
+func splitMetaArray(value string) []string {
+	items := []string{}
+	separator := func(r rune) bool { return r == ',' }
+	if !strings.Contains(value, ",") {
+		separator = func(r rune) bool { return r == ' ' || r == '\t' }
+	}
+	for _, item := range strings.FieldsFunc(value, separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// documentMetadata maps the parsed meta block of file onto the Norg metadata fields
+func documentMetadata(file string, meta map[string]interface{}) DocumentMetadata {
+	text := func(key string) string {
+		switch value := meta[key].(type) {
+		case string:
+			return value
+		case []string:
+			return strings.Join(value, " ")
+		}
+		return ""
+	}
+	list := func(key string) []string {
+		switch value := meta[key].(type) {
+		case string:
+			if value != "" {
+				return []string{value}
+			}
+		case []string:
+			if len(value) > 0 {
+				return value
+			}
+		}
+		return nil
+	}
+
+	metadata := DocumentMetadata{
+		File:        file,
+		Title:       text("title"),
+		Description: text("description"),
+		Authors:     list("authors"),
+		Categories:  list("categories"),
+		Created:     text("created"),
+		Updated:     text("updated"),
+	}
+	for key, value := range meta {
+		switch key {
+		case "title", "description", "authors", "categories", "created", "updated":
+			continue
+		}
+		if metadata.Fields == nil {
+			metadata.Fields = map[string]interface{}{}
+		}
+		metadata.Fields[key] = value
+	}
+	return metadata
+}
+
+// collectMetadata lists the metadata of every .norg file below dir, sorted by path
+func collectMetadata(dir string) ([]DocumentMetadata, error) {
+	files := []DocumentMetadata{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(info.Name(), ".norg") {
+			return err
+		}
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, documentMetadata(filepath.ToSlash(rel), parseDocumentMeta(string(source))))
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].File < files[j].File })
+	return files, err
+}
+
+// metadataHandler extracts an uploaded project and returns the @document.meta fields of every
+// .norg file, so indexing services can catalog a knowledge base without converting it. The root
+// option limits it to a sub-path of the archive.
+func metadataHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	fail := func(status int, code string, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{
+			Error: message,
+			Code:  code,
+			Id:    requestId,
+		})
+	}
+
+	if !isAuthorized(r) {
+		Unauthorized(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		fail(http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	archive, sourceURL, err := unpackUpload(r)
+	if rejectInvalidUpload(w, requestId, err) {
+		return
+	}
+
+	// Options are checked before a source_url is downloaded or a raw archive spooled
+	options, err := parseConversionOptions(r)
+	if err != nil {
+		if archive != nil {
+			archive.Close()
+		}
+		fail(optionsErrorStatus(err), optionsErrorCode(err), fmt.Sprintf("Invalid conversion options: %v", err))
+		return
+	}
+
+	if sourceURL != "" {
+		archive, err = downloadSourceURL(r, sourceURL)
+		if rejectInvalidUpload(w, requestId, err) {
+			return
+		}
+	}
+	if archive == nil {
+		archive, err = getTarballData(r)
+		if rejectUploadTooLarge(w, requestId, err) {
+			return
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, codeInvalidArchive, "Failed to process tarball")
+			return
+		}
+	}
+	defer archive.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultConversionTimeout)
+	defer cancel()
+
+	if rejectScanFailure(w, requestId, scanArchive(ctx, archive, requestId)) {
+		return
+	}
+
+	tempDir, err := os.MkdirTemp("", "neorg_metadata_*")
+	if err != nil {
+		fail(http.StatusInternalServerError, codeInternal, "Failed to create temp directory")
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	extractCtx, cancelExtract := withStageTimeout(ctx, stageExtract)
+	_, err = extractTarball(extractCtx, archive, tempDir, options, nil)
+	err = stageError(ctx, extractCtx, stageExtract, err)
+	cancelExtract()
+	switch {
+	case errors.Is(err, errUnsafeLink), errors.Is(err, errInvalidArchive):
+		fail(http.StatusBadRequest, conversionErrorCode(err), err.Error())
+		return
+	case errors.Is(err, errExtractionLimit):
+		fail(http.StatusUnprocessableEntity, codeExtractionLimit, err.Error())
+		return
+	case err != nil:
+		fail(http.StatusInternalServerError, codeInternal, fmt.Sprintf("Failed to extract tarball: %v", err))
+		return
+	}
+
+	searchDir := filepath.Join(tempDir, filepath.FromSlash(options.Root))
+	if info, err := os.Stat(searchDir); err != nil || !info.IsDir() {
+		fail(http.StatusBadRequest, codeRootNotFound, fmt.Sprintf("%v: %s", errRootNotFound, options.Root))
+		return
+	}
+
+	files, err := collectMetadata(searchDir)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Error("Failed to read document metadata")
+		fail(http.StatusInternalServerError, codeInternal, "Failed to read document metadata")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"request_id": requestId,
+		"norg_files": len(files),
+	}).Info("Extracted document metadata")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(MetadataResult{Id: requestId, Files: files})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMetricsNamespace is the CloudWatch namespace used when METRICS_NAMESPACE is unset
+const defaultMetricsNamespace = "NeorgDocumentation"
+
+// metricsOutput serializes EMF records so concurrent jobs never interleave lines
+var metricsOutput sync.Mutex
+
+// emfEnabled reports whether job metrics should be written in CloudWatch Embedded Metric Format.
+// METRICS_FORMAT=emf forces it on, METRICS_FORMAT=none forces it off, and by default it is on
+// when the process runs on Lambda or ECS/Fargate.
+func emfEnabled() bool {
+	switch strings.ToLower(getEnv("METRICS_FORMAT", "")) {
+	case "emf":
+		return true
+	case "none", "off":
+		return false
+	}
+	return os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" || os.Getenv("ECS_CONTAINER_METADATA_URI_V4") != ""
+}
+
+// jobMetrics collects the measurements of a single conversion job
+type jobMetrics struct {
+	requestId   string
+	start       time.Time
+	inputBytes  int64
+	outputBytes int64
+	files       int
+	success     bool
+}
+
+// newJobMetrics starts timing a conversion job
+func newJobMetrics(requestId string, inputBytes int) *jobMetrics {
+	return &jobMetrics{requestId: requestId, start: time.Now(), inputBytes: int64(inputBytes)}
+}
+
+// Succeed records the size of a successfully delivered result
+func (m *jobMetrics) Succeed(outputBytes int64, files int) {
+	m.outputBytes = outputBytes
+	m.files = files
+	m.success = true
+}
+
+// Emit writes the job's metrics as one EMF record on stdout, where the Lambda and awslogs
+// drivers forward it to CloudWatch Logs for metric extraction
+func (m *jobMetrics) Emit() {
+	if !emfEnabled() {
+		return
+	}
+
+	succeeded, failed := 0, 1
+	if m.success {
+		succeeded, failed = 1, 0
+	}
+
+	record := map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": time.Now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]interface{}{{
+				"Namespace":  getEnv("METRICS_NAMESPACE", defaultMetricsNamespace),
+				"Dimensions": [][]string{{"Service"}},
+				"Metrics": []map[string]string{
+					{"Name": "Duration", "Unit": "Milliseconds"},
+					{"Name": "InputBytes", "Unit": "Bytes"},
+					{"Name": "OutputBytes", "Unit": "Bytes"},
+					{"Name": "FilesConverted", "Unit": "Count"},
+					{"Name": "Success", "Unit": "Count"},
+					{"Name": "Failure", "Unit": "Count"},
+				},
+			}},
+		},
+		"Service":        "neorg-documentation-lambda",
+		"Duration":       float64(time.Since(m.start).Microseconds()) / 1000,
+		"InputBytes":     m.inputBytes,
+		"OutputBytes":    m.outputBytes,
+		"FilesConverted": m.files,
+		"Success":        succeeded,
+		"Failure":        failed,
+		"request_id":     m.requestId,
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to encode job metrics")
+		return
+	}
+
+	metricsOutput.Lock()
+	defer metricsOutput.Unlock()
+	os.Stdout.Write(append(line, '\n'))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// neorgModulesFileName is the module policy written next to the docgen scripts; the Neovim
+// config reads it through NEORG_DOCGEN_MODULES and only loads the listed modules
+const neorgModulesFileName = "neorg_modules.json"
+
+// defaultNeorgModules mirrors the load table in .config/nvim/init.lua
+var defaultNeorgModules = []string{
+	"core.defaults",
+	"core.concealer",
+	"core.dirman",
+	"core.export",
+	"core.export.markdown",
+}
+
+var neorgModulePattern = regexp.MustCompile(`^[a-z0-9_-]+(\.[a-z0-9_-]+)*$`)
+
+// NeorgModulePolicy is the effective module selection for one conversion run
+type NeorgModulePolicy struct {
+	// Load lists the modules passed to neorg.setup
+	Load []string `json:"load"`
+	// Disable lists modules excluded from the core.defaults bundle
+	Disable []string `json:"disable"`
+}
+
+// parseModuleList splits a comma separated list of Neorg module names and validates them
+func parseModuleList(value string) ([]string, error) {
+	var modules []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !neorgModulePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid Neorg module name %q", name)
+		}
+		modules = append(modules, name)
+	}
+	return modules, nil
+}
+
+// neorgModulePolicy combines the deployment's NEORG_MODULES_ALLOW / NEORG_MODULES_DENY with the
+// request's lists. Requests can only narrow the deployment policy, never re-enable a module.
+func neorgModulePolicy(options ConversionOptions) (NeorgModulePolicy, error) {
+	deploymentAllow, err := parseModuleList(getEnv("NEORG_MODULES_ALLOW", ""))
+	if err != nil {
+		return NeorgModulePolicy{}, fmt.Errorf("NEORG_MODULES_ALLOW: %v", err)
+	}
+	deploymentDeny, err := parseModuleList(getEnv("NEORG_MODULES_DENY", ""))
+	if err != nil {
+		return NeorgModulePolicy{}, fmt.Errorf("NEORG_MODULES_DENY: %v", err)
+	}
+
+	denied := map[string]bool{}
+	policy := NeorgModulePolicy{Load: []string{}, Disable: []string{}}
+	for _, name := range append(deploymentDeny, options.DenyModules...) {
+		if !denied[name] {
+			denied[name] = true
+			policy.Disable = append(policy.Disable, name)
+		}
+	}
+
+	for _, name := range defaultNeorgModules {
+		if denied[name] {
+			continue
+		}
+		if len(deploymentAllow) > 0 && !slices.Contains(deploymentAllow, name) {
+			continue
+		}
+		if len(options.AllowModules) > 0 && !slices.Contains(options.AllowModules, name) {
+			continue
+		}
+		policy.Load = append(policy.Load, name)
+	}
+	return policy, nil
+}
+
+// writeNeorgModulePolicy stores the module policy in the workspace's docgen directory
+func writeNeorgModulePolicy(docgenDir string, options ConversionOptions) error {
+	policy, err := neorgModulePolicy(options)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(docgenDir, neorgModulesFileName), data, 0644)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// neorgVersionHeader selects one of the Neorg versions installed in the image for a conversion
+const neorgVersionHeader = "X-Neorg-Version"
+
+// neorgVersionPattern matches the directory names pinned Neorg versions are installed under
+var neorgVersionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// neorgVersionsDir is the directory holding one Neorg checkout per pinned version, named after it
+func neorgVersionsDir() string {
+	return getEnv("NEORG_VERSIONS_DIR", "/app/neorg")
+}
+
+// installedNeorgVersions lists the pinned Neorg versions in the image, sorted
+func installedNeorgVersions() []string {
+	entries, err := os.ReadDir(neorgVersionsDir())
+	if err != nil {
+		return nil
+	}
+	var versions []string
+	for _, entry := range entries {
+		if entry.IsDir() && neorgVersionPattern.MatchString(entry.Name()) {
+			versions = append(versions, entry.Name())
+		}
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// resolveNeorgVersion checks that a requested Neorg version is installed, accepting it with or
+// without a leading v
+func resolveNeorgVersion(version string) (string, error) {
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return "", nil
+	}
+	installed := installedNeorgVersions()
+	for _, candidate := range []string{version, "v" + version} {
+		for _, name := range installed {
+			if name == candidate {
+				return name, nil
+			}
+		}
+	}
+	if len(installed) == 0 {
+		return "", fmt.Errorf("unknown Neorg version %q (this server has no pinned versions)", version)
+	}
+	return "", fmt.Errorf("unknown Neorg version %q (available: %s)", version, strings.Join(installed, ", "))
+}
+
+// neorgVersionPath is the checkout of a pinned Neorg version, which replaces the plugin lazy.nvim
+// manages on the runtimepath; "" keeps the managed one
+func neorgVersionPath(version string) string {
+	if version == "" {
+		return ""
+	}
+	return filepath.Join(neorgVersionsDir(), version)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errInternalAddress is returned when a download would connect to an address inside the network
+var errInternalAddress = errors.New("refusing to connect to internal address")
+
+// sharedAddressSpace is the carrier-grade NAT range, which is as internal as the private ranges
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// internalAddress reports whether ip is loopback, private, link-local, multicast or unspecified,
+// so connecting to it from a URL a client supplied could reach services inside the network
+func internalAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// refuseInternalDial is a net.Dialer Control refusing connections to internal addresses. It runs
+// after name resolution, so host names resolving into the network are refused as well.
+func refuseInternalDial(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if internalAddress(ip) {
+		return fmt.Errorf("%w %s", errInternalAddress, ip)
+	}
+	return nil
+}
+
+// guardedTransport returns a transport refusing connections to internal addresses whenever guard
+// reports true. It ignores proxy settings, since through a proxy only the proxy's address would be
+// checked.
+func guardedTransport(guard func() bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			if !guard() {
+				return nil
+			}
+			return refuseInternalDial(network, address, conn)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// NorgBlockType identifies the kind of a block-level Norg element
+type NorgBlockType string
+
+const (
+	NorgHeading       NorgBlockType = "heading"
+	NorgParagraph     NorgBlockType = "paragraph"
+	NorgUnorderedItem NorgBlockType = "unordered_list_item"
+	NorgOrderedItem   NorgBlockType = "ordered_list_item"
+	NorgTask          NorgBlockType = "task"
+	NorgQuote         NorgBlockType = "quote"
+	NorgCodeBlock     NorgBlockType = "code_block"
+	NorgRule          NorgBlockType = "horizontal_rule"
+	NorgBlank         NorgBlockType = "blank"
+)
+
+type (
+	// NorgDocument is a parsed .norg file: its @document.meta fields and its blocks in order
+	NorgDocument struct {
+		Meta      map[string]string `json:"meta,omitempty"`
+		MetaOrder []string          `json:"-"`
+		Blocks    []NorgBlock       `json:"blocks"`
+	}
+
+	// NorgBlock is a single block-level element. Text holds the raw inline Norg markup.
+	NorgBlock struct {
+		Type     NorgBlockType `json:"type"`
+		Line     int           `json:"line"`
+		Level    int           `json:"level,omitempty"`
+		Text     string        `json:"text,omitempty"`
+		Language string        `json:"language,omitempty"`
+		Lines    []string      `json:"lines,omitempty"`
+		Status   string        `json:"status,omitempty"`
+		Done     bool          `json:"done,omitempty"`
+	}
+)
+
+var (
+	norgHeadingPattern = regexp.MustCompile(`^(\*+)\s+(.*)$`)
+	norgTaskPattern    = regexp.MustCompile(`^([-~]+)\s+\(([^)]*)\)\s*(.*)$`)
+	norgListPattern    = regexp.MustCompile(`^([-~]+)\s+(.*)$`)
+	norgQuotePattern   = regexp.MustCompile(`^(>+)\s+(.*)$`)
+	norgMetaPattern    = regexp.MustCompile(`^([\w-]+):\s*(.*)$`)
+	norgCodePattern    = regexp.MustCompile(`^@code\s*(\S*)`)
+)
+
+// parseNorg parses Norg source into its metadata and block-level structure
+func parseNorg(source string) NorgDocument {
+	doc := NorgDocument{Meta: map[string]string{}}
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		raw := lines[i]
+		line := strings.TrimSpace(raw)
+
+		switch {
+		case line == "@document.meta":
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "@end"; i++ {
+				if match := norgMetaPattern.FindStringSubmatch(strings.TrimSpace(lines[i])); match != nil {
+					if _, seen := doc.Meta[match[1]]; !seen {
+						doc.MetaOrder = append(doc.MetaOrder, match[1])
+					}
+					doc.Meta[match[1]] = strings.TrimSpace(match[2])
+				}
+			}
+
+		case strings.HasPrefix(line, "@code"):
+			block := NorgBlock{Type: NorgCodeBlock, Line: lineNumber, Lines: []string{}}
+			if match := norgCodePattern.FindStringSubmatch(line); match != nil {
+				block.Language = match[1]
+			}
+			indent := raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))]
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "@end"; i++ {
+				block.Lines = append(block.Lines, strings.TrimPrefix(lines[i], indent))
+			}
+			doc.Blocks = append(doc.Blocks, block)
+
+		case line == "":
+			doc.Blocks = append(doc.Blocks, NorgBlock{Type: NorgBlank, Line: lineNumber})
+
+		case isNorgDelimiter(line):
+			// "---" and "===" close heading levels; "___" is a horizontal rule
+			if strings.HasPrefix(line, "_") {
+				doc.Blocks = append(doc.Blocks, NorgBlock{Type: NorgRule, Line: lineNumber})
+			} else {
+				doc.Blocks = append(doc.Blocks, NorgBlock{Type: NorgBlank, Line: lineNumber})
+			}
+
+		default:
+			if match := norgHeadingPattern.FindStringSubmatch(line); match != nil {
+				doc.Blocks = append(doc.Blocks, NorgBlock{Type: NorgHeading, Line: lineNumber, Level: len(match[1]), Text: match[2]})
+			} else if match := norgTaskPattern.FindStringSubmatch(line); match != nil {
+				status := strings.TrimSpace(match[2])
+				doc.Blocks = append(doc.Blocks, NorgBlock{Type: NorgTask, Line: lineNumber, Level: len(match[1]), Text: match[3], Status: status, Done: status == "x"})
+			} else if match := norgListPattern.FindStringSubmatch(line); match != nil {
+				blockType := NorgUnorderedItem
+				if strings.HasPrefix(match[1], "~") {
+					blockType = NorgOrderedItem
+				}
+				doc.Blocks = append(doc.Blocks, NorgBlock{Type: blockType, Line: lineNumber, Level: len(match[1]), Text: match[2]})
+			} else if match := norgQuotePattern.FindStringSubmatch(line); match != nil {
+				doc.Blocks = append(doc.Blocks, NorgBlock{Type: NorgQuote, Line: lineNumber, Level: len(match[1]), Text: match[2]})
+			} else {
+				doc.Blocks = append(doc.Blocks, NorgBlock{Type: NorgParagraph, Line: lineNumber, Text: line})
+			}
+		}
+	}
+
+	return doc
+}
+
+// isNorgDelimiter reports whether a line is a delimiting modifier such as "---", "===" or "___"
+func isNorgDelimiter(line string) bool {
+	if len(line) < 2 {
+		return false
+	}
+	for _, r := range line {
+		if r != rune(line[0]) {
+			return false
+		}
+	}
+	return line[0] == '-' || line[0] == '=' || line[0] == '_'
+}
+
+// Title returns the document title: the meta title, falling back to the first top-level heading
+func (doc NorgDocument) Title() string {
+	if title := doc.Meta["title"]; title != "" {
+		return title
+	}
+	for _, block := range doc.Blocks {
+		if block.Type == NorgHeading && block.Level == 1 {
+			return block.Text
+		}
+	}
+	return ""
+}
+
+// inlineRules translate Norg inline markup to markdown, applied in order like the Lua converter
+var inlineRules = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`\{\*\s*(.*?)\s*\*\}`), "**$1**"},
+	{regexp.MustCompile(`\{/\s*(.*?)\s*/\}`), "*$1*"},
+	{regexp.MustCompile("\\{`\\s*(.*?)\\s*`\\}"), "`$1`"},
+	{regexp.MustCompile(`\{-\s*(.*?)\s*-\}`), "~~$1~~"},
+	{regexp.MustCompile(`\{([^}]+)\}\[([^\]]+)\]`), "[$2]($1)"},
+	{regexp.MustCompile(`\[([^\]]+)\]\{([^}]+)\}`), "[$1]($2)"},
+	{regexp.MustCompile(`\{((?:https?|file)://[^}]+)\}`), "[$1]($1)"},
+}
+
+// commonMarkStrikethrough renders strikethrough for CommonMark, which has no syntax for it
+var commonMarkStrikethrough = regexp.MustCompile(`~~(.*?)~~`)
+
+// norgInlineToMarkdown converts inline Norg markup (bold, italic, code, strikethrough, links)
+func norgInlineToMarkdown(text string, flavor string) string {
+	for _, rule := range inlineRules {
+		text = rule.pattern.ReplaceAllString(text, rule.replacement)
+	}
+	if flavor == flavorCommonMark {
+		text = commonMarkStrikethrough.ReplaceAllString(text, "<del>$1</del>")
+	}
+	return text
+}
+
+// renderNorgMarkdown renders a parsed document as markdown of the given flavor
+func renderNorgMarkdown(doc NorgDocument, flavor string) string {
+	var out []string
+
+	// Emit the meta title as a heading unless the document has its own top-level heading
+	hasTopLevelHeading := false
+	for _, block := range doc.Blocks {
+		if block.Type == NorgHeading && block.Level == 1 {
+			hasTopLevelHeading = true
+			break
+		}
+	}
+	if title := doc.Meta["title"]; title != "" && flavor == flavorPandoc {
+		out = append(out, yamlTitleBlock(title)...)
+	} else if title != "" && !hasTopLevelHeading {
+		out = append(out, "# "+title, "")
+	}
+
+	for _, block := range doc.Blocks {
+		indent := strings.Repeat(" ", max(0, (block.Level-1)*2))
+		switch block.Type {
+		case NorgHeading:
+			out = append(out, strings.Repeat("#", min(block.Level, 6))+" "+norgInlineToMarkdown(block.Text, flavor), "")
+		case NorgTask:
+			checkbox := "[ ]"
+			if block.Done {
+				checkbox = "[x]"
+			}
+			// CommonMark has no task lists; escaped, the checkbox stays literal text
+			if flavor == flavorCommonMark {
+				checkbox = `\` + checkbox[:2] + `\]`
+			}
+			out = append(out, indent+"- "+checkbox+" "+norgInlineToMarkdown(block.Text, flavor))
+		case NorgUnorderedItem:
+			out = append(out, indent+"- "+norgInlineToMarkdown(block.Text, flavor))
+		case NorgOrderedItem:
+			out = append(out, indent+"1. "+norgInlineToMarkdown(block.Text, flavor))
+		case NorgQuote:
+			out = append(out, strings.Repeat("> ", block.Level)+norgInlineToMarkdown(block.Text, flavor))
+		case NorgCodeBlock:
+			out = append(out, "```"+block.Language)
+			out = append(out, block.Lines...)
+			out = append(out, "```")
+		case NorgRule:
+			out = append(out, "---")
+		case NorgBlank:
+			out = append(out, "")
+		default:
+			out = append(out, norgInlineToMarkdown(block.Text, flavor))
+		}
+	}
+
+	return strings.Join(out, "\n") + "\n"
+}
+
+// norgToMarkdown parses and renders Norg source in one step
+func norgToMarkdown(source string, flavor string) string {
+	return renderNorgMarkdown(parseNorg(source), flavor)
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// openAPIVersion is the version of the OpenAPI specification /openapi.json follows
+const openAPIVersion = "3.0.3"
+
+type (
+	// OpenAPIDocument is the response body of GET /openapi.json, describing the HTTP API so
+	// clients can be generated from it
+	OpenAPIDocument struct {
+		OpenAPI    string                     `json:"openapi"`
+		Info       OpenAPIInfo                `json:"info"`
+		Paths      map[string]OpenAPIPathItem `json:"paths"`
+		Components OpenAPIComponents          `json:"components"`
+		// Security is the auth every operation needs unless it sets its own
+		Security []map[string][]string `json:"security"`
+	}
+
+	OpenAPIInfo struct {
+		Title       string `json:"title"`
+		Description string `json:"description,omitempty"`
+		Version     string `json:"version"`
+	}
+
+	// OpenAPIPathItem holds the operations of a path keyed by lowercase HTTP method
+	OpenAPIPathItem map[string]*OpenAPIOperation
+
+	OpenAPIOperation struct {
+		OperationId string                     `json:"operationId"`
+		Summary     string                     `json:"summary"`
+		Description string                     `json:"description,omitempty"`
+		Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+		RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+		Responses   map[string]OpenAPIResponse `json:"responses"`
+		// Security is empty for endpoints that need no auth token, overriding the document's default
+		Security *[]map[string][]string `json:"security,omitempty"`
+	}
+
+	OpenAPIParameter struct {
+		Name        string         `json:"name"`
+		In          string         `json:"in"`
+		Description string         `json:"description,omitempty"`
+		Required    bool           `json:"required,omitempty"`
+		Explode     *bool          `json:"explode,omitempty"`
+		Schema      *OpenAPISchema `json:"schema"`
+	}
+
+	OpenAPIRequestBody struct {
+		Description string                      `json:"description,omitempty"`
+		Required    bool                        `json:"required,omitempty"`
+		Content     map[string]OpenAPIMediaType `json:"content"`
+	}
+
+	OpenAPIMediaType struct {
+		Schema *OpenAPISchema `json:"schema"`
+	}
+
+	OpenAPIResponse struct {
+		Description string                      `json:"description"`
+		Headers     map[string]OpenAPIHeader    `json:"headers,omitempty"`
+		Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+	}
+
+	OpenAPIHeader struct {
+		Description string         `json:"description,omitempty"`
+		Schema      *OpenAPISchema `json:"schema"`
+	}
+
+	OpenAPIComponents struct {
+		Schemas         map[string]*OpenAPISchema        `json:"schemas"`
+		SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes"`
+	}
+
+	OpenAPISecurityScheme struct {
+		Type        string `json:"type"`
+		In          string `json:"in"`
+		Name        string `json:"name"`
+		Description string `json:"description,omitempty"`
+	}
+
+	// OpenAPISchema is the subset of OpenAPI schema objects the document uses
+	OpenAPISchema struct {
+		Ref                  string                    `json:"$ref,omitempty"`
+		Type                 string                    `json:"type,omitempty"`
+		Format               string                    `json:"format,omitempty"`
+		Pattern              string                    `json:"pattern,omitempty"`
+		Enum                 []string                  `json:"enum,omitempty"`
+		Items                *OpenAPISchema            `json:"items,omitempty"`
+		Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+		AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+		Required             []string                  `json:"required,omitempty"`
+		Nullable             bool                      `json:"nullable,omitempty"`
+		OneOf                []*OpenAPISchema          `json:"oneOf,omitempty"`
+	}
+)
+
+// conversionParameterDocs describes the query parameters read into ConversionOptions
+var conversionParameterDocs = map[string]string{
+	"profile":          "Server-side conversion profile to start from; the other parameters override its settings",
+	"root":             "Only convert files under this sub-path of the archive",
+	"include":          "Only convert the .norg files matching one of these glob patterns (** matches any number of directories)",
+	"exclude":          "Skip the archive entries matching one of these glob patterns",
+	"locale":           "Language of the scaffolding generated around the documents; region tags fall back to the language",
+	"converter":        "Conversion backend; auto uses Neovim and falls back to pandoc",
+	"layout":           "Structure of each workspace's wiki",
+	"output":           "How the result is converted and packaged: a packaging, a page format or both as pages+packaging. Without a packaging the Accept header picks one",
+	"compression":      "How archive entries are compressed: store, or a deflate level from 1 (fastest) to 9 (smallest)",
+	"reproducible":     "Build byte-identical archives for identical inputs and options",
+	"strict":           "Fail the request when any .norg file fails to convert instead of returning the others",
+	"flavor":           "The markdown the converters write",
+	"frontmatter":      "Prepend frontmatter built from each page's @document.meta",
+	"toc":              "Add a table of contents page (index.md or SUMMARY.md) to every workspace's output",
+	"backlinks":        "Add the pages linking to each page, as a section, a backlinks.json or both",
+	"search_index":     "Write a search-index.json of every page's title, headings and text",
+	"linkmap":          "Write a linkmap.json of the pages every page links to",
+	"base_url":         "Absolute http(s) URL the output is published at; writes a sitemap.xml",
+	"anchors":          "How headings become link anchors",
+	"anchor_separator": "Joins the words of custom anchors",
+	"link_extension":   "Replaces the extension of links between pages",
+	"link_case":        "Lowercase the paths of links between pages",
+	"link_prefix":      "Prefix links between pages with this path or URL",
+	"dry_run":          "Only extract the archive and answer with the conversion plan",
+	"debug":            "Attach docgen's output to the error body of a failed conversion, where the deployment allows it",
+	"modules_allow":    "Restrict the Neorg modules the Neovim converter loads to these",
+	"modules_deny":     "Neorg modules the Neovim converter must not load",
+}
+
+// conversionParameterValues lists the values a conversion query parameter accepts, where they are
+// a fixed set on this server
+var conversionParameterValues = map[string]func() []string{
+	"profile":          profileNames,
+	"converter":        func() []string { return append(slices.Sorted(maps.Keys(converters)), converterAuto) },
+	"layout":           func() []string { return slices.Sorted(maps.Keys(outputLayouts)) },
+	"output":           formats.Outputs,
+	"flavor":           func() []string { return slices.Sorted(maps.Keys(flavorPandocWriters)) },
+	"frontmatter":      func() []string { return slices.Sorted(maps.Keys(frontmatterFormats)) },
+	"toc":              func() []string { return slices.Sorted(maps.Keys(tocFileNames)) },
+	"backlinks":        func() []string { return slices.Sorted(maps.Keys(backlinkModes)) },
+	"anchors":          func() []string { return slices.Sorted(maps.Keys(anchorSchemes)) },
+	"anchor_separator": func() []string { return slices.Sorted(maps.Keys(anchorSeparators)) },
+	"link_extension":   func() []string { return slices.Sorted(maps.Keys(linkExtensions)) },
+	"link_case":        func() []string { return slices.Sorted(maps.Keys(linkCases)) },
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument []byte
+)
+
+// openAPIHandler answers GET /openapi.json with the OpenAPI document of the API, built once per
+// process from the handlers' request and response types. No auth token is needed.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Method not allowed",
+			Code:  codeMethodNotAllowed,
+			Id:    requestId,
+		})
+		return
+	}
+
+	openAPIOnce.Do(func() {
+		openAPIDocument, _ = json.Marshal(buildOpenAPIDocument())
+	})
+	w.Write(openAPIDocument)
+}
+
+// buildOpenAPIDocument describes every route main registers
+func buildOpenAPIDocument() OpenAPIDocument {
+	schemas := openAPISchemas{}
+	errorSchema := schemas.schemaFor(reflect.TypeOf(Response{}))
+	schemas["Response"].Properties["code"].Enum = errorCodes
+	jobSchema := schemas.schemaFor(reflect.TypeOf(Job{}))
+	public := &[]map[string][]string{}
+	admin := &[]map[string][]string{{"adminToken": {}}}
+
+	errorResponse := func(description string) OpenAPIResponse {
+		return OpenAPIResponse{Description: description, Content: jsonContent(errorSchema)}
+	}
+	jsonResponse := func(description string, body any) OpenAPIResponse {
+		return OpenAPIResponse{Description: description, Content: jsonContent(schemas.schemaFor(reflect.TypeOf(body)))}
+	}
+	withErrors := func(responses map[string]OpenAPIResponse) map[string]OpenAPIResponse {
+		for status, description := range map[string]string{
+			"400":     "Invalid request or options",
+			"401":     "Missing or wrong auth token or request signature",
+			"429":     "Rate limit exceeded or conversion queue full",
+			"default": "Error",
+		} {
+			if _, ok := responses[status]; !ok {
+				responses[status] = errorResponse(description)
+			}
+		}
+		return responses
+	}
+
+	conversion := conversionParameters()
+	tenant := headerParameter(tenantHeader, "Tenant the request is made for, grouping cache entries and selecting job priority")
+	callbackURL := queryParameter("callback_url", "https URL the finished background job is POSTed to", &OpenAPISchema{Type: "string", Format: "uri"})
+	conversionHeaders := []OpenAPIParameter{
+		headerParameter(baselineManifestHeader, "Base64 manifest.json of a previous result; only files changed since are returned"),
+		headerParameter(neorgVersionHeader, "Pinned Neorg version for the Neovim converter, one of those /versions lists"),
+		tenant,
+		headerParameter(priorityHeader, "Scheduling priority: high, normal or low"),
+		headerParameter(timeoutHeader, "Conversion timeout in seconds, up to MAX_TIMEOUT"),
+		headerParameter(callbackHeader, "https URL the finished background job is POSTed to; makes the request a background job"),
+		headerParameter("Prefer", "respond-async runs the conversion as a background job"),
+	}
+	asyncParameters := []OpenAPIParameter{
+		queryParameter("async", "Run the conversion as a background job, answered with 202", &OpenAPISchema{Type: "boolean"}),
+		callbackURL,
+	}
+
+	resultContent := map[string]OpenAPIMediaType{}
+	for _, contentType := range formats.ContentTypes() {
+		resultContent[contentType] = OpenAPIMediaType{Schema: &OpenAPISchema{Type: "string", Format: "binary"}}
+	}
+	resultContent["application/json"] = OpenAPIMediaType{Schema: &OpenAPISchema{OneOf: []*OpenAPISchema{
+		schemas.schemaFor(reflect.TypeOf(JSONOutput{})),
+		schemas.schemaFor(reflect.TypeOf(ConversionPlan{})),
+	}}}
+	conversionResponses := func() map[string]OpenAPIResponse {
+		return withErrors(map[string]OpenAPIResponse{
+			"200": {
+				Description: "The converted documentation, or the conversion plan of dry_run requests",
+				Headers: map[string]OpenAPIHeader{
+					"ETag":            {Description: "Identifies the input, options and versions, for If-None-Match", Schema: &OpenAPISchema{Type: "string"}},
+					cacheStatusHeader: {Description: "HIT, MISS or BYPASS", Schema: &OpenAPISchema{Type: "string"}},
+					"Server-Timing":   {Description: "Milliseconds spent in each stage", Schema: &OpenAPISchema{Type: "string"}},
+				},
+				Content: resultContent,
+			},
+			"202": {
+				Description: "Background job accepted",
+				Headers:     map[string]OpenAPIHeader{"Location": {Description: "The job's status URL", Schema: &OpenAPISchema{Type: "string"}}},
+				Content:     jsonContent(jobSchema),
+			},
+			"304": {Description: "Not modified since the ETag in If-None-Match"},
+			"406": errorResponse("Requested output cannot be produced"),
+			"413": errorResponse("Request body beyond MAX_UPLOAD_BYTES"),
+			"429": jsonResponse("Conversion queue full or rate limit exceeded", QueueFullResponse{}),
+			"422": jsonResponse("Archive or conversion beyond limits, or files failed to convert", FileErrorsResponse{}),
+			"500": errorResponse("Conversion failed"),
+			"503": errorResponse("No conversion slot in time or a dependency unavailable"),
+			"504": errorResponse("Conversion or one of its stages ran out of time"),
+		})
+	}
+	uploadBody := &OpenAPIRequestBody{
+		Description: "The project as a tar, tar.gz, tar.zst or tar.xz archive, a multipart form or a JSON document",
+		Required:    true,
+		Content: map[string]OpenAPIMediaType{
+			"application/x-tar": {Schema: &OpenAPISchema{Type: "string", Format: "binary"}},
+			"multipart/form-data": {Schema: &OpenAPISchema{
+				Type: "object",
+				Properties: map[string]*OpenAPISchema{
+					"project":  {Type: "string", Format: "binary"},
+					"options":  {Type: "string"},
+					"baseline": {Type: "string"},
+				},
+				Required: []string{"project"},
+			}},
+			"application/json": {Schema: schemas.schemaFor(reflect.TypeOf(JSONUpload{}))},
+		},
+	}
+	norgBody := &OpenAPIRequestBody{
+		Description: "A .norg document",
+		Required:    true,
+		Content:     map[string]OpenAPIMediaType{"text/plain": {Schema: &OpenAPISchema{Type: "string"}}},
+	}
+	methodNotAllowed := func(responses map[string]OpenAPIResponse) map[string]OpenAPIResponse {
+		responses["405"] = errorResponse("Method not allowed")
+		return responses
+	}
+
+	paths := map[string]OpenAPIPathItem{
+		"/": {"post": {
+			OperationId: "convert",
+			Summary:     "Convert a Neorg project",
+			Parameters:  slices.Concat(conversion, asyncParameters, conversionHeaders),
+			RequestBody: uploadBody,
+			Responses:   conversionResponses(),
+		}},
+		"/convert/git": {"post": {
+			OperationId: "convertGit",
+			Summary:     "Clone a git repository and convert it",
+			Parameters:  slices.Concat(conversion, asyncParameters, conversionHeaders),
+			RequestBody: &OpenAPIRequestBody{Required: true, Content: jsonContent(schemas.schemaFor(reflect.TypeOf(GitSource{})))},
+			Responses:   withErrors(mergeResponses(conversionResponses(), map[string]OpenAPIResponse{"502": errorResponse("Repository could not be fetched")})),
+		}},
+		"/jobs/{id}": {"get": {
+			OperationId: "getJob",
+			Summary:     "Status of a background job",
+			Parameters:  []OpenAPIParameter{pathParameter("id", "The job's ID")},
+			Responses:   withErrors(map[string]OpenAPIResponse{"200": {Description: "The job", Content: jsonContent(jobSchema)}, "404": errorResponse("Unknown job")}),
+		}},
+		"/jobs/{id}/events": {"get": {
+			OperationId: "getJobEvents",
+			Summary:     "Stream of a background job's progress",
+			Description: "Server-sent events: progress with the job whenever its status, stage, percent or queue position changes, then done with the finished job",
+			Parameters:  []OpenAPIParameter{pathParameter("id", "The job's ID")},
+			Responses: withErrors(map[string]OpenAPIResponse{
+				"200": {Description: "The event stream", Content: map[string]OpenAPIMediaType{"text/event-stream": {Schema: &OpenAPISchema{Type: "string"}}}},
+				"404": errorResponse("Unknown job"),
+			}),
+		}},
+		"/jobs/{id}/result": {"get": {
+			OperationId: "getJobResult",
+			Summary:     "Result of a background job",
+			Parameters:  []OpenAPIParameter{pathParameter("id", "The job's ID")},
+			Responses: withErrors(mergeResponses(conversionResponses(), map[string]OpenAPIResponse{
+				"202": {
+					Description: "The job has not finished yet",
+					Headers:     map[string]OpenAPIHeader{"Retry-After": {Schema: &OpenAPISchema{Type: "integer"}}},
+					Content:     jsonContent(jobSchema),
+				},
+				"404": errorResponse("Unknown job"),
+			})),
+		}},
+		"/render": {"post": {
+			OperationId: "render",
+			Summary:     "Render a single document",
+			Parameters: []OpenAPIParameter{
+				queryParameter("format", "Output format", &OpenAPISchema{Type: "string", Enum: []string{"markdown", "html"}}),
+				queryParameter("converter", "Conversion backend (default native)", &OpenAPISchema{Type: "string", Enum: conversionParameterValues["converter"]()}),
+			},
+			RequestBody: norgBody,
+			Responses: withErrors(map[string]OpenAPIResponse{"200": {
+				Description: "The rendered document",
+				Content: map[string]OpenAPIMediaType{
+					"text/markdown": {Schema: &OpenAPISchema{Type: "string"}},
+					"text/html":     {Schema: &OpenAPISchema{Type: "string"}},
+				},
+			}}),
+		}},
+		"/preview": {"post": {
+			OperationId: "preview",
+			Summary:     "Render a single document as a standalone HTML page",
+			Parameters:  []OpenAPIParameter{queryParameter("title", "Page title", &OpenAPISchema{Type: "string"})},
+			RequestBody: norgBody,
+			Responses: withErrors(map[string]OpenAPIResponse{"200": {
+				Description: "The HTML page",
+				Content:     map[string]OpenAPIMediaType{"text/html": {Schema: &OpenAPISchema{Type: "string"}}},
+			}}),
+		}},
+		"/ast": {"post": {
+			OperationId: "ast",
+			Summary:     "Parse a single document into its syntax tree",
+			RequestBody: norgBody,
+			Responses:   withErrors(map[string]OpenAPIResponse{"200": jsonResponse("The document's syntax tree", NorgDocument{})}),
+		}},
+		"/validate": {"post": {
+			OperationId: "validate",
+			Summary:     "Check a single document for structural errors",
+			RequestBody: norgBody,
+			Responses:   withErrors(map[string]OpenAPIResponse{"200": jsonResponse("The document's diagnostics", ValidationResult{})}),
+		}},
+		"/metadata": {"post": {
+			OperationId: "metadata",
+			Summary:     "Read the @document.meta block of every .norg file in a project",
+			Parameters:  []OpenAPIParameter{queryParameter("root", conversionParameterDocs["root"], &OpenAPISchema{Type: "string"})},
+			RequestBody: uploadBody,
+			Responses:   withErrors(map[string]OpenAPIResponse{"200": jsonResponse("Every file's metadata", MetadataResult{})}),
+		}},
+		"/cache": {
+			"get": {
+				OperationId: "getCache",
+				Summary:     "Result cache statistics and entries",
+				Parameters:  []OpenAPIParameter{queryParameter("tenant", "Only list this tenant's entries", &OpenAPISchema{Type: "string"})},
+				Responses:   withErrors(map[string]OpenAPIResponse{"200": jsonResponse("The cache's statistics and entries", CacheListing{})}),
+				Security:    admin,
+			},
+			"delete": {
+				OperationId: "invalidateTenantCache",
+				Summary:     "Invalidate every cache entry of a tenant",
+				Parameters:  []OpenAPIParameter{{Name: "tenant", In: "query", Required: true, Schema: &OpenAPISchema{Type: "string"}}},
+				Responses:   withErrors(map[string]OpenAPIResponse{"200": jsonResponse("The number of entries removed", CacheInvalidation{})}),
+				Security:    admin,
+			},
+		},
+		"/cache/{key}": {"delete": {
+			OperationId: "invalidateCacheEntry",
+			Summary:     "Invalidate a cache entry",
+			Parameters:  []OpenAPIParameter{pathParameter("key", "The entry's key")},
+			Responses:   withErrors(map[string]OpenAPIResponse{"200": jsonResponse("The number of entries removed", CacheInvalidation{}), "404": errorResponse("Unknown entry")}),
+			Security:    admin,
+		}},
+		"/cache/prewarm": {"post": {
+			OperationId: "prewarmCache",
+			Summary:     "Convert a project and cache the result",
+			Parameters:  append(slices.Clone(conversion), tenant),
+			RequestBody: uploadBody,
+			Responses:   withErrors(map[string]OpenAPIResponse{"201": jsonResponse("The new cache entry", CacheEntry{})}),
+			Security:    admin,
+		}},
+		"/webhooks/github": {"post": {
+			OperationId: "githubWebhook",
+			Summary:     "Convert the commit of a GitHub push event",
+			Description: "Authenticated by X-Hub-Signature-256 with GITHUB_WEBHOOK_SECRET instead of the auth token",
+			Parameters:  append(slices.Clone(conversion), callbackURL),
+			Responses: withErrors(map[string]OpenAPIResponse{
+				"200": {Description: "Event acknowledged without converting anything"},
+				"202": {Description: "Background job started", Content: jsonContent(jobSchema)},
+				"404": errorResponse("No webhook secret configured"),
+			}),
+			Security: public,
+		}},
+		"/webhooks/gitlab": {"post": {
+			OperationId: "gitlabWebhook",
+			Summary:     "Convert the commit of a GitLab push event",
+			Description: "Authenticated by X-Gitlab-Token with GITLAB_WEBHOOK_SECRET instead of the auth token",
+			Parameters:  append(slices.Clone(conversion), callbackURL),
+			Responses: withErrors(map[string]OpenAPIResponse{
+				"200": {Description: "Event acknowledged without converting anything"},
+				"202": {Description: "Background job started", Content: jsonContent(jobSchema)},
+				"404": errorResponse("No webhook secret configured"),
+			}),
+			Security: public,
+		}},
+		"/health": {"get": {
+			OperationId: "health",
+			Summary:     "Combined health check",
+			Parameters:  []OpenAPIParameter{queryParameter("deep", "Also convert a bundled sample document", &OpenAPISchema{Type: "boolean"})},
+			Responses: map[string]OpenAPIResponse{
+				"200": {Description: "Healthy", Content: jsonContent(schemas.schemaFor(reflect.TypeOf(HealthStatus{})))},
+				"503": {Description: "Unavailable", Content: jsonContent(schemas.schemaFor(reflect.TypeOf(HealthStatus{})))},
+			},
+			Security: public,
+		}},
+		"/healthz": {"get": {
+			OperationId: "liveness",
+			Summary:     "Liveness probe",
+			Responses:   map[string]OpenAPIResponse{"200": jsonResponse("The process serves requests", ProbeResponse{})},
+			Security:    public,
+		}},
+		"/readyz": {"get": {
+			OperationId: "readiness",
+			Summary:     "Readiness probe",
+			Responses: map[string]OpenAPIResponse{
+				"200": jsonResponse("The instance can take conversions", ProbeResponse{}),
+				"503": jsonResponse("Checks failed", ProbeResponse{}),
+			},
+			Security: public,
+		}},
+		"/version": {"get": {
+			OperationId: "version",
+			Summary:     "Build metadata of the service",
+			Responses:   methodNotAllowed(map[string]OpenAPIResponse{"200": jsonResponse("The service's build", BuildInfo{})}),
+			Security:    public,
+		}},
+		"/versions": {"get": {
+			OperationId: "versions",
+			Summary:     "Versions of the service and the tools that shape its output",
+			Responses:   methodNotAllowed(map[string]OpenAPIResponse{"200": jsonResponse("The versions", VersionsResponse{})}),
+			Security:    public,
+		}},
+		"/openapi.json": {"get": {
+			OperationId: "openapi",
+			Summary:     "This document",
+			Responses:   methodNotAllowed(map[string]OpenAPIResponse{"200": {Description: "The OpenAPI document", Content: jsonContent(&OpenAPISchema{Type: "object"})}}),
+			Security:    public,
+		}},
+	}
+
+	return OpenAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: OpenAPIInfo{
+			Title:       "Neorg Documentation Lambda",
+			Description: "Converts Neorg projects into markdown wikis and other documentation formats. Errors carry a stable code; every response carries the request's ID in " + requestIdHeader + ".",
+			Version:     buildInfo().Version,
+		},
+		Paths: paths,
+		Components: OpenAPIComponents{
+			Schemas: schemas,
+			SecuritySchemes: map[string]OpenAPISecurityScheme{
+				"authToken":  {Type: "apiKey", In: "header", Name: "x-auth-token", Description: "NEORG_DOCUMENTATION_AUTH_TOKEN"},
+				"adminToken": {Type: "apiKey", In: "header", Name: adminTokenHeader, Description: "CACHE_ADMIN_TOKEN"},
+			},
+		},
+		Security: []map[string][]string{{"authToken": {}}},
+	}
+}
+
+// conversionParameters lists the query parameters read into ConversionOptions, from their query tags
+func conversionParameters() []OpenAPIParameter {
+	optionsType := reflect.TypeOf(ConversionOptions{})
+	var parameters []OpenAPIParameter
+	for i := 0; i < optionsType.NumField(); i++ {
+		field := optionsType.Field(i)
+		name := field.Tag.Get("query")
+		if name == "" {
+			continue
+		}
+		schema := &OpenAPISchema{Type: "string"}
+		var explode *bool
+		switch field.Type.Kind() {
+		case reflect.Bool:
+			schema.Type = "boolean"
+		case reflect.Slice:
+			// Lists are comma-separated, like include=docs/**,notes
+			schema = &OpenAPISchema{Type: "array", Items: schema}
+			explode = new(bool)
+		}
+		if values, ok := conversionParameterValues[name]; ok {
+			schema.Enum = values()
+		}
+		if name == "compression" {
+			schema.Pattern = "^(store|[1-9])$"
+		}
+		parameters = append(parameters, OpenAPIParameter{
+			Name:        name,
+			In:          "query",
+			Description: conversionParameterDocs[name],
+			Explode:     explode,
+			Schema:      schema,
+		})
+	}
+	return parameters
+}
+
+func queryParameter(name string, description string, schema *OpenAPISchema) OpenAPIParameter {
+	return OpenAPIParameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func headerParameter(name string, description string) OpenAPIParameter {
+	return OpenAPIParameter{Name: name, In: "header", Description: description, Schema: &OpenAPISchema{Type: "string"}}
+}
+
+func pathParameter(name string, description string) OpenAPIParameter {
+	return OpenAPIParameter{Name: name, In: "path", Description: description, Required: true, Schema: &OpenAPISchema{Type: "string"}}
+}
+
+func jsonContent(schema *OpenAPISchema) map[string]OpenAPIMediaType {
+	return map[string]OpenAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// mergeResponses returns responses with the entries of overrides replacing its own
+func mergeResponses(responses map[string]OpenAPIResponse, overrides map[string]OpenAPIResponse) map[string]OpenAPIResponse {
+	maps.Copy(responses, overrides)
+	return responses
+}
+
+// openAPISchemas collects the component schemas of the named struct types a document refers to
+type openAPISchemas map[string]*OpenAPISchema
+
+// schemaFor describes how encoding/json marshals values of type t. Named structs become
+// components referred to by $ref; fields without omitempty are required.
+func (s openAPISchemas) schemaFor(t reflect.Type) *OpenAPISchema {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return &OpenAPISchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := *s.schemaFor(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return &schema
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &OpenAPISchema{Type: "number"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+		return &OpenAPISchema{Type: "array", Items: s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: s.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		if _, ok := s[t.Name()]; !ok {
+			// Registered before its fields are described, so recursive types terminate
+			s[t.Name()] = &OpenAPISchema{}
+			*s[t.Name()] = *s.structSchema(t)
+		}
+		return &OpenAPISchema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	// interface{} and anything else encoding/json marshals by its dynamic type
+	return &OpenAPISchema{}
+}
+
+// structSchema describes a struct's exported fields, inlining embedded structs like encoding/json
+func (s openAPISchemas) structSchema(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := s.structSchema(field.Type)
+			maps.Copy(schema.Properties, embedded.Properties)
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := s.schemaFor(field.Type)
+		if strings.Contains(options, "string") {
+			property = &OpenAPISchema{Type: "string"}
+		}
+		schema.Properties[name] = property
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ConversionOptions holds the per-request settings that control a conversion. The query tag names
+// the query parameter a field is read from, which is how /openapi.json lists them.
+type ConversionOptions struct {
+	// Profile names the server-side preset the options started from ("" for none)
+	Profile string `json:"profile,omitempty" query:"profile"`
+	// Root restricts conversion to a sub-path of the archive, using forward slashes ("" for the whole archive)
+	Root string `json:"root,omitempty" query:"root"`
+	// Include limits conversion to the .norg files matching one of these glob patterns, relative
+	// to the archive root (empty converts every .norg file)
+	Include []string `json:"include,omitempty" query:"include"`
+	// Exclude skips the archive entries matching one of these glob patterns
+	Exclude []string `json:"exclude,omitempty" query:"exclude"`
+	// Locale selects the embedded translations used for generated scaffolding
+	Locale string `json:"locale,omitempty" query:"locale"`
+	// Converter selects the conversion backend ("" uses the server default)
+	Converter string `json:"converter,omitempty" query:"converter"`
+	// Layout selects the structure of the generated wiki (flat, tree, slug or github-wiki)
+	Layout string `json:"layout,omitempty" query:"layout"`
+	// Output selects how the result is converted and packaged, e.g. html or html+tar.gz ("" is zip)
+	Output string `json:"output,omitempty" query:"output"`
+	// Compression selects how archive entries are compressed: "store", or a deflate level from 1
+	// (fastest) to 9 (smallest); "" uses the default deflate level
+	Compression string `json:"compression,omitempty" query:"compression"`
+	// Reproducible zeroes timestamps and normalizes permissions and ordering in result archives,
+	// and leaves per-run details out of the manifest and provenance, so identical inputs produce
+	// byte-identical archives
+	Reproducible bool `json:"reproducible,omitempty" query:"reproducible"`
+	// Strict fails the conversion when any .norg file fails to convert, instead of returning the
+	// files that did convert with a per-file error list
+	Strict bool `json:"strict,omitempty" query:"strict"`
+	// Flavor selects the markdown written by the converters: "gfm", "commonmark" or "pandoc"
+	// ("" is GFM)
+	Flavor string `json:"flavor,omitempty" query:"flavor"`
+	// Frontmatter prepends "yaml" or "toml" frontmatter built from each source's @document.meta
+	// to every converted page; "" adds none
+	Frontmatter string `json:"frontmatter,omitempty" query:"frontmatter"`
+	// TOC adds a table of contents page to every workspace's output: "index" (index.md) or
+	// "summary" (SUMMARY.md); "" adds none
+	TOC string `json:"toc,omitempty" query:"toc"`
+	// Backlinks adds the pages linking to each page: "section" appends a list to the page,
+	// "json" writes backlinks.json and "both" does both; "" adds none
+	Backlinks string `json:"backlinks,omitempty" query:"backlinks"`
+	// SearchIndex writes a search-index.json of every page's title, headings and text to each
+	// workspace's output, for client-side search with lunr or Fuse.js
+	SearchIndex bool `json:"search_index,omitempty" query:"search_index"`
+	// LinkMap writes a linkmap.json of the pages every page links to, to each workspace's output
+	LinkMap bool `json:"linkmap,omitempty" query:"linkmap"`
+	// BaseURL is where the output will be published; when set a sitemap.xml of its pages is written
+	BaseURL string `json:"base_url,omitempty" query:"base_url"`
+	// Anchors selects how headings become link anchors: "github", "gitlab" or "custom"; ""
+	// is GitHub's scheme
+	Anchors string `json:"anchors,omitempty" query:"anchors"`
+	// AnchorSeparator joins the words of custom anchors ("" is a hyphen)
+	AnchorSeparator string `json:"anchor_separator,omitempty" query:"anchor_separator"`
+	// LinkExtension replaces the extension of links between delivered pages: "md", "html" or
+	// "none"; "" keeps the one the layout and output format give them
+	LinkExtension string `json:"link_extension,omitempty" query:"link_extension"`
+	// LinkCase is "lower" to lowercase the paths of links between pages ("" or "preserve" keeps them)
+	LinkCase string `json:"link_case,omitempty" query:"link_case"`
+	// LinkPrefix turns links between pages into the prefix followed by the page's path in the
+	// workspace's output, e.g. /wiki/ or https://docs.example.com/
+	LinkPrefix string `json:"link_prefix,omitempty" query:"link_prefix"`
+	// LuaHooks lets the Neovim converter run a workspace's docgen_hooks.lua, as the deployment
+	// allows for the client's tenant; set by the server, never by the client
+	LuaHooks bool `json:"lua_hooks,omitempty"`
+	// NeorgVersion selects one of the pinned Neorg versions installed in the image for the Neovim
+	// converter ("" uses the version the image was built with)
+	NeorgVersion string `json:"neorg_version,omitempty"`
+	// AllowModules restricts the Neorg modules loaded by docgen to this list (empty keeps the deployment set)
+	AllowModules []string `json:"allow_modules,omitempty" query:"modules_allow"`
+	// DenyModules lists Neorg modules that must not be loaded during conversion
+	DenyModules []string `json:"deny_modules,omitempty" query:"modules_deny"`
+	// Baseline is a previous result's manifest; when set only changed files are returned
+	Baseline *Manifest `json:"-"`
+	// Debug attaches docgen's output to the error response when the conversion fails
+	Debug bool `json:"-" query:"debug"`
+	// DryRun answers with the conversion plan instead of converting
+	DryRun bool `json:"-" query:"dry_run"`
+}
+
+// parseConversionOptions reads conversion options from the request query parameters.
+// A profile parameter selects a server-side preset that the other parameters override.
+func parseConversionOptions(r *http.Request) (ConversionOptions, error) {
+	query := r.URL.Query()
+	options := ConversionOptions{Locale: defaultLocale}
+
+	if name := query.Get("profile"); name != "" {
+		profile, ok := conversionProfiles[name]
+		if !ok {
+			return options, fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(profileNames(), ", "))
+		}
+		options = profile
+	}
+
+	if root := query.Get("root"); root != "" {
+		cleaned, err := cleanArchivePath(root)
+		if err != nil {
+			return options, fmt.Errorf("invalid root %q: %v", root, err)
+		}
+		options.Root = cleaned
+	}
+
+	if include := query.Get("include"); include != "" {
+		patterns, err := parseGlobList("include", include)
+		if err != nil {
+			return options, err
+		}
+		options.Include = patterns
+	}
+
+	if exclude := query.Get("exclude"); exclude != "" {
+		patterns, err := parseGlobList("exclude", exclude)
+		if err != nil {
+			return options, err
+		}
+		options.Exclude = patterns
+	}
+
+	if locale := query.Get("locale"); locale != "" {
+		resolved, err := resolveLocale(locale)
+		if err != nil {
+			return options, err
+		}
+		options.Locale = resolved
+	}
+
+	if converter := strings.ToLower(query.Get("converter")); converter != "" {
+		if !validConverter(converter) {
+			return options, fmt.Errorf("unknown converter %q", converter)
+		}
+		options.Converter = converter
+	}
+
+	if layout := strings.ToLower(query.Get("layout")); layout != "" {
+		if !validLayout(layout) {
+			return options, fmt.Errorf("unknown layout %q", layout)
+		}
+		options.Layout = layout
+	}
+
+	// The Accept header picks the packaging unless ?output or the profile names one
+	output := options.Output
+	if requested := strings.ToLower(query.Get("output")); requested != "" {
+		output = requested
+	}
+	format, err := formats.Negotiate(output, r.Header.Get("Accept"))
+	if err != nil {
+		return options, err
+	}
+	options.Output = ""
+	if format.Name != defaultOutput {
+		options.Output = format.Name
+	}
+
+	if options.Compression == "" {
+		options.Compression = getEnv("ARCHIVE_COMPRESSION", "")
+	}
+	if compression := strings.ToLower(query.Get("compression")); compression != "" {
+		options.Compression = compression
+	}
+	if _, err := compressionLevel(options.Compression); err != nil {
+		return options, err
+	}
+
+	options.Reproducible = options.Reproducible || strings.EqualFold(getEnv("REPRODUCIBLE_ARCHIVES", "false"), "true")
+	if value := query.Get("reproducible"); value != "" {
+		reproducible, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid reproducible value %q", value)
+		}
+		options.Reproducible = reproducible
+	}
+
+	if value := query.Get("strict"); value != "" {
+		strict, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid strict value %q", value)
+		}
+		options.Strict = strict
+	}
+
+	if flavor := strings.ToLower(query.Get("flavor")); flavor != "" {
+		if !validFlavor(flavor) {
+			return options, fmt.Errorf("unknown flavor %q (available: gfm, commonmark, pandoc)", flavor)
+		}
+		options.Flavor = flavor
+	}
+
+	if frontmatter := strings.ToLower(query.Get("frontmatter")); frontmatter != "" {
+		if !validFrontmatter(frontmatter) {
+			return options, fmt.Errorf("unknown frontmatter %q (available: yaml, toml)", frontmatter)
+		}
+		options.Frontmatter = frontmatter
+	}
+	// Only markdown pages can carry frontmatter
+	if options.Frontmatter != "" && path.Ext(formatPagePath(format, "page.md")) != ".md" {
+		return options, fmt.Errorf("frontmatter cannot be combined with output=%s, which does not deliver markdown pages", format.Name)
+	}
+
+	if toc := strings.ToLower(query.Get("toc")); toc != "" {
+		if !validTOC(toc) {
+			return options, fmt.Errorf("unknown toc %q (available: index, summary)", toc)
+		}
+		options.TOC = toc
+	}
+	// mdBook projects generate their own SUMMARY.md
+	if options.TOC == tocSummary && strings.HasPrefix(options.Output, "mdbook") {
+		return options, fmt.Errorf("toc=summary cannot be combined with output=%s, which writes its own SUMMARY.md", options.Output)
+	}
+
+	if backlinks := strings.ToLower(query.Get("backlinks")); backlinks != "" {
+		if !validBacklinks(backlinks) {
+			return options, fmt.Errorf("unknown backlinks %q (available: section, json, both)", backlinks)
+		}
+		options.Backlinks = backlinks
+	}
+
+	if value := query.Get("search_index"); value != "" {
+		searchIndex, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid search_index value %q", value)
+		}
+		options.SearchIndex = searchIndex
+	}
+
+	if value := query.Get("linkmap"); value != "" {
+		linkMap, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid linkmap value %q", value)
+		}
+		options.LinkMap = linkMap
+	}
+
+	if value := query.Get("base_url"); value != "" {
+		baseURL, err := parseBaseURL(value)
+		if err != nil {
+			return options, err
+		}
+		options.BaseURL = baseURL
+	}
+
+	if anchors := strings.ToLower(query.Get("anchors")); anchors != "" {
+		if !validAnchors(anchors) {
+			return options, fmt.Errorf("unknown anchors %q (available: github, gitlab, custom)", anchors)
+		}
+		options.Anchors = anchors
+	}
+
+	if value := query.Get("anchor_separator"); value != "" {
+		separator, err := parseAnchorSeparator(value)
+		if err != nil {
+			return options, err
+		}
+		options.AnchorSeparator = separator
+	}
+	if options.AnchorSeparator != "" && options.Anchors != anchorsCustom {
+		return options, fmt.Errorf("anchor_separator requires anchors=custom")
+	}
+
+	if extension := strings.ToLower(query.Get("link_extension")); extension != "" {
+		if !validLinkExtension(extension) {
+			return options, fmt.Errorf("unknown link_extension %q (available: md, html, none)", extension)
+		}
+		options.LinkExtension = extension
+	}
+
+	if linkCase := strings.ToLower(query.Get("link_case")); linkCase != "" {
+		if !validLinkCase(linkCase) {
+			return options, fmt.Errorf("unknown link_case %q (available: lower, preserve)", linkCase)
+		}
+		options.LinkCase = linkCase
+	}
+
+	if value := query.Get("link_prefix"); value != "" {
+		prefix, err := parseLinkPrefix(value)
+		if err != nil {
+			return options, err
+		}
+		options.LinkPrefix = prefix
+	}
+
+	if value := query.Get("dry_run"); value != "" {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid dry_run value %q", value)
+		}
+		options.DryRun = dryRun
+	}
+
+	if value := query.Get("debug"); value != "" {
+		debug, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid debug value %q", value)
+		}
+		if debug && !debugOutputAllowed() {
+			return options, fmt.Errorf("debug output is not enabled on this server")
+		}
+		options.Debug = debug
+	}
+
+	if allow := query.Get("modules_allow"); allow != "" {
+		modules, err := parseModuleList(allow)
+		if err != nil {
+			return options, err
+		}
+		options.AllowModules = modules
+	}
+
+	if deny := query.Get("modules_deny"); deny != "" {
+		modules, err := parseModuleList(deny)
+		if err != nil {
+			return options, err
+		}
+		options.DenyModules = append(options.DenyModules, modules...)
+	}
+
+	if version := r.Header.Get(neorgVersionHeader); version != "" {
+		resolved, err := resolveNeorgVersion(version)
+		if err != nil {
+			return options, err
+		}
+		options.NeorgVersion = resolved
+	}
+
+	options.LuaHooks = luaHooksAllowed(r)
+
+	if header := r.Header.Get(baselineManifestHeader); header != "" {
+		baseline, err := parseBaselineManifest(header)
+		if err != nil {
+			return options, err
+		}
+		options.Baseline = baseline
+	}
+
+	return options, nil
+}
+
+// optionsErrorStatus is the status answering invalid conversion options: 406 when the requested
+// output cannot be produced and 400 otherwise
+func optionsErrorStatus(err error) int {
+	if errors.Is(err, errNotAcceptable) {
+		return http.StatusNotAcceptable
+	}
+	return http.StatusBadRequest
+}
+
+// optionsErrorCode is the error code matching optionsErrorStatus
+func optionsErrorCode(err error) string {
+	if errors.Is(err, errNotAcceptable) {
+		return codeNotAcceptable
+	}
+	return codeInvalidOptions
+}
+
+// cleanArchivePath normalizes a slash-separated path inside an archive and rejects paths escaping it
+func cleanArchivePath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("path must not contain parent directory references")
+		}
+	}
+	return strings.TrimPrefix(path.Clean("/"+p), "/"), nil
+}
+
+// archivePathWithin reports whether an archive entry name lies inside root ("" matches everything)
+func archivePathWithin(name, root string) bool {
+	if root == "" {
+		return true
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return name == root || strings.HasPrefix(name, root+"/")
+}
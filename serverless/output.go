@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OutputFormat describes how a result is converted, packaged and delivered: one of the registry's
+// page converters combined with one of its packagers
+type OutputFormat struct {
+	// Name is the canonical ?output value selecting this combination
+	Name        string
+	Extension   string
+	ContentType string
+	// Inline results are meant to be consumed directly rather than saved as a download
+	Inline bool
+	// Transform rewrites each workspace's generated wiki before digests are taken and it is
+	// packaged, e.g. into HTML pages or a static site generator's project layout
+	Transform func(wikiDir string) error
+	// PagePath is where Transform puts a markdown page of the generated wiki; nil keeps it in place
+	PagePath func(page string) string
+	// Package writes the result file for the collected output directory and returns its name
+	Package func(ctx context.Context, wikiDir string, requestId string, options ConversionOptions) (string, error)
+	// Stream writes the result straight to a response; nil when it can only be packaged to a file
+	Stream func(ctx context.Context, w io.Writer, wikiDir string, requestId string, options ConversionOptions) error
+}
+
+// maxJSONOutputBytes caps the files inlined by output=json, which is meant for small projects
+const maxJSONOutputBytes = 32 << 20
+
+// JSONOutput is the result body of output=json
+type JSONOutput struct {
+	Files []JSONOutputFile `json:"files"`
+}
+
+// JSONOutputFile is one generated file; content is UTF-8 text unless encoding is "base64"
+type JSONOutputFile struct {
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// packageOutput packages the generated documentation in the requested output format and returns
+// the file name of the result, which the caller removes once it has been delivered
+func packageOutput(ctx context.Context, wikiDir string, requestId string, options ConversionOptions) (string, error) {
+	return outputFormat(options.Output).Package(ctx, wikiDir, requestId, options)
+}
+
+// compressionLevel returns the deflate level for a compression option: flate.NoCompression for
+// "store", 1 to 9 as given and flate.DefaultCompression for ""
+func compressionLevel(compression string) (int, error) {
+	switch compression {
+	case "":
+		return flate.DefaultCompression, nil
+	case "store":
+		return flate.NoCompression, nil
+	}
+	level, err := strconv.Atoi(compression)
+	if err != nil || level < flate.BestSpeed || level > flate.BestCompression {
+		return 0, fmt.Errorf("invalid compression %q (store or a level from 1 to 9)", compression)
+	}
+	return level, nil
+}
+
+// reproducibleModTime is the timestamp of every entry in reproducible archives, the earliest an
+// MS-DOS zip timestamp can hold
+var reproducibleModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// normalizeZipHeader strips the details that differ between runs from a zip entry
+func normalizeZipHeader(header *zip.FileHeader) {
+	header.Modified = reproducibleModTime
+	header.SetMode(0644)
+}
+
+// normalizeTarHeader strips the details that differ between runs from a tar entry
+func normalizeTarHeader(header *tar.Header) {
+	header.ModTime = reproducibleModTime
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.Mode = 0644
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.Format = tar.FormatPAX
+}
+
+// streamZipArchive writes the zip archive of the generated wiki files to w as it is built
+func streamZipArchive(ctx context.Context, w io.Writer, wikiDir string, requestId string, options ConversionOptions) error {
+	fileCount, totalBytesAdded, err := writeZipArchive(ctx, w, wikiDir, requestId, options)
+	if err != nil {
+		return err
+	}
+	logger.WithFields(logrus.Fields{
+		"request_id":        requestId,
+		"files_added":       fileCount,
+		"total_bytes_added": totalBytesAdded,
+	}).Info("ZIP archive streamed successfully")
+	return nil
+}
+
+// createTarGzArchive creates a gzip-compressed tarball containing all the generated wiki files,
+// for pipelines such as CI jobs or Nix builds that consume tarballs rather than zips
+func createTarGzArchive(ctx context.Context, wikiDir string, requestId string, options ConversionOptions) (string, error) {
+	archiveFileName := resultFileName(requestId, ".tar.gz")
+
+	// Refuse to package output beyond the configured cap before writing anything
+	err := checkOutputSize(wikiDir, getEnvInt64("MAX_OUTPUT_BYTES", defaultMaxOutputBytes))
+	if err != nil {
+		return "", err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"request_id":       requestId,
+		"archive_filename": archiveFileName,
+		"wiki_dir":         wikiDir,
+	}).Info("Creating tar.gz archive for generated documentation")
+
+	archiveFile, err := os.Create(archiveFileName)
+	if err != nil {
+		return "", err
+	}
+	defer archiveFile.Close()
+	level, err := compressionLevel(options.Compression)
+	if err != nil {
+		return "", err
+	}
+	gzipWriter, err := gzip.NewWriterLevel(archiveFile, level)
+	if err != nil {
+		return "", err
+	}
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	var fileCount int
+	err = filepath.Walk(wikiDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(wikiDir, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if options.Reproducible {
+			normalizeTarHeader(header)
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := io.Copy(tarWriter, file); err != nil {
+			return err
+		}
+		fileCount++
+		return nil
+	})
+	if err == nil {
+		err = tarWriter.Close()
+	}
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		os.Remove(archiveFileName)
+		return "", fmt.Errorf("failed to write tar.gz archive: %v", err)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"request_id":       requestId,
+		"archive_filename": archiveFileName,
+		"files_added":      fileCount,
+	}).Info("tar.gz archive creation completed successfully")
+	return archiveFileName, nil
+}
+
+// createJSONOutput writes all the generated wiki files into a single JSON document so scripts can
+// consume small projects without unzipping; files that are not valid UTF-8 are base64 encoded
+func createJSONOutput(ctx context.Context, wikiDir string, requestId string, options ConversionOptions) (string, error) {
+	outputFileName := resultFileName(requestId, ".json")
+
+	limit := getEnvInt64("MAX_OUTPUT_BYTES", defaultMaxOutputBytes)
+	if limit <= 0 || limit > maxJSONOutputBytes {
+		limit = maxJSONOutputBytes
+	}
+	if err := checkOutputSize(wikiDir, limit); err != nil {
+		return "", err
+	}
+
+	result := JSONOutput{Files: []JSONOutputFile{}}
+	err := filepath.Walk(wikiDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(wikiDir, path)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		file := JSONOutputFile{Path: filepath.ToSlash(relPath), Content: string(content)}
+		if !utf8.Valid(content) {
+			file.Content = base64.StdEncoding.EncodeToString(content)
+			file.Encoding = "base64"
+		}
+		result.Files = append(result.Files, file)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read wiki directory: %v", err)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(outputFileName, data, 0644); err != nil {
+		return "", err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"request_id":      requestId,
+		"output_filename": outputFileName,
+		"files_added":     len(result.Files),
+	}).Info("JSON output creation completed successfully")
+	return outputFileName, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// parseGlobList reads a comma-separated list of ?include / ?exclude glob patterns. Patterns use
+// path.Match syntax per path segment, plus ** for any number of segments.
+func parseGlobList(key string, value string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid %s pattern %q: %v", key, pattern, err)
+			}
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// extractsEntry reports whether an archive entry is extracted for a conversion with options: it
+// must lie inside the root, must not match an exclude pattern and, for .norg files, must match
+// an include pattern when there are any. Other files stay available to link to.
+func extractsEntry(name string, options ConversionOptions) bool {
+	if !archivePathWithin(name, options.Root) {
+		return false
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	for _, pattern := range options.Exclude {
+		if matchGlob(pattern, name) {
+			return false
+		}
+	}
+	if len(options.Include) == 0 || !strings.HasSuffix(name, ".norg") {
+		return true
+	}
+	for _, pattern := range options.Include {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether a slash-separated path, or one of the directories containing it,
+// matches pattern, so a pattern naming a directory covers everything below it
+func matchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where ** stands for any number
+// of segments. A pattern matching the leading segments of the path matches the path.
+func matchSegments(pattern []string, name []string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	if pattern[0] == "**" {
+		for skip := 0; skip <= len(name); skip++ {
+			if matchSegments(pattern[1:], name[skip:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
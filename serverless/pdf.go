@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultPDFEngine is the HTML to PDF renderer pandoc drives for output=pdf
+	defaultPDFEngine = "wkhtmltopdf"
+	// pdfTimeout bounds rendering the combined PDF
+	pdfTimeout = 5 * time.Minute
+	// pdfPageBreak separates the documents in the combined PDF
+	pdfPageBreak = "\n\n<div style=\"page-break-before: always;\"></div>\n\n"
+)
+
+// pdfPageRank is 0 for a root index or readme page and 1 for every other page
+func pdfPageRank(page string) int {
+	switch strings.ToLower(page) {
+	case "index.md", "readme.md", "home.md":
+		return 0
+	}
+	return 1
+}
+
+// pdfPageOrder sorts generated pages for the combined PDF: a root index or readme opens the
+// document and the rest follow in path order
+func pdfPageOrder(pages []string) {
+	sort.SliceStable(pages, func(i, j int) bool {
+		if rank := pdfPageRank(pages[i]) - pdfPageRank(pages[j]); rank != 0 {
+			return rank < 0
+		}
+		return pages[i] < pages[j]
+	})
+}
+
+// createPDFOutput combines all generated pages into a single PDF with pandoc and PDF_ENGINE
+// (wkhtmltopdf by default), one page break between documents, for offline distribution
+func createPDFOutput(ctx context.Context, wikiDir string, requestId string, options ConversionOptions) (string, error) {
+	pdfFileName := resultFileName(requestId, ".pdf")
+
+	// Refuse to render output beyond the configured cap before starting pandoc
+	err := checkOutputSize(wikiDir, getEnvInt64("MAX_OUTPUT_BYTES", defaultMaxOutputBytes))
+	if err != nil {
+		return "", err
+	}
+
+	var pages []string
+	err = filepath.Walk(wikiDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".md") {
+			return err
+		}
+		rel, err := filepath.Rel(wikiDir, path)
+		if err == nil {
+			pages = append(pages, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read wiki directory: %v", err)
+	}
+	if len(pages) == 0 {
+		return "", errNoDocumentation
+	}
+	pdfPageOrder(pages)
+
+	combined, err := os.CreateTemp("", "neorg_pdf_*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(combined.Name())
+	for i, page := range pages {
+		content, err := os.ReadFile(filepath.Join(wikiDir, filepath.FromSlash(page)))
+		if err != nil {
+			combined.Close()
+			return "", err
+		}
+		if i > 0 {
+			combined.WriteString(pdfPageBreak)
+		}
+		combined.Write(content)
+	}
+	if err := combined.Close(); err != nil {
+		return "", err
+	}
+
+	engine := getEnv("PDF_ENGINE", defaultPDFEngine)
+	logger.WithFields(logrus.Fields{
+		"request_id": requestId,
+		"pages":      len(pages),
+		"pdf_engine": engine,
+	}).Info("Rendering combined PDF for generated documentation")
+
+	ctx, cancel := context.WithTimeout(ctx, pdfTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pandoc",
+		"--from", "gfm",
+		"--pdf-engine", engine,
+		"--metadata", "pagetitle=Documentation",
+		"--resource-path", wikiDir,
+		"--output", pdfFileName,
+		combined.Name(),
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+			"stderr":     stderr.String(),
+		}).Error("PDF rendering failed")
+		os.Remove(pdfFileName)
+		return "", fmt.Errorf("pandoc failed to render PDF: %v", err)
+	}
+	return pdfFileName, nil
+}
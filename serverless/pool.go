@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/neovim/go-client/nvim"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultNvimPoolMaxJobs recycles a pooled instance after this many conversions unless
+	// NVIM_POOL_MAX_JOBS is set
+	defaultNvimPoolMaxJobs = 20
+	// nvimStartTimeout bounds how long a new instance may take to load its plugins and listen
+	nvimStartTimeout = 60 * time.Second
+)
+
+// nvimDocgenJob runs the docgen converter in a pooled instance. It switches to the workspace's
+// docgen directory, points docgen at the workspace's error report and timings file, selects the
+// markdown flavor, and captures the converter's messages the way make documentation captures
+// stdout. The module policy and config overlay are only read when Neorg is set up, so they are
+// fixed for the life of the instance; see Serves.
+const nvimDocgenJob = `
+local dir, errors, timings, flavor = ...
+vim.env.NEORG_DOCGEN_ERRORS = errors
+vim.env.NEORG_DOCGEN_TIMINGS = timings
+vim.env.NEORG_DOCGEN_FLAVOR = flavor
+vim.cmd.cd(vim.fn.fnameescape(dir))
+package.loaded["fileio"] = nil
+local ok, result = pcall(vim.api.nvim_exec2, "source simple_norg_converter.lua", { output = true })
+vim.cmd.cd("/")
+if not ok then
+    return { ok = false, output = tostring(result) }
+end
+return { ok = true, output = result.output }
+`
+
+// nvimJobResult is what nvimDocgenJob returns
+type nvimJobResult struct {
+	Ok     bool   `msgpack:"ok"`
+	Output string `msgpack:"output"`
+}
+
+// NvimPool keeps long-lived headless Neovim instances listening on unix sockets, so conversions
+// skip the plugin load time of starting nvim per request. Jobs are dispatched over msgpack-RPC
+// and every instance is recycled after NVIM_POOL_MAX_JOBS conversions, or as soon as a job fails,
+// so state leaking between workspaces stays bounded. Instances run in the conversion sandbox like
+// make documentation: in their own process group, under the resource limits, with the service's
+// secrets scrubbed from their environment and, with CONVERSION_UID, as a sandbox user that can
+// only write to the project it is converting.
+type NvimPool struct {
+	dir     string
+	maxJobs int
+	idle    chan *nvimInstance
+	slots   chan struct{}
+}
+
+// nvimInstance is one pooled Neovim process and its RPC connection
+type nvimInstance struct {
+	cmd     *exec.Cmd
+	process *sandboxedProcess
+	client  *nvim.Nvim
+	// dir holds the instance's socket, module policy and scratch directories
+	dir    string
+	socket string
+	jobs   int
+}
+
+// nvimPool is the process-wide Neovim pool, nil when NVIM_POOL_SIZE is unset or zero
+var nvimPool *NvimPool
+
+// newNvimPoolFromEnv creates a pool of NVIM_POOL_SIZE instances, which are started on first use
+func newNvimPoolFromEnv() *NvimPool {
+	size := int(getEnvInt64("NVIM_POOL_SIZE", 0))
+	if size <= 0 {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "neorg_nvim_*")
+	if err != nil {
+		logger.WithError(err).Error("Failed to create Neovim socket directory, pool disabled")
+		return nil
+	}
+	maxJobs := int(getEnvInt64("NVIM_POOL_MAX_JOBS", defaultNvimPoolMaxJobs))
+	if maxJobs <= 0 {
+		maxJobs = defaultNvimPoolMaxJobs
+	}
+	logger.WithFields(logrus.Fields{
+		"size":     size,
+		"max_jobs": maxJobs,
+	}).Info("Neovim pool enabled")
+	return &NvimPool{
+		dir:     dir,
+		maxJobs: maxJobs,
+		idle:    make(chan *nvimInstance, size),
+		slots:   make(chan struct{}, size),
+	}
+}
+
+// Serves reports whether a pooled instance converts the workspace in projectDir the way a fresh
+// Neovim would. Instances set Neorg up once, with the deployment's module policy and without a
+// neorg_config.lua overlay, so requests narrowing the modules and workspaces with an overlay start
+// their own Neovim instead.
+func (p *NvimPool) Serves(projectDir string, options ConversionOptions) bool {
+	policy, err := neorgModulePolicy(options)
+	if err != nil {
+		return false
+	}
+	deployment, err := neorgModulePolicy(ConversionOptions{})
+	if err != nil || !slices.Equal(policy.Load, deployment.Load) || !slices.Equal(policy.Disable, deployment.Disable) {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(projectDir, "docgen", userConfigFileName))
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// Run converts the workspace in projectDir on a warm instance, starting one when fewer than
+// NVIM_POOL_SIZE are running, and waits for one to become idle otherwise
+func (p *NvimPool) Run(ctx context.Context, projectDir string, flavor string) error {
+	instance, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	docgenDir := filepath.Join(projectDir, "docgen")
+	restore, err := instance.process.HandOver(projectDir)
+	if err != nil {
+		p.release(instance, false)
+		return fmt.Errorf("failed to hand the project directory to the sandbox user: %v", err)
+	}
+	result, err := instance.exec(ctx, docgenDir, flavor)
+	restore()
+	if err == nil && !result.Ok {
+		err = &docgenOutputError{Err: fmt.Errorf("docgen failed: %s", strings.TrimSpace(result.Output)), Stdout: result.Output}
+	}
+	instance.jobs++
+	p.release(instance, err != nil)
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"project_dir": projectDir,
+			"error":       err.Error(),
+			"socket":      instance.socket,
+		}).Error("Pooled Neovim conversion failed")
+		return err
+	}
+	logger.WithFields(logrus.Fields{
+		"project_dir": projectDir,
+		"output":      result.Output,
+		"socket":      instance.socket,
+		"jobs":        instance.jobs,
+	}).Info("Pooled Neovim conversion completed successfully")
+	return nil
+}
+
+// acquire takes an idle instance, or a free slot to start a new one
+func (p *NvimPool) acquire(ctx context.Context) (*nvimInstance, error) {
+	select {
+	case instance := <-p.idle:
+		return instance, nil
+	default:
+	}
+	select {
+	case instance := <-p.idle:
+		return instance, nil
+	case p.slots <- struct{}{}:
+		instance, err := p.start(ctx)
+		if err != nil {
+			<-p.slots
+			return nil, err
+		}
+		return instance, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// release returns an instance to the pool, or stops it when it failed or has run its jobs
+func (p *NvimPool) release(instance *nvimInstance, failed bool) {
+	if !failed && instance.jobs < p.maxJobs {
+		p.idle <- instance
+		return
+	}
+	logger.WithFields(logrus.Fields{
+		"socket": instance.socket,
+		"jobs":   instance.jobs,
+		"failed": failed,
+	}).Debug("Recycling pooled Neovim instance")
+	instance.stop()
+	<-p.slots
+}
+
+// start launches a headless instance listening on a socket in a new directory of the pool
+// directory and connects to it once it is ready
+func (p *NvimPool) start(ctx context.Context) (*nvimInstance, error) {
+	dir, err := os.MkdirTemp(p.dir, "nvim_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Neovim instance directory: %v", err)
+	}
+	socket := filepath.Join(dir, "nvim.sock")
+
+	// The instance sets Neorg up with the deployment's module policy
+	if err := writeNeorgModulePolicy(dir, ConversionOptions{}); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to write Neorg module policy: %v", err)
+	}
+
+	// With CONVERSION_UID the instance runs as a sandbox user for its whole life, and each project
+	// is handed to that user while the instance converts it
+	sandbox := sandboxConfigFromEnv()
+	sandbox.WritableDir = dir
+	// The CPU limit covers every conversion the instance runs
+	sandbox.CPUSeconds *= uint64(p.maxJobs)
+	for _, scratch := range sandbox.scratchDirs() {
+		if err := os.MkdirAll(scratch, 0700); err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("failed to create sandbox scratch directory: %v", err)
+		}
+	}
+
+	// Instances outlive the request that started them, so they do not use its context. Unlike
+	// make documentation they serve many projects, so their environment is always scrubbed.
+	cmd := exec.Command("nvim", "--headless", "--listen", socket)
+	cmd.Dir = dir
+	cmd.Env = sandbox.environment(append(os.Environ(),
+		"XDG_CONFIG_HOME=/app",
+		"XDG_DATA_HOME=/app/data",
+		"HOME=/app",
+		"NEORG_DOCGEN_MODULES="+filepath.Join(dir, neorgModulesFileName),
+	))
+	process, err := startSandboxed(cmd, sandbox)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to start Neovim: %v", err)
+	}
+	instance := &nvimInstance{cmd: cmd, process: process, dir: dir, socket: socket}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	deadline := time.Now().Add(nvimStartTimeout)
+	for {
+		client, err := nvim.Dial(socket, nvim.DialContext(context.Background()))
+		if err == nil {
+			instance.client = client
+			break
+		}
+		select {
+		case <-exited:
+			return nil, fmt.Errorf("Neovim exited before listening on %s", socket)
+		case <-ctx.Done():
+			instance.stop()
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			instance.stop()
+			return nil, fmt.Errorf("Neovim did not listen on %s within %s", socket, nvimStartTimeout)
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"socket": socket,
+		"pid":    cmd.Process.Pid,
+	}).Debug("Started pooled Neovim instance")
+	return instance, nil
+}
+
+// exec runs the docgen job in docgenDir, killing the instance if ctx ends first
+func (i *nvimInstance) exec(ctx context.Context, docgenDir string, flavor string) (nvimJobResult, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			i.cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	var result nvimJobResult
+	err := i.client.ExecLua(nvimDocgenJob, &result,
+		docgenDir,
+		filepath.Join(docgenDir, docgenErrorsFileName),
+		filepath.Join(docgenDir, docgenTimingsFileName),
+		flavor,
+	)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return result, ctxErr
+	}
+	if err != nil {
+		return result, fmt.Errorf("Neovim RPC failed: %v", err)
+	}
+	return result, nil
+}
+
+// stop closes the connection, kills the process group and removes the instance directory
+func (i *nvimInstance) stop() {
+	if i.client != nil {
+		i.client.Close()
+	}
+	i.process.Finish()
+	if err := os.RemoveAll(i.dir); err != nil {
+		logger.WithError(err).Warn("Failed to remove pooled Neovim instance directory")
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// startPprofServer serves the net/http/pprof profiles on a separate admin address when
+// ENABLE_PPROF=true, so memory held by spooled uploads and archive writers can be profiled in
+// production. They are never served on the main port; the default address only listens on
+// loopback.
+func startPprofServer() {
+	if getEnv("ENABLE_PPROF", "false") != "true" {
+		return
+	}
+	addr := getEnv("PPROF_ADDR", "127.0.0.1:6060")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.WithError(err).WithField("addr", addr).Error("pprof server stopped")
+		}
+	}()
+	logger.WithField("addr", addr).Warn("Serving pprof debug endpoints")
+}
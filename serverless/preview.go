@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+)
+
+// previewRenderer renders markdown with syntax-highlighted code blocks using inline styles,
+// so the resulting page needs no external stylesheet
+var previewRenderer = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM,
+		highlighting.NewHighlighting(
+			highlighting.WithStyle("github"),
+			highlighting.WithFormatOptions(chromahtml.WithClasses(false)),
+		),
+	),
+)
+
+// previewTemplate wraps a rendered document in a self-contained HTML page
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; padding: 2rem; font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; line-height: 1.6; color: #1f2328; background: #ffffff; }
+main { max-width: 52rem; margin: 0 auto; }
+h1, h2 { border-bottom: 1px solid #d0d7de; padding-bottom: .3em; }
+a { color: #0969da; }
+code { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; font-size: 85%; background: #f6f8fa; padding: .2em .4em; border-radius: 6px; }
+pre { padding: 1rem; overflow: auto; border-radius: 6px; background: #f6f8fa; }
+pre code { padding: 0; background: transparent; }
+blockquote { margin: 0; padding: 0 1em; color: #59636e; border-left: .25em solid #d0d7de; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #d0d7de; padding: .4em .8em; }
+ul.contains-task-list { list-style: none; padding-left: 1.2em; }
+</style>
+</head>
+<body>
+<main>
+{{.Body}}
+</main>
+</body>
+</html>
+`))
+
+// previewHandler converts a single uploaded .norg document into a standalone, styled HTML page
+// suitable for embedding in an iframe
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	markdown, ok := readRenderRequest(w, r, requestId)
+	if !ok {
+		return
+	}
+
+	var body bytes.Buffer
+	err := previewRenderer.Convert(markdown, &body)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Error("Failed to render preview")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Failed to render preview",
+			Code:  codeInternal,
+			Id:    requestId,
+		})
+		return
+	}
+
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		title = "Preview"
+	}
+
+	var page bytes.Buffer
+	err = previewTemplate.Execute(&page, struct {
+		Title string
+		Body  template.HTML
+	}{
+		Title: title,
+		Body:  template.HTML(body.String()),
+	})
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Error("Failed to build preview page")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Failed to render preview",
+			Code:  codeInternal,
+			Id:    requestId,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(page.Bytes())
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	// ProbeResponse is the response body of /healthz and /readyz
+	ProbeResponse struct {
+		// Status is "ok" when every check passed and "unavailable" otherwise
+		Status string       `json:"status"`
+		Checks []ProbeCheck `json:"checks,omitempty"`
+		Queue  *QueueStatus `json:"queue,omitempty"`
+		Uptime float64      `json:"uptime_seconds,omitempty"`
+	}
+
+	// ProbeCheck is the result of one readiness check
+	ProbeCheck struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}
+)
+
+// processStarted is when the service started, reported by /healthz
+var processStarted = time.Now()
+
+// livenessHandler answers /healthz: the process is up and serving requests. It checks nothing
+// else, so a busy or misconfigured instance is taken out of rotation by /readyz instead of being
+// restarted.
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(ProbeResponse{Status: "ok", Uptime: time.Since(processStarted).Seconds()})
+}
+
+// readinessHandler answers /readyz: 200 when the instance can take conversions, 503 naming the
+// failed checks otherwise
+func readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	response := ProbeResponse{Status: "ok"}
+	for _, check := range []struct {
+		name string
+		run  func(context.Context) error
+	}{
+		{"nvim", checkNvimReady},
+		{"docgen", checkDocgenFiles},
+		{"queue", checkQueueCapacity},
+		{"disk", checkDiskSpace},
+	} {
+		result := ProbeCheck{Name: check.name, Status: "ok"}
+		if err := check.run(ctx); err != nil {
+			result.Status = "fail"
+			result.Error = err.Error()
+			response.Status = "unavailable"
+		}
+		response.Checks = append(response.Checks, result)
+	}
+	if conversionSlots != nil {
+		queue := conversionSlots.Status()
+		response.Queue = &queue
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Status != "ok" {
+		logger.WithField("checks", response.Checks).Warn("Readiness check failed")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// checkNvimReady runs nvim --version, unless the deployment converts without Neovim
+func checkNvimReady(ctx context.Context) error {
+	if backend := strings.ToLower(getEnv("CONVERTER_BACKEND", converterAuto)); backend == "native" || backend == "pandoc" {
+		return nil
+	}
+	if err := exec.CommandContext(ctx, "nvim", "--version").Run(); err != nil {
+		return fmt.Errorf("nvim not available: %v", err)
+	}
+	return nil
+}
+
+// checkDocgenFiles checks that the docgen scripts copied into every workspace are in place
+func checkDocgenFiles(ctx context.Context) error {
+	for _, file := range docgenFiles {
+		if _, err := os.Stat(filepath.Join("./docgen", file)); err != nil {
+			return fmt.Errorf("docgen file missing: %s", file)
+		}
+	}
+	return nil
+}
+
+// checkQueueCapacity fails while the conversion queue refuses new work
+func checkQueueCapacity(ctx context.Context) error {
+	if conversionSlots == nil {
+		return nil
+	}
+	if conversionSlots.Full() {
+		status := conversionSlots.Status()
+		return fmt.Errorf("conversion queue is full (%d running, %d waiting)", status.Running, status.Depth)
+	}
+	return nil
+}
+
+// checkDiskSpace fails when the temporary directory workspaces are extracted into, or the
+// directory uploads are spooled to, has less than READY_MIN_FREE_BYTES available
+func checkDiskSpace(ctx context.Context) error {
+	dirs := []string{os.TempDir()}
+	if spool := getEnv("UPLOAD_SPOOL_DIR", ""); spool != "" && filepath.Clean(spool) != dirs[0] {
+		dirs = append(dirs, spool)
+	}
+	minimum := uint64(getEnvInt64("READY_MIN_FREE_BYTES", 512<<20))
+	for _, dir := range dirs {
+		free, measured, err := freeDiskBytes(dir)
+		if err != nil {
+			return fmt.Errorf("failed to measure free space in %s: %v", dir, err)
+		}
+		if measured && free < minimum {
+			return fmt.Errorf("%d bytes free in %s, below %d", free, dir, minimum)
+		}
+	}
+	return nil
+}
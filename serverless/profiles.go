@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// conversionProfiles maps profile names to the option presets loaded from CONVERSION_PROFILES_FILE
+var conversionProfiles = map[string]ConversionOptions{}
+
+// loadConversionProfiles reads the named option presets admins define in CONVERSION_PROFILES_FILE,
+// a JSON object such as {"github-wiki": {"locale": "en", "converter": "native"}}
+func loadConversionProfiles() error {
+	path := getEnv("CONVERSION_PROFILES_FILE", "")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read conversion profiles: %v", err)
+	}
+	var profiles map[string]ConversionOptions
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("invalid conversion profiles file %s: %v", path, err)
+	}
+
+	for name, profile := range profiles {
+		validated, err := validateProfile(profile)
+		if err != nil {
+			return fmt.Errorf("invalid conversion profile %q: %v", name, err)
+		}
+		validated.Profile = name
+		profiles[name] = validated
+	}
+
+	conversionProfiles = profiles
+	logger.WithField("profiles", profileNames()).Info("Loaded conversion profiles")
+	return nil
+}
+
+// validateProfile normalizes a profile's options with the same rules as the query parameters
+func validateProfile(profile ConversionOptions) (ConversionOptions, error) {
+	root, err := cleanArchivePath(profile.Root)
+	if err != nil {
+		return profile, fmt.Errorf("invalid root %q: %v", profile.Root, err)
+	}
+	profile.Root = root
+
+	profile.Include, err = parseGlobList("include", strings.Join(profile.Include, ","))
+	if err != nil {
+		return profile, err
+	}
+	profile.Exclude, err = parseGlobList("exclude", strings.Join(profile.Exclude, ","))
+	if err != nil {
+		return profile, err
+	}
+
+	profile.NeorgVersion, err = resolveNeorgVersion(profile.NeorgVersion)
+	if err != nil {
+		return profile, err
+	}
+
+	profile.Locale, err = resolveLocale(profile.Locale)
+	if err != nil {
+		return profile, err
+	}
+
+	profile.Layout = strings.ToLower(profile.Layout)
+	if profile.Layout != "" && !validLayout(profile.Layout) {
+		return profile, fmt.Errorf("unknown layout %q", profile.Layout)
+	}
+	profile.Output = strings.ToLower(profile.Output)
+	if profile.Output != "" {
+		if _, err := formats.Lookup(profile.Output); err != nil {
+			return profile, err
+		}
+	}
+
+	profile.Compression = strings.ToLower(profile.Compression)
+	if _, err := compressionLevel(profile.Compression); err != nil {
+		return profile, err
+	}
+
+	for _, name := range slices.Concat(profile.AllowModules, profile.DenyModules) {
+		if !neorgModulePattern.MatchString(name) {
+			return profile, fmt.Errorf("invalid Neorg module name %q", name)
+		}
+	}
+
+	profile.Converter = strings.ToLower(profile.Converter)
+	if profile.Converter != "" && !validConverter(profile.Converter) {
+		return profile, fmt.Errorf("unknown converter %q", profile.Converter)
+	}
+	return profile, nil
+}
+
+// profileNames lists the configured profile names in sorted order
+func profileNames() []string {
+	names := make([]string, 0, len(conversionProfiles))
+	for name := range conversionProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// progressPollInterval is how often the wiki directory is scanned for newly converted files
+const progressPollInterval = 500 * time.Millisecond
+
+// Progress estimates how far a conversion has come from the number of .norg files found during
+// extraction and the number of markdown files docgen has written so far. All methods are safe
+// to call on a nil *Progress.
+type Progress struct {
+	mu        sync.Mutex
+	requestId string
+	total     int
+	done      int // files converted by workspaces that already finished
+	current   int // files converted so far by the running workspace
+	finished  bool
+	logged    int // last percentage milestone written to the log
+	warnings  []string
+	stage     string
+	// stageStarted is when the current stage began; timings holds the stages already left
+	stageStarted time.Time
+	timings      map[string]time.Duration
+}
+
+// Conversion stages reported by Progress.Stage
+const (
+	stageExtract = "extract"
+	stageDocgen  = "docgen"
+	stageZip     = "zip"
+)
+
+func newProgress(requestId string) *Progress {
+	return &Progress{requestId: requestId}
+}
+
+// AddTotal records more .norg files that will need converting
+func (p *Progress) AddTotal(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.total += n
+	p.mu.Unlock()
+}
+
+// SetStage records the pipeline stage the conversion has reached and how long the previous one took
+func (p *Progress) SetStage(stage string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.endStageLocked()
+	p.stage = stage
+	p.stageStarted = time.Now()
+	p.mu.Unlock()
+}
+
+// endStageLocked adds the time spent in the current stage to its timing and logs it
+func (p *Progress) endStageLocked() {
+	if p.stage == "" || p.stageStarted.IsZero() {
+		return
+	}
+	if p.timings == nil {
+		p.timings = map[string]time.Duration{}
+	}
+	elapsed := time.Since(p.stageStarted)
+	p.timings[p.stage] += elapsed
+	p.stageStarted = time.Time{}
+	logger.WithFields(logrus.Fields{
+		"request_id":  p.requestId,
+		"stage":       p.stage,
+		"duration_ms": elapsed.Milliseconds(),
+	}).Info("Conversion stage finished")
+}
+
+// Timings returns the milliseconds spent in each stage so far, including the running one
+func (p *Progress) Timings() map[string]int64 {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.timings) == 0 && p.stageStarted.IsZero() {
+		return nil
+	}
+	timings := map[string]int64{}
+	for stage, elapsed := range p.timings {
+		timings[stage] = elapsed.Milliseconds()
+	}
+	if !p.stageStarted.IsZero() {
+		timings[p.stage] += time.Since(p.stageStarted).Milliseconds()
+	}
+	return timings
+}
+
+// serverTimingHeader formats stage timings as a Server-Timing header value, pipeline stages first,
+// e.g. "extract;dur=12, docgen;dur=3400, zip;dur=80"
+func serverTimingHeader(timings map[string]int64) string {
+	stages := make([]string, 0, len(timings))
+	for stage := range timings {
+		stages = append(stages, stage)
+	}
+	rank := func(stage string) string {
+		switch stage {
+		case stageExtract:
+			return "0"
+		case stageDocgen:
+			return "1"
+		case stageZip:
+			return "2"
+		}
+		return "3" + stage
+	}
+	sort.Slice(stages, func(i, j int) bool { return rank(stages[i]) < rank(stages[j]) })
+
+	metrics := make([]string, len(stages))
+	for i, stage := range stages {
+		metrics[i] = fmt.Sprintf("%s;dur=%d", stage, timings[stage])
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// Stage returns the pipeline stage the conversion is in
+func (p *Progress) Stage() string {
+	if p == nil {
+		return ""
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stage
+}
+
+// Warn records a non-fatal problem encountered during the conversion
+func (p *Progress) Warn(message string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.warnings = append(p.warnings, message)
+	p.mu.Unlock()
+}
+
+// Warnings returns the non-fatal problems recorded so far
+func (p *Progress) Warnings() []string {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.warnings...)
+}
+
+// Percent returns the estimated completion percentage. It stays below 100 until Finish is called,
+// since packaging still has to happen after the last file is converted.
+func (p *Progress) Percent() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.percentLocked()
+}
+
+func (p *Progress) percentLocked() int {
+	if p.finished {
+		return 100
+	}
+	if p.total == 0 {
+		return 0
+	}
+	percent := (p.done + p.current) * 100 / p.total
+	if percent > 99 {
+		percent = 99
+	}
+	return percent
+}
+
+// Counts returns the number of converted and total .norg files
+func (p *Progress) Counts() (completed int, total int) {
+	if p == nil {
+		return 0, 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done + p.current, p.total
+}
+
+// Finish marks the conversion as complete
+func (p *Progress) Finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.endStageLocked()
+	p.finished = true
+	p.mu.Unlock()
+}
+
+// watchOutput polls wikiDir for converted files until the returned stop function is called.
+// Stopping folds the final count into the completed total so the next workspace starts from it.
+func (p *Progress) watchOutput(wikiDir string) (stop func()) {
+	if p == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(progressPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				p.setCurrent(countMarkdownFiles(wikiDir))
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+		count := countMarkdownFiles(wikiDir)
+		p.mu.Lock()
+		p.done += count
+		p.current = 0
+		p.mu.Unlock()
+	}
+}
+
+func (p *Progress) setCurrent(count int) {
+	p.mu.Lock()
+	p.current = count
+	percent := p.percentLocked()
+	milestone := percent / 10 * 10
+	shouldLog := milestone > p.logged
+	if shouldLog {
+		p.logged = milestone
+	}
+	completed, total := p.done+p.current, p.total
+	p.mu.Unlock()
+
+	if shouldLog {
+		logger.WithFields(logrus.Fields{
+			"request_id":      p.requestId,
+			"percent":         percent,
+			"files_completed": completed,
+			"files_total":     total,
+		}).Info("Conversion progress")
+	}
+}
+
+// countMarkdownFiles counts the .md files written so far under dir
+func countMarkdownFiles(dir string) int {
+	count := 0
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(info.Name(), ".md") {
+			count++
+		}
+		return nil
+	})
+	return count
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// provenanceFileName is the unsigned provenance statement written into every result archive
+	provenanceFileName = "provenance.json"
+	// provenanceEnvelopeFileName holds the DSSE-signed statement when PROVENANCE_SIGNING_KEY is set
+	provenanceEnvelopeFileName = "provenance.dsse.json"
+
+	provenanceBuildType   = "https://github.com/adamkali/Neorg.Documentation.Lambda/convert/v1"
+	provenancePayloadType = "application/vnd.in-toto+json"
+)
+
+type (
+	// ProvenanceStatement is an in-toto statement carrying a SLSA v1 provenance predicate
+	ProvenanceStatement struct {
+		Type          string              `json:"_type"`
+		Subject       []ProvenanceSubject `json:"subject"`
+		PredicateType string              `json:"predicateType"`
+		Predicate     ProvenancePredicate `json:"predicate"`
+	}
+
+	// ProvenanceSubject is a generated file and its digest
+	ProvenanceSubject struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	}
+
+	// ProvenancePredicate describes the conversion inputs, options and builder
+	ProvenancePredicate struct {
+		BuildDefinition struct {
+			BuildType            string              `json:"buildType"`
+			ExternalParameters   ConversionOptions   `json:"externalParameters"`
+			ResolvedDependencies []ProvenanceSubject `json:"resolvedDependencies"`
+		} `json:"buildDefinition"`
+		RunDetails struct {
+			Builder struct {
+				Id      string            `json:"id"`
+				Version map[string]string `json:"version"`
+			} `json:"builder"`
+			Metadata struct {
+				InvocationId string `json:"invocationId,omitempty"`
+				StartedOn    string `json:"startedOn,omitempty"`
+				FinishedOn   string `json:"finishedOn,omitempty"`
+			} `json:"metadata"`
+		} `json:"runDetails"`
+	}
+
+	// dsseEnvelope is a Dead Simple Signing Envelope around the provenance statement
+	dsseEnvelope struct {
+		PayloadType string          `json:"payloadType"`
+		Payload     string          `json:"payload"`
+		Signatures  []dsseSignature `json:"signatures"`
+	}
+
+	// dsseSignature is one signature over the envelope's pre-authentication encoding
+	dsseSignature struct {
+		KeyId string `json:"keyid"`
+		Sig   string `json:"sig"`
+	}
+)
+
+var (
+	toolVersionsOnce sync.Once
+	toolVersions     map[string]string
+)
+
+// builderVersions reports the versions of the service and the tools it runs, detected once per process
+func builderVersions() map[string]string {
+	toolVersionsOnce.Do(func() {
+		toolVersions = map[string]string{
+			"service":          serviceVersion(),
+			"converter":        converterRevision(),
+			"go":               runtime.Version(),
+			"neorg":            neorgVersion(),
+			"tree-sitter-norg": treeSitterNorgRevision(),
+		}
+		for _, tool := range []string{"nvim", "pandoc"} {
+			if version := commandVersion(tool); version != "" {
+				toolVersions[tool] = version
+			}
+		}
+	})
+	return toolVersions
+}
+
+// serviceVersion returns the service's version and the commit it was built from
+func serviceVersion() string {
+	build := buildInfo()
+	if build.Commit == "" {
+		return build.Version
+	}
+	return build.Version + "+" + build.Commit
+}
+
+// commandVersion returns the first line of "<name> --version", or "" when the tool is unavailable
+func commandVersion(name string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, name, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(string(output), "\n")
+	return strings.TrimSpace(line)
+}
+
+// neorgVersion reads the pinned Neorg commit from lazy.nvim's lockfile
+func neorgVersion() string {
+	configHome := getEnv("XDG_CONFIG_HOME", filepath.Join(os.Getenv("HOME"), ".config"))
+	data, err := os.ReadFile(filepath.Join(configHome, "nvim", "lazy-lock.json"))
+	if err != nil {
+		return "unknown"
+	}
+	var lock map[string]struct {
+		Branch string `json:"branch"`
+		Commit string `json:"commit"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil || lock["neorg"].Commit == "" {
+		return "unknown"
+	}
+	return lock["neorg"].Commit
+}
+
+// sha256Digest returns a digest map in the in-toto format
+func sha256Digest(data []byte) map[string]string {
+	sum := sha256.Sum256(data)
+	return map[string]string{"sha256": hex.EncodeToString(sum[:])}
+}
+
+// writeProvenance records how the output directory was produced, listing every generated file
+// with its digest, and signs the statement when a signing key is configured
+func writeProvenance(outputDir string, input *spooledArchive, requestId string, options ConversionOptions, startedOn time.Time) error {
+	statement := ProvenanceStatement{
+		Type:          "https://in-toto.io/Statement/v1",
+		PredicateType: "https://slsa.dev/provenance/v1",
+	}
+
+	err := filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(outputDir, path)
+		statement.Subject = append(statement.Subject, ProvenanceSubject{Name: filepath.ToSlash(name), Digest: sha256Digest(data)})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hash output files: %v", err)
+	}
+	sort.Slice(statement.Subject, func(i, j int) bool { return statement.Subject[i].Name < statement.Subject[j].Name })
+
+	predicate := &statement.Predicate
+	predicate.BuildDefinition.BuildType = provenanceBuildType
+	predicate.BuildDefinition.ExternalParameters = options
+	predicate.BuildDefinition.ResolvedDependencies = []ProvenanceSubject{{Name: "input.tar", Digest: map[string]string{"sha256": input.Digest()}}}
+	predicate.RunDetails.Builder.Id = "https://github.com/adamkali/Neorg.Documentation.Lambda"
+	predicate.RunDetails.Builder.Version = builderVersions()
+	// Reproducible results leave out the details that differ between runs
+	if !options.Reproducible {
+		predicate.RunDetails.Metadata.InvocationId = requestId
+		predicate.RunDetails.Metadata.StartedOn = startedOn.UTC().Format(time.RFC3339)
+		predicate.RunDetails.Metadata.FinishedOn = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	payload, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(outputDir, provenanceFileName), payload, 0644)
+	if err != nil {
+		return err
+	}
+
+	key, err := provenanceSigningKey()
+	if err != nil || key == nil {
+		return err
+	}
+
+	publicKey := key.Public().(ed25519.PublicKey)
+	keyId := sha256.Sum256(publicKey)
+	envelope := dsseEnvelope{
+		PayloadType: provenancePayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []dsseSignature{{
+			KeyId: hex.EncodeToString(keyId[:]),
+			Sig:   base64.StdEncoding.EncodeToString(ed25519.Sign(key, dssePreAuthEncoding(provenancePayloadType, payload))),
+		}},
+	}
+	data, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputDir, provenanceEnvelopeFileName), data, 0644)
+}
+
+// dssePreAuthEncoding builds the DSSE v1 message that is actually signed
+func dssePreAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// provenanceSigningKey loads the ed25519 key from PROVENANCE_SIGNING_KEY, either a PKCS#8 PEM block
+// or a base64 encoded 32-byte seed. It returns nil when signing is not configured.
+func provenanceSigningKey() (ed25519.PrivateKey, error) {
+	value := strings.TrimSpace(getSecret("PROVENANCE_SIGNING_KEY"))
+	if value == "" {
+		return nil, nil
+	}
+
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROVENANCE_SIGNING_KEY: %v", err)
+		}
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("PROVENANCE_SIGNING_KEY must be an ed25519 key")
+		}
+		return key, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("PROVENANCE_SIGNING_KEY must be a PEM key or a base64 encoded %d-byte seed", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxPushEventBytes bounds push webhook payloads; GitHub caps its payloads at 25 MB
+	maxPushEventBytes = 25 << 20
+	// defaultGitHubAPIURL is the GitHub REST API; GitHub Enterprise Server uses https://<host>/api/v3
+	defaultGitHubAPIURL = "https://api.github.com"
+	// defaultGitLabAPIURL is the GitLab REST API; self-managed instances use https://<host>/api/v4
+	defaultGitLabAPIURL = "https://gitlab.com/api/v4"
+)
+
+// WebhookEvent answers push webhooks that do not start a conversion
+type WebhookEvent struct {
+	Id     string `json:"id"`
+	Event  string `json:"event"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// GitHubPushEvent is the part of a GitHub push event payload needed to fetch the pushed commit
+type GitHubPushEvent struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// GitLabPushEvent is the part of a GitLab push or tag push event payload needed to fetch the commit
+type GitLabPushEvent struct {
+	Ref string `json:"ref"`
+	// CheckoutSHA is the pushed commit; it is null when the ref was deleted
+	CheckoutSHA string `json:"checkout_sha"`
+	Project     struct {
+		Id                int    `json:"id"`
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+}
+
+// verifyGitHubSignature checks an X-Hub-Signature-256 header ("sha256=<hex HMAC of the body>")
+func verifyGitHubSignature(secret string, body []byte, header string) bool {
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// pushBranchAllowed reports whether a pushed ref should be built: any branch or tag unless
+// the comma separated allowed list (branch names such as "main") is set
+func pushBranchAllowed(ref string, allowed string) bool {
+	if allowed == "" {
+		return true
+	}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	for _, name := range strings.Split(allowed, ",") {
+		if strings.TrimSpace(name) == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// ignorePushEvent answers a webhook delivery that does not trigger a conversion
+func ignorePushEvent(w http.ResponseWriter, requestId string, event string, status string, reason string) {
+	logger.WithFields(logrus.Fields{
+		"request_id": requestId,
+		"event":      event,
+		"reason":     reason,
+	}).Info("Ignoring webhook event")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WebhookEvent{Id: requestId, Event: event, Status: status, Reason: reason})
+}
+
+// githubWebhookHandler serves POST /webhooks/github. Push events signed with GITHUB_WEBHOOK_SECRET
+// fetch the pushed commit's tarball from the GitHub API and convert it as a background job; query
+// parameters of the webhook URL set the conversion options and callback_url publishes the result.
+func githubWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	fail := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{
+			Error: message,
+			Code:  statusErrorCode(status),
+			Id:    requestId,
+		})
+	}
+
+	if r.Method != http.MethodPost {
+		fail(http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	secret := getSecret("GITHUB_WEBHOOK_SECRET")
+	if secret == "" {
+		fail(http.StatusNotFound, "GitHub webhook is not configured")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPushEventBytes))
+	if err != nil {
+		fail(http.StatusBadRequest, "Failed to read webhook payload")
+		return
+	}
+	// The signature stands in for the auth token, which GitHub cannot send
+	if !verifyGitHubSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		logger.WithFields(logrus.Fields{
+			"request_id":  requestId,
+			"remote_addr": r.RemoteAddr,
+			"delivery":    r.Header.Get("X-GitHub-Delivery"),
+		}).Warn("Rejected GitHub webhook with an invalid signature")
+		fail(http.StatusUnauthorized, "Invalid webhook signature")
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	switch event {
+	case "ping":
+		ignorePushEvent(w, requestId, event, "pong", "")
+		return
+	case "push":
+	default:
+		ignorePushEvent(w, requestId, event, "ignored", "only push events are converted")
+		return
+	}
+
+	var push GitHubPushEvent
+	if err := json.Unmarshal(body, &push); err != nil || push.Repository.FullName == "" || push.After == "" {
+		fail(http.StatusBadRequest, "Invalid push event payload")
+		return
+	}
+	if push.Deleted || strings.Trim(push.After, "0") == "" {
+		ignorePushEvent(w, requestId, event, "ignored", "ref was deleted")
+		return
+	}
+	if !pushBranchAllowed(push.Ref, getEnv("GITHUB_WEBHOOK_BRANCHES", "")) {
+		ignorePushEvent(w, requestId, event, "ignored", fmt.Sprintf("%s is not a configured branch", push.Ref))
+		return
+	}
+
+	apiURL := strings.TrimSuffix(getEnv("GITHUB_API_URL", defaultGitHubAPIURL), "/")
+	headers := map[string]string{
+		"Accept":               "application/vnd.github+json",
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+	if token := getSecret("GITHUB_TOKEN"); token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+	tarballURL := fmt.Sprintf("%s/repos/%s/tarball/%s", apiURL, push.Repository.FullName, url.PathEscape(push.After))
+	convertPush(w, r, requestId, "GitHub", push.Repository.FullName, push.Ref, push.After, tarballURL, headers)
+}
+
+// convertPush downloads the archive of a pushed commit from a forge and converts it as a
+// background job, with the conversion options of the webhook URL
+func convertPush(w http.ResponseWriter, r *http.Request, requestId string, forge string, repository string, ref string, commit string, archiveURL string, headers map[string]string) {
+	request, ok := parseConversionRequest(w, r, requestId)
+	if !ok {
+		return
+	}
+	request.Async = true
+
+	logger.WithFields(logrus.Fields{
+		"request_id": requestId,
+		"forge":      forge,
+		"repository": repository,
+		"ref":        ref,
+		"commit":     commit,
+	}).Info("Fetching pushed commit")
+
+	archive, err := fetchArchive(r.Context(), fetchClient, archiveURL, headers)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"forge":      forge,
+			"repository": repository,
+			"error":      err.Error(),
+		}).Error("Failed to download repository archive")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to download repository archive from %s: %v", forge, err),
+			Code:  codeUpstreamFailed,
+			Id:    requestId,
+		})
+		return
+	}
+
+	serveConversion(w, r, requestId, archive, request)
+}
+
+// gitlabWebhookHandler serves POST /webhooks/gitlab, the GitLab counterpart of githubWebhookHandler.
+// Push and tag push events carrying GITLAB_WEBHOOK_SECRET in X-Gitlab-Token fetch the pushed
+// commit's archive from the GitLab API and convert it as a background job.
+func gitlabWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	fail := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{
+			Error: message,
+			Code:  statusErrorCode(status),
+			Id:    requestId,
+		})
+	}
+
+	if r.Method != http.MethodPost {
+		fail(http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	secret := getSecret("GITLAB_WEBHOOK_SECRET")
+	if secret == "" {
+		fail(http.StatusNotFound, "GitLab webhook is not configured")
+		return
+	}
+	// GitLab sends the configured secret token as is rather than signing the payload
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+		logger.WithFields(logrus.Fields{
+			"request_id":  requestId,
+			"remote_addr": r.RemoteAddr,
+		}).Warn("Rejected GitLab webhook with an invalid token")
+		fail(http.StatusUnauthorized, "Invalid webhook token")
+		return
+	}
+
+	event := r.Header.Get("X-Gitlab-Event")
+	if event != "Push Hook" && event != "Tag Push Hook" {
+		ignorePushEvent(w, requestId, event, "ignored", "only push events are converted")
+		return
+	}
+
+	var push GitLabPushEvent
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushEventBytes)).Decode(&push); err != nil || push.Project.Id == 0 {
+		fail(http.StatusBadRequest, "Invalid push event payload")
+		return
+	}
+	if push.CheckoutSHA == "" {
+		ignorePushEvent(w, requestId, event, "ignored", "ref was deleted")
+		return
+	}
+	if !pushBranchAllowed(push.Ref, getEnv("GITLAB_WEBHOOK_BRANCHES", "")) {
+		ignorePushEvent(w, requestId, event, "ignored", fmt.Sprintf("%s is not a configured branch", push.Ref))
+		return
+	}
+
+	apiURL := strings.TrimSuffix(getEnv("GITLAB_API_URL", defaultGitLabAPIURL), "/")
+	headers := map[string]string{}
+	if token := getSecret("GITLAB_TOKEN"); token != "" {
+		headers["PRIVATE-TOKEN"] = token
+	}
+	archiveURL := fmt.Sprintf("%s/projects/%d/repository/archive.tar.gz?sha=%s", apiURL, push.Project.Id, url.QueryEscape(push.CheckoutSHA))
+	convertPush(w, r, requestId, "GitLab", push.Project.PathWithNamespace, push.Ref, push.CheckoutSHA, archiveURL, headers)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterIdleTTL is how long an idle client's bucket is kept before being evicted
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// ipRateLimiter hands out one token bucket per client IP
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	clients  map[string]*clientLimiter
+	proxies  []netip.Prefix
+	lastScan time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newIPRateLimiterFromEnv builds the limiter from RATE_LIMIT_RPS, RATE_LIMIT_BURST and TRUSTED_PROXIES.
+// It returns nil when RATE_LIMIT_RPS is unset or zero, which disables rate limiting.
+func newIPRateLimiterFromEnv() *ipRateLimiter {
+	rps := getEnvFloat("RATE_LIMIT_RPS", 0)
+	if rps <= 0 {
+		return nil
+	}
+	burst := int(getEnvInt64("RATE_LIMIT_BURST", 5))
+	if burst < 1 {
+		burst = 1
+	}
+
+	proxies, err := parseTrustedProxies(getEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid TRUSTED_PROXIES configuration")
+	}
+
+	logger.WithFields(logrus.Fields{
+		"requests_per_second": rps,
+		"burst":               burst,
+		"trusted_proxies":     len(proxies),
+	}).Info("IP rate limiting enabled")
+
+	return &ipRateLimiter{
+		limit:   rate.Limit(rps),
+		burst:   burst,
+		clients: make(map[string]*clientLimiter),
+		proxies: proxies,
+	}
+}
+
+// parseTrustedProxies parses a comma separated list of IP addresses and CIDR ranges
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy range %q: %v", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy address %q: %v", entry, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (l *ipRateLimiter) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range l.proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP derives the originating client address. X-Forwarded-For is only honoured when the
+// direct peer is a trusted proxy, and is read right to left skipping further trusted hops, so
+// clients cannot spoof their address by sending the header themselves.
+func (l *ipRateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !l.isTrustedProxy(peer) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	client := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break
+		}
+		client = addr.Unmap().String()
+		if !l.isTrustedProxy(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// reserve takes a token for the client at now, returning how long it must wait when none is available
+func (l *ipRateLimiter) reserve(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	if now.Sub(l.lastScan) > rateLimiterIdleTTL {
+		for key, client := range l.clients {
+			if now.Sub(client.lastSeen) > rateLimiterIdleTTL {
+				delete(l.clients, key)
+			}
+		}
+		l.lastScan = now
+	}
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+	l.mu.Unlock()
+
+	reservation := client.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return true, 0
+	}
+	reservation.CancelAt(now)
+	return false, delay
+}
+
+// RateLimitMiddleware rejects clients exceeding their request budget with 429 before any
+// authentication or body processing happens. A nil limiter lets every request through.
+func RateLimitMiddleware(limiter *ipRateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := limiter.clientIP(r)
+		allowed, delay := limiter.reserve(ip, time.Now())
+		if allowed {
+			next(w, r)
+			return
+		}
+
+		logger.WithFields(logrus.Fields{
+			"request_id": w.Header().Get("request-id"),
+			"client_ip":  ip,
+			"path":       r.URL.Path,
+		}).Warn("Rate limit exceeded")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(delay.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Too many requests",
+			Code:  codeRateLimited,
+			Id:    w.Header().Get("request-id"),
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies string
+		remoteAddr     string
+		forwardedFor   []string
+		want           string
+	}{
+		{
+			name:         "spoofed header without trusted proxies",
+			remoteAddr:   "203.0.113.7:4711",
+			forwardedFor: []string{"198.51.100.1"},
+			want:         "203.0.113.7",
+		},
+		{
+			name:           "spoofed header from an untrusted peer",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "203.0.113.7:4711",
+			forwardedFor:   []string{"198.51.100.1"},
+			want:           "203.0.113.7",
+		},
+		{
+			name:           "client behind a trusted proxy",
+			trustedProxies: "10.0.0.1",
+			remoteAddr:     "10.0.0.1:4711",
+			forwardedFor:   []string{"198.51.100.1"},
+			want:           "198.51.100.1",
+		},
+		{
+			name:           "spoofed hop left of the client",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "10.0.0.1:4711",
+			forwardedFor:   []string{"192.0.2.99, 198.51.100.1"},
+			want:           "198.51.100.1",
+		},
+		{
+			name:           "trusted hops skipped right to left",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "10.0.0.1:4711",
+			forwardedFor:   []string{"192.0.2.99, 198.51.100.1, 10.0.0.3", "10.0.0.2"},
+			want:           "198.51.100.1",
+		},
+		{
+			name:           "every hop trusted",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "10.0.0.1:4711",
+			forwardedFor:   []string{"10.0.0.3, 10.0.0.2"},
+			want:           "10.0.0.3",
+		},
+		{
+			name:           "invalid hop stops the walk",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "10.0.0.1:4711",
+			forwardedFor:   []string{"198.51.100.1, garbage, 10.0.0.2"},
+			want:           "10.0.0.2",
+		},
+		{
+			name:           "no header from a trusted proxy",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "10.0.0.1:4711",
+			want:           "10.0.0.1",
+		},
+		{
+			name:           "ipv4-mapped addresses",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "[::ffff:10.0.0.1]:4711",
+			forwardedFor:   []string{"::ffff:198.51.100.1"},
+			want:           "198.51.100.1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxies, err := parseTrustedProxies(test.trustedProxies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			limiter := &ipRateLimiter{proxies: proxies}
+			r := httptest.NewRequest(http.MethodPost, "/convert", nil)
+			r.RemoteAddr = test.remoteAddr
+			for _, header := range test.forwardedFor {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if got := limiter.clientIP(r); got != test.want {
+				t.Errorf("clientIP = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterRefillAndEviction(t *testing.T) {
+	limiter := &ipRateLimiter{limit: rate.Limit(1), burst: 2, clients: map[string]*clientLimiter{}}
+	start := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.reserve("198.51.100.1", start); !allowed {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	allowed, delay := limiter.reserve("198.51.100.1", start)
+	if allowed || delay <= 0 || delay > time.Second {
+		t.Fatalf("request beyond the burst = %v after %s, want refused for up to 1s", allowed, delay)
+	}
+	if allowed, _ := limiter.reserve("198.51.100.2", start); !allowed {
+		t.Fatal("another client was refused")
+	}
+	if allowed, _ := limiter.reserve("198.51.100.1", start.Add(time.Second)); !allowed {
+		t.Fatal("request after the bucket refilled was refused")
+	}
+
+	// Idle buckets are dropped by the next scan, which a busy client triggers
+	later := start.Add(rateLimiterIdleTTL + time.Minute)
+	limiter.reserve("198.51.100.3", later)
+	if _, ok := limiter.clients["198.51.100.1"]; ok {
+		t.Error("idle client bucket was not evicted")
+	}
+	if len(limiter.clients) != 1 {
+		t.Errorf("%d buckets left, want only the active client's", len(limiter.clients))
+	}
+	if allowed, _ := limiter.reserve("198.51.100.1", later); !allowed {
+		t.Error("evicted client did not start with a full bucket")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter := &ipRateLimiter{limit: rate.Limit(0.5), burst: 1, clients: map[string]*clientLimiter{}}
+	handler := RateLimitMiddleware(limiter, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	send := func(remoteAddr string, forwardedFor string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/convert", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		recorder := httptest.NewRecorder()
+		handler(recorder, r)
+		return recorder
+	}
+
+	if response := send("203.0.113.7:4711", "198.51.100.1"); response.Code != http.StatusOK {
+		t.Fatalf("first request answered %d, want 200", response.Code)
+	}
+	// Without trusted proxies a new X-Forwarded-For does not buy a new bucket
+	response := send("203.0.113.7:4712", "198.51.100.2")
+	if response.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request answered %d, want 429", response.Code)
+	}
+	if retryAfter := response.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Retry-After = %q, want 2", retryAfter)
+	}
+	var answer Response
+	if err := json.Unmarshal(response.Body.Bytes(), &answer); err != nil || answer.Code != codeRateLimited {
+		t.Errorf("answered %q, want code %s", response.Body, codeRateLimited)
+	}
+}
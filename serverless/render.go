@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// maxRenderBytes caps the size of a single document accepted by /render
+const maxRenderBytes = 5 << 20
+
+// renderTimeout bounds a single-document conversion
+const renderTimeout = 30 * time.Second
+
+// markdownRenderer turns converted markdown into HTML, with the GitHub extensions the converter emits
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// renderDocument converts a single .norg document to markdown through a workspace conversion backend
+func renderDocument(ctx context.Context, norgText []byte, requestId string, converter string) ([]byte, error) {
+	tempDir, err := os.MkdirTemp("", "neorg_render_"+requestId+"_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	err = os.WriteFile(filepath.Join(tempDir, "document.norg"), norgText, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write document: %v", err)
+	}
+
+	err = convertWorkspace(ctx, Workspace{Dir: tempDir}, ConversionOptions{Locale: defaultLocale, Converter: converter}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	markdown, err := os.ReadFile(filepath.Join(tempDir, "wiki", "document.md"))
+	if err != nil {
+		return nil, errNoDocumentation
+	}
+	return markdown, nil
+}
+
+// markdownToHTML renders converted markdown as an HTML fragment
+func markdownToHTML(markdown []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := markdownRenderer.Convert(markdown, &buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readNorgRequest authenticates a single-document request and reads the .norg text from its body.
+// On failure the error response has already been written and ok is false.
+func readNorgRequest(w http.ResponseWriter, r *http.Request, requestId string) (norgText []byte, ok bool) {
+	if !isAuthorized(r) {
+		Unauthorized(w, r)
+		return nil, false
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Method not allowed",
+			Code:  codeMethodNotAllowed,
+			Id:    requestId,
+		})
+		return nil, false
+	}
+
+	norgText, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRenderBytes))
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Warn("Failed to read document for rendering")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Failed to read document",
+			Code:  codeInvalidRequest,
+			Id:    requestId,
+		})
+		return nil, false
+	}
+
+	return norgText, true
+}
+
+// readRenderRequest reads a single-document request and converts its body to markdown.
+// The native parser is used unless the converter query parameter asks for another backend.
+// On failure the error response has already been written and ok is false.
+func readRenderRequest(w http.ResponseWriter, r *http.Request, requestId string) (markdown []byte, ok bool) {
+	norgText, ok := readNorgRequest(w, r, requestId)
+	if !ok {
+		return nil, false
+	}
+
+	converter := strings.ToLower(r.URL.Query().Get("converter"))
+	if converter == "" || converter == "native" {
+		return []byte(norgToMarkdown(string(norgText), flavorGFM)), true
+	}
+	if !validConverter(converter) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Unknown converter: %s", converter),
+			Code:  codeInvalidOptions,
+			Id:    requestId,
+		})
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), renderTimeout)
+	defer cancel()
+
+	// External backends start a Neovim or pandoc process and share the conversion slots
+	release, err := acquireConversionSlot(ctx, priorityHigh, "")
+	if errors.Is(err, errQueueFull) {
+		rejectQueueFull(w, requestId)
+		return nil, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Timed out waiting for a free conversion slot",
+			Code:  codeUnavailable,
+			Id:    requestId,
+		})
+		return nil, false
+	}
+	markdown, err = renderDocument(ctx, norgText, requestId, converter)
+	release()
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"request_id": requestId,
+			"error":      err.Error(),
+		}).Error("Failed to render document")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Rendering failed: %v", err),
+			Code:  codeDocgenFailed,
+			Id:    requestId,
+		})
+		return nil, false
+	}
+
+	return markdown, true
+}
+
+// renderHandler converts a raw .norg document from the request body and returns it directly.
+// The format query parameter selects markdown (default) or html output.
+func renderHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "markdown"
+	}
+	if format != "markdown" && format != "md" && format != "html" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Unsupported format: %s", format),
+			Code:  codeInvalidOptions,
+			Id:    requestId,
+		})
+		return
+	}
+
+	markdown, ok := readRenderRequest(w, r, requestId)
+	if !ok {
+		return
+	}
+
+	if format == "html" {
+		html, err := markdownToHTML(markdown)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"request_id": requestId,
+				"error":      err.Error(),
+			}).Error("Failed to render markdown as HTML")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Failed to render HTML",
+				Code:  codeInternal,
+				Id:    requestId,
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(html)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(markdown)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// reportFileName is the conversion report written at the root of every result archive
+	reportFileName = "_report.json"
+	// docgenTimingsFileName is where converters record how long each .norg file took, inside the
+	// workspace's docgen directory
+	docgenTimingsFileName = "conversion_timings.jsonl"
+)
+
+type (
+	// ConversionReport lists what a conversion produced from every source file, so downstream
+	// tooling can audit a result without re-running it
+	ConversionReport struct {
+		Id       string            `json:"id"`
+		Versions map[string]string `json:"versions"`
+		Sources  []SourceReport    `json:"sources"`
+		Warnings []string          `json:"warnings,omitempty"`
+	}
+
+	// SourceReport is one .norg source: the converter that handled it, the files generated from
+	// it, how long it took and what went wrong. Durations are left out of reproducible results.
+	SourceReport struct {
+		File       string   `json:"file"`
+		Workspace  string   `json:"workspace"`
+		Converter  string   `json:"converter,omitempty"`
+		Outputs    []string `json:"outputs"`
+		DurationMs *int64   `json:"duration_ms,omitempty"`
+		Warnings   []string `json:"warnings,omitempty"`
+	}
+
+	// fileTiming is one line of a docgen timings file, with the file relative to the workspace
+	fileTiming struct {
+		File       string `json:"file"`
+		Converter  string `json:"converter"`
+		DurationMs int64  `json:"duration_ms"`
+	}
+)
+
+// timingsFile is where the converters of a workspace record per-file durations
+func timingsFile(workspaceDir string) string {
+	return filepath.Join(workspaceDir, "docgen", docgenTimingsFileName)
+}
+
+// writeFileTimings records the per-file durations measured by an in-process converter
+func writeFileTimings(workspaceDir string, converter string, durations map[string]time.Duration) error {
+	file := timingsFile(workspaceDir)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	handle, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+	encoder := json.NewEncoder(handle)
+	for name, duration := range durations {
+		err := encoder.Encode(fileTiming{File: name, Converter: converter, DurationMs: duration.Milliseconds()})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFileTimings reads the durations recorded for a workspace, keyed by workspace-relative file.
+// Missing or unreadable lines only cost the report its durations, so they are skipped.
+func readFileTimings(workspaceDir string) map[string]fileTiming {
+	timings := map[string]fileTiming{}
+	file, err := os.Open(timingsFile(workspaceDir))
+	if err != nil {
+		return timings
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var timing fileTiming
+		if json.Unmarshal(scanner.Bytes(), &timing) == nil && timing.File != "" {
+			timings[timing.File] = timing
+		}
+	}
+	return timings
+}
+
+// workspaceSourceReports lists the report entries for a converted workspace. pages maps each
+// source page (workspace-relative, without .norg) to its page name after the output layout.
+func workspaceSourceReports(workspace Workspace, pages map[string]string, output WorkspaceManifest, options ConversionOptions) map[string]*SourceReport {
+	reports := map[string]*SourceReport{}
+	entry := func(source string) *SourceReport {
+		file := path.Join(workspace.Name, source)
+		if reports[file] == nil {
+			reports[file] = &SourceReport{File: file, Workspace: workspace.Name, Outputs: []string{}}
+		}
+		return reports[file]
+	}
+	for page, name := range pages {
+		entry(page + ".norg").Outputs = pageOutputs(name, output, outputFormat(options.Output))
+	}
+	for source, timing := range readFileTimings(workspace.Dir) {
+		report := entry(source)
+		report.Converter = timing.Converter
+		if !options.Reproducible {
+			duration := timing.DurationMs
+			report.DurationMs = &duration
+		}
+	}
+	return reports
+}
+
+// pageOutputs finds the generated files of a page, named after the layout, among a workspace's
+// output once the output format has moved or renamed it
+func pageOutputs(page string, output WorkspaceManifest, format OutputFormat) []string {
+	expected := path.Join(output.Output, formatPagePath(format, page+".md"))
+	outputs := []string{}
+	for _, file := range output.Files {
+		if file == expected {
+			outputs = append(outputs, file)
+		}
+	}
+	return outputs
+}
+
+// buildConversionReport completes the per-workspace entries with the sources no converter
+// reached and the files that failed, sorted by source path. Pages without a source, like the
+// placeholder of archives without .norg files, are left out.
+func buildConversionReport(manifest Manifest, reports map[string]*SourceReport) ConversionReport {
+	for file := range reports {
+		if _, ok := manifest.Sources[file]; !ok {
+			delete(reports, file)
+		}
+	}
+	for source := range manifest.Sources {
+		if _, ok := reports[source]; !ok {
+			reports[source] = &SourceReport{File: source, Workspace: sourceWorkspace(manifest, source), Outputs: []string{}}
+		}
+	}
+	for _, failure := range manifest.FileErrors {
+		if report, ok := reports[failure.File]; ok {
+			report.Warnings = append(report.Warnings, failure.String())
+		}
+	}
+
+	report := ConversionReport{
+		Id:       manifest.Id,
+		Versions: builderVersions(),
+		Sources:  make([]SourceReport, 0, len(reports)),
+		Warnings: manifest.Warnings,
+	}
+	for _, source := range reports {
+		report.Sources = append(report.Sources, *source)
+	}
+	sort.Slice(report.Sources, func(i, j int) bool { return report.Sources[i].File < report.Sources[j].File })
+	return report
+}
+
+// sourceWorkspace is the name of the workspace containing source, or "" when none does
+func sourceWorkspace(manifest Manifest, source string) string {
+	name := ""
+	for _, workspace := range manifest.Workspaces {
+		if (workspace.Name == "" || strings.HasPrefix(source, workspace.Name+"/")) && len(workspace.Name) > len(name) {
+			name = workspace.Name
+		}
+	}
+	return name
+}
+
+// writeConversionReport stores the report as _report.json in the output directory
+func writeConversionReport(outputDir string, report ConversionReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputDir, reportFileName), data, 0644)
+}
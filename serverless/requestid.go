@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// requestIdHeader carries a caller's ID for a request, which the service adopts for its logs and
+// echoes back in the response
+const requestIdHeader = "X-Request-ID"
+
+// requestIdPattern matches the caller-supplied request IDs accepted, such as UUIDs, ULIDs or
+// trace IDs; others are replaced with a generated ID
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
+
+type requestIdKey struct{}
+
+// requestIdValue is the ID stored in a request's context
+type requestIdValue struct {
+	id       string
+	supplied bool
+}
+
+// withRequestId resolves the ID of a request, the caller's X-Request-ID when valid or a new UUID,
+// and stores it in the request's context for handlers to read with requestID
+func withRequestId(r *http.Request) (*http.Request, string) {
+	value := requestIdValue{id: uuid.New().String()}
+	if supplied := r.Header.Get(requestIdHeader); requestIdPattern.MatchString(supplied) {
+		value = requestIdValue{id: supplied, supplied: true}
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIdKey{}, value)), value.id
+}
+
+// requestID returns the ID LoggingMiddleware assigned to a request, or a new UUID for requests
+// that did not pass through it
+func requestID(r *http.Request) string {
+	if value, ok := r.Context().Value(requestIdKey{}).(requestIdValue); ok {
+		return value.id
+	}
+	return uuid.New().String()
+}
+
+// requestIdSupplied reports whether a request's ID came from the caller, and so may be shared by
+// several requests
+func requestIdSupplied(r *http.Request) bool {
+	value, ok := r.Context().Value(requestIdKey{}).(requestIdValue)
+	return ok && value.supplied
+}
+
+// resultFileName names the file a request's result is packaged into in the working directory. A
+// random suffix keeps requests sharing a caller-supplied ID apart.
+func resultFileName(requestId string, extension string) string {
+	return fmt.Sprintf("documentation_%s_%s%s", requestId, uuid.New().String()[:8], extension)
+}
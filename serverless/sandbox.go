@@ -0,0 +1,125 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SandboxConfig bounds the subprocesses of a conversion so a single malicious or pathological
+// project cannot starve the service, and optionally runs them as an unprivileged user so the Lua
+// in an uploaded project cannot reach the service's credentials or other jobs' data. Zero values
+// leave a resource unlimited.
+type SandboxConfig struct {
+	// CPUSeconds caps the CPU time of each process (RLIMIT_CPU)
+	CPUSeconds uint64
+	// MemoryBytes caps memory: memory.max of the conversion's cgroup when CgroupParent is set,
+	// or the address space of each process (RLIMIT_AS) otherwise
+	MemoryBytes uint64
+	// OpenFiles caps the open file descriptors of each process (RLIMIT_NOFILE)
+	OpenFiles uint64
+	// Processes caps the processes: pids.max of the conversion's cgroup when CgroupParent is set,
+	// or RLIMIT_NPROC otherwise, which counts every process of the user
+	Processes uint64
+	// CgroupParent is a cgroup v2 directory delegated to the service, in which each conversion
+	// gets a child cgroup
+	CgroupParent string
+	// UID and GID are the unprivileged user and group subprocesses run as; a zero UID keeps the
+	// service's own user. UIDCount consecutive UIDs starting at UID are handed out one per
+	// running conversion, so concurrent conversions cannot reach each other's files either.
+	UID      uint32
+	GID      uint32
+	UIDCount uint32
+	// WritableDir is the only directory an unprivileged subprocess may write to. It is handed to
+	// the subprocess's user for the duration of the run, and HOME-independent state, cache and
+	// temporary directories point into it.
+	WritableDir string
+}
+
+// sandboxEnvironmentAllowed lists the variables passed to unprivileged subprocesses besides the
+// NEORG_DOCGEN_ ones; everything else, including secrets the service was configured with, is
+// dropped
+var sandboxEnvironmentAllowed = map[string]bool{
+	"PATH":            true,
+	"LANG":            true,
+	"LC_ALL":          true,
+	"TERM":            true,
+	"TZ":              true,
+	"HOME":            true,
+	"XDG_CONFIG_HOME": true,
+	"XDG_DATA_HOME":   true,
+}
+
+// sandboxUIDs tracks which of the sandbox UIDs are in use by a running conversion
+var sandboxUIDs = struct {
+	sync.Mutex
+	busy map[uint32]bool
+	next uint32
+}{busy: map[uint32]bool{}}
+
+// sandboxConfigFromEnv reads the sandbox limits from CONVERSION_CPU_SECONDS,
+// CONVERSION_MEMORY_BYTES, CONVERSION_MAX_OPEN_FILES, CONVERSION_MAX_PROCESSES and
+// CONVERSION_CGROUP_PARENT
+func sandboxConfigFromEnv() SandboxConfig {
+	limit := func(key string) uint64 {
+		return uint64(max(getEnvInt64(key, 0), 0))
+	}
+	return SandboxConfig{
+		CPUSeconds:   limit("CONVERSION_CPU_SECONDS"),
+		MemoryBytes:  limit("CONVERSION_MEMORY_BYTES"),
+		OpenFiles:    limit("CONVERSION_MAX_OPEN_FILES"),
+		Processes:    limit("CONVERSION_MAX_PROCESSES"),
+		CgroupParent: getEnv("CONVERSION_CGROUP_PARENT", ""),
+		UID:          uint32(limit("CONVERSION_UID")),
+		GID:          uint32(getEnvInt64("CONVERSION_GID", getEnvInt64("CONVERSION_UID", 0))),
+		UIDCount:     uint32(max(getEnvInt64("CONVERSION_UID_COUNT", 1), 1)),
+	}
+}
+
+// acquireUID picks a sandbox UID no running conversion uses, or the next one in turn when all are
+// busy; release returns it
+func (c SandboxConfig) acquireUID() (uid uint32, release func()) {
+	sandboxUIDs.Lock()
+	defer sandboxUIDs.Unlock()
+	uid = c.UID + sandboxUIDs.next%c.UIDCount
+	for i := uint32(0); i < c.UIDCount; i++ {
+		candidate := c.UID + (sandboxUIDs.next+i)%c.UIDCount
+		if !sandboxUIDs.busy[candidate] {
+			uid = candidate
+			break
+		}
+	}
+	sandboxUIDs.next = (uid - c.UID + 1) % c.UIDCount
+	sandboxUIDs.busy[uid] = true
+	return uid, func() {
+		sandboxUIDs.Lock()
+		delete(sandboxUIDs.busy, uid)
+		sandboxUIDs.Unlock()
+	}
+}
+
+// environment filters env down to what an unprivileged subprocess needs and points its state,
+// cache and temporary directories into WritableDir
+func (c SandboxConfig) environment(env []string) []string {
+	filtered := []string{}
+	for _, entry := range env {
+		name, _, _ := strings.Cut(entry, "=")
+		if sandboxEnvironmentAllowed[name] || strings.HasPrefix(name, "NEORG_DOCGEN_") || strings.HasPrefix(name, "LC_") {
+			filtered = append(filtered, entry)
+		}
+	}
+	for name, dir := range c.scratchDirs() {
+		filtered = append(filtered, name+"="+dir)
+	}
+	return filtered
+}
+
+// scratchDirs are the directories inside WritableDir an unprivileged subprocess uses for its
+// state, cache and temporary files, keyed by the variable that points to them
+func (c SandboxConfig) scratchDirs() map[string]string {
+	return map[string]string{
+		"TMPDIR":         filepath.Join(c.WritableDir, ".tmp"),
+		"XDG_STATE_HOME": filepath.Join(c.WritableDir, ".state"),
+		"XDG_CACHE_HOME": filepath.Join(c.WritableDir, ".cache"),
+	}
+}
@@ -0,0 +1,299 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// runSandboxed runs cmd, created with exec.CommandContext, in its own process group under the
+// sandbox limits. The whole group is killed when the context ends and once cmd exits, so nothing
+// the project spawned outlives the conversion. With a cgroup the process is started inside it;
+// rlimits are applied right after it starts and are inherited by everything it runs from then on.
+// With a sandbox UID the process runs as that user with a scrubbed environment, and WritableDir
+// belongs to it, mode 0700, until cmd exits.
+func runSandboxed(cmd *exec.Cmd, sandbox SandboxConfig) error {
+	process, err := startSandboxed(cmd, sandbox)
+	if err != nil {
+		return err
+	}
+	err = cmd.Wait()
+	process.Finish()
+	return err
+}
+
+// sandboxedProcess is a subprocess started by startSandboxed
+type sandboxedProcess struct {
+	cmd *exec.Cmd
+	// uid and gid are the sandbox user the process runs as, zero for the service's own user
+	uid uint32
+	gid uint32
+	// cleanup undoes the sandbox setup, in reverse order, once the process group is gone
+	cleanup []func()
+}
+
+// startSandboxed starts cmd like runSandboxed without waiting for it, for long-lived processes.
+// The caller waits for cmd, or kills it, and then calls Finish.
+func startSandboxed(cmd *exec.Cmd, sandbox SandboxConfig) (_ *sandboxedProcess, err error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Only commands created with exec.CommandContext may be cancelled
+	if cmd.Cancel != nil {
+		cmd.Cancel = func() error {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}
+	process := &sandboxedProcess{cmd: cmd}
+	defer func() {
+		if err != nil {
+			process.undo()
+		}
+	}()
+
+	if sandbox.UID != 0 {
+		uid, release := sandbox.acquireUID()
+		process.cleanup = append(process.cleanup, release)
+		for _, dir := range sandbox.scratchDirs() {
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return nil, fmt.Errorf("failed to create sandbox scratch directory: %v", err)
+			}
+		}
+		restore, err := handOver(sandbox.WritableDir, int(uid), int(sandbox.GID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to hand the project directory to the sandbox user: %v", err)
+		}
+		process.cleanup = append(process.cleanup, restore)
+		process.uid, process.gid = uid, sandbox.GID
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: sandbox.GID, Groups: []uint32{}}
+		cmd.Env = sandbox.environment(cmd.Env)
+		logger.WithFields(logrus.Fields{
+			"uid": uid,
+			"gid": sandbox.GID,
+			"dir": sandbox.WritableDir,
+		}).Debug("Running conversion subprocess as sandbox user")
+	}
+
+	// Output goes through files rather than pipes, so Wait does not block on processes left
+	// holding them open; those are killed with the group once cmd exits
+	for _, output := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+		restore, err := outputThroughFile(output)
+		if err != nil {
+			return nil, err
+		}
+		process.cleanup = append(process.cleanup, restore)
+	}
+
+	cgroup, err := sandbox.createCgroup()
+	if err != nil {
+		return nil, err
+	}
+	if cgroup != nil {
+		process.cleanup = append(process.cleanup, cgroup.remove)
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = cgroup.fd
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+	if err := sandbox.setRlimits(pid, cgroup != nil); err != nil {
+		syscall.Kill(-pid, syscall.SIGKILL)
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to apply resource limits: %v", err)
+	}
+	return process, nil
+}
+
+// HandOver gives dir to the process's sandbox user until restore is called, for long-lived
+// processes that work on a different directory for each conversion. Without a sandbox user it
+// does nothing.
+func (p *sandboxedProcess) HandOver(dir string) (restore func(), err error) {
+	if p.uid == 0 {
+		return func() {}, nil
+	}
+	return handOver(dir, int(p.uid), int(p.gid))
+}
+
+// Finish kills whatever is left of the process group and undoes the sandbox setup
+func (p *sandboxedProcess) Finish() {
+	if p.cmd.Process != nil {
+		syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+	}
+	p.undo()
+}
+
+func (p *sandboxedProcess) undo() {
+	for i := len(p.cleanup) - 1; i >= 0; i-- {
+		p.cleanup[i]()
+	}
+	p.cleanup = nil
+}
+
+// handOver gives dir and everything below it to uid and gid, with dir itself closed to other
+// users; restore hands it back to the service's own user
+func handOver(dir string, uid int, gid int) (restore func(), err error) {
+	if dir == "" {
+		return nil, fmt.Errorf("no writable directory configured")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	chown := func(uid int, gid int) error {
+		return filepath.Walk(dir, func(path string, _ os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, uid, gid)
+		})
+	}
+	if err := chown(uid, gid); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, err
+	}
+	// Directories created with os.MkdirTemp above dir are closed to other users, which would
+	// keep the sandbox user from reaching dir at all
+	if err := allowTraversal(filepath.Dir(dir)); err != nil {
+		return nil, err
+	}
+	return func() {
+		err := chown(os.Getuid(), os.Getgid())
+		if err == nil {
+			err = os.Chmod(dir, info.Mode().Perm())
+		}
+		if err != nil {
+			logger.WithError(err).Warn("Failed to take back the project directory from the sandbox user")
+		}
+	}, nil
+}
+
+// allowTraversal lets every user search dir and the directories above it that the service owns,
+// without letting them list their contents
+func allowTraversal(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if ok && int(stat.Uid) == os.Getuid() && info.Mode().Perm()&0011 != 0011 {
+			if err := os.Chmod(dir, info.Mode().Perm()|0011); err != nil {
+				return err
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// outputThroughFile replaces the writer at output with a temporary file, unless it is unset or
+// already a file; restore copies what was written to the original writer and removes the file
+func outputThroughFile(output *io.Writer) (restore func(), err error) {
+	if _, ok := (*output).(*os.File); ok || *output == nil {
+		return func() {}, nil
+	}
+	file, err := os.CreateTemp("", "neorg_output_*")
+	if err != nil {
+		return nil, err
+	}
+	writer := *output
+	*output = file
+	return func() {
+		file.Seek(0, io.SeekStart)
+		io.Copy(writer, file)
+		file.Close()
+		os.Remove(file.Name())
+	}, nil
+}
+
+// setRlimits applies the per-process limits to pid; memory and process counts are left to the
+// cgroup when there is one
+func (c SandboxConfig) setRlimits(pid int, cgroup bool) error {
+	type rlimit struct {
+		resource int
+		value    uint64
+	}
+	limits := []rlimit{{unix.RLIMIT_CPU, c.CPUSeconds}, {unix.RLIMIT_NOFILE, c.OpenFiles}}
+	if !cgroup {
+		limits = append(limits, rlimit{unix.RLIMIT_AS, c.MemoryBytes}, rlimit{unix.RLIMIT_NPROC, c.Processes})
+	}
+	for _, limit := range limits {
+		if limit.value == 0 {
+			continue
+		}
+		err := unix.Prlimit(pid, limit.resource, &unix.Rlimit{Cur: limit.value, Max: limit.value}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conversionCgroup is the cgroup a single conversion subprocess runs in
+type conversionCgroup struct {
+	path string
+	fd   int
+}
+
+// createCgroup creates a child cgroup of CgroupParent limited to MemoryBytes and Processes, or
+// returns nil when no parent is configured
+func (c SandboxConfig) createCgroup() (*conversionCgroup, error) {
+	if c.CgroupParent == "" {
+		return nil, nil
+	}
+	dir, err := os.MkdirTemp(c.CgroupParent, "neorg_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %v", err)
+	}
+	cgroup := &conversionCgroup{path: dir, fd: -1}
+
+	settings := map[string]uint64{"memory.max": c.MemoryBytes, "pids.max": c.Processes}
+	for name, value := range settings {
+		if value == 0 {
+			continue
+		}
+		err := os.WriteFile(filepath.Join(dir, name), []byte(strconv.FormatUint(value, 10)), 0644)
+		if err != nil {
+			cgroup.remove()
+			return nil, fmt.Errorf("failed to set %s: %v", name, err)
+		}
+	}
+
+	cgroup.fd, err = syscall.Open(dir, unix.O_PATH|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		cgroup.remove()
+		return nil, fmt.Errorf("failed to open cgroup: %v", err)
+	}
+	return cgroup, nil
+}
+
+// remove deletes the cgroup once the processes killed in it have exited
+func (c *conversionCgroup) remove() {
+	if c.fd >= 0 {
+		syscall.Close(c.fd)
+	}
+	for attempt := 0; attempt < 20; attempt++ {
+		if err := os.Remove(c.path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	logger.WithFields(logrus.Fields{
+		"cgroup": c.path,
+	}).Warn("Failed to remove conversion cgroup")
+}
@@ -0,0 +1,36 @@
+//go:build !linux
+
+package main
+
+import (
+	"os/exec"
+)
+
+// runSandboxed runs cmd without resource limits or a sandbox user, which are only supported on
+// Linux
+func runSandboxed(cmd *exec.Cmd, sandbox SandboxConfig) error {
+	return cmd.Run()
+}
+
+// sandboxedProcess is a subprocess started by startSandboxed
+type sandboxedProcess struct {
+	cmd *exec.Cmd
+}
+
+// startSandboxed starts cmd without resource limits or a sandbox user
+func startSandboxed(cmd *exec.Cmd, sandbox SandboxConfig) (*sandboxedProcess, error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &sandboxedProcess{cmd: cmd}, nil
+}
+
+// HandOver does nothing, as there is no sandbox user
+func (p *sandboxedProcess) HandOver(dir string) (restore func(), err error) {
+	return func() {}, nil
+}
+
+// Finish kills the process if it is still running
+func (p *sandboxedProcess) Finish() {
+	p.cmd.Process.Kill()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// clamdChunkSize is the size of each INSTREAM chunk sent to clamd
+const clamdChunkSize = 64 << 10
+
+// clamdTimeout bounds a single archive scan
+const clamdTimeout = 2 * time.Minute
+
+// errMalwareScanUnavailable is returned when the scanner cannot be reached or fails and the scan is fail-closed
+var errMalwareScanUnavailable = errors.New("malware scan unavailable")
+
+// malwareFoundError reports an archive rejected by the scanner
+type malwareFoundError struct {
+	Signature string
+}
+
+func (e *malwareFoundError) Error() string {
+	return fmt.Sprintf("malware detected: %s", e.Signature)
+}
+
+// rejectScanFailure answers an archive the scanner flagged with 422 and a scan that could not be
+// completed with 503, reporting whether it wrote a response
+func rejectScanFailure(w http.ResponseWriter, requestId string, err error) bool {
+	if err == nil {
+		return false
+	}
+	var infected *malwareFoundError
+	if errors.As(err, &infected) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(Response{
+			Error: "malware_detected",
+			Code:  codeMalwareDetected,
+			Id:    requestId,
+		})
+		return true
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(Response{
+		Error: "Malware scan unavailable",
+		Code:  codeUnavailable,
+		Id:    requestId,
+	})
+	return true
+}
+
+// scanArchive streams the uploaded archive to clamd when CLAMD_ADDRESS is configured
+// (unix:///path/to/clamd.sock or tcp://host:3310). Every scan outcome is written to the audit log.
+// Scanner failures reject the upload unless CLAMD_FAIL_OPEN is true.
+func scanArchive(ctx context.Context, archive *spooledArchive, requestId string) error {
+	address := getEnv("CLAMD_ADDRESS", "")
+	if address == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, clamdTimeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := clamdScan(ctx, address, archive.Reader())
+	audit := logger.WithFields(logrus.Fields{
+		"audit":        true,
+		"event":        "malware_scan",
+		"request_id":   requestId,
+		"scanner":      "clamd",
+		"archive_size": archive.Size(),
+		"duration_ms":  time.Since(start).Milliseconds(),
+	})
+
+	if err != nil {
+		audit.WithField("error", err.Error()).Error("Malware scan failed")
+		if strings.EqualFold(getEnv("CLAMD_FAIL_OPEN", "false"), "true") {
+			return nil
+		}
+		return fmt.Errorf("%w: %v", errMalwareScanUnavailable, err)
+	}
+
+	if signature, found := strings.CutSuffix(result, " FOUND"); found {
+		audit.WithFields(logrus.Fields{
+			"result":    "infected",
+			"signature": signature,
+		}).Warn("Malware scan rejected archive")
+		return &malwareFoundError{Signature: signature}
+	}
+
+	audit.WithField("result", "clean").Info("Malware scan passed")
+	return nil
+}
+
+// clamdScan streams data to clamd using the INSTREAM command and returns the scan verdict,
+// e.g. "OK" or "Eicar-Test-Signature FOUND"
+func clamdScan(ctx context.Context, address string, data io.Reader) (string, error) {
+	network, addr := "tcp", address
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		network, addr = "unix", path
+	} else if host, ok := strings.CutPrefix(address, "tcp://"); ok {
+		addr = host
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to clamd: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	writer := bufio.NewWriter(conn)
+	writer.WriteString("zINSTREAM\x00")
+	var size [4]byte
+	chunk := make([]byte, clamdChunkSize)
+	for {
+		n, err := io.ReadFull(data, chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			writer.Write(size[:])
+			writer.Write(chunk[:n])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read archive: %v", err)
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	writer.Write(size[:])
+	if err := writer.Flush(); err != nil {
+		return "", fmt.Errorf("failed to send archive to clamd: %v", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\x00')
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("failed to read clamd reply: %v", err)
+	}
+	reply = strings.TrimRight(reply, "\x00\n")
+
+	// Replies look like "stream: OK", "stream: <signature> FOUND" or "<message> ERROR"
+	if strings.HasSuffix(reply, " ERROR") {
+		return "", fmt.Errorf("clamd error: %s", reply)
+	}
+	verdict := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	if verdict != "OK" && !strings.HasSuffix(verdict, " FOUND") {
+		return "", fmt.Errorf("unexpected clamd reply: %s", reply)
+	}
+	return verdict, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// priorityHeader lets clients mark a conversion as interactive (high) or bulk (low)
+const priorityHeader = "X-Priority"
+
+// Job priorities; higher values are scheduled first
+const (
+	priorityLow    = -1
+	priorityNormal = 0
+	priorityHigh   = 1
+)
+
+var priorityNames = map[string]int{"low": priorityLow, "normal": priorityNormal, "high": priorityHigh}
+
+// priorityName returns the name of a priority level
+func priorityName(priority int) string {
+	for name, value := range priorityNames {
+		if value == priority {
+			return name
+		}
+	}
+	return "normal"
+}
+
+// parsePriority reads a priority name (high, normal, low)
+func parsePriority(value string) (int, error) {
+	priority, ok := priorityNames[strings.ToLower(strings.TrimSpace(value))]
+	if !ok {
+		return priorityNormal, fmt.Errorf("unknown priority %q (expected high, normal or low)", value)
+	}
+	return priority, nil
+}
+
+// requestPriority determines a conversion's priority from the X-Priority header, falling back to
+// the tenant's entry in JOB_PRIORITIES (e.g. "docs-site=high,nightly=low") and then to normal
+func requestPriority(headerValue string, tenant string) (int, error) {
+	if headerValue != "" {
+		return parsePriority(headerValue)
+	}
+	for _, entry := range strings.Split(getEnv("JOB_PRIORITIES", ""), ",") {
+		name, value, ok := strings.Cut(entry, "=")
+		if ok && strings.TrimSpace(name) == tenant {
+			return parsePriority(value)
+		}
+	}
+	return priorityNormal, nil
+}
+
+// conversionQueue hands out a fixed number of conversion slots. Waiters are served by priority and
+// in arrival order within a priority, so interactive work passes queued batch jobs. At most
+// maxWaiting conversions may wait (0 means no limit); beyond that new work is refused.
+type conversionQueue struct {
+	mu         sync.Mutex
+	capacity   int
+	maxWaiting int
+	running    int
+	waiting    queueWaiters
+	sequence   uint64
+}
+
+// QueueStatus describes the conversion queue for backpressure responses and the health endpoint
+type QueueStatus struct {
+	Capacity   int `json:"capacity"`
+	Running    int `json:"running"`
+	Depth      int `json:"depth"`
+	MaxDepth   int `json:"max_depth"`
+	RetryAfter int `json:"retry_after_seconds,omitempty"`
+}
+
+// queueWaiter is a conversion waiting for a slot; ready is closed once the slot is handed over
+type queueWaiter struct {
+	// id names the conversion for Position, empty when nobody asks for it
+	id       string
+	priority int
+	sequence uint64
+	ready    chan struct{}
+	index    int
+}
+
+// queueWaiters is a heap of waiters ordered by priority, then arrival
+type queueWaiters []*queueWaiter
+
+func (w queueWaiters) Len() int { return len(w) }
+func (w queueWaiters) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+	return w[i].sequence < w[j].sequence
+}
+func (w queueWaiters) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+func (w *queueWaiters) Push(x interface{}) {
+	waiter := x.(*queueWaiter)
+	waiter.index = len(*w)
+	*w = append(*w, waiter)
+}
+func (w *queueWaiters) Pop() interface{} {
+	old := *w
+	waiter := old[len(old)-1]
+	*w = old[:len(old)-1]
+	waiter.index = -1
+	return waiter
+}
+
+// conversionSlots bounds the conversions running at once, whether synchronous requests, background
+// jobs or renders through an external backend, to MAX_CONCURRENT_CONVERSIONS; main applies the size
+var conversionSlots = newConversionQueue(runtime.NumCPU(), 0)
+
+var (
+	// errNoConversionSlot is returned when a conversion gave up waiting for a free slot
+	errNoConversionSlot = errors.New("timed out waiting for a free conversion slot")
+	// errQueueFull is returned when MAX_QUEUE_DEPTH conversions are already waiting
+	errQueueFull = errors.New("conversion queue is full")
+)
+
+// acquireConversionSlot waits for a conversion slot, reporting errNoConversionSlot when ctx ends
+// first. id names the conversion while it waits, for Position; background jobs pass their ID.
+func acquireConversionSlot(ctx context.Context, priority int, id string) (func(), error) {
+	release, err := conversionSlots.Acquire(ctx, priority, id)
+	if errors.Is(err, errQueueFull) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoConversionSlot, err)
+	}
+	return release, nil
+}
+
+func newConversionQueue(capacity int, maxWaiting int) *conversionQueue {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if maxWaiting < 0 {
+		maxWaiting = 0
+	}
+	return &conversionQueue{capacity: capacity, maxWaiting: maxWaiting}
+}
+
+// Acquire blocks until a slot is free for a conversion of the given priority or ctx is done, and
+// fails with errQueueFull right away when the queue is full. The returned function gives the slot
+// back and must be called exactly once.
+func (q *conversionQueue) Acquire(ctx context.Context, priority int, id string) (func(), error) {
+	q.mu.Lock()
+	if q.running < q.capacity && q.waiting.Len() == 0 {
+		q.running++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	if q.maxWaiting > 0 && q.waiting.Len() >= q.maxWaiting {
+		q.mu.Unlock()
+		return nil, errQueueFull
+	}
+	q.sequence++
+	waiter := &queueWaiter{id: id, priority: priority, sequence: q.sequence, ready: make(chan struct{})}
+	heap.Push(&q.waiting, waiter)
+	q.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return q.release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if waiter.index >= 0 {
+			heap.Remove(&q.waiting, waiter.index)
+			return nil, ctx.Err()
+		}
+		// The slot was handed over while giving up; pass it on
+		q.releaseLocked()
+		return nil, ctx.Err()
+	}
+}
+
+// release gives a slot back, handing it straight to the highest priority waiter
+func (q *conversionQueue) release() {
+	q.mu.Lock()
+	q.releaseLocked()
+	q.mu.Unlock()
+}
+
+func (q *conversionQueue) releaseLocked() {
+	if q.waiting.Len() > 0 {
+		waiter := heap.Pop(&q.waiting).(*queueWaiter)
+		close(waiter.ready)
+		return
+	}
+	q.running--
+}
+
+// Status reports the queue's size and load. RetryAfter estimates, from recent conversion times,
+// when a full queue will accept work again.
+func (q *conversionQueue) Status() QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := QueueStatus{Capacity: q.capacity, Running: q.running, Depth: q.waiting.Len(), MaxDepth: q.maxWaiting}
+	if q.maxWaiting > 0 && status.Depth >= q.maxWaiting {
+		wait := conversionDurations.EstimateWait(status.Depth-q.maxWaiting+1, q.capacity)
+		status.RetryAfter = int(math.Ceil(wait.Seconds()))
+	}
+	return status
+}
+
+// Position returns the one-based place of the waiting conversion id in the queue, 1 being the
+// next to start, or 0 when it is not waiting
+func (q *conversionQueue) Position(id string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, waiter := range q.waiting {
+		if waiter.id != id {
+			continue
+		}
+		position := 1
+		for i := range q.waiting {
+			if q.waiting.Less(i, waiter.index) {
+				position++
+			}
+		}
+		return position
+	}
+	return 0
+}
+
+// ProjectedPosition returns the place a conversion of the given priority would take if it
+// started waiting now, or 0 when it would start right away
+func (q *conversionQueue) ProjectedPosition(priority int) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running < q.capacity && q.waiting.Len() == 0 {
+		return 0
+	}
+	position := 1
+	for _, waiter := range q.waiting {
+		if waiter.priority >= priority {
+			position++
+		}
+	}
+	return position
+}
+
+// EstimatedWaitSeconds estimates from recent conversion times how long a conversion at the given
+// queue position waits before it starts
+func (q *conversionQueue) EstimatedWaitSeconds(position int) int {
+	q.mu.Lock()
+	capacity := q.capacity
+	q.mu.Unlock()
+	wait := conversionDurations.EstimateWait(position, capacity)
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// Full reports whether new work would be refused
+func (q *conversionQueue) Full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.maxWaiting > 0 && q.waiting.Len() >= q.maxWaiting
+}
+
+// QueueFullResponse is the 429 body returned while the conversion queue is full
+type QueueFullResponse struct {
+	Error string      `json:"error"`
+	Code  string      `json:"code"`
+	Id    string      `json:"id"`
+	Queue QueueStatus `json:"queue"`
+}
+
+// rejectQueueFull answers 429 Too Many Requests with the queue's depth and a Retry-After estimate
+func rejectQueueFull(w http.ResponseWriter, requestId string) {
+	status := conversionSlots.Status()
+	if status.RetryAfter < 1 {
+		status.RetryAfter = 1
+	}
+	logger.WithFields(logrus.Fields{
+		"request_id":  requestId,
+		"queue_depth": status.Depth,
+		"retry_after": status.RetryAfter,
+	}).Warn("Conversion queue is full, rejecting request")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(status.RetryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(QueueFullResponse{
+		Error: "Conversion queue is full",
+		Code:  codeQueueFull,
+		Id:    requestId,
+		Queue: status,
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// searchIndexFileName is the client-side search index written with ?search_index=true
+const searchIndexFileName = "search-index.json"
+
+// SearchDocument is one page of the search index. The index is a plain array of documents, which
+// Fuse.js searches as is and lunr indexes with path as the ref.
+type SearchDocument struct {
+	Path     string   `json:"path"`
+	Title    string   `json:"title"`
+	Headings []string `json:"headings"`
+	Body     string   `json:"body"`
+}
+
+// buildSearchIndex extracts the title, headings and plain text of every markdown page under
+// wikiDir, sorted by path. It runs before backlinks and the table of contents are added, so
+// neither ends up in the indexed text.
+func buildSearchIndex(wikiDir string) ([]SearchDocument, error) {
+	documents := []SearchDocument{}
+	err := filepath.Walk(wikiDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(file, ".md") {
+			return err
+		}
+		rel, err := filepath.Rel(wikiDir, file)
+		if err != nil {
+			return err
+		}
+		markdown, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		page := filepath.ToSlash(rel)
+		document := markdownSearchDocument(markdown)
+		document.Path = page
+		if document.Title == "" {
+			document.Title = strings.TrimSuffix(path.Base(page), ".md")
+		}
+		documents = append(documents, document)
+		return nil
+	})
+	sort.Slice(documents, func(i, j int) bool { return documents[i].Path < documents[j].Path })
+	return documents, err
+}
+
+// markdownSearchDocument collects a page's headings and text without markup. The title is the
+// first top-level heading.
+func markdownSearchDocument(markdown []byte) SearchDocument {
+	document := SearchDocument{Headings: []string{}}
+	root := markdownRenderer.Parser().Parse(text.NewReader(markdown))
+
+	var body strings.Builder
+	separate := func() {
+		if body.Len() > 0 {
+			body.WriteString(" ")
+		}
+	}
+	ast.Walk(root, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := node.(type) {
+		case *ast.Heading:
+			heading := strings.TrimSpace(nodeText(node, markdown))
+			if node.Level == 1 && document.Title == "" {
+				document.Title = heading
+			} else if heading != "" {
+				document.Headings = append(document.Headings, heading)
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.Paragraph, *ast.TextBlock, *ast.ListItem:
+			separate()
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			lines := node.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				separate()
+				segment := lines.At(i)
+				body.WriteString(strings.TrimSpace(string(segment.Value(markdown))))
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			body.Write(node.Segment.Value(markdown))
+			if node.SoftLineBreak() || node.HardLineBreak() {
+				body.WriteString(" ")
+			}
+		case *ast.String:
+			body.Write(node.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	document.Body = strings.Join(strings.Fields(body.String()), " ")
+	return document
+}
+
+// nodeText concatenates the text below a node
+func nodeText(node ast.Node, source []byte) string {
+	var out strings.Builder
+	ast.Walk(node, func(child ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch child := child.(type) {
+		case *ast.Text:
+			out.Write(child.Segment.Value(source))
+		case *ast.String:
+			out.Write(child.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return out.String()
+}
+
+// writeSearchIndex stores the documents as search-index.json in a workspace's delivered wiki,
+// with page paths as the output format delivers them
+func writeSearchIndex(wikiDir string, documents []SearchDocument, format OutputFormat) error {
+	for i := range documents {
+		documents[i].Path = formatPagePath(format, documents[i].Path)
+	}
+	data, err := json.MarshalIndent(documents, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(wikiDir, searchIndexFileName), data, 0644)
+}
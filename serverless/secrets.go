@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/sirupsen/logrus"
+)
+
+// secretsFetchTimeout bounds a single load from the secrets backend
+const secretsFetchTimeout = 15 * time.Second
+
+// secretsBackend loads a set of named secrets (e.g. NEORG_DOCUMENTATION_AUTH_TOKEN) from an external store
+type secretsBackend interface {
+	Name() string
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+// secretStore caches the values loaded from the configured backend
+type secretStore struct {
+	mu      sync.RWMutex
+	backend secretsBackend
+	values  map[string]string
+}
+
+// secrets is the process-wide secret cache; nil when secrets come from plain environment variables
+var secrets *secretStore
+
+// getSecret returns a secret from the configured backend, falling back to the environment variable of the same name
+func getSecret(key string) string {
+	if secrets != nil {
+		secrets.mu.RLock()
+		value, ok := secrets.values[key]
+		secrets.mu.RUnlock()
+		if ok {
+			return value
+		}
+	}
+	return getEnv(key, "")
+}
+
+// initSecrets selects the backend from SECRETS_BACKEND (env, aws or vault), performs the initial
+// load and starts the periodic refresh. Failing to load secrets at startup is fatal, so the service
+// never runs with a half-configured authentication setup.
+func initSecrets() {
+	var backend secretsBackend
+	switch strings.ToLower(getEnv("SECRETS_BACKEND", "env")) {
+	case "", "env":
+		return
+	case "aws":
+		backend = &awsSecretsBackend{secretId: getEnv("SECRETS_AWS_SECRET_ID", "")}
+	case "vault":
+		backend = &vaultSecretsBackend{
+			address: strings.TrimRight(getEnv("VAULT_ADDR", ""), "/"),
+			token:   getEnv("VAULT_TOKEN", ""),
+			path:    strings.Trim(getEnv("SECRETS_VAULT_PATH", ""), "/"),
+			client:  &http.Client{Timeout: secretsFetchTimeout},
+		}
+	default:
+		logger.WithField("backend", getEnv("SECRETS_BACKEND", "")).Fatal("Unknown SECRETS_BACKEND")
+	}
+
+	store := &secretStore{backend: backend}
+	if err := store.refresh(); err != nil {
+		logger.WithFields(logrus.Fields{
+			"backend": backend.Name(),
+			"error":   err.Error(),
+		}).Fatal("Failed to load secrets")
+	}
+	secrets = store
+
+	interval := time.Duration(getEnvInt64("SECRETS_REFRESH_SECONDS", 300)) * time.Second
+	if interval > 0 {
+		go store.refreshLoop(interval)
+	}
+}
+
+// refresh reloads every secret, keeping the previous values when the backend is unavailable
+func (s *secretStore) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretsFetchTimeout)
+	defer cancel()
+
+	values, err := s.backend.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.values = values
+	s.mu.Unlock()
+
+	logger.WithFields(logrus.Fields{
+		"backend": s.backend.Name(),
+		"secrets": len(values),
+	}).Info("Secrets loaded")
+	return nil
+}
+
+func (s *secretStore) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.refresh(); err != nil {
+			logger.WithFields(logrus.Fields{
+				"backend": s.backend.Name(),
+				"error":   err.Error(),
+			}).Warn("Failed to refresh secrets, keeping previous values")
+		}
+	}
+}
+
+// awsSecretsBackend reads a JSON object of key/value pairs from one AWS Secrets Manager secret,
+// using the default AWS credential chain (environment, shared config, ECS/Lambda roles)
+type awsSecretsBackend struct {
+	secretId string
+	client   *secretsmanager.Client
+}
+
+func (b *awsSecretsBackend) Name() string { return "aws" }
+
+func (b *awsSecretsBackend) Fetch(ctx context.Context) (map[string]string, error) {
+	if b.secretId == "" {
+		return nil, fmt.Errorf("SECRETS_AWS_SECRET_ID is not set")
+	}
+	if b.client == nil {
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+		}
+		b.client = secretsmanager.NewFromConfig(cfg)
+	}
+
+	output, err := b.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(b.secretId),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %v", b.secretId, err)
+	}
+	if output.SecretString == nil {
+		return nil, fmt.Errorf("secret %s has no string value", b.secretId)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal([]byte(*output.SecretString), &values); err != nil {
+		return nil, fmt.Errorf("secret %s is not a JSON object of strings: %v", b.secretId, err)
+	}
+	return values, nil
+}
+
+// vaultSecretsBackend reads key/value pairs from a HashiCorp Vault KV secret (v1 or v2 engine)
+type vaultSecretsBackend struct {
+	address string
+	token   string
+	path    string
+	client  *http.Client
+}
+
+func (b *vaultSecretsBackend) Name() string { return "vault" }
+
+func (b *vaultSecretsBackend) Fetch(ctx context.Context) (map[string]string, error) {
+	if b.address == "" || b.token == "" || b.path == "" {
+		return nil, fmt.Errorf("VAULT_ADDR, VAULT_TOKEN and SECRETS_VAULT_PATH must be set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.address+"/v1/"+b.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", b.token)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach vault: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned status %d for %s", resp.StatusCode, b.path)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode vault response: %v", err)
+	}
+
+	// KV v2 nests the secret under data.data alongside data.metadata
+	data := body.Data
+	if nested, ok := body.Data["data"]; ok {
+		if _, hasMetadata := body.Data["metadata"]; hasMetadata {
+			data = nil
+			if err := json.Unmarshal(nested, &data); err != nil {
+				return nil, fmt.Errorf("failed to decode vault KV v2 data: %v", err)
+			}
+		}
+	}
+
+	values := make(map[string]string, len(data))
+	for key, raw := range data {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("vault secret %s is not a string", key)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
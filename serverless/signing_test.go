@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureMiddleware(t *testing.T) {
+	t.Setenv("REQUEST_SIGNING_SECRET", "test secret")
+	t.Setenv("MAX_UPLOAD_BYTES", "64")
+
+	// request builds a POST of body to /convert signed at signedAt with nonce
+	request := func(verifier *requestVerifier, body string, signedAt time.Time, nonce string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/convert?output=zip", strings.NewReader(body))
+		// Read the body to its end instead of refusing it by its declared length
+		r.ContentLength = -1
+		timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+		bodyHash := sha256.Sum256([]byte(body))
+		r.Header.Set("X-Signature-Timestamp", timestamp)
+		r.Header.Set("X-Signature-Nonce", nonce)
+		r.Header.Set("X-Signature", verifier.sign(timestamp, nonce, r.Method, r.URL.RequestURI(), bodyHash[:]))
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		signedAt time.Duration
+		prepare  func(r *http.Request)
+		// replay sends the request a second time, which is the one checked
+		replay   bool
+		wantCode string
+	}{
+		{name: "valid signature", body: "archive"},
+		{
+			name: "bad signature",
+			body: "archive",
+			prepare: func(r *http.Request) {
+				r.Header.Set("X-Signature", strings.Repeat("0", 64))
+			},
+			wantCode: codeUnauthorized,
+		},
+		{
+			name: "body changed after signing",
+			body: "archive",
+			prepare: func(r *http.Request) {
+				r.Body = io.NopCloser(strings.NewReader("tampered"))
+			},
+			wantCode: codeUnauthorized,
+		},
+		{
+			name: "missing signature",
+			body: "archive",
+			prepare: func(r *http.Request) {
+				r.Header.Del("X-Signature")
+			},
+			wantCode: codeUnauthorized,
+		},
+		{name: "stale timestamp", body: "archive", signedAt: -10 * time.Minute, wantCode: codeUnauthorized},
+		{name: "future timestamp", body: "archive", signedAt: 10 * time.Minute, wantCode: codeUnauthorized},
+		{name: "timestamp within skew", body: "archive", signedAt: -4 * time.Minute},
+		{name: "replayed nonce", body: "archive", replay: true, wantCode: codeUnauthorized},
+		{name: "body over the limit", body: strings.Repeat("x", 65), wantCode: codeUploadTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier := newRequestVerifierFromEnv()
+			var received string
+			handler := UploadLimitMiddleware(SignatureMiddleware(verifier, func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("reading verified body: %v", err)
+				}
+				received = string(body)
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			send := func() *httptest.ResponseRecorder {
+				r := request(verifier, test.body, time.Now().Add(test.signedAt), "nonce-"+test.name)
+				if test.prepare != nil {
+					test.prepare(r)
+				}
+				recorder := httptest.NewRecorder()
+				handler(recorder, r)
+				return recorder
+			}
+			response := send()
+			if test.replay {
+				if response.Code != http.StatusOK {
+					t.Fatalf("first request answered %d, want 200", response.Code)
+				}
+				received = ""
+				response = send()
+			}
+
+			if test.wantCode == "" {
+				if response.Code != http.StatusOK {
+					t.Fatalf("answered %d: %s", response.Code, response.Body)
+				}
+				if received != test.body {
+					t.Errorf("handler received %q, want %q", received, test.body)
+				}
+				return
+			}
+			var answer struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(response.Body.Bytes(), &answer); err != nil {
+				t.Fatalf("answered %d with %q: %v", response.Code, response.Body, err)
+			}
+			if answer.Code != test.wantCode {
+				t.Errorf("answered %d with code %q, want %q", response.Code, answer.Code, test.wantCode)
+			}
+			if received != "" {
+				t.Errorf("handler ran for a rejected request")
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// sitemapFileName is the sitemap written at the root of the output with ?base_url
+	sitemapFileName = "sitemap.xml"
+	// linkMapFileName maps every page to the pages it links to, written with ?linkmap=true
+	linkMapFileName = "linkmap.json"
+	// sitemapNamespace is the sitemaps.org protocol namespace
+	sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+type (
+	// sitemapURLSet is the root element of sitemap.xml
+	sitemapURLSet struct {
+		XMLName xml.Name     `xml:"urlset"`
+		Xmlns   string       `xml:"xmlns,attr"`
+		URLs    []sitemapURL `xml:"url"`
+	}
+
+	// sitemapURL is one page of the sitemap
+	sitemapURL struct {
+		Loc string `xml:"loc"`
+	}
+)
+
+// parseBaseURL validates the absolute http(s) URL the output will be published at
+func parseBaseURL(value string) (string, error) {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("base_url %q must be an absolute http or https URL", value)
+	}
+	if !strings.HasSuffix(parsed.Path, "/") {
+		parsed.Path += "/"
+	}
+	parsed.RawQuery, parsed.Fragment = "", ""
+	return parsed.String(), nil
+}
+
+// writeSitemap lists every delivered page of the workspaces as URLs below baseURL in sitemap.xml
+// at the root of the output. Pages are the files with the extension the output format gives
+// markdown pages; assets and generated JSON are left out.
+func writeSitemap(outputDir string, baseURL string, workspaces []WorkspaceManifest, format OutputFormat) error {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+	pageExtension := path.Ext(formatPagePath(format, "page.md"))
+
+	sitemap := sitemapURLSet{Xmlns: sitemapNamespace, URLs: []sitemapURL{}}
+	for _, workspace := range workspaces {
+		for _, file := range workspace.Files {
+			if path.Ext(file) != pageExtension {
+				continue
+			}
+			page := &url.URL{Path: file}
+			sitemap.URLs = append(sitemap.URLs, sitemapURL{Loc: base.ResolveReference(page).String()})
+		}
+	}
+
+	data, err := xml.MarshalIndent(sitemap, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	return os.WriteFile(filepath.Join(outputDir, sitemapFileName), data, 0644)
+}
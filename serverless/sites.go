@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// markdownLinkPattern matches relative links to generated .md pages in markdown
+var markdownLinkPattern = regexp.MustCompile(`\]\(<?([^)\s#?:<>]+\.md)(?:#[^)\s>]*)?>?\)`)
+
+// sitePage is a generated page in a static site's navigation
+type sitePage struct {
+	// Path is slash-separated and relative to the wiki directory
+	Path     string
+	Title    string
+	Children []*sitePage
+}
+
+// buildPageTree orders the pages under wikiDir for a site's navigation. The root index opens it,
+// every page nests under the closest page linking to it, and pages nothing links to start new
+// top-level entries in path order.
+func buildPageTree(wikiDir string) ([]*sitePage, error) {
+	contents := map[string][]byte{}
+	var paths []string
+	err := filepath.Walk(wikiDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(file, ".md") {
+			return err
+		}
+		rel, err := filepath.Rel(wikiDir, file)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		contents[rel] = content
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pdfPageOrder(paths)
+
+	newPage := func(pagePath string) *sitePage {
+		return &sitePage{Path: pagePath, Title: markdownPageTitle(contents[pagePath], strings.TrimSuffix(path.Base(pagePath), ".md"))}
+	}
+	visited := map[string]bool{}
+	var roots []*sitePage
+	for _, rootPath := range paths {
+		if visited[rootPath] {
+			continue
+		}
+		visited[rootPath] = true
+		root := newPage(rootPath)
+		roots = append(roots, root)
+
+		// Breadth first, so a page lands under the page fewest links away from the root
+		queue := []*sitePage{root}
+		for len(queue) > 0 {
+			page := queue[0]
+			queue = queue[1:]
+			for _, match := range markdownLinkPattern.FindAllSubmatch(contents[page.Path], -1) {
+				target := path.Join(path.Dir(page.Path), string(match[1]))
+				if _, ok := contents[target]; !ok || visited[target] {
+					continue
+				}
+				visited[target] = true
+				child := newPage(target)
+				page.Children = append(page.Children, child)
+				queue = append(queue, child)
+			}
+		}
+	}
+	return roots, nil
+}
+
+// moveIntoSubdir moves everything in dir into its subdirectory name, which may already exist
+// among the entries being moved
+func moveIntoSubdir(dir string, name string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	staging, err := os.MkdirTemp(dir, ".site")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(dir, entry.Name()), filepath.Join(staging, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, filepath.FromSlash(name))), 0755); err != nil {
+		return err
+	}
+	return os.Rename(staging, filepath.Join(dir, filepath.FromSlash(name)))
+}
+
+// markdownLinkTarget formats a page path as a markdown link destination
+func markdownLinkTarget(pagePath string) string {
+	if strings.ContainsAny(pagePath, " ()") {
+		return "<" + pagePath + ">"
+	}
+	return pagePath
+}
+
+// markdownLinkText escapes a title for use as markdown link text
+func markdownLinkText(title string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`).Replace(title)
+}
+
+// sitePagePath returns the PagePath of site formats that move the pages into dir
+func sitePagePath(dir string) func(page string) string {
+	return func(page string) string {
+		return path.Join(dir, page)
+	}
+}
+
+// arrangeMdBook turns a generated wiki into an mdBook project: the pages move to src/, a
+// SUMMARY.md is generated from the link structure and book.toml points mdBook at it, so the
+// result builds with `mdbook build` as is
+func arrangeMdBook(wikiDir string) error {
+	tree, err := buildPageTree(wikiDir)
+	if err != nil {
+		return err
+	}
+	if err := moveIntoSubdir(wikiDir, "src"); err != nil {
+		return fmt.Errorf("failed to create src directory: %v", err)
+	}
+
+	var summary strings.Builder
+	summary.WriteString("# Summary\n\n")
+	var writeChapters func(pages []*sitePage, depth int)
+	writeChapters = func(pages []*sitePage, depth int) {
+		for _, page := range pages {
+			fmt.Fprintf(&summary, "%s- [%s](%s)\n", strings.Repeat("  ", depth), markdownLinkText(page.Title), markdownLinkTarget(page.Path))
+			writeChapters(page.Children, depth+1)
+		}
+	}
+	title := "Documentation"
+	chapters := tree
+	// A root index becomes the unnumbered introduction and the pages it links to the chapters
+	if len(tree) > 0 && pdfPageRank(tree[0].Path) == 0 {
+		title = tree[0].Title
+		fmt.Fprintf(&summary, "[%s](%s)\n\n", markdownLinkText(tree[0].Title), markdownLinkTarget(tree[0].Path))
+		chapters = append(append([]*sitePage{}, tree[0].Children...), tree[1:]...)
+	}
+	writeChapters(chapters, 0)
+	if err := os.WriteFile(filepath.Join(wikiDir, "src", "SUMMARY.md"), []byte(summary.String()), 0644); err != nil {
+		return err
+	}
+
+	book := fmt.Sprintf("[book]\ntitle = %s\nsrc = \"src\"\n\n[output.html]\n", strconv.Quote(title))
+	return os.WriteFile(filepath.Join(wikiDir, "book.toml"), []byte(book), 0644)
+}
+
+// arrangeMkDocs turns a generated wiki into a MkDocs project: the pages move to docs/ and
+// mkdocs.yml gets a nav generated from the link structure. A page linking to others becomes a
+// section that opens with the page itself.
+func arrangeMkDocs(wikiDir string) error {
+	tree, err := buildPageTree(wikiDir)
+	if err != nil {
+		return err
+	}
+	if err := moveIntoSubdir(wikiDir, "docs"); err != nil {
+		return fmt.Errorf("failed to create docs directory: %v", err)
+	}
+
+	var nav strings.Builder
+	var writeNav func(pages []*sitePage, indent string)
+	writeNav = func(pages []*sitePage, indent string) {
+		for _, page := range pages {
+			if len(page.Children) == 0 {
+				fmt.Fprintf(&nav, "%s- %s: %s\n", indent, strconv.Quote(page.Title), strconv.Quote(page.Path))
+				continue
+			}
+			fmt.Fprintf(&nav, "%s- %s:\n", indent, strconv.Quote(page.Title))
+			fmt.Fprintf(&nav, "%s    - %s: %s\n", indent, strconv.Quote(page.Title), strconv.Quote(page.Path))
+			writeNav(page.Children, indent+"    ")
+		}
+	}
+	title := "Documentation"
+	entries := tree
+	// A root index becomes the home page and the pages it links to the top-level entries
+	if len(tree) > 0 && pdfPageRank(tree[0].Path) == 0 {
+		title = tree[0].Title
+		entries = append([]*sitePage{{Path: tree[0].Path, Title: tree[0].Title}}, tree[0].Children...)
+		entries = append(entries, tree[1:]...)
+	}
+	writeNav(entries, "  ")
+
+	config := fmt.Sprintf("site_name: %s\ndocs_dir: docs\nnav:\n%s", strconv.Quote(title), nav.String())
+	return os.WriteFile(filepath.Join(wikiDir, "mkdocs.yml"), []byte(config), 0644)
+}
+
+// mdxAutolinkPattern matches markdown autolinks, which MDX does not support
+var mdxAutolinkPattern = regexp.MustCompile(`^<((?:https?|mailto):[^<>\s]+)>`)
+
+// escapeMDX makes converted markdown safe for MDX by escaping the braces and angle brackets it
+// would parse as JSX expressions and tags; fenced code blocks and inline code are left alone and
+// autolinks become regular links
+func escapeMDX(markdown []byte) []byte {
+	var out strings.Builder
+	fence := ""
+	for _, line := range strings.SplitAfter(string(markdown), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			out.WriteString(line)
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			out.WriteString(line)
+			continue
+		}
+
+		for i := 0; i < len(line); i++ {
+			switch c := line[i]; c {
+			case '\\':
+				// Keep existing escapes intact
+				out.WriteByte(c)
+				if i+1 < len(line) {
+					i++
+					out.WriteByte(line[i])
+				}
+			case '`':
+				run := len(line[i:]) - len(strings.TrimLeft(line[i:], "`"))
+				marker := line[i : i+run]
+				if end := strings.Index(line[i+run:], marker); end >= 0 {
+					out.WriteString(line[i : i+run+end+run])
+					i += run + end + run - 1
+				} else {
+					out.WriteString(marker)
+					i += run - 1
+				}
+			case '<':
+				if match := mdxAutolinkPattern.FindStringSubmatch(line[i:]); match != nil {
+					fmt.Fprintf(&out, "[%s](%s)", match[1], match[1])
+					i += len(match[0]) - 1
+				} else {
+					out.WriteString(`\<`)
+				}
+			case '{', '}':
+				out.WriteByte('\\')
+				out.WriteByte(c)
+			default:
+				out.WriteByte(c)
+			}
+		}
+	}
+	return []byte(out.String())
+}
+
+// docusaurusDocId is the id Docusaurus gives a page: its path without the extension
+func docusaurusDocId(pagePath string) string {
+	return strings.TrimSuffix(pagePath, ".md")
+}
+
+// arrangeDocusaurus turns a generated wiki into a Docusaurus docs folder: pages move to docs/,
+// are made MDX-safe and get id and sidebar_position frontmatter, and sidebars.js lays out the
+// sidebar from the link structure. A page linking to others becomes a category linked to the page.
+func arrangeDocusaurus(wikiDir string) error {
+	tree, err := buildPageTree(wikiDir)
+	if err != nil {
+		return err
+	}
+	if err := moveIntoSubdir(wikiDir, "docs"); err != nil {
+		return fmt.Errorf("failed to create docs directory: %v", err)
+	}
+	docsDir := filepath.Join(wikiDir, "docs")
+
+	var sidebar strings.Builder
+	var writePages func(pages []*sitePage, indent string) error
+	writePages = func(pages []*sitePage, indent string) error {
+		for i, page := range pages {
+			file := filepath.Join(docsDir, filepath.FromSlash(page.Path))
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			frontmatter := fmt.Sprintf("---\nid: %s\ntitle: %s\nsidebar_position: %d\n---\n\n",
+				strconv.Quote(path.Base(docusaurusDocId(page.Path))), strconv.Quote(page.Title), i+1)
+			if err := os.WriteFile(file, append([]byte(frontmatter), escapeMDX(content)...), 0644); err != nil {
+				return err
+			}
+
+			id := strconv.Quote(docusaurusDocId(page.Path))
+			if len(page.Children) == 0 {
+				fmt.Fprintf(&sidebar, "%s%s,\n", indent, id)
+				continue
+			}
+			fmt.Fprintf(&sidebar, "%s{\n%s  type: 'category',\n%s  label: %s,\n%s  link: {type: 'doc', id: %s},\n%s  items: [\n",
+				indent, indent, indent, strconv.Quote(page.Title), indent, id, indent)
+			if err := writePages(page.Children, indent+"    "); err != nil {
+				return err
+			}
+			fmt.Fprintf(&sidebar, "%s  ],\n%s},\n", indent, indent)
+		}
+		return nil
+	}
+	entries := tree
+	// A root index opens the sidebar and the pages it links to follow at the top level
+	if len(tree) > 0 && pdfPageRank(tree[0].Path) == 0 {
+		entries = append([]*sitePage{{Path: tree[0].Path, Title: tree[0].Title}}, tree[0].Children...)
+		entries = append(entries, tree[1:]...)
+	}
+	if err := writePages(entries, "    "); err != nil {
+		return err
+	}
+
+	config := "/** @type {import('@docusaurus/plugin-content-docs').SidebarsConfig} */\nmodule.exports = {\n  docs: [\n" + sidebar.String() + "  ],\n};\n"
+	return os.WriteFile(filepath.Join(wikiDir, "sidebars.js"), []byte(config), 0644)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// archiveHeadBytes is how much of an archive is kept in memory to sniff its format
+const archiveHeadBytes = 512
+
+// spooledArchive is an input archive spooled to a temporary file in UPLOAD_SPOOL_DIR (the system
+// temp directory by default) as it is received, so concurrent uploads only hold a copy buffer in
+// memory however large they are. Its SHA-256 is computed while spooling and it may be read any
+// number of times, concurrently. Whoever holds it last calls Close to remove the file.
+type spooledArchive struct {
+	file   *os.File
+	size   int64
+	digest string
+	head   []byte
+}
+
+// spoolArchive copies r into a new spooled archive
+func spoolArchive(r io.Reader) (*spooledArchive, error) {
+	file, err := os.CreateTemp(getEnv("UPLOAD_SPOOL_DIR", ""), "neorg_upload_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %v", err)
+	}
+
+	hash := sha256.New()
+	head := &headBuffer{limit: archiveHeadBytes}
+	size, err := io.Copy(io.MultiWriter(file, hash, head), r)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	logger.WithField("body_size", size).Debug("Archive spooled to disk")
+	return &spooledArchive{
+		file:   file,
+		size:   size,
+		digest: hex.EncodeToString(hash.Sum(nil)),
+		head:   head.Bytes(),
+	}, nil
+}
+
+// spoolBytes spools an archive already held in memory, e.g. one generated for a benchmark
+func spoolBytes(data []byte) (*spooledArchive, error) {
+	return spoolArchive(bytes.NewReader(data))
+}
+
+// Reader returns a reader over the whole archive, independent of any other readers
+func (a *spooledArchive) Reader() *io.SectionReader {
+	return io.NewSectionReader(a.file, 0, a.size)
+}
+
+// Size is the archive's length in bytes
+func (a *spooledArchive) Size() int64 {
+	return a.size
+}
+
+// Digest is the hex SHA-256 of the archive
+func (a *spooledArchive) Digest() string {
+	return a.digest
+}
+
+// Close removes the spool file
+func (a *spooledArchive) Close() error {
+	a.file.Close()
+	return os.Remove(a.file.Name())
+}
+
+// headBuffer keeps the first limit bytes written to it and discards the rest
+type headBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.Len(); remaining > 0 {
+		b.Buffer.Write(p[:min(remaining, len(p))])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor systemd passes sockets in (SD_LISTEN_FDS_START)
+const listenFdsStart = 3
+
+// systemdListener returns the socket systemd passed to the process through socket activation, or
+// nil when it was started without one. Only the first socket is served. The LISTEN_* variables
+// are cleared so the converters started later do not take the sockets for theirs.
+func systemdListener() (net.Listener, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	if count > 1 {
+		logger.WithField("sockets", count).Warn("systemd passed several sockets, serving only the first")
+	}
+
+	name, _, _ := strings.Cut(names, ":")
+	if name == "" {
+		name = "systemd"
+	}
+	file := os.NewFile(listenFdsStart, name)
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the socket passed by systemd: %v", err)
+	}
+	return listener, nil
+}
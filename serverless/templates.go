@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode/utf8"
+)
+
+const (
+	// pageTemplateFileName is the optional Go text/template read from the workspace root, which
+	// every converted page is rendered through
+	pageTemplateFileName = "page_template.tmpl"
+	// maxPageTemplateBytes caps the size of the template
+	maxPageTemplateBytes = 64 << 10
+	// maxTemplatedPageBytes caps the size of a page rendered through the template
+	maxTemplatedPageBytes = 8 << 20
+	// maxTemplateSteps caps the range iterations and template calls of rendering one page, since
+	// ranges over integers and templates calling each other can run for as long as they like
+	// without writing anything
+	maxTemplateSteps = 1 << 20
+	// templateStepFunc is the function counting steps, called at the start of every template and
+	// range iteration
+	templateStepFunc = "_step"
+)
+
+// errInvalidTemplate marks a page_template.tmpl that cannot be parsed or fails to render a page
+var errInvalidTemplate = errors.New("invalid " + pageTemplateFileName)
+
+type (
+	// PageTemplateData is what page_template.tmpl renders for each converted page
+	PageTemplateData struct {
+		// Title is the page's title, as the table of contents and HTML output show it
+		Title string
+		// Content is the converted markdown, without frontmatter
+		Content string
+		// Path is the page's slash-separated path in the workspace's output
+		Path string
+		// Source is the slash-separated path of the .norg file it was converted from
+		Source string
+		// Root is the relative link from the page to the root of the workspace's output
+		Root string
+		// Workspace is the workspace's path in the archive ("." for the archive root)
+		Workspace string
+		// Meta holds the source's @document.meta fields
+		Meta DocumentMetadata
+		// Prev and Next link to the neighbouring pages in path order, nil at either end
+		Prev *PageTemplateLink
+		Next *PageTemplateLink
+	}
+
+	// PageTemplateLink is a link from a page to another page
+	PageTemplateLink struct {
+		Title string
+		Link  string
+	}
+)
+
+// loadPageTemplate parses a workspace's page_template.tmpl, returning nil when there is none
+func loadPageTemplate(workspace Workspace) (*template.Template, error) {
+	source, err := os.ReadFile(filepath.Join(workspace.Dir, pageTemplateFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidTemplate, err)
+	}
+
+	switch {
+	case len(source) > maxPageTemplateBytes:
+		return nil, fmt.Errorf("%w: larger than %d bytes", errInvalidTemplate, maxPageTemplateBytes)
+	case !utf8.Valid(source) || bytes.IndexByte(source, 0) >= 0:
+		return nil, fmt.Errorf("%w: must be UTF-8 text", errInvalidTemplate)
+	}
+
+	steps := template.FuncMap{templateStepFunc: func() (string, error) { return "", nil }}
+	tmpl, err := template.New(pageTemplateFileName).Option("missingkey=error").Funcs(steps).Parse(string(source))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidTemplate, err)
+	}
+	step, err := template.New("").Funcs(steps).Parse("{{" + templateStepFunc + "}}")
+	if err != nil {
+		return nil, err
+	}
+	// Count a step at every template call and range iteration, which bounds rendering a page
+	for _, defined := range tmpl.Templates() {
+		if defined.Tree != nil && defined.Tree.Root != nil {
+			countTemplateSteps(defined.Tree.Root, step.Tree.Root.Nodes[0])
+			defined.Tree.Root.Nodes = append([]parse.Node{step.Tree.Root.Nodes[0]}, defined.Tree.Root.Nodes...)
+		}
+	}
+	return tmpl, nil
+}
+
+// countTemplateSteps puts step at the start of the body of every range within list
+func countTemplateSteps(list *parse.ListNode, step parse.Node) {
+	if list == nil {
+		return
+	}
+	for _, node := range list.Nodes {
+		switch node := node.(type) {
+		case *parse.IfNode:
+			countTemplateSteps(node.List, step)
+			countTemplateSteps(node.ElseList, step)
+		case *parse.WithNode:
+			countTemplateSteps(node.List, step)
+			countTemplateSteps(node.ElseList, step)
+		case *parse.RangeNode:
+			countTemplateSteps(node.List, step)
+			countTemplateSteps(node.ElseList, step)
+			if node.List != nil {
+				node.List.Nodes = append([]parse.Node{step}, node.List.Nodes...)
+			}
+		}
+	}
+}
+
+// applyPageTemplate renders every converted page in wikiDir through tmpl, keeping frontmatter at
+// the top of the page. pages maps each source page (workspace-relative, without .norg) to its
+// page name after the output layout.
+func applyPageTemplate(ctx context.Context, tmpl *template.Template, workspace Workspace, wikiDir string, pages map[string]string, options ConversionOptions) error {
+	// Each page may take maxTemplateSteps, and rendering stops once ctx is done
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	steps := 0
+	tmpl.Funcs(template.FuncMap{templateStepFunc: func() (string, error) {
+		steps++
+		if steps > maxTemplateSteps {
+			return "", fmt.Errorf("rendering takes more than %d steps", maxTemplateSteps)
+		}
+		return "", ctx.Err()
+	}})
+
+	sources := make(map[string]string, len(pages))
+	paths := make([]string, 0, len(pages))
+	for source, page := range pages {
+		sources[page+".md"] = source
+		paths = append(paths, page+".md")
+	}
+	pdfPageOrder(paths)
+
+	contents := make(map[string][]byte, len(paths))
+	titles := make(map[string]string, len(paths))
+	for _, pagePath := range paths {
+		content, err := os.ReadFile(filepath.Join(wikiDir, filepath.FromSlash(pagePath)))
+		if err != nil {
+			continue
+		}
+		contents[pagePath] = content
+		titles[pagePath] = markdownPageTitle(content, strings.TrimSuffix(path.Base(pagePath), ".md"))
+	}
+
+	link := func(from string, to string) *PageTemplateLink {
+		target := relativeLink(path.Dir(from), to)
+		if options.Layout == layoutGitHubWiki {
+			target = strings.TrimSuffix(target, ".md")
+		}
+		return &PageTemplateLink{Title: titles[to], Link: target}
+	}
+
+	for i, pagePath := range paths {
+		content, ok := contents[pagePath]
+		if !ok {
+			continue
+		}
+		frontmatter := leadingFrontmatterPattern.Find(content)
+		data := PageTemplateData{
+			Title:     titles[pagePath],
+			Content:   string(content[len(frontmatter):]),
+			Path:      pagePath,
+			Source:    sources[pagePath] + ".norg",
+			Root:      relativeLink(path.Dir(pagePath), ".") + "/",
+			Workspace: workspace.Name,
+		}
+		if source, err := os.ReadFile(filepath.Join(workspace.Dir, filepath.FromSlash(data.Source))); err == nil {
+			data.Meta = documentMetadata(data.Source, parseDocumentMeta(string(source)))
+		}
+		if data.Root == "./" {
+			data.Root = ""
+		}
+		if i > 0 {
+			data.Prev = link(pagePath, paths[i-1])
+		}
+		if i < len(paths)-1 {
+			data.Next = link(pagePath, paths[i+1])
+		}
+
+		steps = 0
+		rendered := &cappedBuffer{limit: maxTemplatedPageBytes}
+		rendered.Write(frontmatter)
+		if err := tmpl.Execute(rendered, data); err != nil {
+			return fmt.Errorf("%w: %s: %v", errInvalidTemplate, data.Source, err)
+		}
+		if err := os.WriteFile(filepath.Join(wikiDir, filepath.FromSlash(pagePath)), rendered.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cappedBuffer is a bytes.Buffer that refuses writes beyond limit, which stops a template that
+// would expand a page without bound
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("rendered page is larger than %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyPageTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "page",
+			template: `{{.Title}}|{{.Content}}`,
+			want:     "Index|# Index\n",
+		},
+		{
+			name:     "range over int within budget",
+			template: `{{range 3}}{{template "dot"}}{{end}}{{define "dot"}}.{{end}}`,
+			want:     "...",
+		},
+		{
+			name:     "nested range over int",
+			template: `{{range 1000000}}{{range 1000000}}{{end}}{{end}}`,
+			wantErr:  "more than",
+		},
+		{
+			name:     "range nested in if",
+			template: `{{if true}}{{with .Title}}{{range 1000000000}}{{end}}{{end}}{{end}}`,
+			wantErr:  "more than",
+		},
+		{
+			name: "templates calling each other",
+			// Every template calls the next twice, 2^40 calls in all
+			template: func() string {
+				var builder strings.Builder
+				builder.WriteString(`{{template "t0"}}`)
+				for i := 0; i < 40; i++ {
+					fmt.Fprintf(&builder, `{{define "t%d"}}{{template "t%d"}}{{template "t%d"}}{{end}}`, i, i+1, i+1)
+				}
+				builder.WriteString(`{{define "t40"}}{{end}}`)
+				return builder.String()
+			}(),
+			wantErr: "more than",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			wikiDir := filepath.Join(dir, "wiki")
+			if err := os.MkdirAll(wikiDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			files := map[string]string{
+				filepath.Join(dir, pageTemplateFileName): test.template,
+				filepath.Join(dir, "index.norg"):         "* Index\n",
+				filepath.Join(wikiDir, "index.md"):       "# Index\n",
+			}
+			for name, content := range files {
+				if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			workspace := Workspace{Name: "", Dir: dir}
+			tmpl, err := loadPageTemplate(workspace)
+			if err != nil {
+				t.Fatalf("loadPageTemplate: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err = applyPageTemplate(ctx, tmpl, workspace, wikiDir, map[string]string{"index": "index"}, ConversionOptions{})
+			if test.wantErr != "" {
+				if !errors.Is(err, errInvalidTemplate) || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("applyPageTemplate = %v, want %v containing %q", err, errInvalidTemplate, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyPageTemplate: %v", err)
+			}
+			page, err := os.ReadFile(filepath.Join(wikiDir, "index.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(page) != test.want {
+				t.Errorf("rendered %q, want %q", page, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyPageTemplateCanceled(t *testing.T) {
+	dir := t.TempDir()
+	wikiDir := filepath.Join(dir, "wiki")
+	if err := os.MkdirAll(wikiDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, pageTemplateFileName), []byte(`{{range 10}}.{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(wikiDir, "index.md"), []byte("* Index\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	workspace := Workspace{Dir: dir}
+	tmpl, err := loadPageTemplate(workspace)
+	if err != nil {
+		t.Fatalf("loadPageTemplate: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = applyPageTemplate(ctx, tmpl, workspace, wikiDir, map[string]string{"index": "index"}, ConversionOptions{})
+	if !errors.Is(err, errInvalidTemplate) || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("applyPageTemplate = %v, want the canceled context", err)
+	}
+}
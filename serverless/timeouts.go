@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// timeoutHeader lets a client choose how long its conversion may take, in seconds
+	timeoutHeader = "X-Timeout-Seconds"
+	// defaultConversionTimeout bounds a conversion the client waits for when it sets no timeout
+	defaultConversionTimeout = 5 * time.Minute
+	// defaultMaxTimeoutSeconds caps the timeout clients may ask for unless MAX_TIMEOUT overrides it
+	defaultMaxTimeoutSeconds = 1800
+)
+
+// errStageTimeout is returned when a pipeline stage runs out of its own budget
+var errStageTimeout = errors.New("conversion stage timed out")
+
+// stageTimeoutVariables name the environment variables budgeting each pipeline stage in seconds
+var stageTimeoutVariables = map[string]string{
+	stageExtract: "EXTRACT_TIMEOUT",
+	stageDocgen:  "DOCGEN_TIMEOUT",
+	stageZip:     "PACKAGE_TIMEOUT",
+}
+
+// maxTimeout is the longest timeout a client may ask for, MAX_TIMEOUT seconds
+func maxTimeout() time.Duration {
+	seconds := getEnvInt64("MAX_TIMEOUT", defaultMaxTimeoutSeconds)
+	if seconds <= 0 {
+		seconds = defaultMaxTimeoutSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// parseRequestTimeout reads the timeout header, returning zero when the client did not set one
+func parseRequestTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	limit := maxTimeout()
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > limit {
+		return 0, fmt.Errorf("%s must be a whole number of seconds between 1 and %d", timeoutHeader, int64(limit/time.Second))
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// conversionTimeout is how long a conversion may take: the client's timeout when it set one,
+// otherwise the server's fallback
+func conversionTimeout(requested time.Duration, fallback time.Duration) time.Duration {
+	if requested > 0 {
+		return requested
+	}
+	return fallback
+}
+
+// stageTimeout is the budget of a pipeline stage, zero when only the conversion's timeout applies
+func stageTimeout(stage string) time.Duration {
+	seconds := getEnvInt64(stageTimeoutVariables[stage], 0)
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// withStageTimeout derives the context a pipeline stage runs with from the conversion's
+func withStageTimeout(ctx context.Context, stage string) (context.Context, context.CancelFunc) {
+	if timeout := stageTimeout(stage); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// stageError reports a stage that failed because its own budget ran out as errStageTimeout, and
+// returns any other error as is
+func stageError(ctx context.Context, stageCtx context.Context, stage string, err error) error {
+	if err == nil || ctx.Err() != nil || !errors.Is(stageCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %s took longer than %s (%s)", errStageTimeout, stage, stageTimeout(stage), stageTimeoutVariables[stage])
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certReloader serves the certificate in certFile and keyFile, reloading it on SIGHUP and when
+// either file changes, so renewed certificates are picked up without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	// loaded identifies the file versions the certificate was read from
+	loaded string
+}
+
+// newTLSConfigFromEnv returns the TLS configuration to serve HTTPS with when TLS_CERT and TLS_KEY
+// name a PEM certificate (chain) and key or ACME_DOMAINS lists hosts to obtain certificates for,
+// or nil to serve plain HTTP
+func newTLSConfigFromEnv() (*tls.Config, error) {
+	certFile, keyFile := getEnv("TLS_CERT", ""), getEnv("TLS_KEY", "")
+	if certFile == "" && keyFile == "" {
+		return newACMETLSConfig(), nil
+	}
+	if getEnv("ACME_DOMAINS", "") != "" {
+		return nil, fmt.Errorf("TLS_CERT/TLS_KEY and ACME_DOMAINS cannot be combined")
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS_CERT and TLS_KEY must be set together")
+	}
+
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	go reloader.watch(time.Duration(getEnvInt64("TLS_RELOAD_INTERVAL", 60)) * time.Second)
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// GetCertificate returns the current certificate for every handshake
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// version describes the current state of the certificate and key files
+func (c *certReloader) version() string {
+	var version string
+	for _, file := range []string{c.certFile, c.keyFile} {
+		if info, err := os.Stat(file); err == nil {
+			version += fmt.Sprintf("%d:%d;", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return version
+}
+
+// load reads the certificate and key, keeping the previous pair when they do not form a valid one
+func (c *certReloader) load() error {
+	version := c.version()
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	c.mu.Lock()
+	c.cert, c.loaded = &cert, version
+	c.mu.Unlock()
+	return nil
+}
+
+// watch reloads the certificate on SIGHUP, and every interval when the files changed (0 only
+// reloads on SIGHUP)
+func (c *certReloader) watch(interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hangup:
+		case <-tick:
+			c.mu.RLock()
+			unchanged := c.version() == c.loaded
+			c.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+		}
+		if err := c.load(); err != nil {
+			logger.WithError(err).Error("Failed to reload TLS certificate, keeping the current one")
+			continue
+		}
+		logger.WithField("cert", c.certFile).Info("Reloaded TLS certificate")
+	}
+}
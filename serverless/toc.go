@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Table of contents pages generated with ?toc
+const (
+	// tocIndex writes the table of contents as index.md
+	tocIndex = "index"
+	// tocSummary writes it as SUMMARY.md, the name GitBook-style tools look for
+	tocSummary = "summary"
+)
+
+// tocFileNames maps each ?toc value to the page it writes at the root of the wiki
+var tocFileNames = map[string]string{tocIndex: "index.md", tocSummary: "SUMMARY.md"}
+
+// validTOC reports whether name is a supported table of contents page
+func validTOC(name string) bool {
+	_, ok := tocFileNames[name]
+	return ok
+}
+
+// tocEntry is a converted page listed in the table of contents
+type tocEntry struct {
+	Title      string
+	Link       string
+	Categories []string
+}
+
+// writeTableOfContents adds a page at the root of wikiDir linking to every converted page,
+// grouped by the directory of its source and by its Neorg categories. pages maps each source
+// page (workspace-relative, without .norg) to its page name after the output layout. Sources
+// that cannot be read are listed under their page name.
+func writeTableOfContents(workspace Workspace, wikiDir string, pages map[string]string, options ConversionOptions) error {
+	fileName := tocFileNames[options.TOC]
+	target := filepath.Join(wikiDir, fileName)
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("a page named %s already exists", fileName)
+	}
+
+	byDir := map[string][]tocEntry{}
+	byCategory := map[string][]tocEntry{}
+	for page, name := range pages {
+		entry := tocEntry{Title: path.Base(name), Link: name}
+		if options.Layout != layoutGitHubWiki {
+			entry.Link += ".md"
+		}
+		if source, err := os.ReadFile(filepath.Join(workspace.Dir, filepath.FromSlash(page)+".norg")); err == nil {
+			if title := parseNorg(string(source)).Title(); title != "" {
+				entry.Title = title
+			}
+			entry.Categories = documentMetadata(page, parseDocumentMeta(string(source))).Categories
+		}
+
+		dir := path.Dir(page)
+		byDir[dir] = append(byDir[dir], entry)
+		for _, category := range entry.Categories {
+			byCategory[category] = append(byCategory[category], entry)
+		}
+		if len(entry.Categories) == 0 {
+			byCategory[""] = append(byCategory[""], entry)
+		}
+	}
+
+	values := localeStrings(options.Locale)
+	var toc strings.Builder
+	fmt.Fprintf(&toc, "# %s\n\n## %s\n", values.T("index_title"), values.T("table_of_contents"))
+	for _, dir := range tocGroupNames(byDir) {
+		toc.WriteString("\n")
+		if dir != "." {
+			fmt.Fprintf(&toc, "### %s\n\n", dir)
+		}
+		writeTOCEntries(&toc, byDir[dir])
+	}
+
+	// Only worth a section when some page is categorized
+	categorized := len(byCategory)
+	if _, ok := byCategory[""]; ok {
+		categorized--
+	}
+	if categorized > 0 {
+		fmt.Fprintf(&toc, "\n## %s\n", values.T("categories"))
+		for _, category := range tocGroupNames(byCategory) {
+			if category == "" {
+				continue
+			}
+			fmt.Fprintf(&toc, "\n### %s\n\n", category)
+			writeTOCEntries(&toc, byCategory[category])
+		}
+		if uncategorized := byCategory[""]; uncategorized != nil {
+			fmt.Fprintf(&toc, "\n### %s\n\n", values.T("uncategorized"))
+			writeTOCEntries(&toc, uncategorized)
+		}
+	}
+
+	return os.WriteFile(target, []byte(toc.String()), 0644)
+}
+
+// writeTOCEntries lists entries as links, sorted by title
+func writeTOCEntries(toc *strings.Builder, entries []tocEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Title != entries[j].Title {
+			return entries[i].Title < entries[j].Title
+		}
+		return entries[i].Link < entries[j].Link
+	})
+	for _, entry := range entries {
+		fmt.Fprintf(toc, "- [%s](%s)\n", markdownLinkText(entry.Title), markdownLinkTarget(entry.Link))
+	}
+}
+
+// tocGroupNames returns the names of the entry groups in order
+func tocGroupNames(groups map[string][]tocEntry) []string {
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// norgParserHeader names the parser that produced an /ast or /validate response
+const norgParserHeader = "X-Norg-Parser"
+
+// Parsers parseNorgSyntax can use
+const (
+	parserTreeSitter = "tree-sitter"
+	parserNative     = "native"
+)
+
+// errTreeSitterUnsupported is returned by loadTreeSitterNorg in binaries built without the
+// treesitter tag or without cgo
+var errTreeSitterUnsupported = errors.New("built without tree-sitter support")
+
+// norgSyntaxParser parses Norg source into a document and the syntax errors the parser recovered from
+type norgSyntaxParser func(source string) (NorgDocument, []Diagnostic)
+
+var (
+	treeSitterNorgOnce sync.Once
+	treeSitterNorg     norgSyntaxParser
+)
+
+// norgParserPath is the compiled tree-sitter-norg grammar: NORG_PARSER_PATH, or the shared
+// library nvim-treesitter built for Neovim
+func norgParserPath() string {
+	return getEnv("NORG_PARSER_PATH", filepath.Join(nvimTreesitterDir(), "parser", "norg.so"))
+}
+
+// parseNorgSyntax parses source with tree-sitter-norg, the grammar Neorg itself uses, when the
+// binary was built with the treesitter tag and the grammar loads. Otherwise it falls back to the
+// native parser, which reports no syntax errors. It also returns the name of the parser used.
+func parseNorgSyntax(source string) (NorgDocument, []Diagnostic, string) {
+	treeSitterNorgOnce.Do(func() {
+		path := norgParserPath()
+		parser, err := loadTreeSitterNorg(path)
+		if err != nil {
+			if !errors.Is(err, errTreeSitterUnsupported) {
+				logger.WithFields(logrus.Fields{
+					"path":  path,
+					"error": err,
+				}).Warn("Failed to load the tree-sitter-norg grammar, using the native parser")
+			}
+			return
+		}
+		treeSitterNorg = parser
+	})
+
+	if treeSitterNorg == nil {
+		return parseNorg(source), nil, parserNative
+	}
+	doc, syntaxErrors := treeSitterNorg(source)
+	return doc, syntaxErrors, parserTreeSitter
+}
@@ -0,0 +1,232 @@
+//go:build cgo && treesitter && unix
+
+package main
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+
+typedef const void *(*language_function)(void);
+
+// load_language opens a compiled tree-sitter grammar and returns the language its symbol defines.
+// The library stays loaded for the life of the process.
+static const void *load_language(const char *path, const char *symbol, const char **error) {
+	void *handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (handle == NULL) {
+		*error = dlerror();
+		return NULL;
+	}
+	language_function language = (language_function)dlsym(handle, symbol);
+	if (language == NULL) {
+		*error = dlerror();
+		return NULL;
+	}
+	return language();
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// norgLeveledKind matches the tree-sitter-norg nodes numbered by nesting level, like heading2 or
+// unordered_list3
+var norgLeveledKind = regexp.MustCompile(`^(heading|unordered_list|ordered_list|quote)([1-6])$`)
+
+// loadTreeSitterNorg loads the tree-sitter-norg grammar compiled at path, the shared library
+// nvim-treesitter builds, and returns a parser using it
+func loadTreeSitterNorg(path string) (norgSyntaxParser, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	cSymbol := C.CString("tree_sitter_norg")
+	defer C.free(unsafe.Pointer(cSymbol))
+
+	var cError *C.char
+	pointer := C.load_language(cPath, cSymbol, &cError)
+	if pointer == nil {
+		return nil, fmt.Errorf("failed to load %s: %s", path, C.GoString(cError))
+	}
+	language := sitter.NewLanguage(unsafe.Pointer(pointer))
+
+	// Reject a grammar built for an incompatible tree-sitter ABI now rather than on every request
+	parser := sitter.NewParser()
+	defer parser.Close()
+	if err := parser.SetLanguage(language); err != nil {
+		return nil, err
+	}
+
+	return func(source string) (NorgDocument, []Diagnostic) {
+		return parseTreeSitterNorg(language, source)
+	}, nil
+}
+
+// parseTreeSitterNorg parses source with the norg grammar into the same document the native
+// parser produces, with the grammar deciding what each line is
+func parseTreeSitterNorg(language *sitter.Language, source string) (NorgDocument, []Diagnostic) {
+	text := strings.ReplaceAll(source, "\r\n", "\n")
+	parser := sitter.NewParser()
+	defer parser.Close()
+	parser.SetLanguage(language)
+	tree := parser.Parse([]byte(text), nil)
+	defer tree.Close()
+
+	walker := norgTreeWalker{
+		source:   []byte(text),
+		lines:    strings.Split(text, "\n"),
+		doc:      NorgDocument{Meta: map[string]string{}},
+		verbatim: map[int]bool{},
+	}
+	walker.walk(tree.RootNode())
+
+	// Blank lines are not part of the tree; the native parser reports them as blocks
+	for i, line := range walker.lines {
+		if strings.TrimSpace(line) == "" && !walker.verbatim[i] {
+			walker.doc.Blocks = append(walker.doc.Blocks, NorgBlock{Type: NorgBlank, Line: i + 1})
+		}
+	}
+	sort.SliceStable(walker.doc.Blocks, func(i, j int) bool { return walker.doc.Blocks[i].Line < walker.doc.Blocks[j].Line })
+	return walker.doc, walker.diagnostics
+}
+
+// norgTreeWalker collects the blocks and syntax errors of a tree-sitter-norg syntax tree
+type norgTreeWalker struct {
+	source      []byte
+	lines       []string
+	doc         NorgDocument
+	diagnostics []Diagnostic
+	// verbatim holds the rows of ranged verbatim tags, whose blank lines are content
+	verbatim map[int]bool
+}
+
+func (w *norgTreeWalker) walk(node *sitter.Node) {
+	row := int(node.StartPosition().Row)
+	kind := node.Kind()
+
+	switch {
+	case node.IsError():
+		context, _, _ := strings.Cut(strings.TrimSpace(node.Utf8Text(w.source)), "\n")
+		if len(context) > 40 {
+			context = context[:40] + "..."
+		}
+		w.diagnostics = append(w.diagnostics, Diagnostic{Line: row + 1, Severity: severityError, Message: fmt.Sprintf("syntax error near %q", context)})
+		return
+
+	case node.IsMissing():
+		w.diagnostics = append(w.diagnostics, Diagnostic{Line: row + 1, Severity: severityError, Message: fmt.Sprintf("missing %s", kind)})
+		return
+
+	case kind == "ranged_verbatim_tag":
+		w.rangedVerbatimTag(node)
+		return
+
+	case kind == "paragraph_segment":
+		w.doc.Blocks = append(w.doc.Blocks, NorgBlock{Type: NorgParagraph, Line: row + 1, Text: strings.TrimSpace(node.Utf8Text(w.source))})
+		return
+
+	case kind == "horizontal_line":
+		w.doc.Blocks = append(w.doc.Blocks, NorgBlock{Type: NorgRule, Line: row + 1})
+		return
+
+	case kind == "strong_paragraph_delimiter" || kind == "weak_paragraph_delimiter":
+		w.doc.Blocks = append(w.doc.Blocks, NorgBlock{Type: NorgBlank, Line: row + 1})
+		return
+
+	case norgLeveledKind.MatchString(kind):
+		match := norgLeveledKind.FindStringSubmatch(kind)
+		level, _ := strconv.Atoi(match[2])
+		w.doc.Blocks = append(w.doc.Blocks, w.leveledBlock(match[1], level, row))
+		// The prefix, title, state and content sit on the node's first line; only nested items
+		// and a heading's content start below it
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			if child := node.NamedChild(i); int(child.StartPosition().Row) > row {
+				w.walk(child)
+			}
+		}
+		return
+	}
+
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		w.walk(node.NamedChild(i))
+	}
+}
+
+// leveledBlock reads a heading, list item, task or quote the grammar found at row, taking its
+// text and task status from the line like the native parser
+func (w *norgTreeWalker) leveledBlock(kind string, level int, row int) NorgBlock {
+	line := strings.TrimSpace(w.lines[row])
+	block := NorgBlock{Line: row + 1, Level: level, Text: line}
+
+	switch kind {
+	case "heading":
+		block.Type = NorgHeading
+		if match := norgHeadingPattern.FindStringSubmatch(line); match != nil {
+			block.Text = match[2]
+		}
+	case "quote":
+		block.Type = NorgQuote
+		if match := norgQuotePattern.FindStringSubmatch(line); match != nil {
+			block.Text = match[2]
+		}
+	default:
+		block.Type = NorgUnorderedItem
+		if kind == "ordered_list" {
+			block.Type = NorgOrderedItem
+		}
+		if match := norgTaskPattern.FindStringSubmatch(line); match != nil {
+			block.Type, block.Text, block.Status = NorgTask, match[3], strings.TrimSpace(match[2])
+			block.Done = block.Status == "x"
+		} else if match := norgListPattern.FindStringSubmatch(line); match != nil {
+			block.Text = match[2]
+		}
+	}
+	return block
+}
+
+// rangedVerbatimTag reads an @tag ... @end block: @document.meta into the document's metadata,
+// @code and any other verbatim tag into a code block
+func (w *norgTreeWalker) rangedVerbatimTag(node *sitter.Node) {
+	start, end := int(node.StartPosition().Row), int(node.EndPosition().Row)
+	if node.EndPosition().Column == 0 && end > start {
+		end--
+	}
+	for row := start; row <= end; row++ {
+		w.verbatim[row] = true
+	}
+	bodyEnd := end
+	if strings.TrimSpace(w.lines[end]) == "@end" {
+		bodyEnd--
+	}
+
+	header := strings.TrimSpace(w.lines[start])
+	if header == "@document.meta" {
+		for row := start + 1; row <= bodyEnd; row++ {
+			if match := norgMetaPattern.FindStringSubmatch(strings.TrimSpace(w.lines[row])); match != nil {
+				if _, seen := w.doc.Meta[match[1]]; !seen {
+					w.doc.MetaOrder = append(w.doc.MetaOrder, match[1])
+				}
+				w.doc.Meta[match[1]] = strings.TrimSpace(match[2])
+			}
+		}
+		return
+	}
+
+	block := NorgBlock{Type: NorgCodeBlock, Line: start + 1, Lines: []string{}}
+	if match := norgCodePattern.FindStringSubmatch(header); match != nil {
+		block.Language = match[1]
+	}
+	raw := w.lines[start]
+	indent := raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))]
+	for row := start + 1; row <= bodyEnd; row++ {
+		block.Lines = append(block.Lines, strings.TrimPrefix(w.lines[row], indent))
+	}
+	w.doc.Blocks = append(w.doc.Blocks, block)
+}
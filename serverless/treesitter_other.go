@@ -0,0 +1,9 @@
+//go:build !cgo || !treesitter || !unix
+
+package main
+
+// loadTreeSitterNorg is unavailable without cgo and the treesitter build tag; the native parser
+// is used instead
+func loadTreeSitterNorg(path string) (norgSyntaxParser, error) {
+	return nil, errTreeSitterUnsupported
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiAssets embed.FS
+
+// uiOptions are the conversion options the upload UI offers, named after their query parameters
+var uiOptions = []string{"output", "layout", "profile"}
+
+// uiHandler serves the embedded single-page upload UI under /ui/, and at /ui/options.json the
+// values this server accepts for the options the page lets users pick
+func uiHandler() http.Handler {
+	assets, err := fs.Sub(uiAssets, "ui")
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load embedded UI assets")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ui/options.json", uiOptionsHandler)
+	mux.Handle("/ui/", http.StripPrefix("/ui/", http.FileServer(http.FS(assets))))
+	return mux
+}
+
+// uiOptionsHandler lists the accepted values of every option in uiOptions. Like /openapi.json it
+// needs no auth token.
+func uiOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	options := map[string][]string{}
+	for _, name := range uiOptions {
+		options[name] = conversionParameterValues[name]()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(options)
+}
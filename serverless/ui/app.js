@@ -0,0 +1,287 @@
+(function () {
+  "use strict";
+
+  var form = document.getElementById("convert-form");
+  var tokenInput = document.getElementById("token");
+  var rootInput = document.getElementById("root");
+  var optionSelects = Array.prototype.slice.call(document.querySelectorAll("select[data-option]"));
+  var fileInput = document.getElementById("file");
+  var dropzone = document.getElementById("dropzone");
+  var dropzoneLabel = document.getElementById("dropzone-label");
+  var submit = document.getElementById("submit");
+  var status = document.getElementById("status");
+  var progress = document.getElementById("progress");
+  var statusText = document.getElementById("status-text");
+  var download = document.getElementById("download");
+
+  var selectedFile = null;
+
+  // Polling interval of a background job's status, in milliseconds
+  var jobPollInterval = 1000;
+
+  tokenInput.value = localStorage.getItem("neorg-docs-token") || "";
+
+  // Offer the values this server accepts for each option
+  fetch("options.json").then(function (response) {
+    return response.ok ? response.json() : {};
+  }).then(function (values) {
+    optionSelects.forEach(function (select) {
+      (values[select.dataset.option] || []).forEach(function (value) {
+        var option = document.createElement("option");
+        option.value = value;
+        option.textContent = value;
+        select.appendChild(option);
+      });
+    });
+  }).catch(function () {});
+
+  function selectFile(file) {
+    selectedFile = file;
+    dropzoneLabel.textContent = file ? file.name + " (" + Math.ceil(file.size / 1024) + " KiB)" : "Drag and drop an archive here, or click to choose a file";
+    submit.disabled = !file;
+  }
+
+  function setStatus(text, isError) {
+    status.hidden = false;
+    statusText.textContent = text;
+    statusText.className = isError ? "error" : "";
+  }
+
+  // errorMessage reads the error of a JSON error response, falling back to the raw body
+  function errorMessage(body) {
+    try {
+      var parsed = JSON.parse(body);
+      return parsed.error || (parsed.error_details && parsed.error_details.error) || body;
+    } catch (ignored) {
+      return body;
+    }
+  }
+
+  // attachmentName reads the file name from a Content-Disposition header
+  function attachmentName(disposition, fallback) {
+    var match = /filename="([^"]+)"/.exec(disposition || "");
+    return match ? match[1] : fallback;
+  }
+
+  function finished(blob, filename) {
+    submit.disabled = false;
+    progress.max = 100;
+    progress.value = 100;
+    download.href = URL.createObjectURL(blob);
+    download.download = filename;
+    download.hidden = false;
+    setStatus("Conversion finished.", false);
+  }
+
+  function failed(statusCode, body) {
+    submit.disabled = false;
+    progress.max = 100;
+    progress.value = 100;
+    setStatus("Conversion failed (" + statusCode + "): " + errorMessage(body), true);
+  }
+
+  function describeJob(job) {
+    if (job.status === "queued") {
+      var text = "Queued";
+      if (job.queue_position) {
+        text += " (position " + job.queue_position;
+        if (job.estimated_wait_seconds) {
+          text += ", about " + job.estimated_wait_seconds + "s";
+        }
+        text += ")";
+      }
+      return text + "…";
+    }
+    return "Converting" + (job.stage ? ": " + job.stage : "") + " (" + (job.percent || 0) + "%)…";
+  }
+
+  // fetchResult downloads a finished job's result, or reports the error it failed with
+  function fetchResult(job) {
+    fetch(job.result_url, { headers: { "x-auth-token": tokenInput.value } }).then(function (response) {
+      if (!response.ok) {
+        return response.text().then(function (body) { failed(response.status, body); });
+      }
+      var filename = attachmentName(response.headers.get("Content-Disposition"), "neorg_documentation_" + job.id);
+      return response.blob().then(function (blob) { finished(blob, filename); });
+    }).catch(function () {
+      failed(0, "Network error while downloading the result.");
+    });
+  }
+
+  function showJob(job) {
+    progress.max = 100;
+    progress.value = job.percent || 0;
+    setStatus(describeJob(job), false);
+  }
+
+  // followJob streams a background job's progress from its event stream until it has finished.
+  // The stream is read with fetch rather than EventSource, which cannot send the auth token; when
+  // it is unavailable or breaks off the job's status is polled instead.
+  function followJob(job) {
+    showJob(job);
+    if (!window.ReadableStream || !window.TextDecoder) {
+      pollJob(job);
+      return;
+    }
+
+    var latest = job;
+    var done = false;
+    fetch(job.status_url + "/events", { headers: { "x-auth-token": tokenInput.value } }).then(function (response) {
+      if (!response.ok || !response.body) {
+        throw new Error("event stream unavailable");
+      }
+      var reader = response.body.getReader();
+      var decoder = new TextDecoder();
+      var buffer = "";
+
+      function read() {
+        return reader.read().then(function (chunk) {
+          if (chunk.done) {
+            return;
+          }
+          buffer += decoder.decode(chunk.value, { stream: true });
+          var events = buffer.split("\n\n");
+          buffer = events.pop();
+          events.forEach(function (block) {
+            var name = "message";
+            var data = "";
+            block.split("\n").forEach(function (line) {
+              if (line.indexOf("event: ") === 0) {
+                name = line.slice(7);
+              } else if (line.indexOf("data: ") === 0) {
+                data += line.slice(6);
+              }
+            });
+            if (data === "") {
+              return;
+            }
+            latest = JSON.parse(data);
+            if (name === "done") {
+              done = true;
+            }
+            showJob(latest);
+          });
+          return done ? reader.cancel() : read();
+        });
+      }
+      return read();
+    }).then(function () {
+      if (done) {
+        fetchResult(latest);
+      } else {
+        pollJob(latest);
+      }
+    }).catch(function () {
+      pollJob(latest);
+    });
+  }
+
+  // pollJob follows a background job by polling its status until it has finished
+  function pollJob(job) {
+    showJob(job);
+
+    if (job.status === "succeeded" || job.status === "failed") {
+      fetchResult(job);
+      return;
+    }
+    setTimeout(function () {
+      fetch(job.status_url, { headers: { "x-auth-token": tokenInput.value } }).then(function (response) {
+        if (!response.ok) {
+          return response.text().then(function (body) { failed(response.status, body); });
+        }
+        return response.json().then(pollJob);
+      }).catch(function () {
+        failed(0, "Network error while checking the conversion.");
+      });
+    }, jobPollInterval);
+  }
+
+  dropzone.addEventListener("click", function () { fileInput.click(); });
+  dropzone.addEventListener("keydown", function (event) {
+    if (event.key === "Enter" || event.key === " ") {
+      event.preventDefault();
+      fileInput.click();
+    }
+  });
+  fileInput.addEventListener("change", function () { selectFile(fileInput.files[0] || null); });
+
+  ["dragenter", "dragover"].forEach(function (name) {
+    dropzone.addEventListener(name, function (event) {
+      event.preventDefault();
+      dropzone.classList.add("active");
+    });
+  });
+  ["dragleave", "drop"].forEach(function (name) {
+    dropzone.addEventListener(name, function (event) {
+      event.preventDefault();
+      dropzone.classList.remove("active");
+    });
+  });
+  dropzone.addEventListener("drop", function (event) {
+    selectFile(event.dataTransfer.files[0] || null);
+  });
+
+  form.addEventListener("submit", function (event) {
+    event.preventDefault();
+    if (!selectedFile) {
+      return;
+    }
+
+    localStorage.setItem("neorg-docs-token", tokenInput.value);
+
+    var query = [];
+    if (rootInput.value.trim() !== "") {
+      query.push("root=" + encodeURIComponent(rootInput.value.trim()));
+    }
+    optionSelects.forEach(function (select) {
+      if (select.value !== "") {
+        query.push(select.dataset.option + "=" + encodeURIComponent(select.value));
+      }
+    });
+    var url = "/" + (query.length > 0 ? "?" + query.join("&") : "");
+
+    var xhr = new XMLHttpRequest();
+    xhr.open("POST", url);
+    xhr.setRequestHeader("Content-Type", "application/x-tar");
+    xhr.setRequestHeader("x-auth-token", tokenInput.value);
+    xhr.responseType = "blob";
+
+    submit.disabled = true;
+    download.hidden = true;
+    progress.value = 0;
+    setStatus("Uploading…", false);
+
+    xhr.upload.addEventListener("progress", function (event) {
+      if (event.lengthComputable) {
+        progress.value = Math.round((event.loaded / event.total) * 100);
+      }
+    });
+    xhr.upload.addEventListener("load", function () {
+      progress.removeAttribute("value");
+      setStatus("Converting…", false);
+    });
+
+    xhr.addEventListener("load", function () {
+      if (xhr.status === 200) {
+        var requestId = xhr.getResponseHeader("request-id") || "documentation";
+        finished(xhr.response, attachmentName(xhr.getResponseHeader("Content-Disposition"), "neorg_documentation_" + requestId + ".zip"));
+        return;
+      }
+
+      xhr.response.text().then(function (body) {
+        // Large uploads, or the server's defaults, turn the request into a background job
+        if (xhr.status === 202) {
+          followJob(JSON.parse(body));
+          return;
+        }
+        failed(xhr.status, body);
+      });
+    });
+    xhr.addEventListener("error", function () {
+      submit.disabled = false;
+      setStatus("Network error while uploading the archive.", true);
+    });
+
+    xhr.send(selectedFile);
+  });
+})();
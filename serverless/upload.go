@@ -0,0 +1,344 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// multipartArchiveField is the form field holding the project archive
+	multipartArchiveField = "project"
+	// multipartOptionsField is the optional form field holding conversion options as JSON
+	multipartOptionsField = "options"
+	// maxMultipartOptionsBytes bounds the options field, which is read into memory
+	maxMultipartOptionsBytes = 64 << 10
+	// multipartBaselineField is the optional form field holding a previous result's manifest.json,
+	// for baselines too large for the X-Baseline-Manifest header
+	multipartBaselineField = "baseline"
+	// maxBaselineManifestBytes bounds an uploaded baseline manifest
+	maxBaselineManifestBytes = 16 << 20
+)
+
+// errSourceDownload is returned when the archive at a JSON upload's source_url cannot be downloaded
+var errSourceDownload = errors.New("failed to download source_url")
+
+// JSONUpload is a JSON request body carrying the project's files inline, for clients that already
+// hold them in memory and would otherwise have to build a tarball, or the URL of an archive the
+// service downloads itself, e.g. a presigned S3 URL for archives too large for the API gateway
+type JSONUpload struct {
+	// Files maps slash-separated archive paths to base64 encoded file contents
+	Files map[string]string `json:"files,omitempty"`
+	// SourceURL is an https URL of a tarball to download instead of sending files
+	SourceURL string `json:"source_url,omitempty"`
+	// Options holds conversion options keyed like the query parameters
+	Options map[string]interface{} `json:"options,omitempty"`
+	// Baseline is a previous result's manifest.json, requesting an incremental delta result
+	Baseline json.RawMessage `json:"baseline,omitempty"`
+}
+
+// unpackUpload turns multipart/form-data and JSON uploads into a spooled archive so the rest of the
+// pipeline need not care how the project was sent; raw archive uploads are left alone and return a
+// nil archive. A source_url upload returns its checked URL instead, to be fetched with
+// downloadSourceURL once the options and the rest of the request have been validated.
+func unpackUpload(r *http.Request) (*spooledArchive, string, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", nil
+	}
+	switch mediaType {
+	case "multipart/form-data":
+		archive, err := readMultipartUpload(r)
+		return archive, "", err
+	case "application/json":
+		return readJSONUpload(r)
+	}
+	return nil, "", nil
+}
+
+// readMultipartUpload unpacks a multipart/form-data upload: the "project" field is spooled as the
+// archive, and the optional "options" JSON object applies like query parameters
+func readMultipartUpload(r *http.Request) (_ *spooledArchive, err error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	var archive *spooledArchive
+	defer func() {
+		if err != nil && archive != nil {
+			archive.Close()
+		}
+	}()
+	var options map[string]interface{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading multipart body: %w", err)
+		}
+
+		switch part.FormName() {
+		case multipartArchiveField:
+			// A repeated field replaces the earlier one
+			if archive != nil {
+				archive.Close()
+			}
+			archive, err = spoolArchive(part)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %q field: %w", multipartArchiveField, err)
+			}
+		case multipartOptionsField:
+			data, err := io.ReadAll(io.LimitReader(part, maxMultipartOptionsBytes+1))
+			if err != nil {
+				return nil, fmt.Errorf("error reading %q field: %w", multipartOptionsField, err)
+			}
+			if len(data) > maxMultipartOptionsBytes {
+				return nil, fmt.Errorf("%q field exceeds %d bytes", multipartOptionsField, maxMultipartOptionsBytes)
+			}
+			if err := json.Unmarshal(data, &options); err != nil {
+				return nil, fmt.Errorf("%q field must be a JSON object: %v", multipartOptionsField, err)
+			}
+		case multipartBaselineField:
+			data, err := io.ReadAll(io.LimitReader(part, maxBaselineManifestBytes+1))
+			if err != nil {
+				return nil, fmt.Errorf("error reading %q field: %w", multipartBaselineField, err)
+			}
+			if len(data) > maxBaselineManifestBytes {
+				return nil, fmt.Errorf("%q field exceeds %d bytes", multipartBaselineField, maxBaselineManifestBytes)
+			}
+			setBaselineManifest(r, data)
+		}
+		part.Close()
+	}
+	if archive == nil {
+		return nil, fmt.Errorf("missing %q field", multipartArchiveField)
+	}
+
+	if err := applyUploadOptions(r, options); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// readJSONUpload packs the inline files of a JSON upload into a spooled tarball, or returns the
+// source_url to download
+func readJSONUpload(r *http.Request) (*spooledArchive, string, error) {
+	var upload JSONUpload
+	if err := json.NewDecoder(r.Body).Decode(&upload); err != nil {
+		return nil, "", fmt.Errorf("invalid JSON body: %w", err)
+	}
+	if len(upload.Baseline) > 0 {
+		setBaselineManifest(r, upload.Baseline)
+	}
+	if upload.SourceURL != "" {
+		if len(upload.Files) > 0 {
+			return nil, "", fmt.Errorf("files and source_url cannot be combined")
+		}
+		if _, err := checkSourceURL(upload.SourceURL); err != nil {
+			return nil, "", err
+		}
+		if err := applyUploadOptions(r, upload.Options); err != nil {
+			return nil, "", err
+		}
+		return nil, upload.SourceURL, nil
+	}
+	if len(upload.Files) == 0 {
+		return nil, "", fmt.Errorf("files must contain at least one file")
+	}
+
+	files := make(map[string][]byte, len(upload.Files))
+	for name, encoded := range upload.Files {
+		cleaned, err := cleanArchivePath(name)
+		if err != nil || cleaned == "" {
+			return nil, "", fmt.Errorf("invalid file path %q", name)
+		}
+		content, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, "", fmt.Errorf("file %q is not valid base64: %v", name, err)
+		}
+		files[cleaned] = content
+	}
+
+	if err := applyUploadOptions(r, upload.Options); err != nil {
+		return nil, "", err
+	}
+	archive, err := tarFiles(files)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to pack files: %v", err)
+	}
+	spooled, err := spoolBytes(archive)
+	return spooled, "", err
+}
+
+// checkSourceURL parses the source_url of an upload. Only https URLs are accepted, and when
+// SOURCE_URL_ALLOWED_HOSTS is set only its hosts; entries starting with a dot match subdomains
+// (".amazonaws.com").
+func checkSourceURL(rawURL string) (*url.URL, error) {
+	source, err := url.Parse(rawURL)
+	if err != nil || source.Scheme != "https" || source.Host == "" {
+		return nil, fmt.Errorf("source_url must be an absolute https URL")
+	}
+	if allowed := getEnv("SOURCE_URL_ALLOWED_HOSTS", ""); allowed != "" {
+		host := strings.ToLower(source.Hostname())
+		permitted := false
+		for _, entry := range strings.Split(allowed, ",") {
+			entry = strings.ToLower(strings.TrimSpace(entry))
+			if host == entry || (strings.HasPrefix(entry, ".") && strings.HasSuffix(host, entry)) {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return nil, fmt.Errorf("source_url host %q is not allowed", source.Hostname())
+		}
+	}
+	return source, nil
+}
+
+// downloadSourceURL fetches the archive of a source_url upload
+func downloadSourceURL(r *http.Request, rawURL string) (*spooledArchive, error) {
+	source, err := checkSourceURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Presigned URLs carry credentials in the query string, which must not reach the logs
+	logURL := *source
+	logURL.RawQuery = ""
+	logger.WithField("source_url", logURL.Redacted()).Info("Downloading archive from source_url")
+	archive, err := fetchArchive(r.Context(), sourceFetchClient, source.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errSourceDownload, err)
+	}
+	return archive, nil
+}
+
+// setBaselineManifest passes a baseline manifest sent in the body on like the X-Baseline-Manifest
+// header, which takes precedence when both are sent
+func setBaselineManifest(r *http.Request, manifest []byte) {
+	if r.Header.Get(baselineManifestHeader) == "" {
+		r.Header.Set(baselineManifestHeader, base64.StdEncoding.EncodeToString(manifest))
+	}
+}
+
+// rejectInvalidUpload answers an error of unpackUpload or downloadSourceURL and reports whether
+// there was one
+func rejectInvalidUpload(w http.ResponseWriter, requestId string, err error) bool {
+	if err == nil {
+		return false
+	}
+	if rejectUploadTooLarge(w, requestId, err) {
+		return true
+	}
+	logger.WithFields(logrus.Fields{
+		"request_id": requestId,
+		"error":      err.Error(),
+	}).Warn("Invalid upload")
+	w.WriteHeader(uploadErrorStatus(err))
+	json.NewEncoder(w).Encode(Response{
+		Error: fmt.Sprintf("Invalid upload: %v", err),
+		Code:  uploadErrorCode(err),
+		Id:    requestId,
+	})
+	return true
+}
+
+// uploadErrorStatus is the status answering a failed unpackUpload or downloadSourceURL: 502 when
+// the source_url download failed and 400 for malformed uploads
+func uploadErrorStatus(err error) int {
+	if errors.Is(err, errSourceDownload) {
+		return http.StatusBadGateway
+	}
+	return http.StatusBadRequest
+}
+
+// uploadErrorCode is the error code matching uploadErrorStatus
+func uploadErrorCode(err error) string {
+	if errors.Is(err, errSourceDownload) {
+		return codeUpstreamFailed
+	}
+	return codeInvalidArchive
+}
+
+// applyUploadOptions applies the options sent with an upload like query parameters; query
+// parameters given explicitly take precedence
+func applyUploadOptions(r *http.Request, options map[string]interface{}) error {
+	query := r.URL.Query()
+	for key, value := range options {
+		if query.Has(key) {
+			continue
+		}
+		formatted, err := formatOptionValue(value)
+		if err != nil {
+			return fmt.Errorf("option %q: %v", key, err)
+		}
+		query.Set(key, formatted)
+	}
+	r.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// tarFiles packs files, keyed by slash-separated path, into an uncompressed tarball in path order
+func tarFiles(files map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// formatOptionValue renders a JSON option value the way it would be written as a query
+// parameter; lists such as modules_allow become comma-separated
+func formatOptionValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("list items must be strings")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("must be a string, number, boolean or list of strings")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+const (
+	// userConfigFileName is the optional Neorg configuration overlay read from the workspace root
+	userConfigFileName = "neorg_config.lua"
+	// maxUserConfigBytes caps the size of the overlay
+	maxUserConfigBytes = 64 << 10
+)
+
+// errInvalidUserConfig marks a neorg_config.lua rejected before conversion
+var errInvalidUserConfig = errors.New("invalid " + userConfigFileName)
+
+// stageUserConfig validates a workspace's neorg_config.lua and copies it into the docgen directory,
+// where the Neovim config evaluates it in an empty sandbox. It reports whether an overlay was staged.
+func stageUserConfig(projectDir, docgenDir string) (bool, error) {
+	source, err := os.ReadFile(filepath.Join(projectDir, userConfigFileName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", errInvalidUserConfig, err)
+	}
+
+	if err := validateLuaSource(source, maxUserConfigBytes); err != nil {
+		return false, fmt.Errorf("%w: %v", errInvalidUserConfig, err)
+	}
+
+	err = os.WriteFile(filepath.Join(docgenDir, userConfigFileName), source, 0644)
+	if err != nil {
+		return false, fmt.Errorf("failed to stage %s: %v", userConfigFileName, err)
+	}
+	return true, nil
+}
+
+// validateLuaSource checks Lua source sent by a client before Neovim loads it: at most limit bytes
+// of UTF-8 text, since loadstring would also run precompiled bytecode
+func validateLuaSource(source []byte, limit int) error {
+	switch {
+	case len(source) > limit:
+		return fmt.Errorf("larger than %d bytes", limit)
+	case bytes.HasPrefix(source, []byte("\x1bLua")), bytes.HasPrefix(source, []byte("\x1bLJ")):
+		return fmt.Errorf("precompiled bytecode is not accepted")
+	case !utf8.Valid(source) || bytes.IndexByte(source, 0) >= 0:
+		return fmt.Errorf("must be UTF-8 text")
+	}
+	return nil
+}
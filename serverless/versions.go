@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// VersionsResponse is the response body of GET /versions: the versions of the service and of the
+// tools whose behaviour shapes its output
+type VersionsResponse struct {
+	// Service is the service's module version and VCS revision
+	Service string `json:"service"`
+	// Converter identifies the revision of the Lua docgen scripts and the pandoc Norg reader
+	Converter string `json:"converter"`
+	// Go is the Go toolchain the service was built with
+	Go string `json:"go"`
+	// Nvim and Pandoc are the first lines of their --version output, empty when not installed
+	Nvim   string `json:"nvim,omitempty"`
+	Pandoc string `json:"pandoc,omitempty"`
+	// Neorg is the commit of the Neorg plugin conversions use by default
+	Neorg string `json:"neorg"`
+	// NeorgVersions lists the pinned versions selectable with X-Neorg-Version
+	NeorgVersions []string `json:"neorg_versions"`
+	// TreeSitterNorg is the revision of the installed norg tree-sitter parser
+	TreeSitterNorg string `json:"tree_sitter_norg"`
+}
+
+// versionsHandler answers GET /versions, so clients can explain output differences between
+// servers and pin themselves to compatible ones
+func versionsHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("request-id", requestId)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Method not allowed",
+			Code:  codeMethodNotAllowed,
+			Id:    requestId,
+		})
+		return
+	}
+
+	versions := builderVersions()
+	neorgVersions := installedNeorgVersions()
+	if neorgVersions == nil {
+		neorgVersions = []string{}
+	}
+	json.NewEncoder(w).Encode(VersionsResponse{
+		Service:        versions["service"],
+		Converter:      versions["converter"],
+		Go:             versions["go"],
+		Nvim:           versions["nvim"],
+		Pandoc:         versions["pandoc"],
+		Neorg:          versions["neorg"],
+		NeorgVersions:  neorgVersions,
+		TreeSitterNorg: versions["tree-sitter-norg"],
+	})
+}
+
+// converterRevision digests the docgen scripts the converters run, so servers built from the same
+// sources report the same revision
+func converterRevision() string {
+	entries, err := os.ReadDir("./docgen")
+	if err != nil {
+		return "unknown"
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	sum := sha256.New()
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join("./docgen", name))
+		if err != nil {
+			return "unknown"
+		}
+		sum.Write([]byte(name + "\n"))
+		sum.Write(data)
+	}
+	return hex.EncodeToString(sum.Sum(nil))[:12]
+}
+
+// treeSitterNorgRevision reads the revision of the norg parser nvim-treesitter installed, falling
+// back to the revision its lockfile pins
+func treeSitterNorgRevision() string {
+	pluginDir := nvimTreesitterDir()
+	if data, err := os.ReadFile(filepath.Join(pluginDir, "parser-info", "norg.revision")); err == nil {
+		if revision := strings.TrimSpace(string(data)); revision != "" {
+			return revision
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(pluginDir, "lockfile.json"))
+	if err != nil {
+		return "unknown"
+	}
+	var lock map[string]struct {
+		Revision string `json:"revision"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil || lock["norg"].Revision == "" {
+		return "unknown"
+	}
+	return lock["norg"].Revision
+}
+
+// nvimTreesitterDir is where lazy.nvim installed nvim-treesitter and the parsers it compiled
+func nvimTreesitterDir() string {
+	dataHome := getEnv("XDG_DATA_HOME", filepath.Join(os.Getenv("HOME"), ".local", "share"))
+	return filepath.Join(dataHome, "nvim", "lazy", "nvim-treesitter")
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// vimdocWidth is the text width of generated help files
+	vimdocWidth = 78
+	// vimdocModeline closes every generated help file
+	vimdocModeline = " vim:tw=78:ts=8:ft=help:norl:"
+)
+
+var (
+	// vimdocHeadingPattern matches markdown ATX headings
+	vimdocHeadingPattern = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+	// vimdocPageLinkPattern matches links to other generated pages
+	vimdocPageLinkPattern = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s#?:]+)\.md(?:#[^)\s]*)?\)`)
+	// vimdocURLLinkPattern matches links to external pages
+	vimdocURLLinkPattern = regexp.MustCompile(`\[([^\]]*)\]\(([a-z]+:[^)\s]+)\)`)
+	// vimdocEmphasisPattern matches bold and italic markers around words
+	vimdocEmphasisPattern = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	// vimdocTagPattern matches the characters not allowed in help tags
+	vimdocTagPattern = regexp.MustCompile(`[^a-z0-9_.-]+`)
+)
+
+// vimdocTag turns text into a help tag fragment
+func vimdocTag(text string) string {
+	return strings.Trim(vimdocTagPattern.ReplaceAllString(strings.ToLower(text), "-"), "-")
+}
+
+// vimdocFileName is the flat file name of a page's help file, which is also its tag
+func vimdocFileName(pagePath string) string {
+	return vimdocTag(strings.ReplaceAll(strings.TrimSuffix(pagePath, ".md"), "/", "-")) + ".txt"
+}
+
+// vimdocRightAlign writes left and right on one line, right aligned to the text width
+func vimdocRightAlign(left string, right string) string {
+	padding := vimdocWidth - len([]rune(left)) - len([]rune(right))
+	if padding < 1 {
+		padding = 1
+	}
+	return left + strings.Repeat(" ", padding) + right
+}
+
+// renderVimdocPages replaces every generated .md page under wikiDir with a Vim help file, flat in
+// wikiDir so the result can be unpacked into a plugin's doc/ directory and indexed with :helptags.
+// Headings become tagged sections and links between pages become help links.
+func renderVimdocPages(wikiDir string) error {
+	var pages []string
+	err := filepath.Walk(wikiDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, ".md") {
+			pages = append(pages, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, page := range pages {
+		rel, err := filepath.Rel(wikiDir, page)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		markdown, err := os.ReadFile(page)
+		if err != nil {
+			return err
+		}
+
+		fileName := vimdocFileName(rel)
+		help := markdownToVimdoc(rel, fileName, string(markdown))
+		if err := os.Remove(page); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(wikiDir, fileName), []byte(help), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markdownToVimdoc renders one markdown page as a Vim help file named fileName
+func markdownToVimdoc(pagePath string, fileName string, markdown string) string {
+	title := markdownPageTitle([]byte(markdown), strings.TrimSuffix(filepath.Base(pagePath), ".md"))
+	prefix := strings.TrimSuffix(fileName, ".txt")
+
+	var out strings.Builder
+	out.WriteString(vimdocRightAlign("*"+fileName+"*", title) + "\n\n")
+
+	inCode := false
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			if inCode {
+				out.WriteString("<\n")
+			} else {
+				out.WriteString(">\n")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			out.WriteString("    " + line + "\n")
+			continue
+		}
+
+		if match := vimdocHeadingPattern.FindStringSubmatch(line); match != nil {
+			heading := vimdocInline(match[2], pagePath)
+			tag := "*" + prefix + "-" + vimdocTag(match[2]) + "*"
+			switch len(match[1]) {
+			case 1:
+				out.WriteString(strings.Repeat("=", vimdocWidth) + "\n")
+				out.WriteString(vimdocRightAlign(strings.ToUpper(heading), tag) + "\n")
+			case 2:
+				out.WriteString(strings.Repeat("-", vimdocWidth) + "\n")
+				out.WriteString(vimdocRightAlign(strings.ToUpper(heading), tag) + "\n")
+			default:
+				out.WriteString(heading + " ~\n")
+			}
+			continue
+		}
+		out.WriteString(vimdocInline(line, pagePath) + "\n")
+	}
+	if inCode {
+		out.WriteString("<\n")
+	}
+
+	out.WriteString("\n" + vimdocModeline + "\n")
+	return out.String()
+}
+
+// vimdocInline rewrites a line's links and emphasis for help files
+func vimdocInline(line string, pagePath string) string {
+	line = vimdocPageLinkPattern.ReplaceAllStringFunc(line, func(link string) string {
+		match := vimdocPageLinkPattern.FindStringSubmatch(link)
+		target := filepath.ToSlash(filepath.Join(filepath.Dir(pagePath), match[2])) + ".md"
+		return fmt.Sprintf("%s |%s|", match[1], vimdocFileName(target))
+	})
+	line = vimdocURLLinkPattern.ReplaceAllString(line, "$1 <$2>")
+	return vimdocEmphasisPattern.ReplaceAllString(line, "$2")
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// callbackHeader carries the completion webhook URL; the callback_url query parameter also works
+	callbackHeader = "X-Callback-URL"
+	// defaultCallbackAttempts is how often a webhook delivery is tried before giving up
+	defaultCallbackAttempts = 5
+	// callbackTimeout bounds a single delivery attempt
+	callbackTimeout = 10 * time.Second
+)
+
+// callbackClient delivers completion webhooks. Every redirect is checked like the callback URL
+// itself, and unless CALLBACK_ALLOWED_HOSTS limits the hosts, connections to loopback, private and
+// link-local addresses are refused.
+var callbackClient = &http.Client{
+	Timeout: callbackTimeout,
+	Transport: guardedTransport(func() bool {
+		return getEnv("CALLBACK_ALLOWED_HOSTS", "") == ""
+	}),
+	CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after %d redirects", len(via))
+		}
+		if _, err := checkCallbackURL(request.URL.String()); err != nil {
+			return fmt.Errorf("redirect refused: %v", err)
+		}
+		return nil
+	},
+}
+
+// JobCallback is the payload POSTed to a job's callback URL when it finishes
+type JobCallback struct {
+	Id          string     `json:"id"`
+	Status      string     `json:"status"`
+	StatusURL   string     `json:"status_url"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	Files       []string   `json:"files,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	ErrorCode   string     `json:"error_code,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// parseCallbackURL returns the request's completion webhook URL, if any, checked by
+// checkCallbackURL. Callbacks link back to the service, so they need PUBLIC_BASE_URL.
+func parseCallbackURL(r *http.Request) (string, error) {
+	raw := r.Header.Get(callbackHeader)
+	if raw == "" {
+		raw = r.URL.Query().Get("callback_url")
+	}
+	if raw == "" {
+		return "", nil
+	}
+	if publicBaseURL() == "" {
+		return "", fmt.Errorf("completion webhooks need PUBLIC_BASE_URL to be configured")
+	}
+
+	callback, err := checkCallbackURL(raw)
+	if err != nil {
+		return "", err
+	}
+	return callback.String(), nil
+}
+
+// checkCallbackURL parses a completion webhook URL. Only absolute https URLs are accepted, and
+// only for hosts in CALLBACK_ALLOWED_HOSTS when that is set.
+func checkCallbackURL(raw string) (*url.URL, error) {
+	callback, err := url.Parse(raw)
+	if err != nil || callback.Scheme != "https" || callback.Host == "" {
+		return nil, fmt.Errorf("callback URL must be an absolute https URL")
+	}
+	if allowed := getEnv("CALLBACK_ALLOWED_HOSTS", ""); allowed != "" {
+		for _, host := range strings.Split(allowed, ",") {
+			if strings.EqualFold(strings.TrimSpace(host), callback.Hostname()) {
+				return callback, nil
+			}
+		}
+		return nil, fmt.Errorf("callback host %q is not allowed", callback.Hostname())
+	}
+	return callback, nil
+}
+
+// publicBaseURL is the externally visible address of the service used in webhook links, taken from
+// PUBLIC_BASE_URL; the request's Host is not trusted for them
+func publicBaseURL() string {
+	return strings.TrimSuffix(getEnv("PUBLIC_BASE_URL", ""), "/")
+}
+
+// signCallback computes hex(HMAC-SHA256(CALLBACK_SIGNING_SECRET, timestamp + "\n" + body)),
+// or returns "" when no signing secret is configured
+func signCallback(timestamp string, body []byte) string {
+	secret := getSecret("CALLBACK_SIGNING_SECRET")
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s", timestamp, body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// archiveFileList lists the files inside a zip, tar.gz or JSON result for the webhook payload
+func archiveFileList(zipFileName string) []string {
+	if strings.HasSuffix(zipFileName, ".tar.gz") {
+		return tarballFileList(zipFileName)
+	}
+	if strings.HasSuffix(zipFileName, ".json") {
+		return jsonOutputFileList(zipFileName)
+	}
+	reader, err := zip.OpenReader(zipFileName)
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+	files := make([]string, 0, len(reader.File))
+	for _, file := range reader.File {
+		files = append(files, file.Name)
+	}
+	return files
+}
+
+// tarballFileList lists the regular files inside a gzip-compressed tarball
+func tarballFileList(fileName string) []string {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil
+	}
+	defer gzipReader.Close()
+
+	var files []string
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			return files
+		}
+		if header.Typeflag == tar.TypeReg {
+			files = append(files, header.Name)
+		}
+	}
+}
+
+// jsonOutputFileList lists the files of an output=json result
+func jsonOutputFileList(fileName string) []string {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil
+	}
+	var result JSONOutput
+	if json.Unmarshal(data, &result) != nil {
+		return nil
+	}
+	files := make([]string, 0, len(result.Files))
+	for _, file := range result.Files {
+		files = append(files, file.Path)
+	}
+	return files
+}
+
+// notifyCallback POSTs the finished job to its callback URL, retrying failed deliveries with
+// exponential backoff (1s, 2s, 4s, ...) up to CALLBACK_MAX_ATTEMPTS times
+func notifyCallback(job JobRecord) {
+	baseURL := job.BaseURL
+	payload := JobCallback{
+		Id:         job.Id,
+		Status:     job.Status,
+		StatusURL:  baseURL + job.StatusURL,
+		Error:      job.Error,
+		ErrorCode:  job.ErrorCode,
+		FinishedAt: job.FinishedAt,
+	}
+	if job.Status == jobSucceeded {
+		payload.DownloadURL = baseURL + job.ResultURL
+		payload.Files = job.ResultFiles
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.WithError(err).Error("Failed to encode callback payload")
+		return
+	}
+
+	attempts := int(getEnvInt64("CALLBACK_MAX_ATTEMPTS", defaultCallbackAttempts))
+	backoff := time.Second
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = deliverCallback(job.CallbackURL, body)
+		if err == nil {
+			logger.WithFields(logrus.Fields{
+				"request_id": job.Id,
+				"attempt":    attempt,
+			}).Info("Delivered completion callback")
+			return
+		}
+
+		logger.WithFields(logrus.Fields{
+			"request_id": job.Id,
+			"attempt":    attempt,
+			"error":      err.Error(),
+		}).Warn("Completion callback failed")
+		if attempt < attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"request_id": job.Id,
+		"attempts":   attempts,
+	}).Error("Giving up on completion callback")
+}
+
+// deliverCallback makes one signed delivery attempt; any non-2xx response counts as a failure
+func deliverCallback(callbackURL string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), callbackTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Signature-Timestamp", timestamp)
+	if signature := signCallback(timestamp, body); signature != "" {
+		request.Header.Set("X-Signature", signature)
+	}
+
+	response, err := callbackClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("callback returned %s", response.Status)
+	}
+	return nil
+}
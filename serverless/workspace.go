@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// workspaceMarker is the file or directory name that explicitly marks a Neorg workspace root
+const workspaceMarker = ".neorg"
+
+// Workspace is a single Neorg workspace found inside an extracted project
+type Workspace struct {
+	// Name is the workspace path relative to the extraction root, using forward slashes ("" for the root itself)
+	Name string
+	// Dir is the absolute directory docgen runs against
+	Dir string
+}
+
+// findWorkspaces locates the Neorg workspaces docgen should run against inside an extracted project.
+// Every outermost directory containing a .neorg marker is a separate workspace; markers nested inside
+// another marked workspace belong to it. Without markers, the deepest directory containing every .norg
+// file is the single workspace, falling back to extractDir when the tree contains no .norg files at all.
+func findWorkspaces(extractDir string) ([]Workspace, error) {
+	var markerDirs []string
+	var norgDirs []string
+
+	err := filepath.Walk(extractDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Name() == workspaceMarker {
+			markerDirs = append(markerDirs, filepath.Dir(path))
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".norg") {
+			norgDirs = append(norgDirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(markerDirs) > 0 {
+		roots := outermostDirs(markerDirs)
+		logger.WithFields(logrus.Fields{
+			"workspaces":    len(roots),
+			"markers_found": len(markerDirs),
+		}).Debug("Detected Neorg workspaces from markers")
+		return newWorkspaces(extractDir, roots)
+	}
+
+	if len(norgDirs) == 0 {
+		logger.WithFields(logrus.Fields{
+			"extract_dir": extractDir,
+		}).Debug("No .norg files found, using archive root as workspace")
+		return newWorkspaces(extractDir, []string{extractDir})
+	}
+
+	root := norgDirs[0]
+	for _, dir := range norgDirs[1:] {
+		root = commonAncestor(root, dir)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"workspace_root": root,
+		"norg_files":     len(norgDirs),
+	}).Debug("Detected Neorg workspace root from .norg file locations")
+	return newWorkspaces(extractDir, []string{root})
+}
+
+// newWorkspaces names each workspace directory relative to the extraction root
+func newWorkspaces(extractDir string, dirs []string) ([]Workspace, error) {
+	workspaces := make([]Workspace, 0, len(dirs))
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(extractDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		if rel == "." {
+			rel = ""
+		}
+		workspaces = append(workspaces, Workspace{Name: filepath.ToSlash(rel), Dir: dir})
+	}
+	return workspaces, nil
+}
+
+// outermostDirs drops every directory that is nested inside another one from the list, sorted by path.
+// Sorting puts every directory after its ancestors, but not right after them: "a-b" sorts between
+// "a" and "a/b", so each directory is checked against every root kept so far.
+func outermostDirs(dirs []string) []string {
+	sorted := append([]string(nil), dirs...)
+	sort.Strings(sorted)
+
+	var roots []string
+	for _, dir := range sorted {
+		if slices.ContainsFunc(roots, func(root string) bool { return isWithinDir(dir, root) }) {
+			continue
+		}
+		roots = append(roots, dir)
+	}
+	return roots
+}
+
+// collectWorkspaceOutput moves a workspace's generated wiki into the output directory and lists its files.
+// With nested set, the wiki lands in a subdirectory named after the workspace instead of the output root.
+func collectWorkspaceOutput(workspace Workspace, outputDir string, nested bool) (WorkspaceManifest, error) {
+	wikiDir := filepath.Join(workspace.Dir, "wiki")
+	if _, err := os.Stat(wikiDir); err != nil {
+		return WorkspaceManifest{}, fmt.Errorf("wiki directory was not created: %v", err)
+	}
+
+	dest := outputDir
+	if nested {
+		dest = filepath.Join(outputDir, filepath.FromSlash(workspace.Name))
+	}
+
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return WorkspaceManifest{}, fmt.Errorf("failed to create output directory: %v", err)
+	}
+	err = os.Rename(wikiDir, dest)
+	if err != nil {
+		return WorkspaceManifest{}, fmt.Errorf("failed to move wiki output: %v", err)
+	}
+
+	entry := WorkspaceManifest{
+		Name:   workspace.Name,
+		Source: workspace.Name,
+		Files:  []string{},
+	}
+	if nested {
+		entry.Output = workspace.Name
+	}
+
+	err = filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(outputDir, path)
+		if err != nil {
+			return err
+		}
+		entry.Files = append(entry.Files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return WorkspaceManifest{}, err
+	}
+
+	return entry, nil
+}
+
+// commonAncestor returns the deepest directory that contains both a and b
+func commonAncestor(a, b string) string {
+	for !isWithinDir(b, a) {
+		parent := filepath.Dir(a)
+		if parent == a {
+			break
+		}
+		a = parent
+	}
+	return a
+}
+
+// isWithinDir reports whether path is dir itself or located underneath it
+func isWithinDir(path, dir string) bool {
+	path = filepath.Clean(path)
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestOutermostDirs(t *testing.T) {
+	tests := []struct {
+		name string
+		dirs []string
+		want []string
+	}{
+		{"single", []string{"a"}, []string{"a"}},
+		{"nested", []string{"a/b", "a"}, []string{"a"}},
+		{"siblings", []string{"b", "a"}, []string{"a", "b"}},
+		{"prefix sibling", []string{"ab", "a"}, []string{"a", "ab"}},
+		// "a-b" sorts between "a" and "a/b"
+		{"sibling sorted between", []string{"a", "a-b", "a/b"}, []string{"a", "a-b"}},
+		{"deeply nested after siblings", []string{"a/b/c", "a.b", "a-b", "a"}, []string{"a", "a-b", "a.b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var dirs, want []string
+			for _, dir := range test.dirs {
+				dirs = append(dirs, filepath.FromSlash(dir))
+			}
+			for _, dir := range test.want {
+				want = append(want, filepath.FromSlash(dir))
+			}
+			if got := outermostDirs(dirs); !slices.Equal(got, want) {
+				t.Errorf("outermostDirs(%q) = %q, want %q", dirs, got, want)
+			}
+		})
+	}
+}